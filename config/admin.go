@@ -0,0 +1,26 @@
+package config
+
+import (
+	"os"
+	"strings"
+)
+
+// AdminUserIDs holds employee numbers allowed to use the /api/admin endpoints.
+// Loaded from the comma-separated ADMIN_USER_IDS env var.
+var AdminUserIDs = map[string]bool{}
+
+// InitAdminUsers parses ADMIN_USER_IDS. Call once from main.go.
+func InitAdminUsers() {
+	AdminUserIDs = map[string]bool{}
+	for _, id := range strings.Split(os.Getenv("ADMIN_USER_IDS"), ",") {
+		id = strings.TrimSpace(id)
+		if id != "" {
+			AdminUserIDs[id] = true
+		}
+	}
+}
+
+// IsAdminUser reports whether the employee number is a configured admin.
+func IsAdminUser(userID string) bool {
+	return userID != "" && AdminUserIDs[userID]
+}
@@ -0,0 +1,40 @@
+package config
+
+import (
+	"log"
+	"os"
+	"strings"
+)
+
+// AllowedOrigins holds the origins allowed to call the API cross-origin.
+// Loaded from the comma-separated CORS_ALLOWED_ORIGINS env var, e.g.
+//
+//	CORS_ALLOWED_ORIGINS=https://gis.pwa.co.th,https://intranet.pwa.co.th
+//
+// Empty (the default) means same-origin only. Origins are compared exactly
+// (scheme + host + port, no trailing slash).
+var AllowedOrigins = map[string]bool{}
+
+// InitCORS parses CORS_ALLOWED_ORIGINS. Call once from main.go.
+func InitCORS() {
+	AllowedOrigins = map[string]bool{}
+	for _, o := range strings.Split(os.Getenv("CORS_ALLOWED_ORIGINS"), ",") {
+		o = strings.TrimRight(strings.TrimSpace(o), "/")
+		if o == "" {
+			continue
+		}
+		if o == "*" {
+			// Cookies are sent cross-origin, so a wildcard would defeat CSRF protection
+			log.Println("CORS_ALLOWED_ORIGINS: '*' is not supported, list origins explicitly")
+			continue
+		}
+		AllowedOrigins[o] = true
+	}
+	log.Printf("CORS allowed origins: %d configured", len(AllowedOrigins))
+}
+
+// IsOriginAllowed reports whether a cross-origin request from origin may
+// read responses.
+func IsOriginAllowed(origin string) bool {
+	return origin != "" && AllowedOrigins[origin]
+}
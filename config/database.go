@@ -18,10 +18,6 @@ var PgDB *sql.DB
 // MongoDB is the shared MongoDB client.
 var MongoDB *mongo.Client
 
-// LoginLogFile is the shared file handle for writing login audit entries.
-// Set by main.go before any handlers are called.
-var LoginLogFile *os.File
-
 // ConnectPostgres opens the PostgreSQL connection pool and verifies it.
 func ConnectPostgres() {
 	dsn := os.Getenv("POSTGRES_DSN")
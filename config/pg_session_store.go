@@ -0,0 +1,325 @@
+package config
+
+import (
+	"bytes"
+	"context"
+	"crypto/rand"
+	"database/sql"
+	"encoding/base32"
+	"encoding/gob"
+	"fmt"
+	"log"
+	"net"
+	"net/http"
+	"strings"
+	"time"
+
+	"github.com/gorilla/securecookie"
+	"github.com/gorilla/sessions"
+)
+
+// PGStore is a gorilla/sessions Store that keeps session values in
+// rbac.pwagis_session (see sql/create_sessions.sql). The cookie only carries
+// the signed session id, so a session can be revoked before MaxAge expires.
+//
+// Lifetimes:
+//   - absolute: Options.MaxAge from the first save (2 hours), never extended
+//   - idle:     IdleTimeout since last_seen_at, renewed on every request
+type PGStore struct {
+	Codecs      []securecookie.Codec
+	Options     *sessions.Options
+	IdleTimeout time.Duration
+	db          *sql.DB
+}
+
+// sessionTouchInterval throttles last_seen_at updates to one write per minute
+// per session, so sliding renewal does not turn every request into an UPDATE.
+const sessionTouchInterval = time.Minute
+
+// SessionInfo is the admin view of one server-side session.
+type SessionInfo struct {
+	ID              string    `json:"id"`
+	UserID          string    `json:"user_id"`
+	UserName        string    `json:"user_name"`
+	PwaCode         string    `json:"pwa_code"`
+	Zone            string    `json:"zone"`
+	PermissionLevel string    `json:"permission_level"`
+	IPAddress       string    `json:"ip_address"`
+	UserAgent       string    `json:"user_agent"`
+	CreatedAt       time.Time `json:"created_at"`
+	LastSeenAt      time.Time `json:"last_seen_at"`
+	ExpiresAt       time.Time `json:"expires_at"`
+}
+
+// NewPGStore creates a Postgres-backed store. keyPairs sign (and optionally
+// encrypt) the session-id cookie, exactly like sessions.NewCookieStore.
+func NewPGStore(db *sql.DB, idleTimeout time.Duration, keyPairs ...[]byte) *PGStore {
+	return &PGStore{
+		Codecs:      securecookie.CodecsFromPairs(keyPairs...),
+		Options:     &sessions.Options{Path: "/", MaxAge: 7200},
+		IdleTimeout: idleTimeout,
+		db:          db,
+	}
+}
+
+// Get implements sessions.Store (cached per request by the registry).
+func (s *PGStore) Get(r *http.Request, name string) (*sessions.Session, error) {
+	return sessions.GetRegistry(r).Get(s, name)
+}
+
+// New implements sessions.Store. An unknown, expired, idle or revoked id
+// yields a fresh session with an empty ID (a new id is issued on Save).
+func (s *PGStore) New(r *http.Request, name string) (*sessions.Session, error) {
+	session := sessions.NewSession(s, name)
+	opts := *s.Options
+	session.Options = &opts
+	session.IsNew = true
+
+	c, err := r.Cookie(name)
+	if err != nil {
+		return session, nil
+	}
+	if err := securecookie.DecodeMulti(name, c.Value, &session.ID, s.Codecs...); err != nil {
+		session.ID = ""
+		return session, nil
+	}
+
+	found, err := s.load(session)
+	if err != nil {
+		return session, err
+	}
+	if !found {
+		session.ID = ""
+		return session, nil
+	}
+	session.IsNew = false
+	return session, nil
+}
+
+// Save implements sessions.Store. MaxAge < 0 deletes the session row.
+func (s *PGStore) Save(r *http.Request, w http.ResponseWriter, session *sessions.Session) error {
+	if session.Options.MaxAge < 0 {
+		if session.ID != "" {
+			if _, err := s.db.Exec(`DELETE FROM rbac.pwagis_session WHERE id = $1`, session.ID); err != nil {
+				return fmt.Errorf("session delete failed: %v", err)
+			}
+		}
+		http.SetCookie(w, sessions.NewCookie(session.Name(), "", session.Options))
+		return nil
+	}
+
+	if session.ID == "" {
+		session.ID = newSessionID()
+	}
+	if err := s.save(r, session); err != nil {
+		return err
+	}
+
+	encoded, err := securecookie.EncodeMulti(session.Name(), session.ID, s.Codecs...)
+	if err != nil {
+		return err
+	}
+	http.SetCookie(w, sessions.NewCookie(session.Name(), encoded, session.Options))
+	return nil
+}
+
+// Delete removes one session row, e.g. the pre-login session replaced by
+// NewLoginSession.
+func (s *PGStore) Delete(id string) error {
+	if _, err := s.db.Exec(`DELETE FROM rbac.pwagis_session WHERE id = $1`, id); err != nil {
+		return fmt.Errorf("session delete failed: %v", err)
+	}
+	return nil
+}
+
+// load reads the session values and applies sliding renewal.
+func (s *PGStore) load(session *sessions.Session) (bool, error) {
+	var data []byte
+	var lastSeen time.Time
+	err := s.db.QueryRow(`
+		SELECT data, last_seen_at
+		FROM rbac.pwagis_session
+		WHERE id = $1
+		  AND revoked_at IS NULL
+		  AND expires_at > NOW()
+		  AND last_seen_at > NOW() - ($2 * INTERVAL '1 second')
+	`, session.ID, int(s.IdleTimeout.Seconds())).Scan(&data, &lastSeen)
+	if err == sql.ErrNoRows {
+		return false, nil
+	}
+	if err != nil {
+		return false, fmt.Errorf("session load failed: %v", err)
+	}
+
+	if err := gob.NewDecoder(bytes.NewReader(data)).Decode(&session.Values); err != nil {
+		log.Printf("[Session] decode error for %s…: %v", session.ID[:8], err)
+		return false, nil
+	}
+
+	if time.Since(lastSeen) > sessionTouchInterval {
+		if _, err := s.db.Exec(`UPDATE rbac.pwagis_session SET last_seen_at = NOW() WHERE id = $1`, session.ID); err != nil {
+			log.Printf("[Session] touch error: %v", err)
+		}
+	}
+	return true, nil
+}
+
+// save upserts the session row. expires_at is only set on insert so the
+// absolute lifetime is not extended by later saves.
+func (s *PGStore) save(r *http.Request, session *sessions.Session) error {
+	var buf bytes.Buffer
+	if err := gob.NewEncoder(&buf).Encode(session.Values); err != nil {
+		return fmt.Errorf("session encode failed: %v", err)
+	}
+
+	// Well-known keys written by handlers/auth.go (sessUID, sessUname, ...)
+	str := func(key string) string {
+		v, _ := session.Values[key].(string)
+		return v
+	}
+
+	// UPDATE-then-INSERT instead of ON CONFLICT (PostgreSQL 9.4 compatible)
+	res, err := s.db.Exec(`
+		UPDATE rbac.pwagis_session SET
+			user_id = $2, user_name = $3, pwa_code = $4, zone = $5,
+			permission_level = $6, data = $7, last_seen_at = NOW()
+		WHERE id = $1
+	`, session.ID, str("uid"), str("uname"), str("pwacode"), str("area"), str("permission_leak"),
+		buf.Bytes())
+	if err != nil {
+		return fmt.Errorf("session save failed: %v", err)
+	}
+	if n, _ := res.RowsAffected(); n > 0 {
+		return nil
+	}
+
+	_, err = s.db.Exec(`
+		INSERT INTO rbac.pwagis_session
+			(id, user_id, user_name, pwa_code, zone, permission_level,
+			 data, ip_address, user_agent, expires_at)
+		VALUES ($1,$2,$3,$4,$5,$6,$7,$8,$9, NOW() + ($10 * INTERVAL '1 second'))
+	`, session.ID, str("uid"), str("uname"), str("pwacode"), str("area"), str("permission_leak"),
+		buf.Bytes(), clientIP(r), r.UserAgent(), session.Options.MaxAge)
+	if err != nil {
+		return fmt.Errorf("session save failed: %v", err)
+	}
+	return nil
+}
+
+// ─── Admin operations ───────────────────────────────────────────────────────
+
+// ListActiveSessions returns live sessions, optionally filtered by user and branch.
+func (s *PGStore) ListActiveSessions(userID, pwaCode string) ([]SessionInfo, error) {
+	rows, err := s.db.Query(`
+		SELECT id, COALESCE(user_id, ''), COALESCE(user_name, ''), COALESCE(pwa_code, ''),
+		       COALESCE(zone, ''), COALESCE(permission_level, ''),
+		       COALESCE(ip_address, ''), COALESCE(user_agent, ''),
+		       created_at, last_seen_at, expires_at
+		FROM rbac.pwagis_session
+		WHERE revoked_at IS NULL
+		  AND expires_at > NOW()
+		  AND last_seen_at > NOW() - ($1 * INTERVAL '1 second')
+		  AND ($2 = '' OR user_id = $2)
+		  AND ($3 = '' OR pwa_code = $3)
+		ORDER BY last_seen_at DESC
+	`, int(s.IdleTimeout.Seconds()), userID, pwaCode)
+	if err != nil {
+		return nil, fmt.Errorf("query sessions failed: %v", err)
+	}
+	defer rows.Close()
+
+	result := []SessionInfo{}
+	for rows.Next() {
+		var si SessionInfo
+		if err := rows.Scan(&si.ID, &si.UserID, &si.UserName, &si.PwaCode, &si.Zone,
+			&si.PermissionLevel, &si.IPAddress, &si.UserAgent,
+			&si.CreatedAt, &si.LastSeenAt, &si.ExpiresAt); err != nil {
+			continue
+		}
+		// Never expose the full id — it is a bearer credential.
+		si.ID = si.ID[:8]
+		result = append(result, si)
+	}
+	return result, rows.Err()
+}
+
+// RevokeUserSessions force-logs-out every session of one user.
+func (s *PGStore) RevokeUserSessions(userID, actor string) (int64, error) {
+	res, err := s.db.Exec(`
+		UPDATE rbac.pwagis_session
+		SET revoked_at = NOW(), revoked_by = $2
+		WHERE user_id = $1 AND revoked_at IS NULL
+	`, userID, actor)
+	if err != nil {
+		return 0, fmt.Errorf("revoke sessions failed: %v", err)
+	}
+	return res.RowsAffected()
+}
+
+// RevokeAllSessions force-logs-out everyone except the given session id.
+func (s *PGStore) RevokeAllSessions(exceptID, actor string) (int64, error) {
+	res, err := s.db.Exec(`
+		UPDATE rbac.pwagis_session
+		SET revoked_at = NOW(), revoked_by = $2
+		WHERE revoked_at IS NULL AND id <> $1
+	`, exceptID, actor)
+	if err != nil {
+		return 0, fmt.Errorf("revoke sessions failed: %v", err)
+	}
+	return res.RowsAffected()
+}
+
+// PurgeExpired deletes rows that can no longer be used. Run periodically.
+func (s *PGStore) PurgeExpired() {
+	res, err := s.db.Exec(`
+		DELETE FROM rbac.pwagis_session
+		WHERE expires_at < NOW() - INTERVAL '1 day'
+		   OR revoked_at < NOW() - INTERVAL '1 day'
+	`)
+	if err != nil {
+		log.Printf("[Session] purge error: %v", err)
+		return
+	}
+	if n, _ := res.RowsAffected(); n > 0 {
+		log.Printf("[Session] purged %d expired sessions", n)
+	}
+}
+
+// ─── Helpers ────────────────────────────────────────────────────────────────
+
+// newSessionID returns a 32-byte random id encoded as base32 (52 chars).
+func newSessionID() string {
+	b := make([]byte, 32)
+	if _, err := rand.Read(b); err != nil {
+		panic(fmt.Sprintf("session id generation failed: %v", err))
+	}
+	return strings.TrimRight(base32.StdEncoding.EncodeToString(b), "=")
+}
+
+// clientIPKey carries gin's ClientIP into the store via the request context.
+type clientIPKey struct{}
+
+// WithClientIP returns r carrying ip, the address recorded in ip_address.
+// Callers pass gin's c.ClientIP(), which honours TRUSTED_PROXIES, so a
+// forged X-Forwarded-For never reaches the session table.
+func WithClientIP(r *http.Request, ip string) *http.Request {
+	return r.WithContext(context.WithValue(r.Context(), clientIPKey{}, ip))
+}
+
+// maxSessionIPLen is the width of ip_address.
+const maxSessionIPLen = 50
+
+// clientIP returns the address set by WithClientIP, or the TCP peer.
+func clientIP(r *http.Request) string {
+	ip, _ := r.Context().Value(clientIPKey{}).(string)
+	if ip == "" {
+		ip = r.RemoteAddr
+		if host, _, err := net.SplitHostPort(ip); err == nil {
+			ip = host
+		}
+	}
+	if len(ip) > maxSessionIPLen {
+		ip = ip[:maxSessionIPLen]
+	}
+	return ip
+}
@@ -0,0 +1,23 @@
+package config
+
+import (
+	"log"
+	"os"
+	"strings"
+)
+
+// TrustedProxies lists the reverse proxies (IPs or CIDRs) whose
+// X-Forwarded-For / X-Real-IP headers gin may use for ClientIP, from
+// TRUSTED_PROXIES (comma-separated). Empty (the default) trusts none, so
+// ClientIP is the TCP peer address and a forged header cannot change the
+// per-IP login throttle or the audit IP.
+func TrustedProxies() []string {
+	var list []string
+	for _, p := range strings.Split(os.Getenv("TRUSTED_PROXIES"), ",") {
+		if p = strings.TrimSpace(p); p != "" {
+			list = append(list, p)
+		}
+	}
+	log.Printf("Trusted proxies: %d configured", len(list))
+	return list
+}
@@ -1,36 +1,93 @@
 package config // ← must match the folder name: config/
 
 import (
+	"log"
 	"net/http"
 	"os"
+	"time"
 
 	"github.com/gorilla/sessions"
 )
 
-// Store is the global gorilla/sessions cookie store.
+// Store is the global gorilla/sessions store.
+//
+// SESSION_STORE selects the backend:
+//   - "postgres" (default) — PGStore; sessions can be listed and revoked
+//   - "cookie"             — the original CookieStore (no server-side state)
+//
 // The authentication key is loaded from SESSION_SECRET env var.
 // An optional encryption key (SESSION_ENC_KEY, must be 16, 24, or 32 bytes)
 // enables AES encryption of cookie contents for extra security.
-var Store *sessions.CookieStore
+var Store sessions.Store
+
+// PGSessions is set when Store is the Postgres-backed store; nil otherwise.
+// Admin session listing / forced logout require it.
+var PGSessions *PGStore
 
 const SessionName = "pwa_gis_session"
 
-// InitSessionStore initialises the gorilla/sessions cookie store.
-// Call once from main.go before starting the HTTP server.
+// sessionOptions are shared by both backends.
+var sessionOptions = sessions.Options{
+	Path:     "/pwa_gis_tracking/",
+	MaxAge:   7200,                // 2 hours (absolute)
+	HttpOnly: true,
+	SameSite: http.SameSiteLaxMode, // correct typed constant (= 2), not a bare int
+}
+
+// InitSessionStore initialises the session store.
+// Call once from main.go (after ConnectPostgres) before starting the HTTP server.
 func InitSessionStore() {
-	authKey := []byte(getEnvOrDefault("SESSION_SECRET", "change-me-in-production-32chars!!"))
+	keyPairs := [][]byte{[]byte(getEnvOrDefault("SESSION_SECRET", "change-me-in-production-32chars!!"))}
+	if encKeyStr := os.Getenv("SESSION_ENC_KEY"); encKeyStr != "" {
+		keyPairs = append(keyPairs, []byte(encKeyStr))
+	}
 
-	encKeyStr := os.Getenv("SESSION_ENC_KEY")
-	if encKeyStr != "" {
-		Store = sessions.NewCookieStore(authKey, []byte(encKeyStr))
-	} else {
-		Store = sessions.NewCookieStore(authKey)
+	if getEnvOrDefault("SESSION_STORE", "postgres") == "cookie" {
+		cs := sessions.NewCookieStore(keyPairs...)
+		opts := sessionOptions
+		cs.Options = &opts
+		Store = cs
+		log.Println("Session store: cookie")
+		return
 	}
 
-	Store.Options = &sessions.Options{
-		Path:     "/pwa_gis_tracking/",
-		MaxAge:   7200,                // 2 hours
-		HttpOnly: true,
-		SameSite: http.SameSiteLaxMode, // correct typed constant (= 2), not a bare int
+	idle, err := time.ParseDuration(getEnvOrDefault("SESSION_IDLE_TIMEOUT", "30m"))
+	if err != nil {
+		log.Fatalf("SESSION_IDLE_TIMEOUT: %v", err)
+	}
+
+	ps := NewPGStore(PgDB, idle, keyPairs...)
+	opts := sessionOptions
+	ps.Options = &opts
+	Store = ps
+	PGSessions = ps
+	log.Printf("Session store: postgres (idle timeout %v)", idle)
+
+	// Purge dead rows hourly
+	go func() {
+		ticker := time.NewTicker(time.Hour)
+		defer ticker.Stop()
+		for range ticker.C {
+			ps.PurgeExpired()
+		}
+	}()
+}
+
+// NewLoginSession returns an empty session to be filled in at login. The
+// session the request arrived with is discarded: its server-side row is
+// deleted and none of its values carry over, so a session id planted before
+// login never becomes authenticated (session fixation). Save issues a new id.
+func NewLoginSession(r *http.Request) *sessions.Session {
+	if PGSessions != nil {
+		if old, err := PGSessions.Get(r, SessionName); err == nil && old.ID != "" {
+			if err := PGSessions.Delete(old.ID); err != nil {
+				log.Printf("[Session] %v", err)
+			}
+		}
 	}
-}
\ No newline at end of file
+	session := sessions.NewSession(Store, SessionName)
+	opts := sessionOptions
+	session.Options = &opts
+	session.IsNew = true
+	return session
+}
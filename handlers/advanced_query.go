@@ -4,6 +4,7 @@ import (
 	"fmt"
 	"net/http"
 	"strings"
+	"time"
 
 	"pwa_gis_tracking/services"
 
@@ -18,16 +19,24 @@ func AdvancedQuery(c *gin.Context) {
 		c.JSON(http.StatusBadRequest, gin.H{"error": "Invalid request: " + err.Error()})
 		return
 	}
+	setAuditTarget(c, "query", summarizeAdvancedQuery(&req, ""))
 
 	// Require at least one pwa code
-	if req.PwaCode == "" && len(req.PwaCodes) == 0 {
+	pwaCodes := req.ResolvedPwaCodes()
+	if len(pwaCodes) == 0 {
 		c.JSON(http.StatusBadRequest, gin.H{"error": "pwaCode or pwaCodes is required"})
 		return
 	}
+	if !authorizeBranches(c, pwaCodes...) {
+		return
+	}
 	if req.Collection == "" {
 		c.JSON(http.StatusBadRequest, gin.H{"error": "collection is required"})
 		return
 	}
+	if !authorizeCapability(c, services.CapViewAttributes, req.Collection) {
+		return
+	}
 
 	// Validate collection name
 	validLayers := services.GetAllLayerNames()
@@ -56,6 +65,14 @@ func AdvancedQuery(c *gin.Context) {
 		req.Limit = 5000
 	}
 
+	req.Mask = piiMask(c)
+	if req.Mask != services.MaskFull {
+		if f := services.SensitiveConditionField(req.Collection, req.Conditions); f != "" {
+			denyAccess(c, "field "+f+" is masked for your permission level")
+			return
+		}
+	}
+
 	result, err := services.ExecuteAdvancedQuery(&req)
 	if err != nil {
 		c.JSON(http.StatusInternalServerError, gin.H{"error": err.Error()})
@@ -84,11 +101,16 @@ func AdvancedQueryExport(c *gin.Context) {
 		c.JSON(http.StatusBadRequest, gin.H{"error": "Invalid request: " + err.Error()})
 		return
 	}
+	setAuditTarget(c, "query", summarizeAdvancedQuery(&req.AdvancedQueryRequest, req.Format))
 
-	if req.PwaCode == "" && len(req.PwaCodes) == 0 {
+	pwaCodes := req.ResolvedPwaCodes()
+	if len(pwaCodes) == 0 {
 		c.JSON(http.StatusBadRequest, gin.H{"error": "pwaCode or pwaCodes is required"})
 		return
 	}
+	if !authorizeBranches(c, pwaCodes...) {
+		return
+	}
 	if req.Collection == "" {
 		c.JSON(http.StatusBadRequest, gin.H{"error": "collection is required"})
 		return
@@ -97,6 +119,10 @@ func AdvancedQueryExport(c *gin.Context) {
 	if req.Format == "" {
 		req.Format = "csv"
 	}
+	defer observeExport(c, req.Format, time.Now())
+	if !authorizeCapability(c, exportCapability(req.Format), req.Collection) {
+		return
+	}
 	if req.Limit <= 0 || req.Limit > 10000 {
 		req.Limit = 10000
 	}
@@ -104,6 +130,14 @@ func AdvancedQueryExport(c *gin.Context) {
 	filename := fmt.Sprintf("%s_%s_query", req.PwaCode, req.Collection)
 	auditDetail := fmt.Sprintf("%s:%s", req.PwaCode, req.Collection)
 
+	req.Mask = piiMask(c)
+	if req.Mask != services.MaskFull {
+		if f := services.SensitiveConditionField(req.Collection, req.Conditions); f != "" {
+			denyAccess(c, "field "+f+" is masked for your permission level")
+			return
+		}
+	}
+
 	// Get GeoJSON with geometry
 	geojsonData, err := services.ExportAdvancedQueryAsGeoJSON(&req.AdvancedQueryRequest)
 	if err != nil {
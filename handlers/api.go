@@ -1,11 +1,12 @@
 package handlers
 
 import (
+	"encoding/json"
 	"fmt"
+	"log"
 	"net/http"
-	"sort"
-	"strconv"
 	"strings"
+	"time"
 
 	"pwa_gis_tracking/config"
 	"pwa_gis_tracking/services"
@@ -15,7 +16,7 @@ import (
 )
 
 // GetCacheStatus returns cache monitoring info.
-// GET /api/cache/status
+// GET /api/admin/cache/status
 func GetCacheStatus(c *gin.Context) {
     stats := GetCacheStats()
     c.JSON(http.StatusOK, gin.H{
@@ -36,9 +37,13 @@ func GetZones(c *gin.Context) {
 }
 
 // GetOffices returns branch offices, optionally filtered by zone.
+// Non-"all" users are limited to their own zone.
 // GET /api/offices?zone=xxx
 func GetOffices(c *gin.Context) {
-	zone := c.Query("zone")
+	zone, ok := authorizeZone(c, c.Query("zone"))
+	if !ok {
+		return
+	}
 
 	var offices interface{}
 	var err error
@@ -114,6 +119,9 @@ func GetBranchCounts(c *gin.Context) {
 		c.JSON(http.StatusBadRequest, gin.H{"error": "pwaCode is required"})
 		return
 	}
+	if !authorizeBranches(c, pwaCode) {
+		return
+	}
 
 	layers, err := services.CountAllLayersForBranch(pwaCode, startDate, endDate)
 	if err != nil {
@@ -137,202 +145,91 @@ func GetBranchCounts(c *gin.Context) {
 // GetDashboardSummary returns the full dashboard summary with all branches and zone totals.
 // GET /api/dashboard?zone=xxx&startDate=xxx&endDate=xxx
 func GetDashboardSummary(c *gin.Context) {
-	zone := c.Query("zone")
+	zone, ok := authorizeZone(c, c.Query("zone"))
+	if !ok {
+		return
+	}
 	startDate := c.Query("startDate")
 	endDate := c.Query("endDate")
+	// Recorded for the usage-driven cache warmer (TopDashboardQueries)
+	setAuditTarget(c, "dashboard", gin.H{"zone": zone, "startDate": startDate, "endDate": endDate})
 
 	// Check cache first — avoids 10+ second MongoDB aggregation
-	cacheKey := CacheKey("dashboard", zone, startDate, endDate)
-	if cached := GetCachedDashboard(cacheKey); cached != nil {
-		c.Header("X-Cache", "HIT")
-		c.Data(http.StatusOK, "application/json; charset=utf-8", cached)
+	cacheKey := dashboardKey(zone, startDate, endDate)
+	if entry, ok := activeCache().Get(cacheKey); ok {
+		if !entry.Stale() {
+			c.Header("X-Cache", "HIT")
+			dashboardCacheRequests.Inc("hit")
+		} else {
+			// Serve the expired copy now; one background run refreshes it
+			c.Header("X-Cache", "STALE")
+			dashboardCacheRequests.Inc("stale")
+			dashFlight.Refresh(cacheKey, func() (json.RawMessage, error) {
+				raw, err := computeDashboardCached(cacheKey, zone, startDate, endDate, CacheTTL, "lazy")
+				if err != nil {
+					log.Printf("[Dashboard] background refresh key=%s failed: %v", cacheKey, err)
+				}
+				return raw, err
+			})
+		}
+		writeDashboard(c, entry.Data)
 		return
 	}
-	c.Header("X-Cache", "MISS")
 
-	var officeList []struct {
-		PwaCode string
-		Name    string
-		Zone    string
+	// Miss: concurrent requests for the same key share one computation
+	raw, err, shared := dashFlight.Do(cacheKey, func() (json.RawMessage, error) {
+		return computeDashboardCached(cacheKey, zone, startDate, endDate, CacheTTL, "lazy")
+	})
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": err.Error()})
+		return
 	}
-
-	if zone != "" {
-		rawOffices, e := services.GetOfficesByZone(zone)
-		if e != nil {
-			c.JSON(http.StatusInternalServerError, gin.H{"error": e.Error()})
-			return
-		}
-		for _, o := range rawOffices {
-			officeList = append(officeList, struct {
-				PwaCode string
-				Name    string
-				Zone    string
-			}{o.PwaCode, o.Name, o.Zone})
-		}
+	if shared {
+		c.Header("X-Cache", "COALESCED")
+		dashboardCacheRequests.Inc("coalesced")
 	} else {
-		rawOffices, e := services.GetAllOffices()
-		if e != nil {
-			c.JSON(http.StatusInternalServerError, gin.H{"error": e.Error()})
-			return
-		}
-		for _, o := range rawOffices {
-			officeList = append(officeList, struct {
-				PwaCode string
-				Name    string
-				Zone    string
-			}{o.PwaCode, o.Name, o.Zone})
-		}
-	}
-
-	// Count features for all branches concurrently
-	type branchResult struct {
-		PwaCode          string           `json:"pwa_code"`
-		BranchName       string           `json:"branch_name"`
-		Zone             string           `json:"zone"`
-		Layers           map[string]int64 `json:"layers"`
-		Total            int64            `json:"total"`
-		PipeLong         float64          `json:"pipe_long"`
-		PipeLongExSleeve float64          `json:"pipe_long_ex_sleeve"`
-		ActiveMeter      int64            `json:"active_meter"`
+		c.Header("X-Cache", "MISS")
+		dashboardCacheRequests.Inc("miss")
 	}
+	writeDashboard(c, raw)
+}
 
-	results := make(chan branchResult, len(officeList))
-	sem := make(chan struct{}, 15) // concurrency limiter
-
-	for _, o := range officeList {
-		sem <- struct{}{}
-		go func(pwa, name, z string) {
-			defer func() { <-sem }()
-
-			layers, err := services.CountAllLayersForBranch(pwa, startDate, endDate)
-			if err != nil {
-				results <- branchResult{PwaCode: pwa, BranchName: name, Zone: z, Layers: map[string]int64{}, Total: 0}
-				return
-			}
-			var total int64
-			for _, cnt := range layers {
-				total += cnt
-			}
-			pipeLong, _ := services.SumPipeLength(pwa, startDate, endDate)
-			pipeLongExSleeve, _ := services.SumPipeLengthExcludingSleeve(pwa, startDate, endDate)
-			activeMeter, _ := services.CountActiveMeters(pwa, startDate, endDate)
-			results <- branchResult{PwaCode: pwa, BranchName: name, Zone: z, Layers: layers, Total: total, PipeLong: pipeLong, PipeLongExSleeve: pipeLongExSleeve, ActiveMeter: activeMeter}
-		}(o.PwaCode, o.Name, o.Zone)
-	}
-
-	// Collect results
-	var allResults []branchResult
-	for i := 0; i < len(officeList); i++ {
-		r := <-results
-		allResults = append(allResults, r)
-	}
-
-	// Sort by zone (numeric) then pwaCode
-	sort.Slice(allResults, func(i, j int) bool {
-		zi, _ := strconv.Atoi(allResults[i].Zone)
-		zj, _ := strconv.Atoi(allResults[j].Zone)
-		if zi != zj {
-			return zi < zj
-		}
-		return allResults[i].PwaCode < allResults[j].PwaCode
-	})
-
-	// Aggregate totals per zone
-	zoneTotals := make(map[string]map[string]int64)
-	zoneNames := []string{}
-	seen := map[string]bool{}
-	for _, r := range allResults {
-		if !seen[r.Zone] {
-			seen[r.Zone] = true
-			zoneNames = append(zoneNames, r.Zone)
-		}
-		if _, ok := zoneTotals[r.Zone]; !ok {
-			zoneTotals[r.Zone] = make(map[string]int64)
-		}
-		for layer, cnt := range r.Layers {
-			zoneTotals[r.Zone][layer] += cnt
-		}
-		zoneTotals[r.Zone]["_total"] += r.Total
-		zoneTotals[r.Zone]["_branches"]++
-	}
-
-	// Sort zone names numerically
-	sort.Slice(zoneNames, func(i, j int) bool {
-		a, _ := strconv.Atoi(zoneNames[i])
-		b, _ := strconv.Atoi(zoneNames[j])
-		return a < b
-	})
-
-	// Compute grand totals across all zones
-	grandTotal := make(map[string]int64)
-	for _, zt := range zoneTotals {
-		for k, v := range zt {
-			grandTotal[k] += v
-		}
+// writeDashboard sends a cached zone dashboard, narrowed to the user's own
+// branches for branch-level users (the cache always holds the whole zone).
+func writeDashboard(c *gin.Context, raw json.RawMessage) {
+	if currentScope(c).Level != permLevelBranch {
+		c.Data(http.StatusOK, "application/json; charset=utf-8", raw)
+		return
 	}
-
-	response := gin.H{
-		"status":         "success",
-		"branches":       allResults,
-		"zone_totals":    zoneTotals,
-		"grand_total":    grandTotal,
-		"zone_names":     zoneNames,
-		"total_branches": len(allResults),
+	var summary services.DashboardSummary
+	if err := json.Unmarshal(raw, &summary); err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": err.Error()})
+		return
 	}
-
-	// Store in cache for subsequent requests
-	SetCachedDashboard(cacheKey, response)
-
-	c.JSON(http.StatusOK, response)
-}
-
-// InvalidateCache clears the dashboard cache (force refresh on next load).
-// GET /api/cache/invalidate
-func InvalidateCache(c *gin.Context) {
-	InvalidateDashboardCache()
-	c.JSON(http.StatusOK, gin.H{"status": "success", "message": "Cache invalidated"})
+	c.JSON(http.StatusOK, scopeDashboard(c, &summary))
 }
 
 // ExportExcel generates and downloads an Excel summary report.
 // GET /api/export/excel?zone=xxx&startDate=xxx&endDate=xxx
 func ExportExcel(c *gin.Context) {
-	zone := c.Query("zone")
+	defer observeExport(c, "xlsx", time.Now())
+	zone, ok := authorizeZone(c, c.Query("zone"))
+	if !ok {
+		return
+	}
+	if !authorizeCapability(c, services.CapExportExcel) {
+		return
+	}
 	startDate := c.Query("startDate")
 	endDate := c.Query("endDate")
 
-	var officeList []struct {
-		PwaCode string
-		Name    string
-		Zone    string
-	}
-
-	if zone != "" {
-		rawOffices, err := services.GetOfficesByZone(zone)
-		if err != nil {
-			c.JSON(http.StatusInternalServerError, gin.H{"error": err.Error()})
-			return
-		}
-		for _, o := range rawOffices {
-			officeList = append(officeList, struct {
-				PwaCode string
-				Name    string
-				Zone    string
-			}{o.PwaCode, o.Name, o.Zone})
-		}
-	} else {
-		rawOffices, err := services.GetAllOffices()
-		if err != nil {
-			c.JSON(http.StatusInternalServerError, gin.H{"error": err.Error()})
-			return
-		}
-		for _, o := range rawOffices {
-			officeList = append(officeList, struct {
-				PwaCode string
-				Name    string
-				Zone    string
-			}{o.PwaCode, o.Name, o.Zone})
-		}
+	// Same numbers as the dashboard, from the cache when available
+	summary, err := loadDashboardSummary(zone, startDate, endDate)
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": err.Error()})
+		return
 	}
+	summary = scopeDashboard(c, summary)
 
 	layers := services.GetAllLayerNames()
 
@@ -372,45 +269,12 @@ func ExportExcel(c *gin.Context) {
 	lastCol, _ := excelize.CoordinatesToCellName(len(headers), 1)
 	f.SetCellStyle(sheet, "A1", lastCol, style)
 
-	// Fetch data concurrently
-	sem := make(chan struct{}, 10)
-	type rowData struct {
-		Index    int
-		PwaCode  string
-		Name     string
-		Zone     string
-		Layers   map[string]int64
-		Total    int64
-		PipeLong float64
-	}
-
-	rowChan := make(chan rowData, len(officeList))
-	for i, o := range officeList {
-		sem <- struct{}{}
-		go func(idx int, pwa, name, z string) {
-			defer func() { <-sem }()
-			layerCounts, _ := services.CountAllLayersForBranch(pwa, startDate, endDate)
-			var total int64
-			for _, cnt := range layerCounts {
-				total += cnt
-			}
-			pipeLong, _ := services.SumPipeLength(pwa, startDate, endDate)
-			rowChan <- rowData{Index: idx, PwaCode: pwa, Name: name, Zone: z, Layers: layerCounts, Total: total, PipeLong: pipeLong}
-		}(i, o.PwaCode, o.Name, o.Zone)
-	}
-
-	rows := make([]rowData, len(officeList))
-	for i := 0; i < len(officeList); i++ {
-		r := <-rowChan
-		rows[r.Index] = r
-	}
-
 	// Write data rows (pipe_long inserted after pipe layer column)
-	for i, r := range rows {
+	for i, r := range summary.Branches {
 		row := i + 2
 		f.SetCellValue(sheet, fmt.Sprintf("A%d", row), i+1)
 		f.SetCellValue(sheet, fmt.Sprintf("B%d", row), r.PwaCode)
-		f.SetCellValue(sheet, fmt.Sprintf("C%d", row), r.Name)
+		f.SetCellValue(sheet, fmt.Sprintf("C%d", row), r.BranchName)
 		f.SetCellValue(sheet, fmt.Sprintf("D%d", row), r.Zone)
 
 		col := 5 // Start at column E
@@ -444,7 +308,8 @@ func ExportExcel(c *gin.Context) {
 }
 
 // DebugCollection returns debug info about a MongoDB collection for a branch.
-// GET /api/debug/collection?pwaCode=xxx&layer=xxx
+// Admin only: the response names the MongoDB database.
+// GET /api/admin/debug/collection?pwaCode=xxx&layer=xxx
 func DebugCollection(c *gin.Context) {
 	pwaCode := c.Query("pwaCode")
 	layer := c.DefaultQuery("layer", "pipe")
@@ -452,6 +317,9 @@ func DebugCollection(c *gin.Context) {
 		c.JSON(http.StatusBadRequest, gin.H{"error": "pwaCode is required"})
 		return
 	}
+	if !authorizeBranches(c, pwaCode) {
+		return
+	}
 	dbName := config.MongoDBName
 	alias := fmt.Sprintf("b%s_%s", pwaCode, layer)
 	collectionID, err := services.FindCollectionID(pwaCode, layer)
@@ -467,6 +335,11 @@ func DebugCollection(c *gin.Context) {
 	c.JSON(http.StatusOK, result)
 }
 
+// geoExportFormats are the formats accepted by ExportGeoData.
+var geoExportFormats = map[string]bool{
+	"geojson": true, "gpkg": true, "shp": true, "fgb": true, "tab": true, "pmtiles": true, "mbtiles": true,
+}
+
 // ExportGeoData exports features as GeoJSON (or other formats) for download.
 // GET /api/export/geodata?pwaCode=xxx&collection=xxx&format=geojson&startDate=xxx&endDate=xxx
 // Supports comma-separated pwaCode and collection for merge export:
@@ -481,6 +354,11 @@ func ExportGeoData(c *gin.Context) {
 	endDate := c.Query("endDate")
 	format := c.DefaultQuery("format", "geojson")
 	mergeMode := c.Query("merge")
+	if !geoExportFormats[format] {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "Unsupported format: " + format})
+		return
+	}
+	defer observeExport(c, format, time.Now())
 
 	if pwaCodeParam == "" || collectionParam == "" {
 		c.JSON(http.StatusBadRequest, gin.H{"error": "pwaCode and collection are required"})
@@ -490,6 +368,16 @@ func ExportGeoData(c *gin.Context) {
 	// Parse comma-separated values
 	pwaCodes := splitAndTrim(pwaCodeParam)
 	collections := splitAndTrim(collectionParam)
+	if len(pwaCodes) == 0 || len(collections) == 0 {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "pwaCode and collection are required"})
+		return
+	}
+	if !authorizeBranches(c, pwaCodes...) {
+		return
+	}
+	if !authorizeCapability(c, exportCapability(format), collections...) {
+		return
+	}
 
 	// Validate all collection names
 	validLayers := services.GetAllLayerNames()
@@ -519,7 +407,7 @@ func ExportGeoData(c *gin.Context) {
 
 	switch format {
 	case "geojson":
-		geojsonData, err := services.ExportFeaturesAsGeoJSON(pwaCode, collection, startDate, endDate)
+		geojsonData, err := services.ExportFeaturesAsGeoJSON(pwaCode, collection, startDate, endDate, piiMask(c))
 		if err != nil {
 			c.JSON(http.StatusInternalServerError, gin.H{"error": err.Error()})
 			return
@@ -530,7 +418,7 @@ func ExportGeoData(c *gin.Context) {
 		c.Data(http.StatusOK, "application/geo+json", geojsonData)
 
 	case "tab":
-		tabData, tabErr := services.ExportAsMapInfoTAB(pwaCode, collection, startDate, endDate)
+		tabData, tabErr := services.ExportAsMapInfoTAB(pwaCode, collection, startDate, endDate, piiMask(c))
 		if tabErr != nil {
 			c.JSON(http.StatusInternalServerError, gin.H{"error": "TAB export failed: " + tabErr.Error()})
 			return
@@ -541,7 +429,7 @@ func ExportGeoData(c *gin.Context) {
 		c.Data(http.StatusOK, "application/zip", tabData)
 
 	case "pmtiles":
-		pmData, pmErr := services.ExportAsPMTiles(pwaCode, collection, startDate, endDate)
+		pmData, pmErr := services.ExportAsPMTiles(pwaCode, collection, startDate, endDate, piiMask(c))
 		if pmErr != nil {
 			c.JSON(http.StatusInternalServerError, gin.H{"error": "PMTiles export failed: " + pmErr.Error()})
 			return
@@ -551,7 +439,7 @@ func ExportGeoData(c *gin.Context) {
 		c.Data(http.StatusOK, "application/octet-stream", pmData)
 
 	case "gpkg":
-		gpkgData, gpkgErr := services.ExportAsGeoPackage(pwaCode, collection, startDate, endDate)
+		gpkgData, gpkgErr := services.ExportAsGeoPackage(pwaCode, collection, startDate, endDate, piiMask(c))
 		if gpkgErr != nil {
 			c.JSON(http.StatusInternalServerError, gin.H{"error": "GPKG export failed: " + gpkgErr.Error()})
 			return
@@ -562,7 +450,7 @@ func ExportGeoData(c *gin.Context) {
 		c.Data(http.StatusOK, "application/geopackage+sqlite3", gpkgData)
 
 	case "shp":
-		shpData, shpErr := services.ExportAsShapefile(pwaCode, collection, startDate, endDate)
+		shpData, shpErr := services.ExportAsShapefile(pwaCode, collection, startDate, endDate, piiMask(c))
 		if shpErr != nil {
 			c.JSON(http.StatusInternalServerError, gin.H{"error": "Shapefile export failed: " + shpErr.Error()})
 			return
@@ -573,7 +461,7 @@ func ExportGeoData(c *gin.Context) {
 		c.Data(http.StatusOK, "application/zip", shpData)
 
 	case "fgb":
-		fgbData, fgbErr := services.ExportAsFlatGeobuf(pwaCode, collection, startDate, endDate)
+		fgbData, fgbErr := services.ExportAsFlatGeobuf(pwaCode, collection, startDate, endDate, piiMask(c))
 		if fgbErr != nil {
 			c.JSON(http.StatusInternalServerError, gin.H{"error": "FlatGeobuf export failed: " + fgbErr.Error()})
 			return
@@ -584,7 +472,7 @@ func ExportGeoData(c *gin.Context) {
 		c.Data(http.StatusOK, "application/octet-stream", fgbData)
 
 	case "mbtiles":
-		pmData, pmErr := services.ExportAsPMTiles(pwaCode, collection, startDate, endDate)
+		pmData, pmErr := services.ExportAsPMTiles(pwaCode, collection, startDate, endDate, piiMask(c))
 		if pmErr != nil {
 			c.JSON(http.StatusInternalServerError, gin.H{"error": "MBTiles export failed: " + pmErr.Error()})
 			return
@@ -598,7 +486,7 @@ func ExportGeoData(c *gin.Context) {
 
 // exportMerged handles merged export for multiple pwaCodes/collections.
 func exportMerged(c *gin.Context, pwaCodes, collections []string, format, startDate, endDate, mergeMode string) {
-	geojsonData, err := services.ExportMergedFeaturesAsGeoJSON(pwaCodes, collections, startDate, endDate)
+	geojsonData, err := services.ExportMergedFeaturesAsGeoJSON(pwaCodes, collections, startDate, endDate, piiMask(c))
 	if err != nil {
 		c.JSON(http.StatusInternalServerError, gin.H{"error": "Merge export failed: " + err.Error()})
 		return
@@ -728,6 +616,12 @@ func GetFeaturesForMap(c *gin.Context) {
 		c.JSON(http.StatusBadRequest, gin.H{"error": "pwaCode and collection are required"})
 		return
 	}
+	if !authorizeBranches(c, pwaCode) {
+		return
+	}
+	if !authorizeCapability(c, services.CapViewMap, collection) {
+		return
+	}
 
 	// Validate collection name
 	validLayers := services.GetAllLayerNames()
@@ -779,8 +673,14 @@ func GetFeatureProps(c *gin.Context) {
 		c.JSON(http.StatusBadRequest, gin.H{"error": "pwaCode, collection, and featureId are required"})
 		return
 	}
+	if !authorizeBranches(c, pwaCode) {
+		return
+	}
+	if !authorizeCapability(c, services.CapViewAttributes, collection) {
+		return
+	}
 
-	props, err := services.GetFeatureProperties(pwaCode, collection, featureID)
+	props, err := services.GetFeatureProperties(pwaCode, collection, featureID, piiMask(c))
 	if err != nil {
 		c.JSON(http.StatusInternalServerError, gin.H{"error": err.Error()})
 		return
@@ -0,0 +1,302 @@
+package handlers
+
+import (
+	"database/sql"
+	"fmt"
+	"net/http"
+	"strconv"
+	"strings"
+
+	"pwa_gis_tracking/config"
+	"pwa_gis_tracking/services"
+
+	"github.com/gin-gonic/gin"
+)
+
+// ========================================================================
+// API Tokens (bearer auth for machine clients / scheduled ETL jobs)
+//
+// Personal tokens — any logged-in user, scope must fit inside their own:
+//   GET    /api/tokens
+//   POST   /api/tokens
+//   DELETE /api/tokens/:id
+//
+// Service tokens and cross-user management — admin only:
+//   GET    /api/admin/tokens
+//   POST   /api/admin/tokens
+//   DELETE /api/admin/tokens/:id
+//
+// Tokens cannot be used to manage tokens (no self-escalation).
+// Requests made with a token are audit-logged with auth_method=token:<id>.
+// ========================================================================
+
+// bearerToken extracts the token from "Authorization: Bearer <token>".
+func bearerToken(c *gin.Context) (string, bool) {
+	h := c.GetHeader("Authorization")
+	if len(h) < 7 || !strings.EqualFold(h[:7], "bearer ") {
+		return "", false
+	}
+	token := strings.TrimSpace(h[7:])
+	return token, token != ""
+}
+
+// authenticateAPIToken validates a bearer token and exposes its scope with the
+// same context keys AuthRequired sets for a browser session.
+func authenticateAPIToken(c *gin.Context, plain string) {
+	tok, err := services.LookupAPIToken(plain)
+	if err != nil {
+		c.AbortWithStatusJSON(http.StatusUnauthorized, gin.H{
+			"status":  "error",
+			"error":   "invalid_token",
+			"reason":  err.Error(),
+			"message": "API token ไม่ถูกต้องหรือหมดอายุ",
+		})
+		return
+	}
+
+	pwaCode := ""
+	if len(tok.PwaCodes) > 0 {
+		pwaCode = tok.PwaCodes[0]
+	}
+
+	c.Set("uid", tok.OwnerUserID)
+	c.Set("uname", "token:"+tok.Name)
+	c.Set("pwacode", pwaCode)
+	c.Set("pwa_codes", tok.PwaCodes)
+	c.Set("permission", "leak")
+	c.Set("permission_leak", tok.PermissionLevel)
+	c.Set("area", tok.Zone)
+	c.Set("auth_method", fmt.Sprintf("token:%d", tok.ID))
+	c.Set("api_token_id", tok.ID)
+
+	go services.TouchAPIToken(tok.ID, c.ClientIP())
+
+	c.Next()
+}
+
+// rejectTokenAuth aborts when the request itself was authenticated by a token.
+func rejectTokenAuth(c *gin.Context) bool {
+	if _, ok := c.Get("api_token_id"); ok {
+		c.AbortWithStatusJSON(http.StatusForbidden, gin.H{
+			"status":  "error",
+			"error":   "forbidden",
+			"reason":  "API tokens cannot manage tokens",
+			"message": "ไม่สามารถจัดการ token ด้วย token ได้",
+		})
+		return true
+	}
+	return false
+}
+
+// ─── Personal tokens ─────────────────────────────────────────────────────────
+
+// ListMyAPITokens returns the caller's own tokens.
+// GET /api/tokens
+func ListMyAPITokens(c *gin.Context) {
+	if rejectTokenAuth(c) {
+		return
+	}
+	uid, _ := c.Get("uid")
+	tokens, err := services.ListAPITokens(strOrEmpty(uid))
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": err.Error()})
+		return
+	}
+	c.JSON(http.StatusOK, gin.H{"status": "success", "data": tokens})
+}
+
+// IssueMyAPIToken issues a personal token whose scope fits inside the caller's.
+// POST /api/tokens
+func IssueMyAPIToken(c *gin.Context) {
+	if rejectTokenAuth(c) || rejectImpersonation(c) {
+		return
+	}
+	var req services.IssueAPITokenRequest
+	if err := c.ShouldBindJSON(&req); err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "Invalid request: " + err.Error()})
+		return
+	}
+
+	uid, _ := c.Get("uid")
+	req.TokenType = services.TokenTypePersonal
+	req.OwnerUserID = strOrEmpty(uid)
+	req.Owner = sessionTokenOwner(c)
+
+	if reason := currentScope(c).checkTokenScope(req); reason != "" {
+		denyAccess(c, reason)
+		return
+	}
+	issueAPIToken(c, req)
+}
+
+// RevokeMyAPIToken revokes one of the caller's own tokens.
+// DELETE /api/tokens/:id
+func RevokeMyAPIToken(c *gin.Context) {
+	if rejectTokenAuth(c) || rejectImpersonation(c) {
+		return
+	}
+	id, err := strconv.Atoi(c.Param("id"))
+	if err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "invalid token id"})
+		return
+	}
+
+	tok, err := services.GetAPIToken(id)
+	uid, _ := c.Get("uid")
+	if err == sql.ErrNoRows || (err == nil && tok.OwnerUserID != strOrEmpty(uid)) {
+		c.JSON(http.StatusNotFound, gin.H{"error": "token not found"})
+		return
+	}
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": err.Error()})
+		return
+	}
+	revokeAPIToken(c, id)
+}
+
+// ─── Admin ───────────────────────────────────────────────────────────────────
+
+// AdminListAPITokens returns all tokens, optionally filtered by ?owner=.
+// GET /api/admin/tokens
+func AdminListAPITokens(c *gin.Context) {
+	if rejectTokenAuth(c) {
+		return
+	}
+	tokens, err := services.ListAPITokens(c.Query("owner"))
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": err.Error()})
+		return
+	}
+	c.JSON(http.StatusOK, gin.H{"status": "success", "data": tokens})
+}
+
+// AdminIssueAPIToken issues a personal or service token with any scope.
+// POST /api/admin/tokens
+func AdminIssueAPIToken(c *gin.Context) {
+	if rejectTokenAuth(c) {
+		return
+	}
+	var req services.IssueAPITokenRequest
+	if err := c.ShouldBindJSON(&req); err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "Invalid request: " + err.Error()})
+		return
+	}
+	uid, _ := c.Get("uid")
+	if req.OwnerUserID == "" {
+		req.OwnerUserID = strOrEmpty(uid)
+	}
+	if req.OwnerUserID == strOrEmpty(uid) {
+		req.Owner = sessionTokenOwner(c)
+	} else {
+		// Another owner's profile comes from their latest login; without
+		// one only user_id rules can keep the token authorised
+		profile, err := services.LookupUserProfile(req.OwnerUserID)
+		if err != nil && err != services.ErrNoLoginHistory {
+			c.JSON(http.StatusInternalServerError, gin.H{"error": err.Error()})
+			return
+		}
+		req.Owner = services.TokenOwner{
+			DepName: profile.DepName,
+			DivName: profile.DivName,
+			JobName: profile.JobName,
+			Area:    profile.Zone,
+			PwaCode: profile.PwaCode,
+		}
+	}
+	issueAPIToken(c, req)
+}
+
+// AdminRevokeAPIToken revokes any token.
+// DELETE /api/admin/tokens/:id
+func AdminRevokeAPIToken(c *gin.Context) {
+	if rejectTokenAuth(c) {
+		return
+	}
+	id, err := strconv.Atoi(c.Param("id"))
+	if err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "invalid token id"})
+		return
+	}
+	revokeAPIToken(c, id)
+}
+
+// ─── Shared ──────────────────────────────────────────────────────────────────
+
+func issueAPIToken(c *gin.Context, req services.IssueAPITokenRequest) {
+	uid, _ := c.Get("uid")
+	tok, plain, err := services.IssueAPIToken(req, strOrEmpty(uid))
+	if err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+		return
+	}
+
+	LogAuditEvent(c, "api_token_issue", "api_token",
+		fmt.Sprintf("id=%d,name=%s,type=%s,owner=%s,level=%s,zone=%s,pwa=[%s],expires=%s",
+			tok.ID, tok.Name, tok.TokenType, tok.OwnerUserID, tok.PermissionLevel,
+			tok.Zone, strings.Join(tok.PwaCodes, ","), tok.ExpiresAt.Format("2006-01-02")))
+
+	c.JSON(http.StatusCreated, gin.H{
+		"status":  "success",
+		"data":    tok,
+		"token":   plain,
+		"message": "เก็บ token นี้ไว้ให้ปลอดภัย — จะแสดงเพียงครั้งเดียว",
+	})
+}
+
+func revokeAPIToken(c *gin.Context, id int) {
+	uid, _ := c.Get("uid")
+	err := services.RevokeAPIToken(id, strOrEmpty(uid))
+	if err == sql.ErrNoRows {
+		c.JSON(http.StatusNotFound, gin.H{"error": "token not found"})
+		return
+	}
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": err.Error()})
+		return
+	}
+	LogAuditEvent(c, "api_token_revoke", "api_token", fmt.Sprintf("id=%d", id))
+	c.JSON(http.StatusOK, gin.H{"status": "success", "message": "Token revoked"})
+}
+
+// sessionTokenOwner captures the caller's intranet profile from the session,
+// so the token owner's permission can be re-resolved on every use.
+func sessionTokenOwner(c *gin.Context) services.TokenOwner {
+	session, err := config.Store.Get(c.Request, config.SessionName)
+	if err != nil {
+		return services.TokenOwner{}
+	}
+	str := func(key string) string {
+		v, _ := session.Values[key].(string)
+		return v
+	}
+	return services.TokenOwner{
+		DepName: str(sessInsitution),
+		DivName: str(sessDivision),
+		JobName: str(sessJobName),
+		Area:    str(sessArea),
+		PwaCode: str(sessPwaCode),
+	}
+}
+
+// checkTokenScope returns a reason when the requested token scope is wider
+// than the caller's own scope.
+func (s accessScope) checkTokenScope(req services.IssueAPITokenRequest) string {
+	switch req.PermissionLevel {
+	case permLevelAll:
+		if s.Level != permLevelAll {
+			return "cannot issue a token wider than your own permission"
+		}
+		return ""
+	case permLevelReg:
+		if s.Level == permLevelAll {
+			return ""
+		}
+		if s.Level == permLevelReg && req.Zone == s.Zone {
+			return ""
+		}
+		return fmt.Sprintf("zone %s is outside your scope", req.Zone)
+	case permLevelBranch:
+		return s.checkBranches(req.PwaCodes)
+	}
+	return "invalid permission_level: " + req.PermissionLevel
+}
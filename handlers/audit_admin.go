@@ -0,0 +1,246 @@
+package handlers
+
+import (
+	"bytes"
+	"encoding/csv"
+	"fmt"
+	"net/http"
+	"strconv"
+	"strings"
+	"time"
+
+	"pwa_gis_tracking/services"
+
+	"github.com/gin-gonic/gin"
+	"github.com/xuri/excelize/v2"
+)
+
+// ========================================================================
+// Audit Log Search & Reports (admin only)
+//
+//   GET /api/admin/audit/logs?user=&action=&pwaCode=&targetType=&target=&status=&startDate=&endDate=&page=&pageSize=
+//   GET /api/admin/audit/reports/:kind?<same filters>&limit=
+//       kind = exports_per_user | top_branches | failed_requests
+//
+// action accepts a comma list and "export_*" prefixes; status accepts a
+// code, 4xx, 5xx or failed; dates are YYYY-MM-DD (endDate inclusive).
+// Add format=csv or format=xlsx to download the whole result instead of
+// a JSON page (capped at services.MaxAuditExportRows).
+// ========================================================================
+
+// auditFilterFromQuery parses the shared filter parameters.
+func auditFilterFromQuery(c *gin.Context) (services.AuditLogFilter, error) {
+	f := services.AuditLogFilter{
+		UserID:     strings.TrimSpace(c.Query("user")),
+		PwaCode:    strings.TrimSpace(c.Query("pwaCode")),
+		TargetType: strings.TrimSpace(c.Query("targetType")),
+		Target:     strings.TrimSpace(c.Query("target")),
+		Status:     strings.TrimSpace(c.Query("status")),
+	}
+	if actions := c.Query("action"); actions != "" {
+		f.Actions = splitAndTrim(actions)
+	}
+	if err := services.ValidateAuditStatus(f.Status); err != nil {
+		return f, err
+	}
+	if s := c.Query("startDate"); s != "" {
+		t, err := time.ParseInLocation("2006-01-02", s, time.Local)
+		if err != nil {
+			return f, fmt.Errorf("invalid startDate: %s (YYYY-MM-DD)", s)
+		}
+		f.From = t
+	}
+	if s := c.Query("endDate"); s != "" {
+		t, err := time.ParseInLocation("2006-01-02", s, time.Local)
+		if err != nil {
+			return f, fmt.Errorf("invalid endDate: %s (YYYY-MM-DD)", s)
+		}
+		f.To = t.AddDate(0, 0, 1)
+	}
+	return f, nil
+}
+
+// SearchAuditLogs pages through the audit log, or downloads it.
+// GET /api/admin/audit/logs
+func SearchAuditLogs(c *gin.Context) {
+	f, err := auditFilterFromQuery(c)
+	if err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+		return
+	}
+
+	format := c.Query("format")
+	if format == "csv" || format == "xlsx" {
+		list, _, err := services.SearchAuditLogs(f, 0, 0)
+		if err != nil {
+			c.JSON(http.StatusInternalServerError, gin.H{"error": err.Error()})
+			return
+		}
+		headers := []string{"id", "created_at", "user_id", "user_name", "pwa_code", "permission_level",
+			"action", "target_type", "target_value", "response_status", "duration_ms",
+			"request_method", "request_path", "ip_address", "auth_method", "impersonator_id", "user_agent"}
+		rows := make([][]interface{}, len(list))
+		for i, r := range list {
+			rows[i] = []interface{}{r.ID, r.CreatedAt.Format("2006-01-02 15:04:05"), r.UserID, r.UserName,
+				r.PwaCode, r.PermissionLevel, r.Action, r.TargetType, r.TargetValue,
+				r.ResponseStatus, r.DurationMs, r.RequestMethod, r.RequestPath, r.IP,
+				r.AuthMethod, r.ImpersonatorID, r.UserAgent}
+		}
+		LogAuditEvent(c, "audit_log_download", "audit", c.Request.URL.RawQuery)
+		writeTabular(c, format, "audit_log", "Audit Log", headers, rows)
+		return
+	}
+
+	page, _ := strconv.Atoi(c.DefaultQuery("page", "1"))
+	pageSize, _ := strconv.Atoi(c.DefaultQuery("pageSize", "50"))
+	if page < 1 {
+		page = 1
+	}
+	if pageSize < 1 || pageSize > 500 {
+		pageSize = 50
+	}
+
+	list, total, err := services.SearchAuditLogs(f, page, pageSize)
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": err.Error()})
+		return
+	}
+	totalPages := int((total + int64(pageSize) - 1) / int64(pageSize))
+	if totalPages == 0 {
+		totalPages = 1
+	}
+
+	c.JSON(http.StatusOK, gin.H{
+		"status":      "success",
+		"data":        list,
+		"page":        page,
+		"page_size":   pageSize,
+		"total":       total,
+		"total_pages": totalPages,
+	})
+}
+
+// auditReportHeaders are the column titles of each report download.
+var auditReportHeaders = map[string][]string{
+	services.AuditReportExportsPerUser: {"user_id", "user_name", "formats", "exports", "targets", "last_export"},
+	services.AuditReportTopBranches:    {"pwa_code", "", "", "views", "users", "last_view"},
+	services.AuditReportFailedRequests: {"request", "action", "status_codes", "failures", "users", "last_failure"},
+}
+
+// GetAuditReport returns one aggregate report over the filtered audit log.
+// GET /api/admin/audit/reports/:kind
+func GetAuditReport(c *gin.Context) {
+	kind := c.Param("kind")
+	headers, ok := auditReportHeaders[kind]
+	if !ok {
+		c.JSON(http.StatusNotFound, gin.H{
+			"error": "unknown report: " + kind + ". Valid: " + strings.Join(services.AuditReportKinds, ", "),
+		})
+		return
+	}
+	f, err := auditFilterFromQuery(c)
+	if err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+		return
+	}
+
+	format := c.Query("format")
+	limit, _ := strconv.Atoi(c.DefaultQuery("limit", "50"))
+	if format == "csv" || format == "xlsx" {
+		limit = 0
+	}
+
+	list, err := services.AuditReport(kind, f, limit)
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": err.Error()})
+		return
+	}
+
+	if format == "csv" || format == "xlsx" {
+		// Drop the columns a report does not use
+		var keep []int
+		var cols []string
+		for i, h := range headers {
+			if h != "" {
+				keep = append(keep, i)
+				cols = append(cols, h)
+			}
+		}
+		rows := make([][]interface{}, len(list))
+		for i, r := range list {
+			all := []interface{}{r.Key, r.Label, r.Detail, r.Count, r.Distinct, r.LastAt.Format("2006-01-02 15:04:05")}
+			row := make([]interface{}, len(keep))
+			for j, k := range keep {
+				row[j] = all[k]
+			}
+			rows[i] = row
+		}
+		LogAuditEvent(c, "audit_report_download", "audit", kind)
+		writeTabular(c, format, "audit_"+kind, kind, cols, rows)
+		return
+	}
+
+	c.JSON(http.StatusOK, gin.H{"status": "success", "report": kind, "data": list, "total": len(list)})
+}
+
+// csvSafe prefixes text that a spreadsheet would run as a formula with '.
+// Audit values are user-controlled (paths, user agents, query text).
+func csvSafe(s string) string {
+	if s != "" && strings.ContainsRune("=+-@\t\r", rune(s[0])) {
+		return "'" + s
+	}
+	return s
+}
+
+// writeTabular sends rows as a CSV (UTF-8 with BOM for Excel) or .xlsx download.
+func writeTabular(c *gin.Context, format, filename, sheet string, headers []string, rows [][]interface{}) {
+	if format == "csv" {
+		var buf bytes.Buffer
+		buf.Write([]byte{0xEF, 0xBB, 0xBF})
+		w := csv.NewWriter(&buf)
+		w.Write(headers)
+		record := make([]string, len(headers))
+		for _, row := range rows {
+			for i, v := range row {
+				if str, ok := v.(string); ok {
+					record[i] = csvSafe(str)
+				} else {
+					record[i] = fmt.Sprintf("%v", v)
+				}
+			}
+			w.Write(record)
+		}
+		w.Flush()
+		c.Header("Content-Disposition", fmt.Sprintf("attachment; filename=%s.csv", filename))
+		c.Data(http.StatusOK, "text/csv; charset=utf-8", buf.Bytes())
+		return
+	}
+
+	f := excelize.NewFile()
+	defer f.Close()
+	f.SetSheetName("Sheet1", sheet)
+	for i, h := range headers {
+		cell, _ := excelize.CoordinatesToCellName(i+1, 1)
+		f.SetCellValue(sheet, cell, h)
+	}
+	style, _ := f.NewStyle(&excelize.Style{
+		Font: &excelize.Font{Bold: true, Color: "FFFFFF"},
+		Fill: excelize.Fill{Type: "pattern", Color: []string{"1B4F72"}, Pattern: 1},
+	})
+	lastCol, _ := excelize.CoordinatesToCellName(len(headers), 1)
+	f.SetCellStyle(sheet, "A1", lastCol, style)
+	for r, row := range rows {
+		for i, v := range row {
+			cell, _ := excelize.CoordinatesToCellName(i+1, r+2)
+			f.SetCellValue(sheet, cell, v)
+		}
+	}
+
+	buf, err := f.WriteToBuffer()
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Excel export failed: " + err.Error()})
+		return
+	}
+	c.Header("Content-Disposition", fmt.Sprintf("attachment; filename=%s.xlsx", filename))
+	c.Data(http.StatusOK, "application/vnd.openxmlformats-officedocument.spreadsheetml.sheet", buf.Bytes())
+}
@@ -0,0 +1,161 @@
+package handlers
+
+import (
+	"fmt"
+	"net/http"
+	"strconv"
+
+	"pwa_gis_tracking/services"
+
+	"github.com/gin-gonic/gin"
+)
+
+// ========================================================================
+// Audit Log Archival (admin only, see services/audit_archive.go)
+//
+//   GET  /api/admin/audit/archive/preview?retentionDays=180   rows that would move
+//   POST /api/admin/audit/archive/run       {"retention_days":180,"mode":"table","dry_run":false}
+//   GET  /api/admin/audit/archive/schedule
+//   PUT  /api/admin/audit/archive/schedule  {"enabled":true,"run_at":"02:30","retention_days":180,"mode":"file"}
+//   GET  /api/admin/audit/archive/runs?limit=20
+//
+// retention_days / mode default to the saved schedule.
+// ========================================================================
+
+// PreviewAuditArchive lists the months that an archival run would move.
+// GET /api/admin/audit/archive/preview
+func PreviewAuditArchive(c *gin.Context) {
+	sched, err := services.GetArchiveSchedule()
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": err.Error()})
+		return
+	}
+	days := sched.RetentionDays
+	if s := c.Query("retentionDays"); s != "" {
+		if days, err = strconv.Atoi(s); err != nil || days < services.MinAuditRetentionDays {
+			c.JSON(http.StatusBadRequest, gin.H{"error": fmt.Sprintf("retentionDays must be a number >= %d", services.MinAuditRetentionDays)})
+			return
+		}
+	}
+	respondArchivePreview(c, days, sched.Mode)
+}
+
+func respondArchivePreview(c *gin.Context, days int, mode string) {
+	cutoff := services.ArchiveCutoff(days)
+	months, err := services.PreviewAuditArchive(cutoff)
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": err.Error()})
+		return
+	}
+	var total int64
+	for _, m := range months {
+		total += m.Rows
+	}
+	c.JSON(http.StatusOK, gin.H{
+		"status":         "success",
+		"dry_run":        true,
+		"retention_days": days,
+		"mode":           mode,
+		"cutoff":         cutoff,
+		"months":         months,
+		"total_rows":     total,
+	})
+}
+
+// runAuditArchiveRequest is the body for POST /api/admin/audit/archive/run.
+type runAuditArchiveRequest struct {
+	RetentionDays int    `json:"retention_days"`
+	Mode          string `json:"mode"`
+	DryRun        bool   `json:"dry_run"`
+}
+
+// RunAuditArchive archives now (or previews with dry_run).
+// POST /api/admin/audit/archive/run
+func RunAuditArchive(c *gin.Context) {
+	var req runAuditArchiveRequest
+	if c.Request.ContentLength > 0 {
+		if err := c.ShouldBindJSON(&req); err != nil {
+			c.JSON(http.StatusBadRequest, gin.H{"error": "Invalid request: " + err.Error()})
+			return
+		}
+	}
+	sched, err := services.GetArchiveSchedule()
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": err.Error()})
+		return
+	}
+	if req.RetentionDays == 0 {
+		req.RetentionDays = sched.RetentionDays
+	}
+	if req.Mode == "" {
+		req.Mode = sched.Mode
+	}
+	if err := services.ValidateArchiveParams(req.RetentionDays, req.Mode); err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+		return
+	}
+	if req.DryRun {
+		respondArchivePreview(c, req.RetentionDays, req.Mode)
+		return
+	}
+
+	uid, _ := c.Get("uid")
+	run, err := services.RunAuditArchive(req.RetentionDays, req.Mode, "manual", strOrEmpty(uid))
+	if err == services.ErrArchiveRunning {
+		c.JSON(http.StatusConflict, gin.H{"error": err.Error()})
+		return
+	}
+	LogAuditEvent(c, "audit_archive_run", "audit",
+		fmt.Sprintf("retention_days=%d,mode=%s,moved=%d", req.RetentionDays, req.Mode, run.MovedRows))
+	if err != nil {
+		// Months before the failure were moved; report both
+		c.JSON(http.StatusInternalServerError, gin.H{"error": err.Error(), "data": run})
+		return
+	}
+	c.JSON(http.StatusOK, gin.H{"status": "success", "data": run})
+}
+
+// GetAuditArchiveSchedule returns the archival schedule.
+// GET /api/admin/audit/archive/schedule
+func GetAuditArchiveSchedule(c *gin.Context) {
+	sched, err := services.GetArchiveSchedule()
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": err.Error()})
+		return
+	}
+	c.JSON(http.StatusOK, gin.H{"status": "success", "data": sched})
+}
+
+// UpdateAuditArchiveSchedule replaces the archival schedule.
+// PUT /api/admin/audit/archive/schedule
+func UpdateAuditArchiveSchedule(c *gin.Context) {
+	var req services.ArchiveSchedule
+	if err := c.ShouldBindJSON(&req); err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "Invalid request: " + err.Error()})
+		return
+	}
+	uid, _ := c.Get("uid")
+	sched, err := services.SaveArchiveSchedule(req, strOrEmpty(uid))
+	if err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+		return
+	}
+	LogAuditEvent(c, "audit_archive_schedule", "audit",
+		fmt.Sprintf("enabled=%t,run_at=%s,retention_days=%d,mode=%s", sched.Enabled, sched.RunAt, sched.RetentionDays, sched.Mode))
+	c.JSON(http.StatusOK, gin.H{"status": "success", "data": sched})
+}
+
+// ListAuditArchiveRuns returns recent archival runs.
+// GET /api/admin/audit/archive/runs
+func ListAuditArchiveRuns(c *gin.Context) {
+	limit, _ := strconv.Atoi(c.DefaultQuery("limit", "20"))
+	if limit < 1 || limit > 200 {
+		limit = 20
+	}
+	list, err := services.ListArchiveRuns(limit)
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": err.Error()})
+		return
+	}
+	c.JSON(http.StatusOK, gin.H{"status": "success", "data": list, "total": len(list)})
+}
@@ -0,0 +1,22 @@
+package handlers
+
+import (
+	"net/http"
+
+	"pwa_gis_tracking/services"
+
+	"github.com/gin-gonic/gin"
+)
+
+// VerifyAuditChain walks the export audit hash chain and reports the first
+// broken link (see services/audit_chain.go). The same check runs from the
+// command line with "verify-audit-chain".
+// GET /api/admin/audit/chain/verify
+func VerifyAuditChain(c *gin.Context) {
+	rep, err := services.VerifyAuditChain(c.Request.Context())
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": err.Error()})
+		return
+	}
+	c.JSON(http.StatusOK, gin.H{"status": "success", "data": rep})
+}
@@ -6,6 +6,7 @@ import (
 	"time"
 
 	"pwa_gis_tracking/config"
+	"pwa_gis_tracking/services"
 
 	"github.com/gin-gonic/gin"
 )
@@ -49,34 +50,14 @@ func AuditLogMiddleware() gin.HandlerFunc {
 
 		duration := time.Since(start)
 
-		// Extract user info from gin context (set by AuthRequired middleware)
-		uid, _ := c.Get("uid")
-		uname, _ := c.Get("uname")
-		pwaCode, _ := c.Get("pwacode")
-		permLeak, _ := c.Get("permission_leak")
-
-		// Determine action from path
-		action := classifyAction(c.Request.Method, path, c.Query("format"))
-
-		// Determine target
-		targetType, targetValue := classifyTarget(c)
-
-		// Write to DB asynchronously (don't block response)
-		go insertAuditLog(
-			strOrEmpty(uid),
-			strOrEmpty(uname),
-			strOrEmpty(pwaCode),
-			strOrEmpty(permLeak),
-			action,
-			targetType,
-			targetValue,
-			c.ClientIP(),
-			c.Request.UserAgent(),
-			path,
-			c.Request.Method,
-			c.Writer.Status(),
-			int(duration.Milliseconds()),
-		)
+		entry := newAuditEntry(c)
+		entry.Action = classifyAction(c.Request.Method, path, c.Query("format"))
+		entry.TargetType, entry.TargetValue = classifyTarget(c)
+		entry.ResponseStatus = c.Writer.Status()
+		entry.DurationMs = int(duration.Milliseconds())
+
+		// Queue for the background writer (don't block response)
+		enqueueAudit(entry)
 	}
 }
 
@@ -88,60 +69,95 @@ func AuditLogMiddleware() gin.HandlerFunc {
 //	handlers.LogAuditEvent(c, "export_geojson", "export",
 //	    fmt.Sprintf("pwaCode=%s,collection=%s", pwaCode, collection))
 func LogAuditEvent(c *gin.Context, action, targetType, targetValue string) {
-	uid, _ := c.Get("uid")
-	uname, _ := c.Get("uname")
-	pwaCode, _ := c.Get("pwacode")
-	permLeak, _ := c.Get("permission_leak")
+	entry := newAuditEntry(c)
+	entry.Action = action
+	entry.TargetType = targetType
+	entry.TargetValue = capAuditValue(targetValue)
 
-	go insertAuditLog(
-		strOrEmpty(uid),
-		strOrEmpty(uname),
-		strOrEmpty(pwaCode),
-		strOrEmpty(permLeak),
-		action,
-		targetType,
-		targetValue,
-		c.ClientIP(),
-		c.Request.UserAgent(),
-		c.Request.URL.Path,
-		c.Request.Method,
-		0,
-		0,
-	)
+	enqueueAudit(entry)
 }
 
 // ────────────────────────────────────────────────────────────
 // Internal helpers
 // ────────────────────────────────────────────────────────────
 
-func insertAuditLog(
-	userID, userName, pwaCode, permLevel,
-	action, targetType, targetValue,
-	ip, userAgent, requestPath, requestMethod string,
-	responseStatus, durationMs int,
-) {
+// auditEntry is one row of audit_logs.pwagis_track_log.
+type auditEntry struct {
+	UserID         string
+	UserName       string
+	PwaCode        string
+	PermLevel      string
+	Action         string
+	TargetType     string
+	TargetValue    string
+	IP             string
+	UserAgent      string
+	RequestPath    string
+	RequestMethod  string
+	ResponseStatus int
+	DurationMs     int
+	AuthMethod     string // "session" or "token:<id>"
+	ImpersonatorID string // admin employee ID when acting as another user
+	CreatedAt      time.Time
+}
+
+// newAuditEntry fills the user and request fields from the gin context
+// (values set by AuthRequired middleware).
+func newAuditEntry(c *gin.Context) auditEntry {
+	uid, _ := c.Get("uid")
+	uname, _ := c.Get("uname")
+	pwaCode, _ := c.Get("pwacode")
+	permLeak, _ := c.Get("permission_leak")
+	authMethod, _ := c.Get("auth_method")
+	impersonator, _ := c.Get("impersonator")
+
+	return auditEntry{
+		UserID:         strOrEmpty(uid),
+		UserName:       strOrEmpty(uname),
+		PwaCode:        strOrEmpty(pwaCode),
+		PermLevel:      strOrEmpty(permLeak),
+		IP:             c.ClientIP(),
+		UserAgent:      c.Request.UserAgent(),
+		RequestPath:    c.Request.URL.Path,
+		RequestMethod:  c.Request.Method,
+		AuthMethod:     strOrEmpty(authMethod),
+		ImpersonatorID: strOrEmpty(impersonator),
+		CreatedAt:      time.Now(),
+	}
+}
+
+// insertAuditLog writes a single row. Used only before StartAuditWriter
+// (see audit_writer.go for the batched path).
+func insertAuditLog(e auditEntry) {
 	if config.PgDB == nil {
 		return
 	}
+	if services.IsChainedAuditAction(e.Action) && services.AuditChainKeyed() {
+		// Export rows must go through the hash chain
+		if err := insertAuditBatch([]auditEntry{e}); err != nil {
+			log.Printf("[AuditLog] insert error: %v (action=%s user=%s)", err, e.Action, e.UserID)
+		}
+		return
+	}
 
 	const query = `
 		INSERT INTO audit_logs.pwagis_track_log
 			(user_id, user_name, pwa_code, permission_level,
 			 action, target_type, target_value,
 			 ip_address, user_agent, request_path, request_method,
-			 response_status, duration_ms)
-		VALUES ($1,$2,$3,$4,$5,$6,$7,$8,$9,$10,$11,$12,$13)
+			 response_status, duration_ms, auth_method, impersonator_id)
+		VALUES ($1,$2,$3,$4,$5,$6,$7,$8,$9,$10,$11,$12,$13,$14,NULLIF($15,''))
 	`
 
 	_, err := config.PgDB.Exec(query,
-		userID, userName, pwaCode, permLevel,
-		action, targetType, targetValue,
-		ip, userAgent, requestPath, requestMethod,
-		responseStatus, durationMs,
+		e.UserID, e.UserName, e.PwaCode, e.PermLevel,
+		e.Action, e.TargetType, e.TargetValue,
+		e.IP, e.UserAgent, e.RequestPath, e.RequestMethod,
+		e.ResponseStatus, e.DurationMs, e.AuthMethod, e.ImpersonatorID,
 	)
 	if err != nil {
 		// Log error but don't fail — audit is best-effort
-		log.Printf("[AuditLog] insert error: %v (action=%s user=%s)", err, action, userID)
+		log.Printf("[AuditLog] insert error: %v (action=%s user=%s)", err, e.Action, e.UserID)
 	}
 }
 
@@ -180,6 +196,15 @@ func classifyAction(method, path, format string) string {
 	if strings.Contains(path, "/features/properties") {
 		return "view_feature_props"
 	}
+	if strings.Contains(path, "/features/advanced-query/export") {
+		return "advanced_query_export"
+	}
+	if strings.Contains(path, "/features/advanced-query") {
+		return "advanced_query"
+	}
+	if strings.Contains(path, "/chatbot/query") {
+		return "chatbot_query"
+	}
 	if strings.Contains(path, "/counts") {
 		return "view_detail"
 	}
@@ -198,14 +223,21 @@ func classifyAction(method, path, format string) string {
 	return "api_call"
 }
 
-// classifyTarget extracts the target type and value from query parameters.
+// classifyTarget extracts the target type and value from query parameters,
+// or returns the body summary a handler set with setAuditTarget.
 func classifyTarget(c *gin.Context) (string, string) {
-	pwaCode := c.Query("pwaCode")
-	collection := c.Query("collection")
-	zone := c.Query("zone")
+	if v, ok := c.Get(ctxAuditTargetValue); ok {
+		t, _ := c.Get(ctxAuditTargetType)
+		return strOrEmpty(t), capAuditValue(strOrEmpty(v))
+	}
+
+	// Query strings are unbounded; target_value is indexed
+	pwaCode := capAuditValue(c.Query("pwaCode"))
+	collection := capAuditValue(c.Query("collection"))
+	zone := capAuditValue(c.Query("zone"))
 
 	if collection != "" && pwaCode != "" {
-		return "layer", pwaCode + ":" + collection
+		return "layer", capAuditValue(pwaCode + ":" + collection)
 	}
 	if pwaCode != "" {
 		return "branch", pwaCode
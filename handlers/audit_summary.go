@@ -0,0 +1,168 @@
+package handlers
+
+import (
+	"encoding/json"
+	"regexp"
+	"strings"
+	"unicode/utf8"
+
+	"pwa_gis_tracking/services"
+
+	"github.com/gin-gonic/gin"
+)
+
+// ========================================================================
+// Audit summaries of POST bodies
+//
+// classifyTarget only sees query parameters, so JSON-body endpoints record
+// what was asked via setAuditTarget; AuditLogMiddleware prefers that value.
+//
+//   advanced query   {"pwaCodes":[..],"collection":"meter","conditions":{..},
+//                     "startDate":"..","endDate":"..","format":"csv"}
+//   chatbot          {"pwaCode":"1020","prompt":"..."}
+//
+// Summaries are capped at maxAuditSummaryLen bytes. Rule values on PII
+// fields (services.FieldSensitivity) and number runs / e-mail addresses in
+// chatbot prompts are replaced with redactedValue.
+// ========================================================================
+
+const (
+	maxAuditSummaryLen = 2048
+	maxAuditValueLen   = 100 // per string inside a summary
+	redactedValue      = "[redacted]"
+)
+
+// Context keys read by classifyTarget.
+const (
+	ctxAuditTargetType  = "audit_target_type"
+	ctxAuditTargetValue = "audit_target_value"
+)
+
+// setAuditTarget attaches a JSON summary to the request's audit row.
+func setAuditTarget(c *gin.Context, targetType string, summary gin.H) {
+	b, err := json.Marshal(summary)
+	if err != nil {
+		return
+	}
+	c.Set(ctxAuditTargetType, targetType)
+	c.Set(ctxAuditTargetValue, capSummary(b))
+}
+
+// capAuditValue caps a target_value at maxAuditSummaryLen bytes, below the
+// btree row limit of idx_audit_target (about 2.7 KB).
+func capAuditValue(s string) string {
+	return capSummary([]byte(s))
+}
+
+// capSummary returns b as a string of at most maxAuditSummaryLen bytes.
+// Oversized summaries are cut on a rune boundary and marked "…".
+func capSummary(b []byte) string {
+	if len(b) <= maxAuditSummaryLen {
+		return string(b)
+	}
+	cut := b[:maxAuditSummaryLen-len("…")]
+	for len(cut) > 0 && !utf8.Valid(cut) {
+		cut = cut[:len(cut)-1]
+	}
+	return string(cut) + "…"
+}
+
+// summarizeAdvancedQuery describes an advanced query (format "" for the
+// paginated query).
+func summarizeAdvancedQuery(req *services.AdvancedQueryRequest, format string) gin.H {
+	s := gin.H{
+		"pwaCodes":   req.ResolvedPwaCodes(),
+		"collection": req.Collection,
+	}
+	if req.Conditions != nil {
+		s["conditions"] = redactConditions(req.Collection, req.Conditions, 0)
+	}
+	if req.StartDate != "" {
+		s["startDate"] = req.StartDate
+	}
+	if req.EndDate != "" {
+		s["endDate"] = req.EndDate
+	}
+	if format != "" {
+		s["format"] = format
+	}
+	return s
+}
+
+// redactConditions copies a condition group (see services.TranslateConditions),
+// keeping only logic/field/operator/value(2) and redacting PII values.
+func redactConditions(collection string, group map[string]interface{}, depth int) gin.H {
+	out := gin.H{}
+	if logic, ok := group["logic"].(string); ok {
+		out["logic"] = logic
+	}
+	rules, _ := group["rules"].([]interface{})
+	if depth >= 5 {
+		out["rules"] = len(rules)
+		return out
+	}
+
+	list := make([]gin.H, 0, len(rules))
+	for _, r := range rules {
+		rule, ok := r.(map[string]interface{})
+		if !ok {
+			continue
+		}
+		if _, nested := rule["logic"]; nested {
+			list = append(list, redactConditions(collection, rule, depth+1))
+			continue
+		}
+		field, _ := rule["field"].(string)
+		entry := gin.H{"field": truncateRunes(field, maxAuditValueLen), "operator": truncateValue(rule["operator"])}
+		sensitive := services.IsSensitiveField(collection, field)
+		for _, k := range []string{"value", "value2"} {
+			v, ok := rule[k]
+			if !ok {
+				continue
+			}
+			if sensitive {
+				entry[k] = redactedValue
+			} else {
+				entry[k] = truncateValue(v)
+			}
+		}
+		list = append(list, entry)
+	}
+	out["rules"] = list
+	return out
+}
+
+// truncateValue shortens long strings (and string lists) inside a summary.
+func truncateValue(v interface{}) interface{} {
+	switch val := v.(type) {
+	case string:
+		return truncateRunes(val, maxAuditValueLen)
+	case []interface{}:
+		out := make([]interface{}, len(val))
+		for i, x := range val {
+			out[i] = truncateValue(x)
+		}
+		return out
+	}
+	return v
+}
+
+func truncateRunes(s string, n int) string {
+	if utf8.RuneCountInString(s) <= n {
+		return s
+	}
+	return string([]rune(s)[:n]) + "…"
+}
+
+var (
+	promptNumberRe = regexp.MustCompile(`\d{6,}|\d{2,3}-\d{3}-\d{4}`)
+	promptEmailRe  = regexp.MustCompile(`[A-Za-z0-9._%+\-]+@[A-Za-z0-9.\-]+\.[A-Za-z]{2,}`)
+)
+
+// redactPrompt removes values that may identify a customer (customer codes,
+// phone / ID-card numbers, e-mail addresses) from a chatbot prompt.
+func redactPrompt(prompt string) string {
+	p := promptEmailRe.ReplaceAllString(prompt, redactedValue)
+	p = promptNumberRe.ReplaceAllString(p, redactedValue)
+	return strings.TrimSpace(p)
+}
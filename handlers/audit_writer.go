@@ -0,0 +1,551 @@
+package handlers
+
+import (
+	"bufio"
+	"bytes"
+	"database/sql"
+	"encoding/json"
+	"fmt"
+	"io"
+	"log"
+	"os"
+	"strconv"
+	"strings"
+	"sync"
+	"sync/atomic"
+	"time"
+
+	"pwa_gis_tracking/config"
+	"pwa_gis_tracking/services"
+)
+
+// ========================================================================
+// Audit Writer — one background goroutine for all audit rows
+//
+// AuditLogMiddleware / LogAuditEvent / logAuthEvent call enqueueAudit,
+// which never blocks the request:
+//
+//   request ──► bounded channel ──► writer ──► multi-row INSERT
+//                    │ full                  │ Postgres down
+//                    └──────► spill file ◄───┘
+//
+// The spill file (JSON lines, AUDIT_SPILL_FILE) is replayed by the writer
+// once Postgres accepts inserts again. A row Postgres rejects on its own
+// (not an outage) goes to AUDIT_SPILL_FILE.rejected instead. StopAuditWriter drains the channel
+// on shutdown; whatever cannot be inserted is spilled, so nothing is lost.
+//
+// Env: AUDIT_QUEUE_SIZE (10000), AUDIT_BATCH_SIZE (200, max 4095),
+//      AUDIT_FLUSH_INTERVAL (1s), AUDIT_SPILL_FILE (audit_spill.jsonl)
+// ========================================================================
+
+// auditWriter is the process-wide writer (nil until StartAuditWriter).
+var auditW *auditWriter
+
+type auditWriter struct {
+	ch        chan auditEntry
+	done      chan struct{}
+	batchSize int
+	interval  time.Duration
+
+	closeMu sync.RWMutex // guards ch against send-after-close
+	closed  bool
+
+	spillMu   sync.Mutex
+	spillPath string
+
+	spilled  uint64 // entries written to the spill file
+	replayed uint64 // entries replayed from the spill file
+	rejected uint64 // entries Postgres refused, in the .rejected file
+}
+
+// StartAuditWriter starts the background writer.
+func StartAuditWriter() {
+	w := &auditWriter{
+		ch:        make(chan auditEntry, envInt("AUDIT_QUEUE_SIZE", 10000)),
+		done:      make(chan struct{}),
+		batchSize: envInt("AUDIT_BATCH_SIZE", 200),
+		interval:  time.Second,
+		spillPath: os.Getenv("AUDIT_SPILL_FILE"),
+	}
+	if d, err := time.ParseDuration(os.Getenv("AUDIT_FLUSH_INTERVAL")); err == nil && d > 0 {
+		w.interval = d
+	}
+	if w.spillPath == "" {
+		w.spillPath = "audit_spill.jsonl"
+	}
+	if w.batchSize > maxAuditBatchSize {
+		log.Printf("[AuditLog] AUDIT_BATCH_SIZE=%d exceeds %d, clamped", w.batchSize, maxAuditBatchSize)
+		w.batchSize = maxAuditBatchSize
+	}
+	if !services.AuditChainKeyed() {
+		log.Printf("[AuditLog] WARNING: AUDIT_CHAIN_KEY is not set — export rows are written without the hash chain")
+	}
+	auditW = w
+	go w.run()
+	log.Printf("[AuditLog] writer started (queue=%d batch=%d interval=%s spill=%s)",
+		cap(w.ch), w.batchSize, w.interval, w.spillPath)
+}
+
+// StopAuditWriter stops accepting entries, flushes what is queued and waits
+// up to timeout. Call after the HTTP server has stopped.
+func StopAuditWriter(timeout time.Duration) {
+	w := auditW
+	if w == nil {
+		return
+	}
+	w.closeMu.Lock()
+	if !w.closed {
+		w.closed = true
+		close(w.ch)
+	}
+	w.closeMu.Unlock()
+
+	select {
+	case <-w.done:
+		log.Printf("[AuditLog] writer drained")
+	case <-time.After(timeout):
+		log.Printf("[AuditLog] writer drain timed out after %s (%d entries still queued)", timeout, len(w.ch))
+	}
+}
+
+// AuditQueueDepth returns the number of entries waiting in the channel.
+func AuditQueueDepth() int {
+	if auditW == nil {
+		return 0
+	}
+	return len(auditW.ch)
+}
+
+// enqueueAudit hands an entry to the writer without blocking. A full queue
+// (or a stopped writer) spills the entry to disk instead.
+func enqueueAudit(e auditEntry) {
+	w := auditW
+	if w == nil {
+		go insertAuditLog(e)
+		return
+	}
+	w.closeMu.RLock()
+	if !w.closed {
+		select {
+		case w.ch <- e:
+			w.closeMu.RUnlock()
+			return
+		default:
+		}
+	}
+	w.closeMu.RUnlock()
+	w.spill([]auditEntry{e})
+}
+
+// run is the writer loop: batch, insert, spill on failure, replay the
+// spill file when Postgres is healthy.
+func (w *auditWriter) run() {
+	defer close(w.done)
+	ticker := time.NewTicker(w.interval)
+	defer ticker.Stop()
+
+	batch := make([]auditEntry, 0, w.batchSize)
+	flush := func() {
+		if len(batch) == 0 {
+			return
+		}
+		if _, rest, err := w.writeBatch(batch); len(rest) > 0 {
+			log.Printf("[AuditLog] batch insert of %d failed, spilling %d: %v", len(batch), len(rest), err)
+			w.spill(rest)
+		}
+		batch = batch[:0]
+	}
+
+	lastReplay := time.Time{}
+	for {
+		select {
+		case e, ok := <-w.ch:
+			if !ok {
+				flush()
+				return
+			}
+			batch = append(batch, e)
+			if len(batch) >= w.batchSize {
+				flush()
+			}
+		case <-ticker.C:
+			flush()
+			if time.Since(lastReplay) >= 30*time.Second {
+				lastReplay = time.Now()
+				w.replay()
+			}
+		}
+	}
+}
+
+// ─── Batch insert ────────────────────────────────────────────────────────────
+
+// writeBatch inserts entries and returns how many were written and the
+// entries to spill. When the multi-row INSERT fails while Postgres is
+// reachable, the rows are retried one by one and any row Postgres rejects
+// on its own is quarantined, so one bad row cannot hold back its batch or
+// the spill file. Only rows not attempted because Postgres went away are
+// returned for spilling.
+func (w *auditWriter) writeBatch(entries []auditEntry) (int, []auditEntry, error) {
+	err := insertAuditBatch(entries)
+	if err == nil {
+		return len(entries), nil, nil
+	}
+	if config.PgDB == nil || config.PgDB.Ping() != nil {
+		return 0, entries, err
+	}
+
+	written := 0
+	var rejected []auditEntry
+	for i, e := range entries {
+		rowErr := insertAuditBatch([]auditEntry{e})
+		if rowErr == nil {
+			written++
+			continue
+		}
+		if config.PgDB.Ping() != nil {
+			w.quarantine(rejected)
+			return written, entries[i:], rowErr
+		}
+		log.Printf("[AuditLog] row rejected, quarantined (action=%s user=%s): %v", e.Action, e.UserID, rowErr)
+		rejected = append(rejected, e)
+	}
+	w.quarantine(rejected)
+	return written, nil, err
+}
+
+const auditInsertColumns = 16
+
+// maxAuditBatchSize keeps a multi-row INSERT under Postgres' limit of
+// 65535 bind parameters.
+const maxAuditBatchSize = 65535 / auditInsertColumns
+
+// insertAuditBatch writes entries with one multi-row INSERT. Export rows
+// are appended to the hash chain (services/audit_chain.go) in the same
+// transaction, so a failed batch leaves nothing behind to duplicate when
+// it is spilled and replayed.
+func insertAuditBatch(entries []auditEntry) error {
+	if config.PgDB == nil {
+		return fmt.Errorf("postgres not connected")
+	}
+
+	// Without AUDIT_CHAIN_KEY export rows are written unchained (see
+	// StartAuditWriter) rather than with a hash anyone could recompute
+	keyed := services.AuditChainKeyed()
+	var plain, chained []auditEntry
+	for _, e := range entries {
+		if keyed && services.IsChainedAuditAction(e.Action) {
+			chained = append(chained, e)
+		} else {
+			plain = append(plain, e)
+		}
+	}
+	if len(chained) == 0 {
+		query, args := auditBatchInsert(plain)
+		_, err := config.PgDB.Exec(query, args...)
+		return err
+	}
+
+	tx, err := config.PgDB.Begin()
+	if err != nil {
+		return err
+	}
+	defer tx.Rollback()
+	if err := insertChainedAudit(tx, chained); err != nil {
+		return err
+	}
+	if len(plain) > 0 {
+		query, args := auditBatchInsert(plain)
+		if _, err := tx.Exec(query, args...); err != nil {
+			return err
+		}
+	}
+	return tx.Commit()
+}
+
+// auditBatchInsert builds the multi-row INSERT for entries.
+func auditBatchInsert(entries []auditEntry) (string, []interface{}) {
+	var sb strings.Builder
+	sb.WriteString(`INSERT INTO audit_logs.pwagis_track_log
+		(user_id, user_name, pwa_code, permission_level,
+		 action, target_type, target_value,
+		 ip_address, user_agent, request_path, request_method,
+		 response_status, duration_ms, auth_method, impersonator_id, created_at)
+		VALUES `)
+	args := make([]interface{}, 0, len(entries)*auditInsertColumns)
+	for i, e := range entries {
+		if i > 0 {
+			sb.WriteString(",")
+		}
+		n := i * auditInsertColumns
+		p := func(k int) string { return "$" + strconv.Itoa(n+k) }
+		sb.WriteString("(" + p(1) + "," + p(2) + "," + p(3) + "," + p(4) + "," + p(5) + "," + p(6) + "," +
+			p(7) + "," + p(8) + "," + p(9) + "," + p(10) + "," + p(11) + "," + p(12) + "," + p(13) + "," +
+			p(14) + ",NULLIF(" + p(15) + ",'')," + p(16) + ")")
+
+		createdAt := e.CreatedAt
+		if createdAt.IsZero() {
+			createdAt = time.Now()
+		}
+		args = append(args,
+			e.UserID, e.UserName, e.PwaCode, e.PermLevel,
+			e.Action, e.TargetType, e.TargetValue,
+			e.IP, e.UserAgent, e.RequestPath, e.RequestMethod,
+			e.ResponseStatus, e.DurationMs, e.AuthMethod, e.ImpersonatorID, createdAt,
+		)
+	}
+
+	return sb.String(), args
+}
+
+// insertChainedAudit inserts export rows one by one, each linked to the
+// previous chain_hash, while holding the chain head.
+func insertChainedAudit(tx *sql.Tx, entries []auditEntry) error {
+	lastID, prev, err := services.LockAuditChainHead(tx)
+	if err != nil {
+		return err
+	}
+	for _, e := range entries {
+		if e.CreatedAt.IsZero() {
+			e.CreatedAt = time.Now()
+		}
+		e.CreatedAt = services.AuditChainTime(e.CreatedAt)
+		h := services.AuditChainHash(prev, services.AuditChainFields{
+			UserID: e.UserID, UserName: e.UserName, PwaCode: e.PwaCode, PermLevel: e.PermLevel,
+			Action: e.Action, TargetType: e.TargetType, TargetValue: e.TargetValue,
+			IP: e.IP, UserAgent: e.UserAgent, RequestPath: e.RequestPath, RequestMethod: e.RequestMethod,
+			ResponseStatus: e.ResponseStatus, DurationMs: e.DurationMs,
+			AuthMethod: e.AuthMethod, ImpersonatorID: e.ImpersonatorID, CreatedAt: e.CreatedAt,
+		})
+		if err := tx.QueryRow(`INSERT INTO audit_logs.pwagis_track_log
+			(user_id, user_name, pwa_code, permission_level,
+			 action, target_type, target_value,
+			 ip_address, user_agent, request_path, request_method,
+			 response_status, duration_ms, auth_method, impersonator_id, created_at,
+			 prev_hash, chain_hash)
+			VALUES ($1,$2,$3,$4,$5,$6,$7,$8,$9,$10,$11,$12,$13,$14,NULLIF($15,''),$16,$17,$18)
+			RETURNING id`,
+			e.UserID, e.UserName, e.PwaCode, e.PermLevel,
+			e.Action, e.TargetType, e.TargetValue,
+			e.IP, e.UserAgent, e.RequestPath, e.RequestMethod,
+			e.ResponseStatus, e.DurationMs, e.AuthMethod, e.ImpersonatorID, e.CreatedAt,
+			prev, h,
+		).Scan(&lastID); err != nil {
+			return fmt.Errorf("chained insert failed: %v", err)
+		}
+		prev = h
+	}
+	return services.UpdateAuditChainHead(tx, lastID, prev)
+}
+
+// ─── Spill file ──────────────────────────────────────────────────────────────
+
+// spill appends entries to the spill file as JSON lines.
+func (w *auditWriter) spill(entries []auditEntry) {
+	w.spillMu.Lock()
+	defer w.spillMu.Unlock()
+	if appendAuditLines(w.spillPath, entries) {
+		atomic.AddUint64(&w.spilled, uint64(len(entries)))
+	}
+}
+
+// quarantine appends rows Postgres rejected to "<spill file>.rejected"
+// for manual inspection; they are never replayed.
+func (w *auditWriter) quarantine(entries []auditEntry) {
+	if len(entries) == 0 {
+		return
+	}
+	w.spillMu.Lock()
+	defer w.spillMu.Unlock()
+	if appendAuditLines(w.spillPath+".rejected", entries) {
+		atomic.AddUint64(&w.rejected, uint64(len(entries)))
+	}
+}
+
+// appendAuditLines appends entries to path as JSON lines and syncs it.
+func appendAuditLines(path string, entries []auditEntry) bool {
+	f, err := os.OpenFile(path, os.O_CREATE|os.O_WRONLY|os.O_APPEND, 0600)
+	if err != nil {
+		log.Printf("[AuditLog] open %s failed, %d entries lost: %v", path, len(entries), err)
+		return false
+	}
+	defer f.Close()
+
+	bw := bufio.NewWriter(f)
+	enc := json.NewEncoder(bw)
+	for _, e := range entries {
+		if e.CreatedAt.IsZero() {
+			e.CreatedAt = time.Now()
+		}
+		if err := enc.Encode(e); err != nil {
+			log.Printf("[AuditLog] spill encode failed (action=%s user=%s): %v", e.Action, e.UserID, err)
+		}
+	}
+	if err := bw.Flush(); err != nil {
+		log.Printf("[AuditLog] write %s failed: %v", path, err)
+		return false
+	}
+	if err := f.Sync(); err != nil {
+		log.Printf("[AuditLog] sync %s failed: %v", path, err)
+	}
+	return true
+}
+
+// replay inserts the spill file in batches. The file is renamed first so
+// new spills go to a fresh file; unreplayed entries are spilled back.
+// A ".replay" file left by an interrupted replay is finished first.
+func (w *auditWriter) replay() {
+	replayPath := w.spillPath + ".replay"
+
+	_, errReplay := os.Stat(replayPath)
+	_, errSpill := os.Stat(w.spillPath)
+	if errReplay != nil && errSpill != nil {
+		return
+	}
+	if config.PgDB == nil || config.PgDB.Ping() != nil {
+		return
+	}
+
+	w.spillMu.Lock()
+	if errReplay != nil {
+		if err := os.Rename(w.spillPath, replayPath); err != nil {
+			w.spillMu.Unlock()
+			log.Printf("[AuditLog] replay rename failed: %v", err)
+			return
+		}
+	}
+	w.spillMu.Unlock()
+
+	f, err := os.Open(replayPath)
+	if err != nil {
+		log.Printf("[AuditLog] replay open failed: %v", err)
+		return
+	}
+
+	// Once Postgres becomes unavailable the rest is kept without trying;
+	// rows Postgres rejects are quarantined by writeBatch
+	var pending, failed []auditEntry
+	total := 0
+	replayBatch := func(batch []auditEntry) {
+		if failed != nil {
+			failed = append(failed, batch...)
+			return
+		}
+		n, rest, _ := w.writeBatch(batch)
+		total += n
+		if len(rest) > 0 {
+			failed = append(failed, rest...)
+		}
+	}
+	br := bufio.NewReaderSize(f, 64*1024)
+	var offset int64 // start of the first line not read yet
+	var readErr error
+	for {
+		line, n, tooLong, err := readReplayLine(br)
+		if err != nil && err != io.EOF {
+			readErr = err
+			break
+		}
+		offset += int64(n)
+		if tooLong {
+			log.Printf("[AuditLog] replay: skip line longer than %d bytes", maxReplayLineLen)
+		} else if line = bytes.TrimSpace(line); len(line) > 0 {
+			var e auditEntry
+			if err := json.Unmarshal(line, &e); err != nil {
+				log.Printf("[AuditLog] replay: skip malformed line: %v", err)
+			} else {
+				pending = append(pending, e)
+			}
+		}
+		if len(pending) >= w.batchSize {
+			replayBatch(pending)
+			pending = nil
+		}
+		if err == io.EOF {
+			break
+		}
+	}
+	if len(pending) > 0 {
+		replayBatch(pending)
+	}
+	f.Close()
+
+	if len(failed) > 0 {
+		w.spill(failed)
+	}
+	atomic.AddUint64(&w.replayed, uint64(total))
+	if readErr != nil {
+		// Everything before offset is inserted or re-spilled: keep only the
+		// unread tail so the next attempt does not insert it twice
+		log.Printf("[AuditLog] replay read failed at byte %d, keeping the rest of %s: %v", offset, replayPath, readErr)
+		if err := truncateReplayHead(replayPath, offset); err != nil {
+			log.Printf("[AuditLog] replay: dropping the replayed part of %s failed: %v", replayPath, err)
+		}
+		return
+	}
+	os.Remove(replayPath)
+	log.Printf("[AuditLog] replayed %d spilled entries (%d re-spilled)", total, len(failed))
+}
+
+// maxReplayLineLen caps one spilled line. Longer lines are skipped so a
+// single bad line cannot stop the replay.
+const maxReplayLineLen = 4 * 1024 * 1024
+
+// readReplayLine returns the next line of r and the number of bytes consumed.
+// A line over maxReplayLineLen is consumed but returned as tooLong (no data).
+func readReplayLine(r *bufio.Reader) (line []byte, n int, tooLong bool, err error) {
+	for {
+		chunk, err := r.ReadSlice('\n')
+		n += len(chunk)
+		if !tooLong {
+			line = append(line, chunk...)
+			if len(line) > maxReplayLineLen {
+				line, tooLong = nil, true
+			}
+		}
+		if err != bufio.ErrBufferFull {
+			return line, n, tooLong, err
+		}
+	}
+}
+
+// truncateReplayHead rewrites path to hold only the bytes after offset.
+func truncateReplayHead(path string, offset int64) error {
+	src, err := os.Open(path)
+	if err != nil {
+		return err
+	}
+	defer src.Close()
+	if _, err := src.Seek(offset, io.SeekStart); err != nil {
+		return err
+	}
+
+	tmp := path + ".tmp"
+	dst, err := os.OpenFile(tmp, os.O_CREATE|os.O_WRONLY|os.O_TRUNC, 0600)
+	if err != nil {
+		return err
+	}
+	if _, err := io.Copy(dst, src); err != nil {
+		dst.Close()
+		os.Remove(tmp)
+		return err
+	}
+	if err := dst.Sync(); err != nil {
+		dst.Close()
+		os.Remove(tmp)
+		return err
+	}
+	if err := dst.Close(); err != nil {
+		os.Remove(tmp)
+		return err
+	}
+	return os.Rename(tmp, path)
+}
+
+// envInt reads a positive integer env var.
+func envInt(key string, fallback int) int {
+	if n, err := strconv.Atoi(os.Getenv(key)); err == nil && n > 0 {
+		return n
+	}
+	return fallback
+}
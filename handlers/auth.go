@@ -7,15 +7,16 @@
 // Design decisions vs. the PHP originals:
 //   - Passwords are MD5-hashed only to satisfy the upstream intranet API;
 //     the hash is NEVER stored locally.
-//   - Sessions are managed with gorilla/sessions backed by a server-side HMAC
-//     signed cookie, so clients cannot tamper with session values.
+//   - Sessions are managed with gorilla/sessions. With SESSION_STORE=postgres
+//     the HMAC-signed cookie only holds the session id and values live in
+//     rbac.pwagis_session, so sessions can be revoked before they expire.
 //   - SQL uses parameterised queries throughout (no string interpolation).
 //   - Permission logic is delegated to services.ResolvePermission, which
 //     replaces the deeply nested if-else tree in check_user.php.
 package handlers
 
 import (
-	"fmt"
+	"context"
 	"log"
 	"net/http"
 	"strings"
@@ -44,6 +45,7 @@ const (
 	sessLvl          = "lvl"
 	sessUID          = "uid"
 	sessLoginStatus  = "loginstatus"
+	sessCSRF         = "csrf_token"
 )
 
 // ─── Login page ───────────────────────────────────────────────────────────────
@@ -52,7 +54,10 @@ const (
 // GET /pwa_gis_tracking/login
 func ShowLoginPage(c *gin.Context) {
 	basePath := resolveBasePath(c.Request.URL.Path, "/login")
-	c.HTML(http.StatusOK, "login.html", gin.H{"BasePath": basePath})
+	c.HTML(http.StatusOK, "login.html", gin.H{
+		"BasePath":  basePath,
+		"CSRFToken": issueLoginCSRF(c, basePath),
+	})
 }
 
 // ─── Login action ─────────────────────────────────────────────────────────────
@@ -84,11 +89,51 @@ func HandleLogin(c *gin.Context) {
 
 	// 1. Sanitise username: keep digits only (replicates PHP's preg_replace('~[^0-9]~iu','',…))
 	username := keepDigitsOnly(req.Username)
+	if username == "" || len(username) > maxAuditUserIDLen {
+		// Not an employee number; rejected before anything is logged
+		c.JSON(http.StatusBadRequest, gin.H{
+			"result":  "error",
+			"message": "ชื่อผู้ใช้ไม่ถูกต้อง",
+			"link":    "./",
+		})
+		return
+	}
 
-	// 2. Call intranet authentication endpoint
-	user, err := services.AuthenticateIntranet(username, req.Password)
+	// Filled in as the login progresses and written to the audit log
+	ev := authEvent{ClientInfo: req.Info}
+
+	// Login CSRF: header must match the cookie set by ShowLoginPage
+	if !checkLoginCSRF(c) {
+		ev.Reason = "csrf"
+		logLoginFailure(c, username, http.StatusForbidden, ev)
+		c.JSON(http.StatusForbidden, gin.H{
+			"result":  "error",
+			"message": "หน้าเข้าสู่ระบบหมดอายุ กรุณาโหลดหน้าใหม่แล้วลองอีกครั้ง",
+			"link":    "./",
+		})
+		return
+	}
+
+	// 2. Throttle brute-force attempts (per employee ID and per client IP).
+	//    The attempt counts from here on; it is taken back below unless the
+	//    password was wrong.
+	ip := c.ClientIP()
+	if block := services.BeginLoginAttempt(username, ip); block != nil {
+		rejectThrottledLogin(c, username, ev, block)
+		return
+	}
+
+	// 3. Call the configured authenticator (intranet / curl / stub)
+	ctx, cancel := context.WithTimeout(c.Request.Context(), 20*time.Second)
+	defer cancel()
+	auth := services.GetAuthenticator()
+	user, err := auth.Authenticate(ctx, username, req.Password)
 	if err != nil {
-		log.Printf("intranet auth error for user %s: %v", username, err)
+		log.Printf("%s auth error for user %s: %v", auth.Name(), username, err)
+		// Upstream outages are audited but do not count against the user
+		services.CancelLoginAttempt(username, ip)
+		ev.Reason, ev.Detail = "upstream_error", "provider="+auth.Name()
+		logLoginFailure(c, username, http.StatusInternalServerError, ev)
 		c.JSON(http.StatusInternalServerError, gin.H{
 			"result":  "N_Found",
 			"message": "ไม่สามารถเชื่อมต่อระบบยืนยันตัวตนได้",
@@ -97,8 +142,10 @@ func HandleLogin(c *gin.Context) {
 		return
 	}
 
-	// 3. Reject non-passing responses from the intranet
+	// 4. Reject non-passing responses from the intranet
 	if user.Check != "P" {
+		ev.Reason = "N_Found"
+		logLoginFailure(c, username, http.StatusUnauthorized, ev)
 		c.JSON(http.StatusUnauthorized, gin.H{
 			"result":  "N_Found",
 			"message": "ชื่อผู้ใช้หรือรหัสผ่านไม่ถูกต้อง",
@@ -107,9 +154,16 @@ func HandleLogin(c *gin.Context) {
 		return
 	}
 
-	// 4. Resolve RBAC permission (replaces the big if-else block)
+	// 5. Resolve RBAC permission (replaces the big if-else block)
 	perm := services.ResolvePermission(user.DepName, user.DivName, user.JobName, user.User)
+	ev.BA, ev.IntranetPwaCode, ev.Zone = user.BA, user.PwaCode, user.Area
+	ev.Permission, ev.PermissionLeak = perm.Permission, perm.PermissionLeak
+	ev.DepName, ev.DivName, ev.JobName = user.DepName, user.DivName, user.JobName
 	if !services.IsAuthorised(perm) {
+		// Valid credentials — not a guessing attempt, so no throttle counter
+		services.CancelLoginAttempt(username, ip)
+		ev.Reason = "N_Rights"
+		logLoginFailure(c, username, http.StatusForbidden, ev)
 		c.JSON(http.StatusForbidden, gin.H{
 			"result":  "N_Rights",
 			"message": "คุณไม่มีสิทธิ์เข้าใช้งานระบบนี้",
@@ -118,19 +172,16 @@ func HandleLogin(c *gin.Context) {
 		return
 	}
 
-	// 5. Query pwa_code from PostgreSQL (parameterised — no SQL injection)
+	// 6. Query pwa_code from PostgreSQL (parameterised — no SQL injection)
 	pwaCode, err := services.LookupPwaCode(user.BA)
 	if err != nil {
 		log.Printf("pwa_code lookup error for ba=%s: %v", user.BA, err)
 		// Non-fatal: continue without pwa_code rather than blocking the login
 	}
 
-	// 6. Establish session
-	session, err := config.Store.Get(c.Request, config.SessionName)
-	if err != nil {
-		// Corrupt/old session — create a fresh one
-		session, _ = config.Store.New(c.Request, config.SessionName)
-	}
+	// 7. Establish a fresh session: new id, no values from the pre-login
+	//    session, old server-side row deleted (prevents session fixation)
+	session := config.NewLoginSession(c.Request)
 	session.Options.HttpOnly = true
 	session.Options.SameSite = http.SameSiteLaxMode
 
@@ -149,17 +200,21 @@ func HandleLogin(c *gin.Context) {
 	session.Values[sessLvl]         = user.Level
 	session.Values[sessUID]         = user.User
 	session.Values[sessLoginStatus] = 1
+	session.Values[sessCSRF]        = newCSRFToken()
 
 	if err := session.Save(c.Request, c.Writer); err != nil {
 		log.Printf("session save error: %v", err)
+		services.CancelLoginAttempt(username, ip)
 		c.JSON(http.StatusInternalServerError, gin.H{"result": "error", "link": "./"})
 		return
 	}
 
-	// 7. Write audit log entry (replicates PHP's file_put_contents)
-	writeLoginLog(pwaCode, user.PwaCode, user.User)
+	// 8. Write audit log entry (replaces PHP's file_put_contents)
+	ev.PwaCode = pwaCode
+	logAuthEvent(c, "login", user.User, fullName, http.StatusOK, ev)
+	services.ResetLoginFailures(username, ip)
 
-	// 8. Return success response
+	// 9. Return success response
 	// "status"+"redirect" are used by login.html JS; legacy fields kept for API compat.
 	c.JSON(http.StatusOK, gin.H{
 		"status":      "success",
@@ -172,6 +227,7 @@ func HandleLogin(c *gin.Context) {
 		"division":    user.DivName,
 		"insitution":  user.DepName,
 		"permission":  perm.Permission,
+		"csrf_token":  session.Values[sessCSRF],
 		"redirect":    basePath + "/",
 		"link":        basePath + "/",
 	})
@@ -186,6 +242,20 @@ func HandleLogout(c *gin.Context) {
 
 	session, err := config.Store.Get(c.Request, config.SessionName)
 	if err == nil {
+		if uid, _ := session.Values[sessUID].(string); uid != "" {
+			uname, _ := session.Values[sessUname].(string)
+			pwaCode, _ := session.Values[sessPwaCode].(string)
+			permission, _ := session.Values[sessPermission].(string)
+			permLeak, _ := session.Values[sessPermLeak].(string)
+			area, _ := session.Values[sessArea].(string)
+			logAuthEvent(c, "logout", uid, uname, http.StatusFound, authEvent{
+				PwaCode:        pwaCode,
+				Permission:     permission,
+				PermissionLeak: permLeak,
+				Zone:           area,
+			})
+		}
+
 		// MaxAge = -1 instructs the browser to delete the cookie immediately.
 		session.Options.MaxAge = -1
 		_ = session.Save(c.Request, c.Writer)
@@ -198,7 +268,10 @@ func HandleLogout(c *gin.Context) {
 
 // AuthRequired replaces check_session.php.
 //
-// It reads the gorilla session and checks that:
+// Requests carrying an API bearer token are authenticated by
+// authenticateAPIToken instead (see api_tokens.go).
+//
+// Otherwise it reads the gorilla session and checks that:
 //   1. ses_userid is set (non-empty)
 //   2. permission is set (non-empty)
 //   3. loginstatus == 1
@@ -207,6 +280,12 @@ func HandleLogout(c *gin.Context) {
 // the login page (for HTML page requests).
 func AuthRequired(basePath string) gin.HandlerFunc {
 	return func(c *gin.Context) {
+		// Machine clients authenticate with "Authorization: Bearer pgt_..."
+		if token, ok := bearerToken(c); ok {
+			authenticateAPIToken(c, token)
+			return
+		}
+
 		session, err := config.Store.Get(c.Request, config.SessionName)
 		if err != nil {
 			abortOrRedirect(c, basePath)
@@ -226,13 +305,83 @@ func AuthRequired(basePath string) gin.HandlerFunc {
 			return
 		}
 
+		// Sessions created before CSRF protection get a token on first use
+		csrf, _ := session.Values[sessCSRF].(string)
+		if csrf == "" {
+			csrf = newCSRFToken()
+			session.Values[sessCSRF] = csrf
+			if err := session.Save(c.Request, c.Writer); err != nil {
+				log.Printf("session save error (csrf): %v", err)
+			}
+		}
+
 		// Expose session values to downstream handlers if needed
 		c.Set("uid", session.Values[sessUID])
 		c.Set("uname", session.Values[sessUname])
 		c.Set("pwacode", session.Values[sessPwaCode])
 		c.Set("permission", permission)
 		c.Set("permission_leak", session.Values[sessPermLeak])
+		c.Set("area", session.Values[sessArea])
+		c.Set("auth_method", "session")
+		c.Set("csrf_token", csrf)
+
+		// Admin "view as user" overlay (see impersonation.go)
+		applyImpersonation(c, session)
+
+		c.Next()
+	}
+}
+
+// AdminRequired restricts a route group to the employee numbers listed in
+// ADMIN_USER_IDS. Must run after AuthRequired (reads "uid" from the context).
+// Requests authenticated by an API token are always rejected: a token acts
+// as its owner, and an admin's token must not grant admin routes (which
+// CSRFProtect does not cover for token requests).
+func AdminRequired() gin.HandlerFunc {
+	return func(c *gin.Context) {
+		if _, ok := c.Get("api_token_id"); ok {
+			logPermissionDenied(c, "admin routes are not available to API tokens")
+			c.AbortWithStatusJSON(http.StatusForbidden, gin.H{
+				"status":  "error",
+				"error":   "forbidden",
+				"reason":  "admin routes are not available to API tokens",
+				"message": "ไม่สามารถใช้ API token กับเมนูผู้ดูแลระบบได้",
+			})
+			return
+		}
+
+		if _, ok := c.Get("impersonator"); ok {
+			logPermissionDenied(c, "admin routes are disabled while impersonating")
+			c.AbortWithStatusJSON(http.StatusForbidden, gin.H{
+				"status":  "error",
+				"error":   "forbidden",
+				"reason":  "admin routes are disabled while impersonating",
+				"message": "กรุณาออกจากโหมดดูในฐานะผู้ใช้อื่นก่อน",
+			})
+			return
+		}
 
+		uid, _ := c.Get("uid")
+		if !config.IsAdminUser(strOrEmpty(uid)) {
+			logPermissionDenied(c, "admin permission required")
+			c.AbortWithStatusJSON(http.StatusForbidden, gin.H{
+				"status":  "error",
+				"error":   "forbidden",
+				"reason":  "admin permission required",
+				"message": "เฉพาะผู้ดูแลระบบเท่านั้น",
+			})
+			return
+		}
+		c.Set("is_admin", true)
+		c.Next()
+	}
+}
+
+// SessionClientIP hands gin's ClientIP (TRUSTED_PROXIES-aware) to the
+// session store, which records it with each server-side session.
+func SessionClientIP() gin.HandlerFunc {
+	return func(c *gin.Context) {
+		c.Request = config.WithClientIP(c.Request, c.ClientIP())
 		c.Next()
 	}
 }
@@ -269,17 +418,3 @@ func keepDigitsOnly(s string) string {
 	}
 	return b.String()
 }
-
-// writeLoginLog replicates the PHP file_put_contents audit log.
-// Format: "YYYY-MM-DD HH:MM:SS  <pwaCodeBA>|<pwaCodeAPI>  <employeeID>"
-func writeLoginLog(pwaCodeBA, pwaCodeAPI, employeeID string) {
-	if config.LoginLogFile == nil {
-		return
-	}
-	ts := time.Now().Format("2006-01-02 15:04:05")
-	line := fmt.Sprintf("%s  %s|%s  %s\n", ts, pwaCodeBA, pwaCodeAPI, employeeID)
-
-	if _, err := fmt.Fprint(config.LoginLogFile, line); err != nil {
-		log.Printf("login log write error: %v", err)
-	}
-}
\ No newline at end of file
@@ -0,0 +1,102 @@
+package handlers
+
+import (
+	"encoding/json"
+	"net/http"
+
+	"github.com/gin-gonic/gin"
+)
+
+// ========================================================================
+// Authentication Events (replaces the PHP-style writeLoginLog text file)
+//
+// Rows in audit_logs.pwagis_track_log with target_type = 'auth':
+//
+//   login              successful login
+//   logout             user-initiated logout
+//   login_failed       reason = N_Found | N_Rights | upstream_error |
+//                      throttled | locked | csrf
+//   permission_denied  403 from denyAccess / AdminRequired
+//
+// target_value holds an authEvent as JSON, e.g.
+//   {"ba":"1020","pwa_code":"5531022","intranet_pwacode":"5531022",
+//    "permission":"leak","permission_leak":"branch","client_info":"Chrome 120 / Windows"}
+// ========================================================================
+
+// maxClientInfoLen caps the free-text "info" field sent by the login form
+// (in characters, so Thai text is never cut mid-rune).
+const maxClientInfoLen = 500
+
+// maxAuditUserIDLen is the width of audit_logs.pwagis_track_log.user_id.
+const maxAuditUserIDLen = 20
+
+// authEvent is the JSON payload stored in target_value for auth rows.
+type authEvent struct {
+	Reason          string `json:"reason,omitempty"`
+	BA              string `json:"ba,omitempty"`
+	PwaCode         string `json:"pwa_code,omitempty"`         // resolved from BA (pwa_office234)
+	IntranetPwaCode string `json:"intranet_pwacode,omitempty"` // as returned by the intranet API
+	Permission      string `json:"permission,omitempty"`       // RBAC result, "" when denied
+	PermissionLeak  string `json:"permission_leak,omitempty"`
+	Zone            string `json:"zone,omitempty"`
+	DepName         string `json:"dep_name,omitempty"` // intranet attributes RBAC matched on
+	DivName         string `json:"div_name,omitempty"`
+	JobName         string `json:"job_name,omitempty"`
+	ClientInfo      string `json:"client_info,omitempty"`
+	Detail          string `json:"detail,omitempty"`
+}
+
+// logAuthEvent writes one auth row. userID / userName override the values
+// from the gin context because /login and /logout run outside AuthRequired.
+func logAuthEvent(c *gin.Context, action, userID, userName string, status int, ev authEvent) {
+	entry := newAuditEntry(c)
+	if userID != "" {
+		entry.UserID = userID
+	}
+	// An over-long value would fail the whole audit batch insert
+	if r := []rune(entry.UserID); len(r) > maxAuditUserIDLen {
+		entry.UserID = string(r[:maxAuditUserIDLen])
+	}
+	if userName != "" {
+		entry.UserName = userName
+	}
+	if ev.PwaCode != "" {
+		entry.PwaCode = ev.PwaCode
+	}
+	if ev.PermissionLeak != "" {
+		entry.PermLevel = ev.PermissionLeak
+	}
+	entry.Action = action
+	entry.TargetType = "auth"
+	entry.ResponseStatus = status
+
+	ev.ClientInfo = truncateRunes(ev.ClientInfo, maxClientInfoLen)
+	b, err := json.Marshal(ev)
+	if err == nil && len(b) > maxAuditSummaryLen && ev.ClientInfo != "" {
+		// Cutting the JSON would make it unparseable (LookupUserProfile
+		// reads login rows back), so drop the free-text field first
+		ev.ClientInfo = ""
+		b, err = json.Marshal(ev)
+	}
+	if err == nil {
+		entry.TargetValue = capAuditValue(string(b))
+	}
+
+	enqueueAudit(entry)
+}
+
+// logLoginFailure records a failed login attempt for the given employee ID.
+func logLoginFailure(c *gin.Context, username string, status int, ev authEvent) {
+	logAuthEvent(c, "login_failed", username, "", status, ev)
+}
+
+// logPermissionDenied records a 403 for the current (authenticated) user.
+func logPermissionDenied(c *gin.Context, reason string) {
+	permLeak, _ := c.Get("permission_leak")
+	area, _ := c.Get("area")
+	logAuthEvent(c, "permission_denied", "", "", http.StatusForbidden, authEvent{
+		Reason:         reason,
+		PermissionLeak: strOrEmpty(permLeak),
+		Zone:           strOrEmpty(area),
+	})
+}
@@ -0,0 +1,258 @@
+package handlers
+
+import (
+	"fmt"
+	"log"
+	"net/http"
+	"strings"
+
+	"pwa_gis_tracking/services"
+
+	"github.com/gin-gonic/gin"
+)
+
+// ========================================================================
+// Branch-level Authorization
+//
+// AuthRequired only proves the user is logged in. This file decides WHICH
+// branch offices that user may read, based on permission_leak:
+//
+//   "all"    → every office in pwa_office234
+//   "reg"    → offices whose zone matches the user's area
+//   "branch" → only the user's own pwa_code
+//
+// Handlers call authorizeBranches / authorizeZone before touching data, and
+// authorizeCapability for per-layer actions (see services/capabilities.go).
+// Both write a 403 JSON response and return false when access is denied,
+// so the handler only needs to `return`.
+// ========================================================================
+
+// Permission levels stored in the session under permission_leak.
+const (
+	permLevelAll    = "all"
+	permLevelReg    = "reg"
+	permLevelBranch = "branch"
+)
+
+// accessScope is the branch-visibility scope of the current user.
+type accessScope struct {
+	Level    string   // "all" | "reg" | "branch"
+	Zone     string   // user's area — used by "reg"
+	PwaCode  string   // user's own branch — used by "branch"
+	Branches []string // explicit branch list (API tokens) — overrides PwaCode for "branch"
+}
+
+// branchList returns the branches a "branch" scope may access.
+func (s accessScope) branchList() []string {
+	if len(s.Branches) > 0 {
+		return s.Branches
+	}
+	if s.PwaCode != "" {
+		return []string{s.PwaCode}
+	}
+	return nil
+}
+
+// currentScope reads the scope from values set by AuthRequired.
+func currentScope(c *gin.Context) accessScope {
+	level, _ := c.Get("permission_leak")
+	area, _ := c.Get("area")
+	pwaCode, _ := c.Get("pwacode")
+	branches, _ := c.Get("pwa_codes")
+	list, _ := branches.([]string)
+	return accessScope{
+		Level:    strOrEmpty(level),
+		Zone:     strOrEmpty(area),
+		PwaCode:  strOrEmpty(pwaCode),
+		Branches: list,
+	}
+}
+
+// checkBranches returns an empty reason when every pwaCode is inside the
+// scope, otherwise a human-readable reason for the first denied code.
+func (s accessScope) checkBranches(pwaCodes []string) string {
+	switch s.Level {
+	case permLevelAll:
+		return ""
+
+	case permLevelReg:
+		if s.Zone == "" {
+			return "regional permission has no zone assigned"
+		}
+		offices, err := services.GetOfficesByZone(s.Zone)
+		if err != nil {
+			log.Printf("[Authz] GetOfficesByZone(%s) failed: %v", s.Zone, err)
+			return "unable to resolve branches for zone " + s.Zone
+		}
+		allowed := make(map[string]bool, len(offices))
+		for _, o := range offices {
+			allowed[o.PwaCode] = true
+		}
+		for _, code := range pwaCodes {
+			if !allowed[code] {
+				return fmt.Sprintf("branch %s is outside zone %s", code, s.Zone)
+			}
+		}
+		return ""
+
+	case permLevelBranch:
+		own := s.branchList()
+		if len(own) == 0 {
+			return "branch permission has no pwa_code assigned"
+		}
+		allowed := make(map[string]bool, len(own))
+		for _, code := range own {
+			allowed[code] = true
+		}
+		for _, code := range pwaCodes {
+			if !allowed[code] {
+				return fmt.Sprintf("branch %s is not your branch (%s)", code, strings.Join(own, ","))
+			}
+		}
+		return ""
+	}
+
+	return "unknown permission level: " + s.Level
+}
+
+// checkZone returns an empty reason when the zone is inside the scope.
+// Branch-level users may query the zone of their own office, but zone-wide
+// data is then narrowed to their branches (see scopeDashboard); an empty
+// zone ("all zones") is only allowed for "all".
+func (s accessScope) checkZone(zone string) string {
+	switch s.Level {
+	case permLevelAll:
+		return ""
+
+	case permLevelReg:
+		if zone == "" {
+			return "regional permission cannot query all zones"
+		}
+		if zone != s.Zone {
+			return fmt.Sprintf("zone %s is outside your zone (%s)", zone, s.Zone)
+		}
+		return ""
+
+	case permLevelBranch:
+		own := s.branchList()
+		if len(own) == 0 {
+			return "branch permission has no pwa_code assigned"
+		}
+		if zone == "" {
+			return "branch permission cannot query all zones"
+		}
+		var ownZones []string
+		for _, code := range own {
+			ownZone, err := services.GetOfficeZone(code)
+			if err != nil {
+				log.Printf("[Authz] GetOfficeZone(%s) failed: %v", code, err)
+				return "unable to resolve zone for branch " + code
+			}
+			if zone == ownZone {
+				return ""
+			}
+			ownZones = append(ownZones, ownZone)
+		}
+		return fmt.Sprintf("zone %s is outside your branch zone (%s)", zone, strings.Join(ownZones, ","))
+	}
+
+	return "unknown permission level: " + s.Level
+}
+
+// defaultZone is the zone used when a zone-scoped endpoint is called without
+// one: empty (all zones) for "all", the user's own zone otherwise.
+func (s accessScope) defaultZone() string {
+	switch s.Level {
+	case permLevelReg:
+		return s.Zone
+	case permLevelBranch:
+		if own := s.branchList(); len(own) > 0 {
+			zone, _ := services.GetOfficeZone(own[0])
+			return zone
+		}
+	}
+	return ""
+}
+
+// authorizeBranches aborts with 403 unless the user may access every pwaCode.
+func authorizeBranches(c *gin.Context, pwaCodes ...string) bool {
+	reason := currentScope(c).checkBranches(pwaCodes)
+	if reason == "" {
+		return true
+	}
+	denyAccess(c, reason)
+	return false
+}
+
+// authorizeZone resolves the zone for a zone-scoped endpoint and aborts with
+// 403 if it is outside the user's scope. An empty zone is narrowed to the
+// user's own zone for non-"all" users so the default page load still works.
+func authorizeZone(c *gin.Context, zone string) (string, bool) {
+	scope := currentScope(c)
+	if zone == "" {
+		zone = scope.defaultZone()
+	}
+	if reason := scope.checkZone(zone); reason != "" {
+		denyAccess(c, reason)
+		return "", false
+	}
+	return zone, true
+}
+
+// scopeDashboard narrows a zone dashboard to the branches of a "branch"
+// scope, with totals recomputed; other scopes see the whole zone they were
+// authorized for.
+func scopeDashboard(c *gin.Context, summary *services.DashboardSummary) *services.DashboardSummary {
+	scope := currentScope(c)
+	if scope.Level != permLevelBranch {
+		return summary
+	}
+	return summary.OnlyBranches(scope.branchList())
+}
+
+// authorizeCapability aborts with 403 unless the user's permission level has
+// capability on every layer. With no layers the check is layer-independent.
+func authorizeCapability(c *gin.Context, capability string, layers ...string) bool {
+	level := currentScope(c).Level
+	if len(layers) == 0 {
+		layers = []string{""}
+	}
+	for _, layer := range layers {
+		if !services.HasCapability(level, layer, capability) {
+			reason := fmt.Sprintf("capability %s is not granted to %s", capability, level)
+			if layer != "" {
+				reason += " on layer " + layer
+			}
+			denyAccess(c, reason)
+			return false
+		}
+	}
+	return true
+}
+
+// exportCapability maps an export format to the capability it requires.
+func exportCapability(format string) string {
+	switch format {
+	case "csv", "xlsx", "excel":
+		return services.CapExportExcel
+	}
+	return services.CapExportVector
+}
+
+// piiMask returns how customer PII is emitted to the current user
+// (see services/pii_masking.go).
+func piiMask(c *gin.Context) services.MaskMode {
+	return services.PIIMaskModeFor(currentScope(c).Level)
+}
+
+// denyAccess writes the standard 403 response and records a
+// permission_denied audit event.
+func denyAccess(c *gin.Context, reason string) {
+	logPermissionDenied(c, reason)
+	c.AbortWithStatusJSON(http.StatusForbidden, gin.H{
+		"status":  "error",
+		"error":   "forbidden",
+		"reason":  reason,
+		"message": "คุณไม่มีสิทธิ์เข้าถึงข้อมูลนี้",
+	})
+}
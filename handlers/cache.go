@@ -5,12 +5,16 @@ import (
 	"encoding/hex"
 	"encoding/json"
 	"log"
+	"os"
+	"strings"
 	"sync"
 	"time"
+
+	"pwa_gis_tracking/config"
 )
 
 // ========================================================================
-// In-Memory TTL Cache for Dashboard API
+// TTL Cache for Dashboard API
 //
 // Two modes of operation:
 //   1. Lazy Cache (original)   — CacheTTL = 5 min, set on first user request
@@ -21,26 +25,95 @@ import (
 // If a warm cycle fails, stale data remains available until the next success.
 //
 // Cache key = sha256(zone|startDate|endDate)[:16]
+//
+// Storage is a Cache backend: in-memory per process (default) or a shared
+// Postgres table for multi-instance deployments (see cache_pg.go).
 // ========================================================================
 
 // CacheTTL is the TTL for lazy-loaded cache entries (user-triggered).
 var CacheTTL = 5 * time.Minute
 
 // WarmCacheTTL is the TTL for warmer-populated cache entries.
-// Set longer than WarmInterval to provide overlap/fallback.
+// Set longer than the default warm schedule (every 15 minutes) to provide
+// overlap/fallback; a warm schedule may override it with "ttl".
 var WarmCacheTTL = 20 * time.Minute
 
-type cacheEntry struct {
+// CacheStaleTTL is how long an entry is kept after it expires so that
+// GetDashboardSummary can serve it (X-Cache: STALE) while it is refreshed.
+var CacheStaleTTL = 30 * time.Minute
+
+// CacheEntry is one cached dashboard response.
+type CacheEntry struct {
 	Data      json.RawMessage
 	ExpiresAt time.Time
-	Source    string // "lazy" or "warm" — for logging/monitoring
+	Source    string    // "lazy" or "warm" — for logging/monitoring
+	StoredAt  time.Time // when the data was computed
+}
+
+// CacheEntryInfo describes an entry for GetCacheStats (without the data).
+type CacheEntryInfo struct {
+	Key       string
+	Source    string
+	ExpiresAt time.Time
+	SizeBytes int
+}
+
+// Cache is a dashboard cache backend. Backends are best-effort: errors are
+// logged and treated as a miss, never returned to the request.
+//
+// DASHBOARD_CACHE selects the backend (InitDashboardCache):
+//   "memory"   (default) – process-local map
+//   "postgres"           – shared table cache.pwagis_dashboard_cache, so
+//                          every instance sees the same warm entries and
+//                          an invalidation on one instance clears all
+type Cache interface {
+	Get(key string) (CacheEntry, bool) // fresh or stale (expired < CacheStaleTTL ago)
+	Set(key string, entry CacheEntry)
+	Delete(key string)
+	Clear() int                // removes every entry, returns how many
+	CleanExpired() int         // removes entries past their stale window
+	Entries() []CacheEntryInfo // including expired, for monitoring
+	Name() string
 }
 
 var (
-	dashCache   = make(map[string]cacheEntry)
+	dashCache   Cache = newMemoryCache()
 	dashCacheMu sync.RWMutex
 )
 
+// InitDashboardCache selects the cache backend from DASHBOARD_CACHE.
+// Call once from main.go after ConnectPostgres; exits on invalid configuration.
+func InitDashboardCache() {
+	var c Cache
+	switch backend := strings.ToLower(os.Getenv("DASHBOARD_CACHE")); backend {
+	case "", "memory":
+		c = newMemoryCache()
+	case "postgres":
+		if config.PgDB == nil {
+			log.Fatalf("DASHBOARD_CACHE=postgres requires a PostgreSQL connection")
+		}
+		c = newPGCache(config.PgDB)
+	default:
+		log.Fatalf("unknown DASHBOARD_CACHE: %s", backend)
+	}
+	SetDashboardCache(c)
+	log.Printf("Dashboard cache: %s", c.Name())
+}
+
+// SetDashboardCache replaces the active backend.
+func SetDashboardCache(c Cache) {
+	dashCacheMu.Lock()
+	dashCache = c
+	dashCacheMu.Unlock()
+}
+
+// activeCache returns the current backend.
+func activeCache() Cache {
+	dashCacheMu.RLock()
+	defer dashCacheMu.RUnlock()
+	return dashCache
+}
+
 // CacheKey generates a deterministic cache key from query parameters.
 func CacheKey(parts ...string) string {
 	h := sha256.New()
@@ -51,17 +124,17 @@ func CacheKey(parts ...string) string {
 	return hex.EncodeToString(h.Sum(nil))[:16]
 }
 
+// Stale reports whether the entry has expired (but is still within its
+// stale window, otherwise the backend would not have returned it).
+func (e CacheEntry) Stale() bool {
+	return time.Now().After(e.ExpiresAt)
+}
+
 // GetCachedDashboard returns cached dashboard data if available and not expired.
 // Returns nil if cache miss or expired.
 func GetCachedDashboard(key string) json.RawMessage {
-	dashCacheMu.RLock()
-	defer dashCacheMu.RUnlock()
-
-	entry, ok := dashCache[key]
-	if !ok {
-		return nil
-	}
-	if time.Now().After(entry.ExpiresAt) {
+	entry, ok := activeCache().Get(key)
+	if !ok || entry.Stale() {
 		return nil
 	}
 	log.Printf("[Cache] HIT key=%s source=%s expires_in=%v", key, entry.Source, time.Until(entry.ExpiresAt).Round(time.Second))
@@ -76,42 +149,37 @@ func SetCachedDashboard(key string, data interface{}) {
 		log.Printf("[Cache] marshal error: %v", err)
 		return
 	}
-	SetCachedDashboardRaw(key, raw, CacheTTL)
+	SetCachedDashboardRaw(key, raw, CacheTTL, "lazy")
 }
 
 // SetCachedDashboardRaw stores pre-serialised JSON with a custom TTL.
-// Used by both lazy cache and the background warmer.
-func SetCachedDashboardRaw(key string, raw json.RawMessage, ttl time.Duration) {
-	dashCacheMu.Lock()
-	defer dashCacheMu.Unlock()
-
-	source := "lazy"
-	if ttl == WarmCacheTTL {
-		source = "warm"
-	}
-
-	dashCache[key] = cacheEntry{
+// Used by both lazy cache and the background warmer; source ("lazy" or
+// "warm") is recorded for monitoring.
+func SetCachedDashboardRaw(key string, raw json.RawMessage, ttl time.Duration, source string) {
+	now := time.Now()
+	activeCache().Set(key, CacheEntry{
 		Data:      raw,
-		ExpiresAt: time.Now().Add(ttl),
+		ExpiresAt: now.Add(ttl),
 		Source:    source,
-	}
+		StoredAt:  now,
+	})
 	log.Printf("[Cache] SET key=%s source=%s ttl=%v", key, source, ttl)
 }
 
 // InvalidateDashboardCache clears all cached dashboard data.
 // Call this when underlying data changes (e.g. after data import).
-func InvalidateDashboardCache() {
-	dashCacheMu.Lock()
-	defer dashCacheMu.Unlock()
-	count := len(dashCache)
-	dashCache = make(map[string]cacheEntry)
+// With a shared backend this clears the entries of every instance.
+func InvalidateDashboardCache() int {
+	count := activeCache().Clear()
 	log.Printf("[Cache] INVALIDATED (%d entries removed)", count)
+	SaveCacheSnapshot()
+	return count
 }
 
 // GetCacheStats returns monitoring info about current cache state.
 func GetCacheStats() map[string]interface{} {
-	dashCacheMu.RLock()
-	defer dashCacheMu.RUnlock()
+	c := activeCache()
+	all := c.Entries()
 
 	now := time.Now()
 	warmCount := 0
@@ -119,8 +187,9 @@ func GetCacheStats() map[string]interface{} {
 	expiredCount := 0
 	entries := []map[string]interface{}{}
 
-	for k, v := range dashCache {
+	for _, v := range all {
 		if now.After(v.ExpiresAt) {
+			// Stale: still served while it is refreshed
 			expiredCount++
 			continue
 		}
@@ -130,15 +199,16 @@ func GetCacheStats() map[string]interface{} {
 			lazyCount++
 		}
 		entries = append(entries, map[string]interface{}{
-			"key":        k,
+			"key":        v.Key,
 			"source":     v.Source,
 			"expires_in": time.Until(v.ExpiresAt).Round(time.Second).String(),
-			"size_bytes": len(v.Data),
+			"size_bytes": v.SizeBytes,
 		})
 	}
 
 	return map[string]interface{}{
-		"total_entries":   len(dashCache),
+		"backend":         c.Name(),
+		"total_entries":   len(all),
 		"warm_entries":    warmCount,
 		"lazy_entries":    lazyCount,
 		"expired_entries": expiredCount,
@@ -148,19 +218,84 @@ func GetCacheStats() map[string]interface{} {
 
 // CleanExpiredCache removes expired entries. Run periodically.
 func CleanExpiredCache() {
-	dashCacheMu.Lock()
-	defer dashCacheMu.Unlock()
+	if removed := activeCache().CleanExpired(); removed > 0 {
+		log.Printf("[Cache] Cleaned %d expired entries", removed)
+	}
+}
+
+// ─── In-memory backend ───────────────────────────────────────────────────────
+
+// memoryCache is the process-local backend.
+type memoryCache struct {
+	mu sync.RWMutex
+	m  map[string]CacheEntry
+}
+
+func newMemoryCache() *memoryCache {
+	return &memoryCache{m: make(map[string]CacheEntry)}
+}
+
+// Name implements Cache.
+func (m *memoryCache) Name() string { return "memory" }
+
+// Get implements Cache.
+func (m *memoryCache) Get(key string) (CacheEntry, bool) {
+	m.mu.RLock()
+	defer m.mu.RUnlock()
+	entry, ok := m.m[key]
+	if !ok || time.Now().After(entry.ExpiresAt.Add(CacheStaleTTL)) {
+		return CacheEntry{}, false
+	}
+	return entry, true
+}
+
+// Set implements Cache.
+func (m *memoryCache) Set(key string, entry CacheEntry) {
+	m.mu.Lock()
+	m.m[key] = entry
+	m.mu.Unlock()
+}
+
+// Delete implements Cache.
+func (m *memoryCache) Delete(key string) {
+	m.mu.Lock()
+	delete(m.m, key)
+	m.mu.Unlock()
+}
+
+// Clear implements Cache.
+func (m *memoryCache) Clear() int {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	count := len(m.m)
+	m.m = make(map[string]CacheEntry)
+	return count
+}
+
+// CleanExpired implements Cache.
+func (m *memoryCache) CleanExpired() int {
+	m.mu.Lock()
+	defer m.mu.Unlock()
 	now := time.Now()
 	removed := 0
-	for k, v := range dashCache {
-		if now.After(v.ExpiresAt) {
-			delete(dashCache, k)
+	for k, v := range m.m {
+		if now.After(v.ExpiresAt.Add(CacheStaleTTL)) {
+			delete(m.m, k)
 			removed++
 		}
 	}
-	if removed > 0 {
-		log.Printf("[Cache] Cleaned %d expired entries", removed)
+	return removed
+}
+
+// Entries implements Cache.
+func (m *memoryCache) Entries() []CacheEntryInfo {
+	m.mu.RLock()
+	defer m.mu.RUnlock()
+	list := make([]CacheEntryInfo, 0, len(m.m))
+	for k, v := range m.m {
+		list = append(list, CacheEntryInfo{Key: k, Source: v.Source, ExpiresAt: v.ExpiresAt, SizeBytes: len(v.Data)})
 	}
+	return list
 }
 
 // StartCacheCleaner runs a background goroutine to clean expired entries every minute.
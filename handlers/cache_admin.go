@@ -0,0 +1,175 @@
+package handlers
+
+import (
+	"encoding/json"
+	"net/http"
+	"strings"
+	"time"
+
+	"pwa_gis_tracking/services"
+
+	"github.com/gin-gonic/gin"
+)
+
+// ========================================================================
+// Dashboard cache administration (admin only)
+//
+//   GET  /api/admin/cache/status       backend, counts and live entries
+//   GET  /api/admin/cache/entry        one entry: metadata, age, decoded summary
+//   POST /api/admin/cache/invalidate   drop all, or by zone / branch / dates / key
+//
+// Targeted invalidation matches the zone/date recorded for each key by
+// dashboardKey, i.e. the keys this instance has built or restored. A zone
+// also drops the all-zones keys, whose totals include it. Use {"all": true}
+// to clear keys written by other instances of a shared backend as well.
+// ========================================================================
+
+// cacheInvalidateRequest selects the dashboard keys to drop. Filters combine.
+type cacheInvalidateRequest struct {
+	All       bool   `json:"all"`
+	Key       string `json:"key"`
+	Zone      string `json:"zone"`
+	PwaCode   string `json:"pwa_code"`   // resolved to the branch's zone
+	StartDate string `json:"start_date"` // with end_date: only keys of exactly this range
+	EndDate   string `json:"end_date"`
+}
+
+// InvalidateCache drops dashboard cache entries (recomputed on next load).
+// POST /api/admin/cache/invalidate
+func InvalidateCache(c *gin.Context) {
+	var req cacheInvalidateRequest
+	if err := c.ShouldBindJSON(&req); err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "invalid request body: " + err.Error()})
+		return
+	}
+
+	if req.All {
+		removed := InvalidateDashboardCache()
+		LogAuditEvent(c, "cache_invalidate", "cache", "all")
+		c.JSON(http.StatusOK, gin.H{"status": "success", "message": "Cache invalidated", "removed": removed})
+		return
+	}
+	if req.Key != "" {
+		activeCache().Delete(req.Key)
+		LogAuditEvent(c, "cache_invalidate", "cache", "key:"+req.Key)
+		c.JSON(http.StatusOK, gin.H{"status": "success", "message": "Cache invalidated", "removed": 1})
+		return
+	}
+
+	zone := req.Zone
+	if req.PwaCode != "" {
+		z, err := services.GetOfficeZone(req.PwaCode)
+		if err != nil {
+			c.JSON(http.StatusInternalServerError, gin.H{"error": err.Error()})
+			return
+		}
+		if z == "" {
+			c.JSON(http.StatusNotFound, gin.H{"error": "unknown pwa_code: " + req.PwaCode})
+			return
+		}
+		if zone != "" && zone != z {
+			c.JSON(http.StatusBadRequest, gin.H{"error": "pwa_code " + req.PwaCode + " is not in zone " + zone})
+			return
+		}
+		zone = z
+	}
+	dated := req.StartDate != "" || req.EndDate != ""
+	if zone == "" && !dated {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "all=true, key, zone, pwa_code or start_date/end_date is required"})
+		return
+	}
+
+	keys := matchDashboardKeys(func(info dashKeyInfo) bool {
+		if zone != "" && info.Zone != "" && info.Zone != zone {
+			return false
+		}
+		return !dated || (info.StartDate == req.StartDate && info.EndDate == req.EndDate)
+	})
+	cache := activeCache()
+	for _, key := range keys {
+		cache.Delete(key)
+	}
+
+	var scope []string
+	if zone != "" {
+		scope = append(scope, "zone:"+zone)
+	}
+	if dated {
+		scope = append(scope, "dates:"+req.StartDate+".."+req.EndDate)
+	}
+	LogAuditEvent(c, "cache_invalidate", "cache", strings.Join(scope, " "))
+	c.JSON(http.StatusOK, gin.H{
+		"status":  "success",
+		"message": "Cache invalidated",
+		"removed": len(keys),
+		"zone":    zone,
+		"keys":    keys,
+	})
+}
+
+// matchDashboardKeys returns the known dashboard keys accepted by match.
+func matchDashboardKeys(match func(dashKeyInfo) bool) []string {
+	dashKeysMu.Lock()
+	defer dashKeysMu.Unlock()
+	keys := []string{}
+	for key, info := range dashKeys {
+		if match(info) {
+			keys = append(keys, key)
+		}
+	}
+	return keys
+}
+
+// GetCacheEntry describes one dashboard cache entry: where it came from,
+// how old it is and what it contains, without the branch rows.
+// GET /api/admin/cache/entry?key=xxx
+// GET /api/admin/cache/entry?zone=xxx&startDate=xxx&endDate=xxx
+func GetCacheEntry(c *gin.Context) {
+	key := c.Query("key")
+	if key == "" {
+		key = CacheKey("dashboard", c.Query("zone"), c.Query("startDate"), c.Query("endDate"))
+	}
+
+	cache := activeCache()
+	entry, ok := cache.Get(key)
+	if !ok {
+		c.JSON(http.StatusNotFound, gin.H{"error": "cache entry not found", "key": key})
+		return
+	}
+
+	now := time.Now()
+	data := gin.H{
+		"key":        key,
+		"backend":    cache.Name(),
+		"source":     entry.Source,
+		"stale":      entry.Stale(),
+		"expires_at": entry.ExpiresAt,
+		"expires_in": entry.ExpiresAt.Sub(now).Round(time.Second).String(),
+		"size_bytes": len(entry.Data),
+	}
+	if !entry.StoredAt.IsZero() {
+		data["stored_at"] = entry.StoredAt
+		data["age"] = now.Sub(entry.StoredAt).Round(time.Second).String()
+	}
+
+	dashKeysMu.Lock()
+	info, known := dashKeys[key]
+	dashKeysMu.Unlock()
+	if known {
+		data["params"] = gin.H{"zone": info.Zone, "startDate": info.StartDate, "endDate": info.EndDate}
+	}
+
+	var summary services.DashboardSummary
+	if err := json.Unmarshal(entry.Data, &summary); err != nil {
+		data["decode_error"] = err.Error()
+	} else {
+		data["summary"] = gin.H{
+			"status":         summary.Status,
+			"total_branches": summary.TotalBranches,
+			"zone_names":     summary.ZoneNames,
+			"grand_total":    summary.GrandTotal,
+		}
+	}
+
+	c.JSON(http.StatusOK, gin.H{"status": "success", "data": data})
+}
@@ -0,0 +1,223 @@
+package handlers
+
+import (
+	"context"
+	"encoding/json"
+	"log"
+	"os"
+	"sync"
+	"time"
+
+	"pwa_gis_tracking/services"
+)
+
+// ========================================================================
+// Event-driven dashboard cache invalidation
+//
+// services.StartFeatureChangeWatcher reports changed features_<id>
+// collections (MongoDB change stream, or polling without a replica set).
+// Changes are collected for CACHE_INVALIDATE_DEBOUNCE (default 15s) so a
+// bulk upload becomes one refresh, then:
+//
+//   collection id ──alias──► b{pwaCode}_{layer} ──pwa_office234──► zone
+//
+// and only the dashboard keys of that zone (plus the all-zones key) are
+// touched: keys without a date range are re-warmed in the background, keys
+// with a date range are dropped and recomputed on the next request.
+//
+// The zone/date behind each hashed key is kept in dashKeys when the key is
+// built (dashboardKey). With DASHBOARD_CACHE=postgres every instance runs
+// its own watcher for the keys it serves.
+// ========================================================================
+
+// dashKeyInfo is what a dashboard cache key was built from.
+type dashKeyInfo struct {
+	Zone      string
+	StartDate string
+	EndDate   string
+	LastUsed  time.Time
+}
+
+var (
+	dashKeys   = make(map[string]dashKeyInfo)
+	dashKeysMu sync.Mutex
+)
+
+// dashboardKey returns the cache key of a dashboard query and remembers
+// its parameters for targeted invalidation.
+func dashboardKey(zone, startDate, endDate string) string {
+	key := CacheKey("dashboard", zone, startDate, endDate)
+	dashKeysMu.Lock()
+	dashKeys[key] = dashKeyInfo{Zone: zone, StartDate: startDate, EndDate: endDate, LastUsed: time.Now()}
+	dashKeysMu.Unlock()
+	return key
+}
+
+// featureInvalidator collects changed collection ids between flushes.
+type featureInvalidator struct {
+	mu       sync.Mutex
+	pending  map[string]bool // collection ids
+	metadata bool
+	zones    map[string]string // pwaCode → zone
+}
+
+// StartCacheInvalidator starts the change watcher and the debounced flush.
+func StartCacheInvalidator(ctx context.Context) {
+	debounce := 15 * time.Second
+	if d, err := time.ParseDuration(os.Getenv("CACHE_INVALIDATE_DEBOUNCE")); err == nil && d > 0 {
+		debounce = d
+	}
+	poll := 2 * time.Minute
+	if d, err := time.ParseDuration(os.Getenv("CACHE_CHANGE_POLL_INTERVAL")); err == nil && d > 0 {
+		poll = d
+	}
+
+	inv := &featureInvalidator{pending: make(map[string]bool), zones: make(map[string]string)}
+	services.StartFeatureChangeWatcher(ctx, poll, inv.add)
+
+	go func() {
+		ticker := time.NewTicker(debounce)
+		defer ticker.Stop()
+		for {
+			select {
+			case <-ticker.C:
+				inv.flush()
+			case <-ctx.Done():
+				return
+			}
+		}
+	}()
+}
+
+func (inv *featureInvalidator) add(ch services.FeatureChange) {
+	inv.mu.Lock()
+	if ch.CollectionID != "" {
+		inv.pending[ch.CollectionID] = true
+	}
+	if ch.Metadata {
+		inv.metadata = true
+	}
+	inv.mu.Unlock()
+}
+
+// flush maps the pending collections to zones and refreshes their keys.
+func (inv *featureInvalidator) flush() {
+	inv.mu.Lock()
+	ids, metadata := inv.pending, inv.metadata
+	inv.pending, inv.metadata = make(map[string]bool), false
+	inv.mu.Unlock()
+	pruneDashboardKeys()
+	if len(ids) == 0 && !metadata {
+		return
+	}
+
+	// Resolve aliases before a reload (a deleted alias is gone after it),
+	// then reload for new collections and retry the unknown ids
+	zones := make(map[string]bool)
+	var unknown []string
+	for id := range ids {
+		if !inv.resolveZone(id, zones) {
+			unknown = append(unknown, id)
+		}
+	}
+	if metadata || len(unknown) > 0 {
+		if err := services.ReloadCollectionIndex(); err != nil {
+			log.Printf("[CacheInvalidate] collection index reload failed: %v", err)
+		}
+		for _, id := range unknown {
+			if !inv.resolveZone(id, zones) {
+				log.Printf("[CacheInvalidate] changed collection features_%s has no branch alias", id)
+			}
+		}
+	}
+	if len(zones) == 0 {
+		return
+	}
+
+	refreshed, dropped := refreshDashboardZones(zones)
+	log.Printf("[CacheInvalidate] %d collections changed in zones %v: %d keys re-warming, %d dropped",
+		len(ids), zoneList(zones), refreshed, dropped)
+}
+
+// resolveZone adds the zone of collection id to zones.
+func (inv *featureInvalidator) resolveZone(id string, zones map[string]bool) bool {
+	alias, ok := services.CollectionAlias(id)
+	if !ok {
+		return false
+	}
+	pwaCode, _, ok := services.ParseCollectionAlias(alias)
+	if !ok {
+		return false
+	}
+	zone, ok := inv.zones[pwaCode]
+	if !ok {
+		var err error
+		if zone, err = services.GetOfficeZone(pwaCode); err != nil {
+			return false
+		}
+		inv.zones[pwaCode] = zone
+	}
+	if zone != "" { // branches outside pwa_office234 are not on the dashboard
+		zones[zone] = true
+	}
+	return true
+}
+
+// refreshDashboardZones re-warms or drops every known key of the zones
+// (and the all-zones keys).
+func refreshDashboardZones(zones map[string]bool) (refreshed, dropped int) {
+	dashKeysMu.Lock()
+	affected := make(map[string]dashKeyInfo)
+	for key, info := range dashKeys {
+		if info.Zone == "" || zones[info.Zone] {
+			affected[key] = info
+		}
+	}
+	dashKeysMu.Unlock()
+
+	cache := activeCache()
+	for key, info := range affected {
+		if info.StartDate != "" || info.EndDate != "" {
+			cache.Delete(key)
+			dropped++
+			continue
+		}
+		key, info := key, info
+		started := dashFlight.Refresh(key, func() (json.RawMessage, error) {
+			raw, err := computeDashboardCached(key, info.Zone, "", "", WarmCacheTTL, "warm")
+			if err != nil {
+				log.Printf("[CacheInvalidate] re-warm key=%s failed: %v", key, err)
+			}
+			return raw, err
+		})
+		if !started {
+			// A computation already running may predate the change
+			cache.Delete(key)
+			dropped++
+			continue
+		}
+		refreshed++
+	}
+	return refreshed, dropped
+}
+
+// pruneDashboardKeys forgets keys that have not been used for longer than
+// any entry can live.
+func pruneDashboardKeys() {
+	cutoff := time.Now().Add(-(WarmCacheTTL + CacheStaleTTL))
+	dashKeysMu.Lock()
+	for key, info := range dashKeys {
+		if info.LastUsed.Before(cutoff) {
+			delete(dashKeys, key)
+		}
+	}
+	dashKeysMu.Unlock()
+}
+
+func zoneList(zones map[string]bool) []string {
+	list := make([]string, 0, len(zones))
+	for z := range zones {
+		list = append(list, z)
+	}
+	return list
+}
@@ -0,0 +1,129 @@
+package handlers
+
+import (
+	"database/sql"
+	"log"
+	"time"
+)
+
+// ========================================================================
+// Shared Dashboard Cache — PostgreSQL backend (DASHBOARD_CACHE=postgres)
+//
+// Entries live in cache.pwagis_dashboard_cache (sql/create_dashboard_cache.sql),
+// an UNLOGGED table shared by every instance behind the load balancer:
+//   - a warm entry written by one instance is a HIT on all of them
+//   - InvalidateDashboardCache deletes the rows, so it reaches all instances
+//
+// Expiry (plus CacheStaleTTL) is compared against the application clock,
+// passed as a parameter, the same clock the in-memory backend uses.
+// ========================================================================
+
+// pgCache is the Postgres-table backend.
+type pgCache struct {
+	db *sql.DB
+}
+
+func newPGCache(db *sql.DB) *pgCache {
+	return &pgCache{db: db}
+}
+
+// Name implements Cache.
+func (p *pgCache) Name() string { return "postgres" }
+
+// Get implements Cache.
+func (p *pgCache) Get(key string) (CacheEntry, bool) {
+	var e CacheEntry
+	var data []byte
+	err := p.db.QueryRow(`
+		SELECT data, expires_at, source, updated_at FROM cache.pwagis_dashboard_cache
+		WHERE cache_key = $1 AND expires_at > $2
+	`, key, time.Now().Add(-CacheStaleTTL)).Scan(&data, &e.ExpiresAt, &e.Source, &e.StoredAt)
+	if err == sql.ErrNoRows {
+		return e, false
+	}
+	if err != nil {
+		log.Printf("[Cache] postgres get %s failed: %v", key, err)
+		return e, false
+	}
+	e.Data = data
+	return e, true
+}
+
+// Set implements Cache. PG 9.4 has no ON CONFLICT, so this is UPDATE then
+// INSERT; a concurrent insert of the same key by another instance wins.
+func (p *pgCache) Set(key string, entry CacheEntry) {
+	if entry.StoredAt.IsZero() {
+		entry.StoredAt = time.Now()
+	}
+	res, err := p.db.Exec(`
+		UPDATE cache.pwagis_dashboard_cache
+		SET data = $2, expires_at = $3, source = $4, updated_at = $5
+		WHERE cache_key = $1
+	`, key, []byte(entry.Data), entry.ExpiresAt, entry.Source, entry.StoredAt)
+	if err != nil {
+		log.Printf("[Cache] postgres set %s failed: %v", key, err)
+		return
+	}
+	if n, _ := res.RowsAffected(); n > 0 {
+		return
+	}
+	if _, err := p.db.Exec(`
+		INSERT INTO cache.pwagis_dashboard_cache (cache_key, data, expires_at, source, updated_at)
+		SELECT $1, $2, $3, $4, $5
+		WHERE NOT EXISTS (SELECT 1 FROM cache.pwagis_dashboard_cache WHERE cache_key = $1)
+	`, key, []byte(entry.Data), entry.ExpiresAt, entry.Source, entry.StoredAt); err != nil {
+		log.Printf("[Cache] postgres insert %s failed: %v", key, err)
+	}
+}
+
+// Delete implements Cache.
+func (p *pgCache) Delete(key string) {
+	if _, err := p.db.Exec(`DELETE FROM cache.pwagis_dashboard_cache WHERE cache_key = $1`, key); err != nil {
+		log.Printf("[Cache] postgres delete %s failed: %v", key, err)
+	}
+}
+
+// Clear implements Cache.
+func (p *pgCache) Clear() int {
+	res, err := p.db.Exec(`DELETE FROM cache.pwagis_dashboard_cache`)
+	if err != nil {
+		log.Printf("[Cache] postgres clear failed: %v", err)
+		return 0
+	}
+	n, _ := res.RowsAffected()
+	return int(n)
+}
+
+// CleanExpired implements Cache.
+func (p *pgCache) CleanExpired() int {
+	res, err := p.db.Exec(`DELETE FROM cache.pwagis_dashboard_cache WHERE expires_at <= $1`, time.Now().Add(-CacheStaleTTL))
+	if err != nil {
+		log.Printf("[Cache] postgres clean failed: %v", err)
+		return 0
+	}
+	n, _ := res.RowsAffected()
+	return int(n)
+}
+
+// Entries implements Cache.
+func (p *pgCache) Entries() []CacheEntryInfo {
+	list := []CacheEntryInfo{}
+	rows, err := p.db.Query(`
+		SELECT cache_key, source, expires_at, octet_length(data)
+		FROM cache.pwagis_dashboard_cache ORDER BY cache_key
+	`)
+	if err != nil {
+		log.Printf("[Cache] postgres list failed: %v", err)
+		return list
+	}
+	defer rows.Close()
+	for rows.Next() {
+		var e CacheEntryInfo
+		if err := rows.Scan(&e.Key, &e.Source, &e.ExpiresAt, &e.SizeBytes); err != nil {
+			log.Printf("[Cache] postgres scan failed: %v", err)
+			return list
+		}
+		list = append(list, e)
+	}
+	return list
+}
@@ -0,0 +1,198 @@
+package handlers
+
+import (
+	"context"
+	"encoding/json"
+	"log"
+	"os"
+	"path/filepath"
+	"time"
+)
+
+// ========================================================================
+// Dashboard cache snapshot — survive restarts of the in-memory backend
+//
+// The memory backend starts empty after every deploy, and the first warm
+// cycle takes minutes. The cache is therefore written to a snapshot file
+// every DASHBOARD_CACHE_SNAPSHOT_INTERVAL and on shutdown, and loaded on
+// startup:
+//
+//   startup ──► RestoreCacheSnapshot ──► entries served as X-Cache: STALE
+//                                        (background refresh per key)
+//           ──► StartCacheWarmer     ──► fresh entries replace them
+//
+// Restored entries keep their source, but are marked expired as of the
+// restart so they are never served as a HIT and live for CacheStaleTTL.
+// Entries that expired more than DASHBOARD_CACHE_SNAPSHOT_MAX_AGE before
+// the restart are dropped. The zone/date of each key is saved too, so the
+// cache invalidator can still target restored keys.
+//
+// The postgres backend is shared and outlives the process; no snapshot.
+//
+// Env: DASHBOARD_CACHE_SNAPSHOT (dashboard_cache_snapshot.json, "off" to
+//      disable), DASHBOARD_CACHE_SNAPSHOT_INTERVAL (5m),
+//      DASHBOARD_CACHE_SNAPSHOT_MAX_AGE (24h)
+// ========================================================================
+
+// cacheSnapshot is the snapshot file.
+type cacheSnapshot struct {
+	SavedAt time.Time            `json:"saved_at"`
+	Entries []cacheSnapshotEntry `json:"entries"`
+}
+
+type cacheSnapshotEntry struct {
+	Key       string          `json:"key"`
+	Zone      string          `json:"zone"`
+	StartDate string          `json:"start_date"`
+	EndDate   string          `json:"end_date"`
+	Known     bool            `json:"known"` // zone/date above are set
+	Source    string          `json:"source"`
+	ExpiresAt time.Time       `json:"expires_at"`
+	StoredAt  time.Time       `json:"stored_at"`
+	Data      json.RawMessage `json:"data"`
+}
+
+// cacheSnapshotPath returns the snapshot file, or "" when snapshots do not
+// apply (disabled, or a shared backend).
+func cacheSnapshotPath() string {
+	if activeCache().Name() != "memory" {
+		return ""
+	}
+	path := os.Getenv("DASHBOARD_CACHE_SNAPSHOT")
+	switch path {
+	case "":
+		return "dashboard_cache_snapshot.json"
+	case "off":
+		return ""
+	}
+	return path
+}
+
+// RestoreCacheSnapshot loads the snapshot into the cache. Call once from
+// main.go after InitDashboardCache and before StartCacheWarmer.
+func RestoreCacheSnapshot() {
+	path := cacheSnapshotPath()
+	if path == "" {
+		return
+	}
+	raw, err := os.ReadFile(path)
+	if os.IsNotExist(err) {
+		return
+	}
+	if err != nil {
+		log.Printf("[CacheSnapshot] read %s failed: %v", path, err)
+		return
+	}
+	var snap cacheSnapshot
+	if err := json.Unmarshal(raw, &snap); err != nil {
+		log.Printf("[CacheSnapshot] %s is not a valid snapshot, ignored: %v", path, err)
+		return
+	}
+
+	maxAge := 24 * time.Hour
+	if d, err := time.ParseDuration(os.Getenv("DASHBOARD_CACHE_SNAPSHOT_MAX_AGE")); err == nil && d > 0 {
+		maxAge = d
+	}
+	now := time.Now()
+	cache := activeCache()
+	restored, skipped := 0, 0
+	for _, e := range snap.Entries {
+		if now.Sub(e.ExpiresAt) > maxAge || len(e.Data) == 0 {
+			skipped++
+			continue
+		}
+		cache.Set(e.Key, CacheEntry{Data: e.Data, ExpiresAt: now, Source: e.Source, StoredAt: e.StoredAt})
+		if e.Known {
+			dashKeysMu.Lock()
+			dashKeys[e.Key] = dashKeyInfo{Zone: e.Zone, StartDate: e.StartDate, EndDate: e.EndDate, LastUsed: now}
+			dashKeysMu.Unlock()
+		}
+		restored++
+	}
+	log.Printf("[CacheSnapshot] restored %d entries from %s (saved %s ago, %d too old)",
+		restored, path, now.Sub(snap.SavedAt).Round(time.Second), skipped)
+}
+
+// SaveCacheSnapshot writes the cache to the snapshot file. The file is
+// replaced atomically so a crash mid-write keeps the previous snapshot.
+// InvalidateDashboardCache calls it right after clearing the cache.
+func SaveCacheSnapshot() {
+	path := cacheSnapshotPath()
+	if path == "" {
+		return
+	}
+
+	dashKeysMu.Lock()
+	keys := make(map[string]dashKeyInfo, len(dashKeys))
+	for k, v := range dashKeys {
+		keys[k] = v
+	}
+	dashKeysMu.Unlock()
+
+	cache := activeCache()
+	snap := cacheSnapshot{SavedAt: time.Now(), Entries: []cacheSnapshotEntry{}}
+	for _, info := range cache.Entries() {
+		entry, ok := cache.Get(info.Key)
+		if !ok {
+			continue
+		}
+		se := cacheSnapshotEntry{Key: info.Key, Source: entry.Source, ExpiresAt: entry.ExpiresAt, StoredAt: entry.StoredAt, Data: entry.Data}
+		if k, ok := keys[info.Key]; ok {
+			se.Zone, se.StartDate, se.EndDate, se.Known = k.Zone, k.StartDate, k.EndDate, true
+		}
+		snap.Entries = append(snap.Entries, se)
+	}
+	// An empty cache is saved too: keeping the previous snapshot would
+	// bring invalidated entries back after a restart
+
+	raw, err := json.Marshal(snap)
+	if err != nil {
+		log.Printf("[CacheSnapshot] marshal failed: %v", err)
+		return
+	}
+	tmp, err := os.CreateTemp(filepath.Dir(path), filepath.Base(path)+".tmp*")
+	if err != nil {
+		log.Printf("[CacheSnapshot] create temp file failed: %v", err)
+		return
+	}
+	_, err = tmp.Write(raw)
+	if err == nil {
+		err = tmp.Sync()
+	}
+	if cerr := tmp.Close(); err == nil {
+		err = cerr
+	}
+	if err == nil {
+		err = os.Rename(tmp.Name(), path)
+	}
+	if err != nil {
+		os.Remove(tmp.Name())
+		log.Printf("[CacheSnapshot] write %s failed: %v", path, err)
+		return
+	}
+	log.Printf("[CacheSnapshot] saved %d entries to %s (%d bytes)", len(snap.Entries), path, len(raw))
+}
+
+// StartCacheSnapshotter saves the snapshot periodically until ctx is
+// cancelled. main.go also calls SaveCacheSnapshot once on shutdown.
+func StartCacheSnapshotter(ctx context.Context) {
+	if cacheSnapshotPath() == "" {
+		return
+	}
+	interval := 5 * time.Minute
+	if d, err := time.ParseDuration(os.Getenv("DASHBOARD_CACHE_SNAPSHOT_INTERVAL")); err == nil && d > 0 {
+		interval = d
+	}
+	go func() {
+		ticker := time.NewTicker(interval)
+		defer ticker.Stop()
+		for {
+			select {
+			case <-ticker.C:
+				SaveCacheSnapshot()
+			case <-ctx.Done():
+				return
+			}
+		}
+	}()
+}
@@ -5,8 +5,6 @@ import (
 	"encoding/json"
 	"fmt"
 	"log"
-	"sort"
-	"strconv"
 	"sync"
 	"time"
 
@@ -17,7 +15,7 @@ import (
 // Background Cache Warmer
 //
 // แทนที่จะรอให้ user คนแรกมา request แล้วค่อยคำนวณ (Lazy Loading)
-// ตัว Warmer จะ "คำนวณเตรียมไว้ล่วงหน้า" (Proactive Cache) ทุก 15 นาที
+// ตัว Warmer จะ "คำนวณเตรียมไว้ล่วงหน้า" (Proactive Cache) ตามตารางเวลา
 //
 // ข้อดี:
 //   - ทุก request ดึงจาก cache ได้ทันที (< 0.1 วินาที)
@@ -25,21 +23,22 @@ import (
 //   - Stale data fallback: ถ้า warm รอบใหม่ fail จะยังใช้ cache เดิมได้
 //
 // Flow:
-//   1. Startup → warm ทันที (ไม่ต้องรอ 15 นาทีแรก)
-//   2. ทุก 15 นาที → warm ใหม่ทั้งหมด
-//   3. warm = คำนวณ dashboard สำหรับ "ทุกเขต" + "แต่ละเขต" + date combos
+//   1. Startup → warm schedule ที่ตั้ง on_startup ทันที
+//   2. ทุกต้นนาที → warm schedule ที่ cron ตรงกับเวลานั้น
+//   3. warm = คำนวณ dashboard สำหรับ "ทุกเขต" + "แต่ละเขต" × date presets
+//      และ/หรือ zone/date ที่ถูกเรียกบ่อยที่สุดจาก audit log
+//
+// Schedules come from WARM_CONFIG_FILE (services/dashboard_warm.go); the
+// default warms the no-date dashboards every 15 minutes.
 // ========================================================================
 
-// WarmInterval is the interval between cache warming cycles.
-// Default: 15 minutes. Configurable for testing.
-var WarmInterval = 15 * time.Minute
-
 // warmerRunning guards against multiple warmer goroutines
 var warmerRunning bool
 var warmerMu sync.Mutex
 
 // StartCacheWarmer starts the background cache warming goroutine.
-// It warms the cache immediately on startup, then repeats every WarmInterval.
+// It runs the on_startup schedules immediately, then checks every
+// schedule's cron expression at each minute boundary.
 // Safe to call multiple times — only one warmer will run.
 //
 // Usage in main.go:
@@ -48,6 +47,11 @@ var warmerMu sync.Mutex
 //	defer cancel()
 //	handlers.StartCacheWarmer(ctx)
 func StartCacheWarmer(ctx context.Context) {
+	cfg, err := services.LoadWarmConfig()
+	if err != nil {
+		log.Fatalf("warm config error: %v", err)
+	}
+
 	warmerMu.Lock()
 	if warmerRunning {
 		warmerMu.Unlock()
@@ -57,21 +61,50 @@ func StartCacheWarmer(ctx context.Context) {
 	warmerRunning = true
 	warmerMu.Unlock()
 
-	log.Printf("[CacheWarmer] Starting background cache warmer (interval: %v)", WarmInterval)
+	for _, s := range cfg.Schedules {
+		log.Printf("[CacheWarmer] Schedule %s: cron=%q presets=%v usage=%v", s.Name, s.Cron, s.Presets, s.Usage != nil)
+	}
 
-	// Warm immediately on startup (don't wait for the first tick)
-	go func() {
-		log.Println("[CacheWarmer] 🔥 Initial warming on startup...")
-		warmAllDashboards()
+	// A schedule that is still running when it fires again is skipped
+	running := make(map[string]bool)
+	var runningMu sync.Mutex
+	fire := func(s services.WarmSchedule) {
+		runningMu.Lock()
+		if running[s.Name] {
+			runningMu.Unlock()
+			log.Printf("[CacheWarmer] Schedule %s still running, skipping this run", s.Name)
+			return
+		}
+		running[s.Name] = true
+		runningMu.Unlock()
+		go func() {
+			defer func() {
+				runningMu.Lock()
+				delete(running, s.Name)
+				runningMu.Unlock()
+			}()
+			runWarmSchedule(s)
+		}()
+	}
 
-		ticker := time.NewTicker(WarmInterval)
-		defer ticker.Stop()
+	go func() {
+		// Warm immediately on startup (don't wait for the first match)
+		for _, s := range cfg.Schedules {
+			if s.OnStartup {
+				log.Printf("[CacheWarmer] 🔥 Initial warming on startup (%s)...", s.Name)
+				fire(s)
+			}
+		}
 
 		for {
+			next := time.Now().Truncate(time.Minute).Add(time.Minute)
 			select {
-			case <-ticker.C:
-				log.Println("[CacheWarmer] 🔄 Periodic cache warming cycle...")
-				warmAllDashboards()
+			case <-time.After(time.Until(next)):
+				for _, s := range cfg.Schedules {
+					if s.Schedule.Matches(next) {
+						fire(s)
+					}
+				}
 			case <-ctx.Done():
 				log.Println("[CacheWarmer] 🛑 Stopped (context cancelled)")
 				warmerMu.Lock()
@@ -83,35 +116,66 @@ func StartCacheWarmer(ctx context.Context) {
 	}()
 }
 
-// warmAllDashboards pre-computes dashboard data for all common query patterns:
-//   - All zones combined (zone="")
-//   - Each individual zone (zone="1", "2", ..., "10")
+// warmTask is one dashboard to warm.
+type warmTask struct {
+	zone, startDate, endDate string
+	desc                     string
+}
+
+// runWarmSchedule pre-computes every dashboard of a schedule:
+//   - each preset's date ranges for all zones combined (zone="") and each zone
+//   - the usage-driven zone/date combinations from the audit log
 //
-// Each combination is stored in cache with a long TTL.
-// Date filters are not pre-warmed (too many combinations) — those still use
-// lazy caching from the normal API handler.
-func warmAllDashboards() {
+// Each dashboard is stored in cache with the schedule's TTL (WarmCacheTTL
+// by default), set longer than the gap between runs to provide overlap.
+func runWarmSchedule(s services.WarmSchedule) {
 	start := time.Now()
+	ttl := s.TTLDur
+	if ttl == 0 {
+		ttl = WarmCacheTTL
+	}
 
-	// 1. Fetch list of all zones
-	zones, err := services.GetZones()
-	if err != nil {
-		log.Printf("[CacheWarmer] ✗ Failed to fetch zones: %v", err)
-		return
+	var tasks []warmTask
+	seenTask := make(map[string]bool)
+	addTask := func(t warmTask) {
+		k := t.zone + "|" + t.startDate + "|" + t.endDate
+		if !seenTask[k] {
+			seenTask[k] = true
+			tasks = append(tasks, t)
+		}
 	}
 
-	// Build warm tasks: "" (all) + each zone
-	tasks := []struct {
-		zone string
-		desc string
-	}{
-		{zone: "", desc: "ทุกเขต (all zones)"},
+	if len(s.Presets) > 0 {
+		// 1. Fetch list of all zones
+		zones, err := services.GetZones()
+		if err != nil {
+			log.Printf("[CacheWarmer] ✗ Failed to fetch zones: %v", err)
+			return
+		}
+		for _, p := range s.Presets {
+			ranges, err := services.WarmPresetRanges(p, start)
+			if err != nil {
+				log.Printf("[CacheWarmer] ✗ Preset %s: %v", p, err)
+				continue
+			}
+			// "" (all) + each zone, for every range of the preset
+			for _, r := range ranges {
+				addTask(warmTask{zone: "", startDate: r[0], endDate: r[1], desc: fmt.Sprintf("ทุกเขต (all zones) %s %s..%s", p, r[0], r[1])})
+				for _, z := range zones {
+					addTask(warmTask{zone: z.Zone, startDate: r[0], endDate: r[1], desc: fmt.Sprintf("เขต %s %s %s..%s", z.Zone, p, r[0], r[1])})
+				}
+			}
+		}
 	}
-	for _, z := range zones {
-		tasks = append(tasks, struct {
-			zone string
-			desc string
-		}{zone: z.Zone, desc: fmt.Sprintf("เขต %s", z.Zone)})
+	if s.Usage != nil {
+		queries, err := services.TopDashboardQueries(s.Usage.Days, s.Usage.Top)
+		if err != nil {
+			log.Printf("[CacheWarmer] ✗ Usage query: %v", err)
+		}
+		for _, q := range queries {
+			addTask(warmTask{zone: q.Zone, startDate: q.StartDate, endDate: q.EndDate,
+				desc: fmt.Sprintf("usage zone=%q %s..%s (%d hits)", q.Zone, q.StartDate, q.EndDate, q.Hits)})
+		}
 	}
 
 	// 2. Execute warming concurrently (bounded concurrency)
@@ -124,169 +188,52 @@ func warmAllDashboards() {
 	for _, task := range tasks {
 		wg.Add(1)
 		sem <- struct{}{}
-		go func(zone, desc string) {
+		go func(t warmTask) {
 			defer wg.Done()
 			defer func() { <-sem }()
 
-			if err := warmDashboard(zone, "", ""); err != nil {
-				log.Printf("[CacheWarmer]   ✗ FAILED: %s — %v", desc, err)
+			// Shares the dashboard computation limit with user requests
+			if err := withComputeSlot(func() error { return warmDashboard(t.zone, t.startDate, t.endDate, ttl) }); err != nil {
+				log.Printf("[CacheWarmer]   ✗ FAILED: %s — %v", t.desc, err)
+				warmTasks.Inc(s.Name, "failed")
 				mu.Lock()
 				failCount++
 				mu.Unlock()
 			} else {
+				warmTasks.Inc(s.Name, "success")
 				mu.Lock()
 				successCount++
 				mu.Unlock()
 			}
-		}(task.zone, task.desc)
+		}(task)
 	}
 
 	wg.Wait()
 	elapsed := time.Since(start)
-	log.Printf("[CacheWarmer] ✓ Warm cycle complete: %d success, %d failed, took %v",
-		successCount, failCount, elapsed)
+	warmCycleSeconds.Observe(elapsed.Seconds(), s.Name)
+	log.Printf("[CacheWarmer] ✓ Warm cycle %s complete: %d success, %d failed, took %v",
+		s.Name, successCount, failCount, elapsed)
 }
 
 // warmDashboard computes the dashboard data for a given zone/date combination
-// and stores it in cache. This is the same logic as GetDashboardSummary handler
-// but decoupled from HTTP context.
-func warmDashboard(zone, startDate, endDate string) error {
-	// Build office list
-	var officeList []struct {
-		PwaCode string
-		Name    string
-		Zone    string
-	}
-
-	if zone != "" {
-		rawOffices, err := services.GetOfficesByZone(zone)
-		if err != nil {
-			return fmt.Errorf("GetOfficesByZone(%s): %w", zone, err)
-		}
-		for _, o := range rawOffices {
-			officeList = append(officeList, struct {
-				PwaCode string
-				Name    string
-				Zone    string
-			}{o.PwaCode, o.Name, o.Zone})
-		}
-	} else {
-		rawOffices, err := services.GetAllOffices()
-		if err != nil {
-			return fmt.Errorf("GetAllOffices: %w", err)
-		}
-		for _, o := range rawOffices {
-			officeList = append(officeList, struct {
-				PwaCode string
-				Name    string
-				Zone    string
-			}{o.PwaCode, o.Name, o.Zone})
-		}
+// and stores it in cache with ttl. It uses the same builder as the
+// GetDashboardSummary handler, so warm and lazy entries are identical.
+func warmDashboard(zone, startDate, endDate string, ttl time.Duration) error {
+	summary, err := services.BuildDashboardSummary(zone, startDate, endDate)
+	if err != nil {
+		return fmt.Errorf("BuildDashboardSummary(%q): %w", zone, err)
 	}
-
-	if len(officeList) == 0 {
+	if summary.TotalBranches == 0 {
 		return nil // no branches — nothing to warm
 	}
 
-	// Count features for all branches concurrently
-	type branchResult struct {
-		PwaCode    string           `json:"pwa_code"`
-		BranchName string           `json:"branch_name"`
-		Zone       string           `json:"zone"`
-		Layers     map[string]int64 `json:"layers"`
-		Total      int64            `json:"total"`
-		PipeLong   float64          `json:"pipe_long"`
-	}
-
-	results := make(chan branchResult, len(officeList))
-	sem := make(chan struct{}, 15)
-
-	for _, o := range officeList {
-		sem <- struct{}{}
-		go func(pwa, name, z string) {
-			defer func() { <-sem }()
-
-			layers, err := services.CountAllLayersForBranch(pwa, startDate, endDate)
-			if err != nil {
-				results <- branchResult{PwaCode: pwa, BranchName: name, Zone: z, Layers: map[string]int64{}, Total: 0}
-				return
-			}
-			var total int64
-			for _, cnt := range layers {
-				total += cnt
-			}
-			pipeLong, _ := services.SumPipeLength(pwa, startDate, endDate)
-			results <- branchResult{PwaCode: pwa, BranchName: name, Zone: z, Layers: layers, Total: total, PipeLong: pipeLong}
-		}(o.PwaCode, o.Name, o.Zone)
-	}
-
-	// Collect results
-	allResults := make([]branchResult, 0, len(officeList))
-	for i := 0; i < len(officeList); i++ {
-		allResults = append(allResults, <-results)
-	}
-
-	// Sort by zone (numeric) then pwaCode
-	sort.Slice(allResults, func(i, j int) bool {
-		zi, _ := strconv.Atoi(allResults[i].Zone)
-		zj, _ := strconv.Atoi(allResults[j].Zone)
-		if zi != zj {
-			return zi < zj
-		}
-		return allResults[i].PwaCode < allResults[j].PwaCode
-	})
-
-	// Aggregate totals per zone
-	zoneTotals := make(map[string]map[string]int64)
-	zoneNames := []string{}
-	seen := map[string]bool{}
-	for _, r := range allResults {
-		if !seen[r.Zone] {
-			seen[r.Zone] = true
-			zoneNames = append(zoneNames, r.Zone)
-		}
-		if _, ok := zoneTotals[r.Zone]; !ok {
-			zoneTotals[r.Zone] = make(map[string]int64)
-		}
-		for layer, cnt := range r.Layers {
-			zoneTotals[r.Zone][layer] += cnt
-		}
-		zoneTotals[r.Zone]["_total"] += r.Total
-		zoneTotals[r.Zone]["_branches"]++
-	}
-
-	// Sort zone names numerically
-	sort.Slice(zoneNames, func(i, j int) bool {
-		a, _ := strconv.Atoi(zoneNames[i])
-		b, _ := strconv.Atoi(zoneNames[j])
-		return a < b
-	})
-
-	// Compute grand totals
-	grandTotal := make(map[string]int64)
-	for _, zt := range zoneTotals {
-		for k, v := range zt {
-			grandTotal[k] += v
-		}
-	}
-
-	// Build the response (same structure as GetDashboardSummary)
-	response := map[string]interface{}{
-		"status":         "success",
-		"branches":       allResults,
-		"zone_totals":    zoneTotals,
-		"grand_total":    grandTotal,
-		"zone_names":     zoneNames,
-		"total_branches": len(allResults),
-	}
-
 	// Store in cache with extended TTL for warmed data
-	cacheKey := CacheKey("dashboard", zone, startDate, endDate)
-	raw, err := json.Marshal(response)
+	cacheKey := dashboardKey(zone, startDate, endDate)
+	raw, err := json.Marshal(summary)
 	if err != nil {
 		return fmt.Errorf("json marshal: %w", err)
 	}
 
-	SetCachedDashboardRaw(cacheKey, raw, WarmCacheTTL)
+	SetCachedDashboardRaw(cacheKey, raw, ttl, "warm")
 	return nil
-}
\ No newline at end of file
+}
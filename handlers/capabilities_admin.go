@@ -0,0 +1,90 @@
+package handlers
+
+import (
+	"database/sql"
+	"encoding/json"
+	"net/http"
+	"strconv"
+
+	"pwa_gis_tracking/services"
+
+	"github.com/gin-gonic/gin"
+)
+
+// ========================================================================
+// Capability Grant Administration (admin only)
+//
+// CRUD over rbac.pwagis_capability (see services/capabilities.go).
+// Changes reload the in-memory grants and are audit-logged.
+//
+//   GET    /api/admin/rbac/capabilities
+//   POST   /api/admin/rbac/capabilities
+//   DELETE /api/admin/rbac/capabilities/:id
+// ========================================================================
+
+// ListCapabilityGrants returns all grants plus the effective matrix per level.
+// GET /api/admin/rbac/capabilities
+func ListCapabilityGrants(c *gin.Context) {
+	grants, err := services.ListCapabilityGrants()
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": err.Error()})
+		return
+	}
+
+	effective := gin.H{}
+	for _, level := range []string{permLevelAll, permLevelReg, permLevelBranch} {
+		global, layers := services.CapabilityMatrix(level)
+		effective[level] = gin.H{"global": global, "layers": layers}
+	}
+
+	c.JSON(http.StatusOK, gin.H{
+		"status":       "success",
+		"data":         grants,
+		"capabilities": services.AllCapabilities,
+		"effective":    effective,
+	})
+}
+
+// CreateCapabilityGrant adds an allow or deny grant.
+// POST /api/admin/rbac/capabilities
+func CreateCapabilityGrant(c *gin.Context) {
+	var req services.CapabilityGrant
+	if err := c.ShouldBindJSON(&req); err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "Invalid request: " + err.Error()})
+		return
+	}
+
+	uid, _ := c.Get("uid")
+	grant, err := services.CreateCapabilityGrant(req, strOrEmpty(uid))
+	if err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+		return
+	}
+
+	raw, _ := json.Marshal(grant)
+	LogAuditEvent(c, "rbac_capability_create", "rbac_capability", string(raw))
+	c.JSON(http.StatusCreated, gin.H{"status": "success", "data": grant})
+}
+
+// DeleteCapabilityGrant removes a grant.
+// DELETE /api/admin/rbac/capabilities/:id
+func DeleteCapabilityGrant(c *gin.Context) {
+	id, err := strconv.Atoi(c.Param("id"))
+	if err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "invalid grant id"})
+		return
+	}
+
+	err = services.DeleteCapabilityGrant(id)
+	if err == sql.ErrNoRows {
+		c.JSON(http.StatusNotFound, gin.H{"error": "grant not found"})
+		return
+	}
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": err.Error()})
+		return
+	}
+
+	LogAuditEvent(c, "rbac_capability_delete", "rbac_capability", "id="+strconv.Itoa(id))
+	c.JSON(http.StatusOK, gin.H{"status": "success", "message": "Grant deleted"})
+}
@@ -8,8 +8,11 @@ import (
 	"log"
 	"net/http"
 	"os"
+	"strconv"
 	"time"
 
+	"pwa_gis_tracking/services"
+
 	"github.com/gin-gonic/gin"
 )
 
@@ -36,6 +39,7 @@ type chatbotProxyPayload struct {
 	PwaCode    string `json:"pwa_code"`
 	UID        string `json:"uid"`
 	Permission string `json:"permission"`
+	PiiMask    string `json:"pii_mask"` // full | partial | removed
 }
 
 // ChatbotQuery proxies the chatbot request to the Python text-to-query service.
@@ -49,6 +53,14 @@ func ChatbotQuery(c *gin.Context) {
 		})
 		return
 	}
+	setAuditTarget(c, "chatbot", gin.H{
+		"pwaCode": req.PwaCode,
+		"prompt":  truncateRunes(redactPrompt(req.Prompt), 500),
+	})
+
+	if !authorizeCapability(c, services.CapUseChatbot) {
+		return
+	}
 
 	// Enforce max prompt length
 	if len([]rune(req.Prompt)) > 500 {
@@ -71,6 +83,9 @@ func ChatbotQuery(c *gin.Context) {
 			pwaCode = pc
 		}
 	}
+	if pwaCode != "" && !authorizeBranches(c, splitAndTrim(pwaCode)...) {
+		return
+	}
 
 	uidStr := ""
 	if u, ok := uid.(string); ok {
@@ -87,6 +102,7 @@ func ChatbotQuery(c *gin.Context) {
 		PwaCode:    pwaCode,
 		UID:        uidStr,
 		Permission: permStr,
+		PiiMask:    string(piiMask(c)),
 	}
 
 	body, err := json.Marshal(payload)
@@ -102,8 +118,10 @@ func ChatbotQuery(c *gin.Context) {
 	client := &http.Client{Timeout: 600 * time.Second}
 	proxyURL := fmt.Sprintf("%s/api/text-to-query", chatbotServiceURL)
 
+	start := time.Now()
 	resp, err := client.Post(proxyURL, "application/json", bytes.NewReader(body))
 	if err != nil {
+		chatbotProxySeconds.ObserveSince(start, "error")
 		log.Printf("[chatbot] proxy error: %v", err)
 		c.JSON(http.StatusBadGateway, gin.H{
 			"status":  "error",
@@ -115,6 +133,7 @@ func ChatbotQuery(c *gin.Context) {
 
 	// Read response body
 	respBody, err := io.ReadAll(resp.Body)
+	chatbotProxySeconds.ObserveSince(start, strconv.Itoa(resp.StatusCode))
 	if err != nil {
 		log.Printf("[chatbot] read response error: %v", err)
 		c.JSON(http.StatusInternalServerError, gin.H{
@@ -124,6 +143,45 @@ func ChatbotQuery(c *gin.Context) {
 		return
 	}
 
-	// Forward the response as-is with the same status code
+	// Results may hold customer attributes; mask them for this user's level
+	// (the Python service caches responses across users, so it is done here)
+	if mode := piiMask(c); mode != services.MaskFull && resp.StatusCode < 300 {
+		var result interface{}
+		if err := json.Unmarshal(respBody, &result); err != nil {
+			log.Printf("[chatbot] response is not JSON, not forwarded: %v", err)
+			c.JSON(http.StatusBadGateway, gin.H{
+				"status":  "error",
+				"message": "เกิดข้อผิดพลาดในการอ่านผลลัพธ์ค่ะ",
+			})
+			return
+		}
+		redactChatbotFreeText(result)
+		if respBody, err = json.Marshal(services.MaskAnyJSON(result, mode)); err != nil {
+			c.JSON(http.StatusInternalServerError, gin.H{
+				"status":  "error",
+				"message": "เกิดข้อผิดพลาดในการอ่านผลลัพธ์ค่ะ",
+			})
+			return
+		}
+	}
+
+	// Forward the response with the same status code
 	c.Data(resp.StatusCode, "application/json; charset=utf-8", respBody)
 }
+
+// chatbotMaskedNotice replaces the LLM explanation for masked users.
+const chatbotMaskedNotice = "ข้อมูลส่วนบุคคลในผลลัพธ์ถูกปิดบังตามสิทธิ์การใช้งานของคุณค่ะ"
+
+// redactChatbotFreeText drops the parts of a chatbot response that
+// MaskAnyJSON cannot mask by field name: the LLM's text_response and the
+// generated query (query_display.code) may quote customer values verbatim.
+func redactChatbotFreeText(result interface{}) {
+	m, ok := result.(map[string]interface{})
+	if !ok {
+		return
+	}
+	if _, ok := m["text_response"]; ok {
+		m["text_response"] = chatbotMaskedNotice
+	}
+	delete(m, "query_display")
+}
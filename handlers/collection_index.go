@@ -0,0 +1,43 @@
+package handlers
+
+import (
+	"net/http"
+
+	"pwa_gis_tracking/services"
+
+	"github.com/gin-gonic/gin"
+)
+
+// ========================================================================
+// Collection alias index (admin only, see services/collection_index.go)
+//
+//   POST /api/admin/collections/reload    reload alias → ObjectID from Mongo
+//   GET  /api/admin/collections/orphans   aliases whose branch is not in pwa_office234
+// ========================================================================
+
+// ReloadCollectionIndex reloads the alias index now.
+// POST /api/admin/collections/reload
+func ReloadCollectionIndex(c *gin.Context) {
+	if err := services.ReloadCollectionIndex(); err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": err.Error()})
+		return
+	}
+	LogAuditEvent(c, "collection_index_reload", "collections", "")
+	c.JSON(http.StatusOK, gin.H{"status": "success", "data": services.GetCollectionIndexStats()})
+}
+
+// ListOrphanCollections reports aliases in Mongo without a pwa_office234 branch.
+// GET /api/admin/collections/orphans
+func ListOrphanCollections(c *gin.Context) {
+	list, err := services.FindOrphanCollections()
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": err.Error()})
+		return
+	}
+	c.JSON(http.StatusOK, gin.H{
+		"status": "success",
+		"index":  services.GetCollectionIndexStats(),
+		"data":   list,
+		"total":  len(list),
+	})
+}
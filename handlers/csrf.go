@@ -0,0 +1,156 @@
+package handlers
+
+import (
+	"crypto/rand"
+	"crypto/subtle"
+	"encoding/base64"
+	"log"
+	"net/http"
+	"net/url"
+
+	"pwa_gis_tracking/config"
+
+	"github.com/gin-gonic/gin"
+)
+
+// ========================================================================
+// CORS + CSRF
+//
+// CORSMiddleware only reflects origins listed in CORS_ALLOWED_ORIGINS
+// (see config/cors.go); everything else is same-origin only.
+//
+// CSRF uses a per-session token:
+//   - issued at login and stored in the session (sessCSRF)
+//   - readable by the frontend via GET /api/session/info → csrf_token
+//   - sent back in the X-CSRF-Token header on every non-GET request
+//
+// POST /login has no session yet, so it uses a double-submit cookie set by
+// ShowLoginPage and rendered into login.html.
+//
+// Requests authenticated with an API bearer token are exempt — browsers
+// never attach that header on their own.
+// ========================================================================
+
+const (
+	csrfHeader      = "X-CSRF-Token"
+	csrfLoginCookie = "pwa_gis_login_csrf"
+)
+
+// CORSMiddleware replaces the old "Access-Control-Allow-Origin: *" handler.
+func CORSMiddleware() gin.HandlerFunc {
+	return func(c *gin.Context) {
+		origin := c.GetHeader("Origin")
+		h := c.Writer.Header()
+		h.Add("Vary", "Origin")
+
+		if config.IsOriginAllowed(origin) {
+			h.Set("Access-Control-Allow-Origin", origin)
+			h.Set("Access-Control-Allow-Credentials", "true")
+			h.Set("Access-Control-Allow-Methods", "GET, POST, PUT, DELETE, OPTIONS")
+			h.Set("Access-Control-Allow-Headers", "Content-Type, Authorization, "+csrfHeader)
+			h.Set("Access-Control-Max-Age", "600")
+		}
+
+		if c.Request.Method == http.MethodOptions {
+			c.AbortWithStatus(http.StatusNoContent)
+			return
+		}
+		c.Next()
+	}
+}
+
+// CSRFProtect rejects state-changing requests without a valid CSRF token.
+// Must run after AuthRequired (reads "csrf_token" / "api_token_id").
+func CSRFProtect() gin.HandlerFunc {
+	return func(c *gin.Context) {
+		if isSafeMethod(c.Request.Method) {
+			c.Next()
+			return
+		}
+		if _, ok := c.Get("api_token_id"); ok {
+			c.Next()
+			return
+		}
+
+		if !checkOrigin(c) {
+			rejectCSRF(c, "cross-origin request from "+c.GetHeader("Origin"))
+			return
+		}
+		expected, _ := c.Get("csrf_token")
+		if !tokensEqual(strOrEmpty(expected), c.GetHeader(csrfHeader)) {
+			rejectCSRF(c, "missing or invalid "+csrfHeader+" header")
+			return
+		}
+		c.Next()
+	}
+}
+
+// ─── Login (pre-session) ─────────────────────────────────────────────────────
+
+// issueLoginCSRF sets the double-submit cookie for the login form and
+// returns the token to render into the page.
+func issueLoginCSRF(c *gin.Context, basePath string) string {
+	token := newCSRFToken()
+	http.SetCookie(c.Writer, &http.Cookie{
+		Name:     csrfLoginCookie,
+		Value:    token,
+		Path:     basePath + "/login",
+		MaxAge:   3600,
+		HttpOnly: true,
+		Secure:   c.Request.TLS != nil,
+		SameSite: http.SameSiteStrictMode,
+	})
+	return token
+}
+
+// checkLoginCSRF verifies the login form's header against its cookie.
+func checkLoginCSRF(c *gin.Context) bool {
+	if !checkOrigin(c) {
+		return false
+	}
+	cookie, err := c.Cookie(csrfLoginCookie)
+	if err != nil {
+		return false
+	}
+	return tokensEqual(cookie, c.GetHeader(csrfHeader))
+}
+
+// ─── Helpers ─────────────────────────────────────────────────────────────────
+
+// newCSRFToken returns 32 random bytes, base64url-encoded.
+func newCSRFToken() string {
+	b := make([]byte, 32)
+	if _, err := rand.Read(b); err != nil {
+		log.Panicf("csrf token generation failed: %v", err)
+	}
+	return base64.RawURLEncoding.EncodeToString(b)
+}
+
+func tokensEqual(expected, got string) bool {
+	return expected != "" && subtle.ConstantTimeCompare([]byte(expected), []byte(got)) == 1
+}
+
+// checkOrigin accepts requests without an Origin header (same-origin GET-style
+// navigations, old browsers), same-host origins and CORS_ALLOWED_ORIGINS.
+func checkOrigin(c *gin.Context) bool {
+	origin := c.GetHeader("Origin")
+	if origin == "" || config.IsOriginAllowed(origin) {
+		return true
+	}
+	u, err := url.Parse(origin)
+	return err == nil && u.Host == c.Request.Host
+}
+
+func isSafeMethod(m string) bool {
+	return m == http.MethodGet || m == http.MethodHead || m == http.MethodOptions
+}
+
+// rejectCSRF writes the standard 403 response for a failed CSRF check.
+func rejectCSRF(c *gin.Context, reason string) {
+	c.AbortWithStatusJSON(http.StatusForbidden, gin.H{
+		"status":  "error",
+		"error":   "csrf_failed",
+		"reason":  reason,
+		"message": "คำขอไม่ผ่านการตรวจสอบความปลอดภัย กรุณาโหลดหน้าใหม่แล้วลองอีกครั้ง",
+	})
+}
@@ -0,0 +1,148 @@
+package handlers
+
+import (
+	"encoding/json"
+	"log"
+	"sync"
+	"time"
+
+	"pwa_gis_tracking/services"
+)
+
+// ========================================================================
+// Dashboard computation control
+//
+// A full dashboard is thousands of CountDocuments calls, so:
+//   - dashFlight coalesces concurrent computations of the same cache key:
+//     the first request computes, the others wait for its result
+//   - a stale entry (expired < CacheStaleTTL ago) is served at once with
+//     X-Cache: STALE while a single background refresh recomputes it
+//   - dashComputeSem caps concurrent computations across all keys
+//     (handler, refresh and warmer); DASHBOARD_MAX_COMPUTE, default 4
+// ========================================================================
+
+var (
+	dashComputeSem  chan struct{}
+	dashComputeOnce sync.Once
+)
+
+// withComputeSlot runs fn while holding one dashboard computation slot.
+// The limit is read on first use, after main.go has loaded .env.
+func withComputeSlot(fn func() error) error {
+	dashComputeOnce.Do(func() {
+		dashComputeSem = make(chan struct{}, envInt("DASHBOARD_MAX_COMPUTE", 4))
+	})
+	dashComputeSem <- struct{}{}
+	defer func() { <-dashComputeSem }()
+	return fn()
+}
+
+// flightCall is one in-progress computation.
+type flightCall struct {
+	wg  sync.WaitGroup
+	val json.RawMessage
+	err error
+}
+
+// flightGroup runs at most one computation per key at a time.
+type flightGroup struct {
+	mu    sync.Mutex
+	calls map[string]*flightCall
+}
+
+var dashFlight = &flightGroup{calls: make(map[string]*flightCall)}
+
+// Do runs fn for key, or waits for the computation already running for
+// key. shared is true when the result came from another caller's run.
+func (g *flightGroup) Do(key string, fn func() (json.RawMessage, error)) (val json.RawMessage, err error, shared bool) {
+	g.mu.Lock()
+	if call, ok := g.calls[key]; ok {
+		g.mu.Unlock()
+		call.wg.Wait()
+		return call.val, call.err, true
+	}
+	call := g.start(key)
+	g.mu.Unlock()
+
+	g.run(key, call, fn)
+	return call.val, call.err, false
+}
+
+// Refresh starts fn for key in the background unless a computation for
+// key is already running. It reports whether a refresh was started.
+func (g *flightGroup) Refresh(key string, fn func() (json.RawMessage, error)) bool {
+	g.mu.Lock()
+	if _, ok := g.calls[key]; ok {
+		g.mu.Unlock()
+		return false
+	}
+	call := g.start(key)
+	g.mu.Unlock()
+
+	go g.run(key, call, fn)
+	return true
+}
+
+// start registers a call for key; g.mu must be held.
+func (g *flightGroup) start(key string) *flightCall {
+	call := &flightCall{}
+	call.wg.Add(1)
+	g.calls[key] = call
+	return call
+}
+
+func (g *flightGroup) run(key string, call *flightCall, fn func() (json.RawMessage, error)) {
+	defer func() {
+		g.mu.Lock()
+		delete(g.calls, key)
+		g.mu.Unlock()
+		call.wg.Done()
+	}()
+	call.val, call.err = fn()
+}
+
+// computeDashboardCached computes the dashboard for zone/dates under a
+// compute slot and stores it with ttl and source.
+func computeDashboardCached(key, zone, startDate, endDate string, ttl time.Duration, source string) (json.RawMessage, error) {
+	var raw json.RawMessage
+	err := withComputeSlot(func() error {
+		start := time.Now()
+		response, err := services.BuildDashboardSummary(zone, startDate, endDate)
+		if err != nil {
+			return err
+		}
+		if raw, err = json.Marshal(response); err != nil {
+			return err
+		}
+		log.Printf("[Dashboard] computed key=%s zone=%q in %v", key, zone, time.Since(start).Round(time.Millisecond))
+		return nil
+	})
+	if err != nil {
+		return nil, err
+	}
+	SetCachedDashboardRaw(key, raw, ttl, source)
+	return raw, nil
+}
+
+// loadDashboardSummary returns the typed dashboard for zone/dates: the
+// cached copy (fresh or stale) if there is one, otherwise a coalesced
+// computation that also fills the cache.
+func loadDashboardSummary(zone, startDate, endDate string) (*services.DashboardSummary, error) {
+	key := dashboardKey(zone, startDate, endDate)
+	var summary services.DashboardSummary
+	if entry, ok := activeCache().Get(key); ok {
+		if err := json.Unmarshal(entry.Data, &summary); err == nil {
+			return &summary, nil
+		}
+	}
+	raw, err, _ := dashFlight.Do(key, func() (json.RawMessage, error) {
+		return computeDashboardCached(key, zone, startDate, endDate, CacheTTL, "lazy")
+	})
+	if err != nil {
+		return nil, err
+	}
+	if err := json.Unmarshal(raw, &summary); err != nil {
+		return nil, err
+	}
+	return &summary, nil
+}
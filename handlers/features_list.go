@@ -29,6 +29,12 @@ func GetFeaturesList(c *gin.Context) {
 		c.JSON(http.StatusBadRequest, gin.H{"error": "pwaCode and collection are required"})
 		return
 	}
+	if !authorizeBranches(c, pwaCode) {
+		return
+	}
+	if !authorizeCapability(c, services.CapViewAttributes, collection) {
+		return
+	}
 
 	// Validate collection name
 	validLayers := services.GetAllLayerNames()
@@ -68,7 +74,7 @@ func GetFeaturesList(c *gin.Context) {
 		sortOrder = 1
 	}
 
-	result, err := services.ListFeaturesPaginated(pwaCode, collection, startDate, endDate, search, page, pageSize, raw, filters, sortBy, sortOrder)
+	result, err := services.ListFeaturesPaginated(pwaCode, collection, startDate, endDate, search, page, pageSize, raw, filters, sortBy, sortOrder, piiMask(c))
 	if err != nil {
 		c.JSON(http.StatusInternalServerError, gin.H{"error": err.Error()})
 		return
@@ -97,11 +103,17 @@ func GetFeatureSuggestions(c *gin.Context) {
 		c.JSON(http.StatusOK, gin.H{"suggestions": []interface{}{}})
 		return
 	}
+	if !authorizeBranches(c, pwaCode) {
+		return
+	}
+	if !authorizeCapability(c, services.CapViewAttributes, collection) {
+		return
+	}
 	if limit < 1 || limit > 20 {
 		limit = 8
 	}
 
-	suggestions, err := services.SuggestFeatureValues(pwaCode, collection, q, limit)
+	suggestions, err := services.SuggestFeatureValues(pwaCode, collection, q, limit, piiMask(c))
 	if err != nil {
 		c.JSON(http.StatusOK, gin.H{"suggestions": []interface{}{}})
 		return
@@ -120,6 +132,12 @@ func GetFeatureFacets(c *gin.Context) {
 		c.JSON(http.StatusOK, gin.H{"facets": []interface{}{}})
 		return
 	}
+	if !authorizeBranches(c, pwaCode) {
+		return
+	}
+	if !authorizeCapability(c, services.CapViewAttributes, collection) {
+		return
+	}
 
 	facets, err := services.GetFacetValues(pwaCode, collection)
 	if err != nil {
@@ -0,0 +1,257 @@
+package handlers
+
+import (
+	"fmt"
+	"log"
+	"net/http"
+	"time"
+
+	"pwa_gis_tracking/config"
+	"pwa_gis_tracking/services"
+
+	"github.com/gin-gonic/gin"
+	"github.com/gorilla/sessions"
+)
+
+// ========================================================================
+// Admin "View as User" (impersonation)
+//
+//   POST /api/admin/impersonate   {"user_id":"14180"}        start (admin only)
+//   POST /api/impersonate/stop                               exit (any session)
+//
+// The admin's own session values are left untouched; the target's scope is
+// stored in an overlay (sessImp*) that AuthRequired applies on every
+// request. While the overlay is active:
+//   - uid / pwacode / permission_leak / area in the context are the target's
+//   - "impersonator" holds the admin's employee ID, so every audit row is
+//     written under both identities (user_id + impersonator_id)
+//   - admin routes and token management are refused
+//   - the overlay expires after impersonationTTL
+// ========================================================================
+
+const impersonationTTL = 30 * time.Minute
+
+// Session keys for the overlay.
+const (
+	sessImpUID        = "imp_uid"
+	sessImpUname      = "imp_uname"
+	sessImpPwaCode    = "imp_pwacode"
+	sessImpPermission = "imp_permission"
+	sessImpPermLeak   = "imp_permission_leak"
+	sessImpArea       = "imp_area"
+	sessImpExpires    = "imp_expires" // unix seconds
+)
+
+var impersonationKeys = []string{
+	sessImpUID, sessImpUname, sessImpPwaCode, sessImpPermission,
+	sessImpPermLeak, sessImpArea, sessImpExpires,
+}
+
+// impersonateRequest is the body for POST /api/admin/impersonate.
+// Scope fields are optional overrides for employees without login history.
+type impersonateRequest struct {
+	UserID         string `json:"user_id" binding:"required"`
+	PwaCode        string `json:"pwa_code"`
+	Area           string `json:"area"`
+	PermissionLeak string `json:"permission_leak"`
+}
+
+// StartImpersonation loads another employee's scope into the admin's session.
+// POST /api/admin/impersonate
+func StartImpersonation(c *gin.Context) {
+	if _, ok := c.Get("api_token_id"); ok {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "impersonation requires a browser session"})
+		return
+	}
+	var req impersonateRequest
+	if err := c.ShouldBindJSON(&req); err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "user_id is required"})
+		return
+	}
+	req.UserID = keepDigitsOnly(req.UserID)
+	if config.IsAdminUser(req.UserID) {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "cannot impersonate another admin"})
+		return
+	}
+
+	profile, err := services.LookupUserProfile(req.UserID)
+	if err != nil && err != services.ErrNoLoginHistory {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": err.Error()})
+		return
+	}
+	switch req.PermissionLeak {
+	case "", permLevelAll, permLevelReg, permLevelBranch:
+	default:
+		c.JSON(http.StatusBadRequest, gin.H{"error": "invalid permission_leak: " + req.PermissionLeak})
+		return
+	}
+	if req.PermissionLeak != "" {
+		profile.Permission, profile.PermissionLeak = "leak", req.PermissionLeak
+	}
+	if req.PwaCode != "" {
+		profile.PwaCode = req.PwaCode
+	}
+	if req.PwaCode != "" || req.Area != "" {
+		status, reason := checkImpersonationOffice(&profile, req.Area)
+		if reason != "" {
+			c.JSON(status, gin.H{"error": reason})
+			return
+		}
+	}
+	if err == services.ErrNoLoginHistory && req.PermissionLeak == "" {
+		c.JSON(http.StatusNotFound, gin.H{
+			"error":   err.Error(),
+			"message": "ไม่พบประวัติการเข้าสู่ระบบของพนักงานนี้ — ระบุ permission_leak, pwa_code และ area เอง",
+		})
+		return
+	}
+	if !services.IsAuthorised(services.Permission{Permission: profile.Permission, PermissionLeak: profile.PermissionLeak}) {
+		c.JSON(http.StatusConflict, gin.H{
+			"error":   "employee has no access under the current rules",
+			"profile": profile,
+			"message": "พนักงานนี้ไม่มีสิทธิ์เข้าใช้งานระบบตามกฎปัจจุบัน",
+		})
+		return
+	}
+
+	session, err := config.Store.Get(c.Request, config.SessionName)
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "session not found"})
+		return
+	}
+	session.Values[sessImpUID] = profile.UserID
+	session.Values[sessImpUname] = profile.UserName
+	session.Values[sessImpPwaCode] = profile.PwaCode
+	session.Values[sessImpPermission] = profile.Permission
+	session.Values[sessImpPermLeak] = profile.PermissionLeak
+	session.Values[sessImpArea] = profile.Zone
+	session.Values[sessImpExpires] = time.Now().Add(impersonationTTL).Unix()
+	if err := session.Save(c.Request, c.Writer); err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": err.Error()})
+		return
+	}
+
+	LogAuditEvent(c, "impersonation_start", "user",
+		fmt.Sprintf("target=%s,level=%s,pwa=%s,area=%s", profile.UserID, profile.PermissionLeak, profile.PwaCode, profile.Zone))
+	c.JSON(http.StatusOK, gin.H{"status": "success", "data": profile, "expires_in": int(impersonationTTL.Seconds())})
+}
+
+// StopImpersonation removes the overlay. Reachable while impersonating
+// (admin routes are not).
+// POST /api/impersonate/stop
+func StopImpersonation(c *gin.Context) {
+	session, err := config.Store.Get(c.Request, config.SessionName)
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "session not found"})
+		return
+	}
+	if _, ok := c.Get("impersonator"); !ok {
+		c.JSON(http.StatusOK, gin.H{"status": "success", "message": "not impersonating"})
+		return
+	}
+
+	LogAuditEvent(c, "impersonation_stop", "user", "target="+strOrEmpty(session.Values[sessImpUID]))
+	clearImpersonation(session)
+	if err := session.Save(c.Request, c.Writer); err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": err.Error()})
+		return
+	}
+	c.JSON(http.StatusOK, gin.H{"status": "success"})
+}
+
+// ─── Helpers ─────────────────────────────────────────────────────────────────
+
+// checkImpersonationOffice validates overridden scope fields: the branch must
+// exist in pwa_office234 and area must be its zone (area alone must be a
+// known zone). profile.Zone is set from the office. Returns an HTTP status
+// and reason on failure.
+func checkImpersonationOffice(profile *services.UserProfile, area string) (int, string) {
+	if profile.PwaCode == "" {
+		offices, err := services.GetOfficesByZone(area)
+		if err != nil {
+			return http.StatusInternalServerError, err.Error()
+		}
+		if len(offices) == 0 {
+			return http.StatusBadRequest, "unknown area: " + area
+		}
+		profile.Zone = area
+		return 0, ""
+	}
+
+	zone, err := services.GetOfficeZone(profile.PwaCode)
+	if err != nil {
+		return http.StatusInternalServerError, err.Error()
+	}
+	if zone == "" {
+		return http.StatusBadRequest, "unknown pwa_code: " + profile.PwaCode
+	}
+	if area != "" && area != zone {
+		return http.StatusBadRequest, fmt.Sprintf("area %s is not the zone of pwa_code %s (%s)", area, profile.PwaCode, zone)
+	}
+	profile.Zone = zone
+	return 0, ""
+}
+
+// applyImpersonation overrides the context identity with the overlay.
+// Called by AuthRequired after the real session values are set.
+func applyImpersonation(c *gin.Context, session *sessions.Session) {
+	target, _ := session.Values[sessImpUID].(string)
+	if target == "" {
+		return
+	}
+	expires, _ := session.Values[sessImpExpires].(int64)
+	if time.Now().Unix() > expires {
+		clearImpersonation(session)
+		if err := session.Save(c.Request, c.Writer); err != nil {
+			log.Printf("session save error (impersonation expiry): %v", err)
+		}
+		return
+	}
+
+	c.Set("impersonator", session.Values[sessUID])
+	c.Set("impersonator_name", session.Values[sessUname])
+	c.Set("uid", target)
+	c.Set("uname", session.Values[sessImpUname])
+	c.Set("pwacode", session.Values[sessImpPwaCode])
+	c.Set("permission", session.Values[sessImpPermission])
+	c.Set("permission_leak", session.Values[sessImpPermLeak])
+	c.Set("area", session.Values[sessImpArea])
+}
+
+func clearImpersonation(session *sessions.Session) {
+	for _, k := range impersonationKeys {
+		delete(session.Values, k)
+	}
+}
+
+// rejectImpersonation aborts state-changing personal actions (e.g. issuing a
+// token) that would otherwise be performed as the impersonated user.
+func rejectImpersonation(c *gin.Context) bool {
+	if _, ok := c.Get("impersonator"); ok {
+		c.AbortWithStatusJSON(http.StatusForbidden, gin.H{
+			"status":  "error",
+			"error":   "forbidden",
+			"reason":  "not allowed while impersonating",
+			"message": "ไม่สามารถทำรายการนี้ระหว่างโหมดดูในฐานะผู้ใช้อื่น",
+		})
+		return true
+	}
+	return false
+}
+
+// impersonationInfo describes the active overlay for GET /api/session/info
+// (nil when not impersonating).
+func impersonationInfo(c *gin.Context, session *sessions.Session) gin.H {
+	admin, ok := c.Get("impersonator")
+	if !ok {
+		return nil
+	}
+	adminName, _ := c.Get("impersonator_name")
+	expires, _ := session.Values[sessImpExpires].(int64)
+	return gin.H{
+		"active":     true,
+		"admin_uid":  admin,
+		"admin_name": adminName,
+		"expires_at": time.Unix(expires, 0),
+	}
+}
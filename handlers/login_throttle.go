@@ -0,0 +1,101 @@
+package handlers
+
+import (
+	"database/sql"
+	"fmt"
+	"net/http"
+	"strconv"
+	"time"
+
+	"pwa_gis_tracking/services"
+
+	"github.com/gin-gonic/gin"
+)
+
+// ========================================================================
+// Login Throttling (see services/login_throttle.go for the policy)
+//
+// HandleLogin calls services.BeginLoginAttempt before contacting the
+// intranet and rejects blocked attempts with 429 + Retry-After.
+//
+// Admin only:
+//   GET    /api/admin/login/lockouts
+//   DELETE /api/admin/login/lockouts/:scope/:key   (scope = user | ip)
+// ========================================================================
+
+// rejectThrottledLogin writes the Thai 429 response for a blocked attempt.
+func rejectThrottledLogin(c *gin.Context, username string, ev authEvent, block *services.LoginBlock) {
+	wait := thaiDuration(block.RetryIn)
+
+	var msg string
+	switch {
+	case block.Locked && block.Scope == services.LoginScopeIP:
+		msg = "มีการเข้าสู่ระบบผิดพลาดจากเครื่องนี้หลายครั้ง ระบบระงับการเข้าสู่ระบบชั่วคราว กรุณาลองใหม่ในอีก " + wait
+	case block.Locked:
+		msg = "บัญชีนี้ถูกระงับการเข้าสู่ระบบชั่วคราว เนื่องจากใส่รหัสผ่านผิดหลายครั้ง กรุณาลองใหม่ในอีก " + wait + " หรือติดต่อผู้ดูแลระบบ"
+	default:
+		msg = "เข้าสู่ระบบผิดพลาดหลายครั้ง กรุณารอ " + wait + " แล้วลองใหม่อีกครั้ง"
+	}
+
+	ev.Reason = "throttled"
+	if block.Locked {
+		ev.Reason = "locked"
+	}
+	ev.Detail = fmt.Sprintf("scope=%s,failures=%d,retry_in=%ds", block.Scope, block.FailCount, int(block.RetryIn.Seconds()))
+	logLoginFailure(c, username, http.StatusTooManyRequests, ev)
+
+	c.Header("Retry-After", strconv.Itoa(int(block.RetryIn.Seconds())+1))
+	c.JSON(http.StatusTooManyRequests, gin.H{
+		"result":      "Locked",
+		"message":     msg,
+		"retry_after": int(block.RetryIn.Seconds()) + 1,
+		"link":        "./",
+	})
+}
+
+// thaiDuration formats a wait time for the lockout message.
+func thaiDuration(d time.Duration) string {
+	switch {
+	case d >= time.Hour:
+		h := int(d.Hours())
+		m := int((d - time.Duration(h)*time.Hour).Minutes())
+		if m == 0 {
+			return fmt.Sprintf("%d ชั่วโมง", h)
+		}
+		return fmt.Sprintf("%d ชั่วโมง %d นาที", h, m)
+	case d >= time.Minute:
+		return fmt.Sprintf("%d นาที", int(d.Minutes()+0.5))
+	default:
+		return fmt.Sprintf("%d วินาที", int(d.Seconds())+1)
+	}
+}
+
+// ─── Admin ───────────────────────────────────────────────────────────────────
+
+// ListLoginLockouts returns employee IDs / IPs that are currently blocked.
+// GET /api/admin/login/lockouts
+func ListLoginLockouts(c *gin.Context) {
+	list, err := services.ListLoginLockouts()
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": err.Error()})
+		return
+	}
+	c.JSON(http.StatusOK, gin.H{"status": "success", "data": list, "total": len(list)})
+}
+
+// UnlockLogin clears the failure counter for one employee ID or IP.
+// DELETE /api/admin/login/lockouts/:scope/:key
+func UnlockLogin(c *gin.Context) {
+	scope, key := c.Param("scope"), c.Param("key")
+	err := services.UnlockLogin(scope, key)
+	if err == sql.ErrNoRows {
+		c.JSON(http.StatusNotFound, gin.H{"error": "no failed logins recorded for " + scope + " " + key})
+		return
+	}
+	if err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+		return
+	}
+	LogAuditEvent(c, "login_unlock", "login", fmt.Sprintf("scope=%s,key=%s", scope, key))
+	c.JSON(http.StatusOK, gin.H{"status": "success", "message": "ปลดล็อกการเข้าสู่ระบบแล้ว"})
+}
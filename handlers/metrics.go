@@ -0,0 +1,124 @@
+package handlers
+
+import (
+	"crypto/subtle"
+	"net"
+	"net/http"
+	"os"
+	"strconv"
+	"strings"
+	"sync/atomic"
+	"time"
+
+	"pwa_gis_tracking/services"
+
+	"github.com/gin-gonic/gin"
+)
+
+// ========================================================================
+// Prometheus metrics (GET /metrics, text format, see services/metrics.go)
+//
+//   pwagis_http_request_duration_seconds{method,route,status}
+//   pwagis_dashboard_cache_requests_total{result}     hit | stale | miss | coalesced
+//   pwagis_dashboard_warm_cycle_duration_seconds{schedule}
+//   pwagis_dashboard_warm_tasks_total{schedule,result} success | failed
+//   pwagis_export_duration_seconds{format}
+//   pwagis_export_size_bytes{format}
+//   pwagis_audit_queue_depth, pwagis_audit_spilled_total
+//   pwagis_chatbot_proxy_duration_seconds{result}
+//
+// plus the Mongo, export tool and Postgres pool metrics of services.
+//
+// /metrics is outside the login. With METRICS_TOKEN set it requires
+// "Authorization: Bearer <token>"; without it only loopback clients are
+// answered (scrape through a local agent or set the token).
+// ========================================================================
+
+var (
+	httpRequestSeconds = services.NewHistogramVec("pwagis_http_request_duration_seconds",
+		"HTTP request latency by route template.", services.DurationBuckets, "method", "route", "status")
+
+	dashboardCacheRequests = services.NewCounterVec("pwagis_dashboard_cache_requests_total",
+		"GET /api/dashboard cache results (X-Cache).", "result")
+
+	warmCycleSeconds = services.NewHistogramVec("pwagis_dashboard_warm_cycle_duration_seconds",
+		"Dashboard cache warm cycle duration by schedule.", services.LongDurationBuckets, "schedule")
+
+	warmTasks = services.NewCounterVec("pwagis_dashboard_warm_tasks_total",
+		"Dashboards warmed by schedule and result.", "schedule", "result")
+
+	exportSeconds = services.NewHistogramVec("pwagis_export_duration_seconds",
+		"Successful export request duration by format.", services.LongDurationBuckets, "format")
+
+	exportBytes = services.NewHistogramVec("pwagis_export_size_bytes",
+		"Successful export response size by format.", services.SizeBuckets, "format")
+
+	chatbotProxySeconds = services.NewHistogramVec("pwagis_chatbot_proxy_duration_seconds",
+		"Latency of the chatbot text-to-query service by result.", services.LongDurationBuckets, "result")
+)
+
+func init() {
+	services.NewGaugeFunc("pwagis_audit_queue_depth",
+		"Audit entries waiting for the writer.", func() float64 { return float64(AuditQueueDepth()) })
+	services.NewGaugeFunc("pwagis_audit_spilled_total",
+		"Audit entries written to the spill file since startup.", func() float64 {
+			if auditW == nil {
+				return 0
+			}
+			return float64(atomic.LoadUint64(&auditW.spilled))
+		})
+}
+
+// MetricsMiddleware records the latency of every request by route template.
+func MetricsMiddleware() gin.HandlerFunc {
+	return func(c *gin.Context) {
+		start := time.Now()
+		c.Next()
+		route := c.FullPath()
+		if route == "" {
+			route = "unmatched" // 404s must not create one series per URL
+		}
+		httpRequestSeconds.ObserveSince(start, c.Request.Method, route, strconv.Itoa(c.Writer.Status()))
+	}
+}
+
+// exportFormatLabels is the fixed set of format label values; anything
+// else is recorded as "other".
+var exportFormatLabels = map[string]bool{
+	"xlsx": true, "csv": true, "geojson": true, "gpkg": true, "shp": true,
+	"fgb": true, "tab": true, "pmtiles": true, "mbtiles": true,
+}
+
+// observeExport records a finished export request. Call deferred at the
+// start of an export handler, after the format has been validated; failed
+// exports are not recorded.
+func observeExport(c *gin.Context, format string, start time.Time) {
+	if c.Writer.Status() >= 400 {
+		return
+	}
+	format = strings.ToLower(format)
+	if !exportFormatLabels[format] {
+		format = "other"
+	}
+	exportSeconds.ObserveSince(start, format)
+	exportBytes.Observe(float64(c.Writer.Size()), format)
+}
+
+// Metrics serves all metrics in the Prometheus text format.
+// GET /metrics
+func Metrics(c *gin.Context) {
+	if token := os.Getenv("METRICS_TOKEN"); token != "" {
+		got := strings.TrimPrefix(c.GetHeader("Authorization"), "Bearer ")
+		if subtle.ConstantTimeCompare([]byte(got), []byte(token)) != 1 {
+			c.AbortWithStatus(http.StatusUnauthorized)
+			return
+		}
+	} else if ip := net.ParseIP(c.RemoteIP()); ip == nil || !ip.IsLoopback() {
+		c.AbortWithStatus(http.StatusForbidden)
+		return
+	}
+
+	c.Header("Content-Type", "text/plain; version=0.0.4; charset=utf-8")
+	c.Status(http.StatusOK)
+	services.WriteMetrics(c.Writer)
+}
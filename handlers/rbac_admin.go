@@ -0,0 +1,168 @@
+package handlers
+
+import (
+	"database/sql"
+	"encoding/json"
+	"net/http"
+	"strconv"
+
+	"pwa_gis_tracking/services"
+
+	"github.com/gin-gonic/gin"
+)
+
+// ========================================================================
+// RBAC Rule Administration (admin only)
+//
+// CRUD over rbac.pwagis_access_rule. Every change reloads the in-memory
+// rule set used by services.ResolvePermission and is written to the
+// audit log with the rule contents (before/after) as target_value.
+//
+//   GET    /api/admin/rbac/rules
+//   POST   /api/admin/rbac/rules
+//   PUT    /api/admin/rbac/rules/:id
+//   DELETE /api/admin/rbac/rules/:id
+//   POST   /api/admin/rbac/reload
+// ========================================================================
+
+// ListAccessRules returns all RBAC rules.
+// GET /api/admin/rbac/rules
+func ListAccessRules(c *gin.Context) {
+	rules, err := services.ListAccessRules()
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": err.Error()})
+		return
+	}
+	c.JSON(http.StatusOK, gin.H{
+		"status":    "success",
+		"data":      rules,
+		"loaded_at": services.AccessRulesLoadedAt(),
+	})
+}
+
+// createAccessRuleRequest is the body of POST /api/admin/rbac/rules.
+// "enabled" defaults to true when omitted, like the column default.
+type createAccessRuleRequest struct {
+	services.AccessRule
+	Enabled *bool `json:"enabled"`
+}
+
+// CreateAccessRule adds a new RBAC rule.
+// POST /api/admin/rbac/rules
+func CreateAccessRule(c *gin.Context) {
+	var req createAccessRuleRequest
+	if err := c.ShouldBindJSON(&req); err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "Invalid request: " + err.Error()})
+		return
+	}
+	req.AccessRule.Enabled = req.Enabled == nil || *req.Enabled
+
+	uid, _ := c.Get("uid")
+	rule, err := services.CreateAccessRule(req.AccessRule, strOrEmpty(uid))
+	if err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+		return
+	}
+
+	LogAuditEvent(c, "rbac_rule_create", "rbac_rule", ruleAuditDetail(nil, &rule))
+	c.JSON(http.StatusCreated, gin.H{"status": "success", "data": rule})
+}
+
+// UpdateAccessRule replaces an existing RBAC rule.
+// PUT /api/admin/rbac/rules/:id
+func UpdateAccessRule(c *gin.Context) {
+	id, err := strconv.Atoi(c.Param("id"))
+	if err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "invalid rule id"})
+		return
+	}
+
+	var req services.AccessRule
+	if err := c.ShouldBindJSON(&req); err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "Invalid request: " + err.Error()})
+		return
+	}
+
+	before, err := services.GetAccessRule(id)
+	if err == sql.ErrNoRows {
+		c.JSON(http.StatusNotFound, gin.H{"error": "rule not found"})
+		return
+	}
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": err.Error()})
+		return
+	}
+
+	uid, _ := c.Get("uid")
+	rule, err := services.UpdateAccessRule(id, req, strOrEmpty(uid))
+	if err == sql.ErrNoRows {
+		c.JSON(http.StatusNotFound, gin.H{"error": "rule not found"})
+		return
+	}
+	if err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+		return
+	}
+
+	LogAuditEvent(c, "rbac_rule_update", "rbac_rule", ruleAuditDetail(&before, &rule))
+	c.JSON(http.StatusOK, gin.H{"status": "success", "data": rule})
+}
+
+// DeleteAccessRule removes an RBAC rule.
+// DELETE /api/admin/rbac/rules/:id
+func DeleteAccessRule(c *gin.Context) {
+	id, err := strconv.Atoi(c.Param("id"))
+	if err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "invalid rule id"})
+		return
+	}
+
+	before, err := services.GetAccessRule(id)
+	if err == sql.ErrNoRows {
+		c.JSON(http.StatusNotFound, gin.H{"error": "rule not found"})
+		return
+	}
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": err.Error()})
+		return
+	}
+
+	uid, _ := c.Get("uid")
+	if err := services.DeleteAccessRule(id, strOrEmpty(uid)); err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": err.Error()})
+		return
+	}
+
+	LogAuditEvent(c, "rbac_rule_delete", "rbac_rule", ruleAuditDetail(&before, nil))
+	c.JSON(http.StatusOK, gin.H{"status": "success", "message": "Rule deleted"})
+}
+
+// ReloadAccessRules forces an immediate reload from the database.
+// POST /api/admin/rbac/reload
+func ReloadAccessRules(c *gin.Context) {
+	if err := services.ReloadAccessRules(); err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": err.Error()})
+		return
+	}
+	if err := services.ReloadCapabilityGrants(); err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": err.Error()})
+		return
+	}
+	LogAuditEvent(c, "rbac_rule_reload", "rbac_rule", "")
+	c.JSON(http.StatusOK, gin.H{
+		"status":    "success",
+		"loaded_at": services.AccessRulesLoadedAt(),
+	})
+}
+
+// ruleAuditDetail serialises the before/after state of a rule for the audit log.
+func ruleAuditDetail(before, after *services.AccessRule) string {
+	raw, err := json.Marshal(map[string]*services.AccessRule{
+		"before": before,
+		"after":  after,
+	})
+	if err != nil {
+		return ""
+	}
+	return string(raw)
+}
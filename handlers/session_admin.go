@@ -0,0 +1,103 @@
+package handlers
+
+import (
+	"fmt"
+	"net/http"
+
+	"pwa_gis_tracking/config"
+	"pwa_gis_tracking/services"
+
+	"github.com/gin-gonic/gin"
+)
+
+// ========================================================================
+// Session Administration (admin only, SESSION_STORE=postgres)
+//
+//   GET  /api/admin/sessions?user=xxx&pwaCode=xxx
+//   POST /api/admin/sessions/logout   {"user_id":"14180"} | {"all":true}
+// ========================================================================
+
+// ListActiveSessions lists live sessions with per-user and per-branch counts.
+// GET /api/admin/sessions
+func ListActiveSessions(c *gin.Context) {
+	if config.PGSessions == nil {
+		c.JSON(http.StatusNotImplemented, gin.H{"error": "session listing requires SESSION_STORE=postgres"})
+		return
+	}
+
+	list, err := config.PGSessions.ListActiveSessions(c.Query("user"), c.Query("pwaCode"))
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": err.Error()})
+		return
+	}
+
+	byUser := map[string]int{}
+	byBranch := map[string]int{}
+	for _, s := range list {
+		byUser[s.UserID]++
+		byBranch[s.PwaCode]++
+	}
+
+	c.JSON(http.StatusOK, gin.H{
+		"status":    "success",
+		"data":      list,
+		"total":     len(list),
+		"by_user":   byUser,
+		"by_branch": byBranch,
+	})
+}
+
+// forceLogoutRequest is the body for POST /api/admin/sessions/logout.
+type forceLogoutRequest struct {
+	UserID string `json:"user_id"`
+	All    bool   `json:"all"`
+}
+
+// ForceLogout revokes the sessions and API tokens of one user, or of everyone
+// except the caller.
+// POST /api/admin/sessions/logout
+func ForceLogout(c *gin.Context) {
+	if config.PGSessions == nil {
+		c.JSON(http.StatusNotImplemented, gin.H{"error": "forced logout requires SESSION_STORE=postgres"})
+		return
+	}
+
+	var req forceLogoutRequest
+	if err := c.ShouldBindJSON(&req); err != nil || (req.UserID == "" && !req.All) {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "user_id or all=true is required"})
+		return
+	}
+
+	uid, _ := c.Get("uid")
+	actor := strOrEmpty(uid)
+
+	var n, tokens int64
+	var err error
+	var target string
+	if req.All {
+		// Keep the caller's own session (and tokens) so the admin is not
+		// logged out too
+		currentID := ""
+		if session, e := config.Store.Get(c.Request, config.SessionName); e == nil {
+			currentID = session.ID
+		}
+		n, err = config.PGSessions.RevokeAllSessions(currentID, actor)
+		if err == nil {
+			tokens, err = services.RevokeAllAPITokens(actor, actor)
+		}
+		target = "all"
+	} else {
+		n, err = config.PGSessions.RevokeUserSessions(req.UserID, actor)
+		if err == nil {
+			tokens, err = services.RevokeUserAPITokens(req.UserID, actor)
+		}
+		target = req.UserID
+	}
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": err.Error()})
+		return
+	}
+
+	LogAuditEvent(c, "force_logout", "session", fmt.Sprintf("user=%s,revoked=%d,tokens=%d", target, n, tokens))
+	c.JSON(http.StatusOK, gin.H{"status": "success", "revoked": n, "revoked_tokens": tokens})
+}
@@ -4,6 +4,7 @@ import (
 	"net/http"
 
 	"pwa_gis_tracking/config"
+	"pwa_gis_tracking/services"
 
 	"github.com/gin-gonic/gin"
 )
@@ -24,7 +25,12 @@ import (
 //	  "area":             "3",      // zone number
 //	  "job_name":         "งานแผนที่แนวท่อ",
 //	  "division":         "กองเทคโนโลยี...",
-//	  "institution":      "สำนักควบคุม..."
+//	  "institution":      "สำนักควบคุม...",
+//	  "csrf_token":       "...",    // send as X-CSRF-Token on POST/PUT/DELETE
+//	  "capabilities":     {"global": {"use_chatbot": true, ...},
+//	                       "layers": {"pipe": {"view_map": true, "export_vector": false, ...}}},
+//	  "pii_mask":         "partial", // "full"|"partial"|"removed" for customer name/code/address
+//	  "impersonation":    {...}     // only while an admin is viewing as this user
 //	}
 func GetSessionInfo(c *gin.Context) {
 	session, err := config.Store.Get(c.Request, config.SessionName)
@@ -33,7 +39,7 @@ func GetSessionInfo(c *gin.Context) {
 		return
 	}
 
-	c.JSON(http.StatusOK, gin.H{
+	info := gin.H{
 		"status":          "success",
 		"uid":             session.Values[sessUID],
 		"uname":           session.Values[sessUname],
@@ -45,5 +51,28 @@ func GetSessionInfo(c *gin.Context) {
 		"division":        session.Values[sessDivision],
 		"institution":     session.Values[sessInsitution],
 		"position":        session.Values[sessPosition],
-	})
-}
\ No newline at end of file
+		"csrf_token":      session.Values[sessCSRF],
+	}
+
+	// Capabilities of the effective permission level, so the UI can hide
+	// actions that would be refused (see services/capabilities.go)
+	permLeak, _ := c.Get("permission_leak")
+	global, layers := services.CapabilityMatrix(strOrEmpty(permLeak))
+	info["capabilities"] = gin.H{"global": global, "layers": layers}
+	info["pii_mask"] = piiMask(c)
+
+	// While impersonating, report the target's scope so the UI renders
+	// exactly what that user sees (job/division are not known for them)
+	if imp := impersonationInfo(c, session); imp != nil {
+		info["uid"], _ = c.Get("uid")
+		info["uname"], _ = c.Get("uname")
+		info["pwa_code"], _ = c.Get("pwacode")
+		info["permission"], _ = c.Get("permission")
+		info["permission_leak"], _ = c.Get("permission_leak")
+		info["area"], _ = c.Get("area")
+		info["job_name"], info["division"], info["institution"], info["position"] = "", "", "", ""
+		info["impersonation"] = imp
+	}
+
+	c.JSON(http.StatusOK, info)
+}
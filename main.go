@@ -2,13 +2,19 @@ package main
 
 import (
 	"context"
+	"encoding/json"
 	"fmt"
 	"log"
+	"net/http"
 	"os"
+	"os/signal"
+	"syscall"
+	"time"
 
 	"pwa_gis_tracking/config"
 	"pwa_gis_tracking/handlers"
 	"pwa_gis_tracking/routes"
+	"pwa_gis_tracking/services"
 
 	"github.com/gin-gonic/gin"
 	"github.com/joho/godotenv"
@@ -22,34 +28,78 @@ func main() {
 
 	// Establish database connections
 	config.ConnectPostgres()
+
+	// One-off maintenance commands (no HTTP server)
+	if len(os.Args) > 1 {
+		os.Exit(runCommand(os.Args[1]))
+	}
+
 	config.ConnectMongoDB()
 
 	// Initialize gorilla/sessions cookie store (must be before routes)
 	config.InitSessionStore()
 
+	// Admin employee numbers for /api/admin routes
+	config.InitAdminUsers()
+
+	// Login back-end (AUTH_PROVIDER=intranet|curl|stub)
+	services.InitAuthenticator()
+	services.InitLoginThrottle()
+	services.InitPIIMasking()
+
 	// Initialize Gin router in release mode
 	gin.SetMode(gin.ReleaseMode)
 	router := gin.Default()
 
-	// CORS middleware for cross-origin requests
-	router.Use(func(c *gin.Context) {
-		c.Writer.Header().Set("Access-Control-Allow-Origin", "*")
-		c.Writer.Header().Set("Access-Control-Allow-Methods", "GET, POST, OPTIONS")
-		c.Writer.Header().Set("Access-Control-Allow-Headers", "Content-Type")
-		if c.Request.Method == "OPTIONS" {
-			c.AbortWithStatus(204)
-			return
-		}
-		c.Next()
-	})
+	// Forwarded client IPs are honoured only from TRUSTED_PROXIES
+	if err := router.SetTrustedProxies(config.TrustedProxies()); err != nil {
+		log.Fatalf("TRUSTED_PROXIES: %v", err)
+	}
+	router.Use(handlers.SessionClientIP())
+
+	// CORS: only origins listed in CORS_ALLOWED_ORIGINS (same-origin otherwise)
+	config.InitCORS()
+	router.Use(handlers.CORSMiddleware())
+
+	// Request latency per route for GET /metrics
+	router.Use(handlers.MetricsMiddleware())
+
+	// Dashboard cache backend (DASHBOARD_CACHE=memory|postgres)
+	handlers.InitDashboardCache()
+
+	// Last-known-good dashboards from before the restart (served as stale)
+	handlers.RestoreCacheSnapshot()
 
 	// Start background cache cleaner (removes expired entries every minute)
 	handlers.StartCacheCleaner()
 
-	// Background cache warmer every 15 minutes
 	ctx, cancel := context.WithCancel(context.Background())
-    defer cancel()
-    handlers.StartCacheWarmer(ctx)
+	defer cancel()
+
+	// Collection alias → ObjectID index for FindCollectionID
+	indexRefresh := 10 * time.Minute
+	if d, err := time.ParseDuration(os.Getenv("COLLECTION_INDEX_REFRESH")); err == nil && d > 0 {
+		indexRefresh = d
+	}
+	services.StartCollectionIndexRefresher(ctx, indexRefresh)
+
+	// Save the in-memory dashboard cache periodically for the next restart
+	handlers.StartCacheSnapshotter(ctx)
+
+	// Background cache warmer (schedules from WARM_CONFIG_FILE)
+	handlers.StartCacheWarmer(ctx)
+
+	// Refresh affected dashboard keys when feature data changes in MongoDB
+	handlers.StartCacheInvalidator(ctx)
+
+	// Batched audit writer (spills to disk while Postgres is unavailable)
+	handlers.StartAuditWriter()
+
+	// Daily audit log archival (schedule in audit_logs.pwagis_archive_schedule)
+	services.StartAuditArchiver(ctx)
+
+	// Load RBAC rules from PostgreSQL and hot-reload them every minute
+	services.StartAccessRuleReloader(ctx, time.Minute)
 
 	// Register all routes
 	routes.RegisterRoutes(router)
@@ -67,5 +117,49 @@ func main() {
 	fmt.Printf("============================================\n")
 	fmt.Printf("\n")
 
-	router.Run(":" + port)
+	srv := &http.Server{Addr: ":" + port, Handler: router}
+	go func() {
+		if err := srv.ListenAndServe(); err != nil && err != http.ErrServerClosed {
+			log.Fatalf("server error: %v", err)
+		}
+	}()
+
+	// Graceful shutdown: finish in-flight requests, save the cache snapshot,
+	// then drain the audit queue
+	quit := make(chan os.Signal, 1)
+	signal.Notify(quit, syscall.SIGINT, syscall.SIGTERM)
+	<-quit
+	log.Println("Shutting down...")
+
+	shutdownCtx, shutdownCancel := context.WithTimeout(context.Background(), 15*time.Second)
+	defer shutdownCancel()
+	if err := srv.Shutdown(shutdownCtx); err != nil {
+		log.Printf("server shutdown error: %v", err)
+	}
+	cancel()
+	handlers.SaveCacheSnapshot()
+	handlers.StopAuditWriter(10 * time.Second)
+}
+
+// runCommand runs a maintenance command and returns the exit code.
+//
+//	verify-audit-chain   check the export audit hash chain (exit 1 if broken)
+func runCommand(name string) int {
+	switch name {
+	case "verify-audit-chain":
+		rep, err := services.VerifyAuditChain(context.Background())
+		if err != nil {
+			log.Printf("verify-audit-chain: %v", err)
+			return 2
+		}
+		out, _ := json.MarshalIndent(rep, "", "  ")
+		fmt.Println(string(out))
+		if !rep.OK {
+			return 1
+		}
+		return 0
+	default:
+		log.Printf("unknown command: %s (available: verify-audit-chain)", name)
+		return 2
+	}
 }
@@ -25,13 +25,17 @@ func RegisterRoutes(router *gin.Engine) {
 		pub.GET("/logout", handlers.HandleLogout)
 	}
 
+	// ─── Prometheus metrics (METRICS_TOKEN or loopback only) ─
+	router.GET("/metrics", handlers.Metrics)
+
 	// ─── Static files (served WITHOUT auth so login page can load CSS/images) ──
 	// Single Static() call to avoid Gin wildcard conflict.
 	// Images, icons, CSS, JS are all under ./static/
 	router.Group(basePath).Static("/static", "./static")
 
-	// ─── Protected routes (session auth required) ─────────
-	base := router.Group(basePath, handlers.AuthRequired(basePath))
+	// ─── Protected routes (session or API token required) ─
+	// CSRFProtect checks X-CSRF-Token on every non-GET request.
+	base := router.Group(basePath, handlers.AuthRequired(basePath), handlers.CSRFProtect())
 	{
 		// HTML pages
 		base.GET("/", func(c *gin.Context) {
@@ -59,10 +63,6 @@ func RegisterRoutes(router *gin.Engine) {
 			api.GET("/export/geodata", handlers.ExportGeoData)
 			api.GET("/features/map", handlers.GetFeaturesForMap)
 			api.GET("/features/properties", handlers.GetFeatureProps)
-			api.GET("/cache/invalidate", handlers.InvalidateCache)
-			api.GET("/debug/collection", handlers.DebugCollection)
-			// Add API route for monitoring cache status
-			api.GET("/cache/status", handlers.GetCacheStatus)
 			api.GET("/features/list", handlers.GetFeaturesList)
 			api.GET("/features/suggest", handlers.GetFeatureSuggestions)
 			api.GET("/features/facets", handlers.GetFeatureFacets)
@@ -76,6 +76,72 @@ func RegisterRoutes(router *gin.Engine) {
 
 			// Chatbot — text-to-query (proxy to Python service)
 			api.POST("/chatbot/query", handlers.ChatbotQuery)
+
+			// Exit admin "view as user" mode (must stay outside /admin)
+			api.POST("/impersonate/stop", handlers.StopImpersonation)
+
+			// Personal API tokens (bearer auth for scripts)
+			api.GET("/tokens", handlers.ListMyAPITokens)
+			api.POST("/tokens", handlers.IssueMyAPIToken)
+			api.DELETE("/tokens/:id", handlers.RevokeMyAPIToken)
+
+			// ─── Admin-only (ADMIN_USER_IDS) ──────────────
+			admin := api.Group("/admin", handlers.AdminRequired())
+			{
+				// View as user (impersonation)
+				admin.POST("/impersonate", handlers.StartImpersonation)
+
+				// Server-side sessions
+				admin.GET("/sessions", handlers.ListActiveSessions)
+				admin.POST("/sessions/logout", handlers.ForceLogout)
+
+				// Login throttling
+				admin.GET("/login/lockouts", handlers.ListLoginLockouts)
+				admin.DELETE("/login/lockouts/:scope/:key", handlers.UnlockLogin)
+
+				// API tokens (personal + service)
+				admin.GET("/tokens", handlers.AdminListAPITokens)
+				admin.POST("/tokens", handlers.AdminIssueAPIToken)
+				admin.DELETE("/tokens/:id", handlers.AdminRevokeAPIToken)
+
+				// RBAC access rules
+				admin.GET("/rbac/rules", handlers.ListAccessRules)
+				admin.POST("/rbac/rules", handlers.CreateAccessRule)
+				admin.PUT("/rbac/rules/:id", handlers.UpdateAccessRule)
+				admin.DELETE("/rbac/rules/:id", handlers.DeleteAccessRule)
+				admin.POST("/rbac/reload", handlers.ReloadAccessRules)
+
+				// Per-layer / per-action capabilities
+				admin.GET("/rbac/capabilities", handlers.ListCapabilityGrants)
+				admin.POST("/rbac/capabilities", handlers.CreateCapabilityGrant)
+				admin.DELETE("/rbac/capabilities/:id", handlers.DeleteCapabilityGrant)
+
+				// Audit log search & reports
+				admin.GET("/audit/logs", handlers.SearchAuditLogs)
+				admin.GET("/audit/reports/:kind", handlers.GetAuditReport)
+
+				// Audit log retention / archival
+				admin.GET("/audit/archive/preview", handlers.PreviewAuditArchive)
+				admin.POST("/audit/archive/run", handlers.RunAuditArchive)
+				admin.GET("/audit/archive/schedule", handlers.GetAuditArchiveSchedule)
+				admin.PUT("/audit/archive/schedule", handlers.UpdateAuditArchiveSchedule)
+				admin.GET("/audit/archive/runs", handlers.ListAuditArchiveRuns)
+
+				// Export audit hash chain
+				admin.GET("/audit/chain/verify", handlers.VerifyAuditChain)
+
+				// MongoDB collection alias index
+				admin.POST("/collections/reload", handlers.ReloadCollectionIndex)
+				admin.GET("/collections/orphans", handlers.ListOrphanCollections)
+
+				// Dashboard cache inspection / invalidation
+				admin.GET("/cache/status", handlers.GetCacheStatus)
+				admin.GET("/cache/entry", handlers.GetCacheEntry)
+				admin.POST("/cache/invalidate", handlers.InvalidateCache)
+
+				// MongoDB collection lookup for a branch
+				admin.GET("/debug/collection", handlers.DebugCollection)
+			}
 		}
 
 		// Health check
@@ -34,6 +34,9 @@ type AdvancedQueryRequest struct {
 	SortBy     string                 `json:"sortBy"`
 	SortOrder  string                 `json:"sortOrder"`
 	Limit      int                    `json:"limit"`
+
+	// Mask is set by the handler from the caller's permission level.
+	Mask MaskMode `json:"-"`
 }
 
 // resolvePwaCodes returns the list of pwa codes from the request.
@@ -66,6 +69,12 @@ func resolvePwaCodes(req *AdvancedQueryRequest) []string {
 	return nil
 }
 
+// ResolvedPwaCodes returns the branch codes the request targets, using the
+// same rules as query execution (PwaCodes first, then comma-separated PwaCode).
+func (req *AdvancedQueryRequest) ResolvedPwaCodes() []string {
+	return resolvePwaCodes(req)
+}
+
 // ════════════════════════════════════════════════════════════
 // Validation & Security
 // ════════════════════════════════════════════════════════════
@@ -464,7 +473,7 @@ func ExecuteAdvancedQuery(req *AdvancedQueryRequest) (*PaginatedResult, error) {
 	// ── Sort config ──
 	sortKey := "properties.recordDate"
 	sortDir := -1
-	if req.SortBy != "" {
+	if req.SortBy != "" && (req.Mask == MaskFull || !IsSensitiveField(req.Collection, req.SortBy)) {
 		if validated, err := validateField(req.Collection, req.SortBy); err == nil {
 			sortKey = validated
 		}
@@ -489,7 +498,9 @@ func ExecuteAdvancedQuery(req *AdvancedQueryRequest) (*PaginatedResult, error) {
 		coll := config.GetMongoCollection(fmt.Sprintf("features_%s", collectionID))
 
 		// Count
+		countStart := time.Now()
 		cnt, err := coll.CountDocuments(ctx, filter)
+		MongoOpSeconds.ObserveSince(countStart, "count", req.Collection)
 		if err != nil {
 			log.Printf("[AdvancedQuery] count error %s: %v", code, err)
 			continue
@@ -544,6 +555,7 @@ func ExecuteAdvancedQuery(req *AdvancedQueryRequest) (*PaginatedResult, error) {
 			} else {
 				row = rawProps
 			}
+			row = MaskProperties(req.Collection, row, req.Mask)
 
 			if docID, ok := doc["_id"]; ok {
 				if oid, ok := docID.(primitive.ObjectID); ok {
@@ -732,7 +744,7 @@ func ExportAdvancedQueryAsGeoJSON(req *AdvancedQueryRequest) ([]byte, error) {
 			features = append(features, Feature{
 				Type:       "Feature",
 				Geometry:   cleanBsonForJSON(geom),
-				Properties: props,
+				Properties: MaskProperties(req.Collection, props, req.Mask),
 			})
 		}
 		cursor.Close(ctx)
@@ -0,0 +1,422 @@
+// Package services/api_tokens.go
+// Personal and service API tokens for machine clients (nightly ETL, scripts).
+//
+// Tokens look like "pgt_<43 url-safe chars>". Only the SHA-256 hex digest is
+// stored in rbac.pwagis_api_token (see sql/create_api_tokens.sql), so a DB
+// leak does not leak usable credentials. Each token carries its own scope
+// (permission level + zone or branch list) and a mandatory expiry.
+//
+// A token never outranks its owner: the owner's intranet profile is stored
+// with the token and their permission is re-resolved on every use, so a
+// token is narrowed (or rejected) as soon as the owner's access shrinks.
+package services
+
+import (
+	"crypto/rand"
+	"crypto/sha256"
+	"database/sql"
+	"encoding/base64"
+	"encoding/hex"
+	"fmt"
+	"strings"
+	"time"
+
+	"pwa_gis_tracking/config"
+)
+
+// apiTokenPrefix marks our tokens so they are easy to spot in logs and scanners.
+const apiTokenPrefix = "pgt_"
+
+// MaxAPITokenLifetime caps the expiry that can be requested for a token.
+var MaxAPITokenLifetime = 365 * 24 * time.Hour
+
+// Token types.
+const (
+	TokenTypePersonal = "personal"
+	TokenTypeService  = "service"
+)
+
+// APIToken is one row of rbac.pwagis_api_token (never includes the secret).
+type APIToken struct {
+	ID              int        `json:"id"`
+	Prefix          string     `json:"prefix"`
+	Name            string     `json:"name"`
+	TokenType       string     `json:"token_type"`
+	OwnerUserID     string     `json:"owner_user_id"`
+	PermissionLevel string     `json:"permission_level"`
+	Zone            string     `json:"zone"`
+	PwaCodes        []string   `json:"pwa_codes"`
+	ExpiresAt       time.Time  `json:"expires_at"`
+	CreatedBy       string     `json:"created_by"`
+	CreatedAt       time.Time  `json:"created_at"`
+	RevokedAt       *time.Time `json:"revoked_at,omitempty"`
+	RevokedBy       string     `json:"revoked_by,omitempty"`
+	LastUsedAt      *time.Time `json:"last_used_at,omitempty"`
+	LastUsedIP      string     `json:"last_used_ip,omitempty"`
+	Expired         bool       `json:"expired"` // expires_at <= NOW(), evaluated by the DB
+	Owner           TokenOwner `json:"-"`
+}
+
+// TokenOwner is the owner's intranet profile captured when the token is
+// issued; ResolvePermission needs it to re-check the owner's access.
+type TokenOwner struct {
+	DepName string
+	DivName string
+	JobName string
+	Area    string
+	PwaCode string
+}
+
+// Active reports whether the token is neither revoked nor expired.
+func (t APIToken) Active() bool {
+	return t.RevokedAt == nil && !t.Expired
+}
+
+// IssueAPITokenRequest is the input for IssueAPIToken.
+type IssueAPITokenRequest struct {
+	Name            string    `json:"name" binding:"required"`
+	TokenType       string    `json:"token_type"`
+	OwnerUserID     string    `json:"owner_user_id"`
+	PermissionLevel string    `json:"permission_level" binding:"required"`
+	Zone            string    `json:"zone"`
+	PwaCodes        []string  `json:"pwa_codes"`
+	ExpiresAt       time.Time `json:"expires_at" binding:"required"`
+
+	// Owner is filled in by the handler from the issuing session, never
+	// from the request body.
+	Owner TokenOwner `json:"-"`
+}
+
+// validate normalises the request and checks scope/expiry consistency.
+func (r *IssueAPITokenRequest) validate() error {
+	r.Name = strings.TrimSpace(r.Name)
+	if r.Name == "" || len(r.Name) > 100 {
+		return fmt.Errorf("name is required (max 100 chars)")
+	}
+	if r.TokenType == "" {
+		r.TokenType = TokenTypePersonal
+	}
+	if r.TokenType != TokenTypePersonal && r.TokenType != TokenTypeService {
+		return fmt.Errorf("invalid token_type: %s", r.TokenType)
+	}
+	if r.OwnerUserID == "" {
+		return fmt.Errorf("owner_user_id is required")
+	}
+
+	var codes []string
+	for _, c := range r.PwaCodes {
+		if c = strings.TrimSpace(c); c != "" {
+			codes = append(codes, c)
+		}
+	}
+	r.PwaCodes = codes
+
+	switch r.PermissionLevel {
+	case "all":
+		r.Zone, r.PwaCodes = "", nil
+	case "reg":
+		if r.Zone == "" {
+			return fmt.Errorf("zone is required for permission_level=reg")
+		}
+		r.PwaCodes = nil
+	case "branch":
+		if len(r.PwaCodes) == 0 {
+			return fmt.Errorf("pwa_codes is required for permission_level=branch")
+		}
+		r.Zone = ""
+	default:
+		return fmt.Errorf("invalid permission_level: %s", r.PermissionLevel)
+	}
+
+	now := time.Now()
+	if !r.ExpiresAt.After(now) {
+		return fmt.Errorf("expires_at must be in the future")
+	}
+	if r.ExpiresAt.After(now.Add(MaxAPITokenLifetime)) {
+		return fmt.Errorf("expires_at exceeds the maximum lifetime of %v", MaxAPITokenLifetime)
+	}
+	return nil
+}
+
+// IssueAPIToken creates a token and returns the stored row plus the plain
+// token. The plain token cannot be recovered later.
+func IssueAPIToken(req IssueAPITokenRequest, actor string) (APIToken, string, error) {
+	if err := req.validate(); err != nil {
+		return APIToken{}, "", err
+	}
+
+	secret := make([]byte, 32)
+	if _, err := rand.Read(secret); err != nil {
+		return APIToken{}, "", fmt.Errorf("generate token failed: %v", err)
+	}
+	plain := apiTokenPrefix + base64.RawURLEncoding.EncodeToString(secret)
+
+	var id int
+	err := config.PgDB.QueryRow(`
+		INSERT INTO rbac.pwagis_api_token
+			(token_prefix, token_hash, name, token_type, owner_user_id,
+			 permission_level, zone, pwa_codes, expires_at, created_by,
+			 owner_dep_name, owner_div_name, owner_job_name, owner_area, owner_pwa_code)
+		VALUES ($1,$2,$3,$4,$5,$6,$7,$8,$9,$10,$11,$12,$13,$14,$15)
+		RETURNING id
+	`, plain[:len(apiTokenPrefix)+6], hashAPIToken(plain), req.Name, req.TokenType, req.OwnerUserID,
+		req.PermissionLevel, req.Zone, strings.Join(req.PwaCodes, ","), req.ExpiresAt, actor,
+		req.Owner.DepName, req.Owner.DivName, req.Owner.JobName, req.Owner.Area, req.Owner.PwaCode).Scan(&id)
+	if err != nil {
+		return APIToken{}, "", fmt.Errorf("insert api token failed: %v", err)
+	}
+
+	tok, err := GetAPIToken(id)
+	return tok, plain, err
+}
+
+// LookupAPIToken resolves a presented bearer token. Returns an error when the
+// token is unknown, revoked or expired, or when its owner has lost access.
+// The returned scope is narrowed to the owner's current permission.
+func LookupAPIToken(plain string) (APIToken, error) {
+	if !strings.HasPrefix(plain, apiTokenPrefix) {
+		return APIToken{}, fmt.Errorf("malformed token")
+	}
+	row := config.PgDB.QueryRow(`SELECT `+apiTokenColumns+`
+		FROM rbac.pwagis_api_token WHERE token_hash = $1`, hashAPIToken(plain))
+	tok, err := scanAPIToken(row)
+	if err == sql.ErrNoRows {
+		return APIToken{}, fmt.Errorf("unknown token")
+	}
+	if err != nil {
+		return APIToken{}, err
+	}
+	if tok.RevokedAt != nil {
+		return APIToken{}, fmt.Errorf("token revoked")
+	}
+	if tok.Expired {
+		return APIToken{}, fmt.Errorf("token expired")
+	}
+	if err := tok.narrowToOwner(); err != nil {
+		return APIToken{}, err
+	}
+	return tok, nil
+}
+
+// permLevelRank orders permission levels from narrowest to widest.
+var permLevelRank = map[string]int{"branch": 1, "reg": 2, "all": 3}
+
+// ownerPermission re-resolves the owner's permission against the current rules.
+func (t APIToken) ownerPermission() Permission {
+	return ResolvePermission(t.Owner.DepName, t.Owner.DivName, t.Owner.JobName, t.OwnerUserID)
+}
+
+// ownerCovers reports whether the owner's current permission still covers
+// the full scope the token was issued with.
+func (t APIToken) ownerCovers() bool {
+	perm := t.ownerPermission()
+	return IsAuthorised(perm) && permLevelRank[perm.PermissionLeak] >= permLevelRank[t.PermissionLevel]
+}
+
+// narrowToOwner cuts the token scope down to the owner's current permission
+// level (using the owner's zone / branch). Returns an error when the owner
+// is no longer authorised or nothing of the token scope is left.
+func (t *APIToken) narrowToOwner() error {
+	perm := t.ownerPermission()
+	if !IsAuthorised(perm) {
+		return fmt.Errorf("token owner is no longer authorised")
+	}
+	if permLevelRank[perm.PermissionLeak] >= permLevelRank[t.PermissionLevel] {
+		return nil
+	}
+
+	switch perm.PermissionLeak {
+	case "reg":
+		// Only an "all" token is wider than a regional owner
+		if t.Owner.Area == "" {
+			return fmt.Errorf("token owner has no zone")
+		}
+		t.PermissionLevel, t.Zone, t.PwaCodes = "reg", t.Owner.Area, nil
+	case "branch":
+		if t.Owner.PwaCode == "" {
+			return fmt.Errorf("token owner has no branch")
+		}
+		if t.PermissionLevel == "reg" {
+			zone, err := GetOfficeZone(t.Owner.PwaCode)
+			if err != nil {
+				return err
+			}
+			if zone != t.Zone {
+				return fmt.Errorf("token owner's branch is outside zone %s", t.Zone)
+			}
+		}
+		t.PermissionLevel, t.Zone, t.PwaCodes = "branch", "", []string{t.Owner.PwaCode}
+	default:
+		return fmt.Errorf("invalid owner permission_level: %s", perm.PermissionLeak)
+	}
+	return nil
+}
+
+// TouchAPIToken records the last use of a token. Best-effort.
+func TouchAPIToken(id int, ip string) {
+	if config.PgDB == nil {
+		return
+	}
+	_, _ = config.PgDB.Exec(`
+		UPDATE rbac.pwagis_api_token
+		SET last_used_at = NOW(), last_used_ip = $2
+		WHERE id = $1
+	`, id, ip)
+}
+
+// GetAPIToken returns one token by id (sql.ErrNoRows when missing).
+func GetAPIToken(id int) (APIToken, error) {
+	row := config.PgDB.QueryRow(`SELECT `+apiTokenColumns+`
+		FROM rbac.pwagis_api_token WHERE id = $1`, id)
+	return scanAPIToken(row)
+}
+
+// ListAPITokens returns tokens, newest first. An empty owner lists all tokens.
+func ListAPITokens(ownerUserID string) ([]APIToken, error) {
+	query := `SELECT ` + apiTokenColumns + ` FROM rbac.pwagis_api_token`
+	args := []interface{}{}
+	if ownerUserID != "" {
+		query += ` WHERE owner_user_id = $1`
+		args = append(args, ownerUserID)
+	}
+	query += ` ORDER BY created_at DESC`
+
+	rows, err := config.PgDB.Query(query, args...)
+	if err != nil {
+		return nil, fmt.Errorf("query api tokens failed: %v", err)
+	}
+	defer rows.Close()
+
+	tokens := []APIToken{}
+	for rows.Next() {
+		t, err := scanAPIToken(rows)
+		if err != nil {
+			continue
+		}
+		tokens = append(tokens, t)
+	}
+	return tokens, rows.Err()
+}
+
+// RevokeAPIToken marks a token as revoked. Revoking twice is a no-op.
+func RevokeAPIToken(id int, actor string) error {
+	res, err := config.PgDB.Exec(`
+		UPDATE rbac.pwagis_api_token
+		SET revoked_at = NOW(), revoked_by = $2
+		WHERE id = $1 AND revoked_at IS NULL
+	`, id, actor)
+	if err != nil {
+		return fmt.Errorf("revoke api token failed: %v", err)
+	}
+	if n, _ := res.RowsAffected(); n == 0 {
+		if _, err := GetAPIToken(id); err == sql.ErrNoRows {
+			return sql.ErrNoRows
+		}
+	}
+	return nil
+}
+
+// RevokeUserAPITokens revokes every active token owned by userID.
+func RevokeUserAPITokens(userID, actor string) (int64, error) {
+	res, err := config.PgDB.Exec(`
+		UPDATE rbac.pwagis_api_token
+		SET revoked_at = NOW(), revoked_by = $2
+		WHERE owner_user_id = $1 AND revoked_at IS NULL
+	`, userID, actor)
+	if err != nil {
+		return 0, fmt.Errorf("revoke user api tokens failed: %v", err)
+	}
+	return res.RowsAffected()
+}
+
+// RevokeAllAPITokens revokes every active token except those owned by
+// exceptUserID (the admin performing the revocation).
+func RevokeAllAPITokens(exceptUserID, actor string) (int64, error) {
+	res, err := config.PgDB.Exec(`
+		UPDATE rbac.pwagis_api_token
+		SET revoked_at = NOW(), revoked_by = $2
+		WHERE owner_user_id <> $1 AND revoked_at IS NULL
+	`, exceptUserID, actor)
+	if err != nil {
+		return 0, fmt.Errorf("revoke api tokens failed: %v", err)
+	}
+	return res.RowsAffected()
+}
+
+// RevokeUncoveredAPITokens revokes active tokens whose owner no longer holds
+// the permission level the token was issued with. Called after RBAC rule
+// changes; lookups narrow such tokens anyway, this makes the loss explicit.
+func RevokeUncoveredAPITokens(actor string) (int, error) {
+	if config.PgDB == nil {
+		return 0, fmt.Errorf("postgres not connected")
+	}
+	rows, err := config.PgDB.Query(`SELECT ` + apiTokenColumns + `
+		FROM rbac.pwagis_api_token
+		WHERE revoked_at IS NULL AND expires_at > NOW()`)
+	if err != nil {
+		return 0, fmt.Errorf("query api tokens failed: %v", err)
+	}
+	var stale []int
+	for rows.Next() {
+		t, err := scanAPIToken(rows)
+		if err != nil {
+			continue
+		}
+		if !t.ownerCovers() {
+			stale = append(stale, t.ID)
+		}
+	}
+	err = rows.Err()
+	rows.Close()
+	if err != nil {
+		return 0, err
+	}
+
+	revoked := 0
+	for _, id := range stale {
+		if err := RevokeAPIToken(id, actor); err != nil {
+			return revoked, err
+		}
+		revoked++
+	}
+	return revoked, nil
+}
+
+// ─── Helpers ────────────────────────────────────────────────────────────────
+
+const apiTokenColumns = `
+	id, token_prefix, name, token_type, owner_user_id, permission_level,
+	COALESCE(zone, ''), COALESCE(pwa_codes, ''), expires_at, created_by, created_at,
+	revoked_at, COALESCE(revoked_by, ''), last_used_at, COALESCE(last_used_ip, ''),
+	expires_at <= NOW(),
+	COALESCE(owner_dep_name, ''), COALESCE(owner_div_name, ''), COALESCE(owner_job_name, ''),
+	COALESCE(owner_area, ''), COALESCE(owner_pwa_code, '')`
+
+func scanAPIToken(s rowScanner) (APIToken, error) {
+	var t APIToken
+	var codes string
+	var revokedAt, lastUsedAt sql.NullTime
+	err := s.Scan(&t.ID, &t.Prefix, &t.Name, &t.TokenType, &t.OwnerUserID, &t.PermissionLevel,
+		&t.Zone, &codes, &t.ExpiresAt, &t.CreatedBy, &t.CreatedAt,
+		&revokedAt, &t.RevokedBy, &lastUsedAt, &t.LastUsedIP, &t.Expired,
+		&t.Owner.DepName, &t.Owner.DivName, &t.Owner.JobName, &t.Owner.Area, &t.Owner.PwaCode)
+	if err != nil {
+		return APIToken{}, err
+	}
+	if codes != "" {
+		t.PwaCodes = strings.Split(codes, ",")
+	}
+	if revokedAt.Valid {
+		t.RevokedAt = &revokedAt.Time
+	}
+	if lastUsedAt.Valid {
+		t.LastUsedAt = &lastUsedAt.Time
+	}
+	return t, nil
+}
+
+// hashAPIToken returns the hex SHA-256 digest stored in token_hash.
+func hashAPIToken(plain string) string {
+	sum := sha256.Sum256([]byte(plain))
+	return hex.EncodeToString(sum[:])
+}
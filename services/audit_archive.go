@@ -0,0 +1,589 @@
+// Package services/audit_archive.go
+// Retention for audit_logs.pwagis_track_log.
+//
+// Rows older than retention_days are moved month by month, either into
+// audit_logs.pwagis_track_log_YYYYMM ("table" mode, created on demand) or
+// into AUDIT_ARCHIVE_DIR/pwagis_track_log_YYYYMM_<unix>.csv.gz ("file"
+// mode), and then deleted from the live table. Each month is copied and
+// deleted in one transaction, bounded by the max id seen at the start so
+// rows replayed from the audit spill file during a run are never lost.
+// The same transaction records the last export hash-chain row it moved in
+// the run row (chain_last_id / chain_last_hash, authenticated by
+// chain_anchor_mac), which VerifyAuditChain uses to check the prev_hash of
+// the first live export row.
+//
+// The schedule (sql/create_audit_archive.sql) is checked every minute by
+// StartAuditArchiver; a Postgres advisory lock keeps multiple instances
+// from archiving at the same time. audit_logs.daily_usage is untouched and
+// keeps summarising the live table.
+package services
+
+import (
+	"compress/gzip"
+	"context"
+	"database/sql"
+	"encoding/csv"
+	"fmt"
+	"log"
+	"os"
+	"path/filepath"
+	"regexp"
+	"strconv"
+	"strings"
+	"time"
+
+	"pwa_gis_tracking/config"
+)
+
+// Archive modes.
+const (
+	ArchiveModeTable = "table"
+	ArchiveModeFile  = "file"
+)
+
+// auditArchiveLockKey is the pg_advisory_lock key of the archival job.
+const auditArchiveLockKey = 7310015
+
+// MinAuditRetentionDays guards against archiving recent activity by mistake.
+const MinAuditRetentionDays = 7
+
+var archiveMonthRe = regexp.MustCompile(`^\d{6}$`)
+
+// ArchiveSchedule is the single row of audit_logs.pwagis_archive_schedule.
+type ArchiveSchedule struct {
+	Enabled       bool      `json:"enabled"`
+	RunAt         string    `json:"run_at"` // HH:MM local time
+	RetentionDays int       `json:"retention_days"`
+	Mode          string    `json:"mode"`
+	UpdatedBy     string    `json:"updated_by"`
+	UpdatedAt     time.Time `json:"updated_at"`
+}
+
+// Validate checks a schedule before it is saved.
+func (s *ArchiveSchedule) Validate() error {
+	if _, err := time.Parse("15:04", s.RunAt); err != nil {
+		return fmt.Errorf("invalid run_at: %s (HH:MM)", s.RunAt)
+	}
+	return ValidateArchiveParams(s.RetentionDays, s.Mode)
+}
+
+// ValidateArchiveParams checks the retention and mode of a run.
+func ValidateArchiveParams(retentionDays int, mode string) error {
+	if retentionDays < MinAuditRetentionDays {
+		return fmt.Errorf("retention_days must be at least %d", MinAuditRetentionDays)
+	}
+	if mode != ArchiveModeTable && mode != ArchiveModeFile {
+		return fmt.Errorf("invalid mode: %s (table or file)", mode)
+	}
+	return nil
+}
+
+// ArchiveMonth is the number of rows of one month that are (or would be) moved.
+type ArchiveMonth struct {
+	Month  string `json:"month"` // YYYYMM
+	Rows   int64  `json:"rows"`
+	Target string `json:"target,omitempty"`
+}
+
+// ArchiveRun is one row of audit_logs.pwagis_archive_run.
+type ArchiveRun struct {
+	ID         int        `json:"id"`
+	StartedAt  time.Time  `json:"started_at"`
+	FinishedAt *time.Time `json:"finished_at,omitempty"`
+	Trigger    string     `json:"trigger"`
+	Mode       string     `json:"mode"`
+	Cutoff     time.Time  `json:"cutoff"`
+	MovedRows  int64      `json:"moved_rows"`
+	Months     string     `json:"months"`
+	Error      string     `json:"error,omitempty"`
+	RunBy      string     `json:"run_by"`
+
+	// Last chained export row moved by the run (see audit_chain.go)
+	ChainLastID   int64  `json:"chain_last_id,omitempty"`
+	ChainLastHash string `json:"chain_last_hash,omitempty"`
+}
+
+// ─── Schedule ────────────────────────────────────────────────────────────────
+
+// GetArchiveSchedule reads the schedule row.
+func GetArchiveSchedule() (ArchiveSchedule, error) {
+	var s ArchiveSchedule
+	if config.PgDB == nil {
+		return s, fmt.Errorf("postgres not connected")
+	}
+	err := config.PgDB.QueryRow(`
+		SELECT enabled, run_at, retention_days, mode, COALESCE(updated_by, ''), updated_at
+		FROM audit_logs.pwagis_archive_schedule WHERE id = 1
+	`).Scan(&s.Enabled, &s.RunAt, &s.RetentionDays, &s.Mode, &s.UpdatedBy, &s.UpdatedAt)
+	if err != nil {
+		return s, fmt.Errorf("read archive schedule failed: %v", err)
+	}
+	return s, nil
+}
+
+// SaveArchiveSchedule validates and stores the schedule.
+func SaveArchiveSchedule(s ArchiveSchedule, actor string) (ArchiveSchedule, error) {
+	if err := s.Validate(); err != nil {
+		return s, err
+	}
+	res, err := config.PgDB.Exec(`
+		UPDATE audit_logs.pwagis_archive_schedule
+		SET enabled = $1, run_at = $2, retention_days = $3, mode = $4, updated_by = $5, updated_at = NOW()
+		WHERE id = 1
+	`, s.Enabled, s.RunAt, s.RetentionDays, s.Mode, actor)
+	if err != nil {
+		return s, fmt.Errorf("update archive schedule failed: %v", err)
+	}
+	if n, _ := res.RowsAffected(); n == 0 {
+		if _, err := config.PgDB.Exec(`
+			INSERT INTO audit_logs.pwagis_archive_schedule (id, enabled, run_at, retention_days, mode, updated_by)
+			VALUES (1, $1, $2, $3, $4, $5)
+		`, s.Enabled, s.RunAt, s.RetentionDays, s.Mode, actor); err != nil {
+			return s, fmt.Errorf("insert archive schedule failed: %v", err)
+		}
+	}
+	return GetArchiveSchedule()
+}
+
+// ─── Preview / run ───────────────────────────────────────────────────────────
+
+// ArchiveCutoff returns the start of the day retentionDays ago.
+func ArchiveCutoff(retentionDays int) time.Time {
+	now := time.Now()
+	today := time.Date(now.Year(), now.Month(), now.Day(), 0, 0, 0, 0, now.Location())
+	return today.AddDate(0, 0, -retentionDays)
+}
+
+// PreviewAuditArchive lists the months and row counts older than cutoff.
+func PreviewAuditArchive(cutoff time.Time) ([]ArchiveMonth, error) {
+	if config.PgDB == nil {
+		return nil, fmt.Errorf("postgres not connected")
+	}
+	rows, err := config.PgDB.Query(`
+		SELECT to_char(created_at, 'YYYYMM'), COUNT(*)
+		FROM audit_logs.pwagis_track_log
+		WHERE created_at < $1
+		GROUP BY 1 ORDER BY 1
+	`, cutoff)
+	if err != nil {
+		return nil, fmt.Errorf("archive preview failed: %v", err)
+	}
+	defer rows.Close()
+
+	months := []ArchiveMonth{}
+	for rows.Next() {
+		var m ArchiveMonth
+		if err := rows.Scan(&m.Month, &m.Rows); err != nil {
+			return nil, fmt.Errorf("scan archive preview failed: %v", err)
+		}
+		months = append(months, m)
+	}
+	return months, rows.Err()
+}
+
+// ErrArchiveRunning is returned when another instance holds the archive lock.
+var ErrArchiveRunning = fmt.Errorf("audit archival is already running")
+
+// RunAuditArchive moves rows older than retentionDays and records the run.
+// trigger is "schedule" or "manual"; actor is the admin (empty for schedule).
+func RunAuditArchive(retentionDays int, mode, trigger, actor string) (ArchiveRun, error) {
+	run := ArchiveRun{Trigger: trigger, Mode: mode, RunBy: actor, StartedAt: time.Now()}
+	if config.PgDB == nil {
+		return run, fmt.Errorf("postgres not connected")
+	}
+	if err := ValidateArchiveParams(retentionDays, mode); err != nil {
+		return run, err
+	}
+	run.Cutoff = ArchiveCutoff(retentionDays)
+
+	ctx := context.Background()
+	conn, err := config.PgDB.Conn(ctx)
+	if err != nil {
+		return run, fmt.Errorf("archive lock connection failed: %v", err)
+	}
+	defer conn.Close()
+	var locked bool
+	if err := conn.QueryRowContext(ctx, `SELECT pg_try_advisory_lock($1)`, auditArchiveLockKey).Scan(&locked); err != nil {
+		return run, fmt.Errorf("archive lock failed: %v", err)
+	}
+	if !locked {
+		return run, ErrArchiveRunning
+	}
+	defer conn.ExecContext(ctx, `SELECT pg_advisory_unlock($1)`, auditArchiveLockKey)
+
+	if err := config.PgDB.QueryRow(`
+		INSERT INTO audit_logs.pwagis_archive_run (run_trigger, mode, cutoff, run_by)
+		VALUES ($1, $2, $3, $4) RETURNING id, started_at
+	`, trigger, mode, run.Cutoff, actor).Scan(&run.ID, &run.StartedAt); err != nil {
+		return run, fmt.Errorf("record archive run failed: %v", err)
+	}
+
+	moved, runErr := archiveOlderThan(run.ID, run.Cutoff, mode)
+	var parts []string
+	for _, m := range moved {
+		run.MovedRows += m.Rows
+		parts = append(parts, m.Month+":"+strconv.FormatInt(m.Rows, 10))
+	}
+	run.Months = strings.Join(parts, ",")
+	if runErr != nil {
+		run.Error = runErr.Error()
+	}
+	now := time.Now()
+	run.FinishedAt = &now
+
+	if _, err := config.PgDB.Exec(`
+		UPDATE audit_logs.pwagis_archive_run
+		SET finished_at = NOW(), moved_rows = $2, months = $3, error = NULLIF($4, '')
+		WHERE id = $1
+	`, run.ID, run.MovedRows, run.Months, run.Error); err != nil {
+		log.Printf("[AuditArchive] update run %d failed: %v", run.ID, err)
+	}
+	log.Printf("[AuditArchive] run %d (%s, %s): moved %d rows older than %s [%s] %s",
+		run.ID, trigger, mode, run.MovedRows, run.Cutoff.Format("2006-01-02"), run.Months, run.Error)
+	return run, runErr
+}
+
+// archiveOlderThan moves every month before cutoff; it stops at the first
+// failing month and returns what was moved so far.
+func archiveOlderThan(runID int, cutoff time.Time, mode string) ([]ArchiveMonth, error) {
+	var maxID int64
+	if err := config.PgDB.QueryRow(`SELECT COALESCE(MAX(id), 0) FROM audit_logs.pwagis_track_log`).Scan(&maxID); err != nil {
+		return nil, fmt.Errorf("read max id failed: %v", err)
+	}
+	months, err := PreviewAuditArchive(cutoff)
+	if err != nil {
+		return nil, err
+	}
+
+	var moved []ArchiveMonth
+	for _, m := range months {
+		if !archiveMonthRe.MatchString(m.Month) {
+			continue
+		}
+		start, _ := time.ParseInLocation("200601", m.Month, time.Local)
+		end := start.AddDate(0, 1, 0)
+		if end.After(cutoff) {
+			end = cutoff
+		}
+
+		var n int64
+		var target string
+		if mode == ArchiveModeFile {
+			n, target, err = archiveMonthToFile(runID, m.Month, start, end, maxID)
+		} else {
+			n, target, err = archiveMonthToTable(runID, m.Month, start, end, maxID)
+		}
+		if err != nil {
+			return moved, fmt.Errorf("month %s: %v", m.Month, err)
+		}
+		moved = append(moved, ArchiveMonth{Month: m.Month, Rows: n, Target: target})
+	}
+	return moved, nil
+}
+
+// archiveMonthToTable copies one month into pwagis_track_log_YYYYMM and
+// deletes it from the live table in one transaction.
+func archiveMonthToTable(runID int, month string, start, end time.Time, maxID int64) (int64, string, error) {
+	table := "audit_logs.pwagis_track_log_" + month
+	if _, err := config.PgDB.Exec(`CREATE TABLE IF NOT EXISTS ` + table + ` (LIKE audit_logs.pwagis_track_log)`); err != nil {
+		return 0, "", fmt.Errorf("create %s failed: %v", table, err)
+	}
+	cols, err := archiveTableColumns("pwagis_track_log_" + month)
+	if err != nil {
+		return 0, "", err
+	}
+
+	tx, err := config.PgDB.Begin()
+	if err != nil {
+		return 0, "", err
+	}
+	defer tx.Rollback()
+
+	colList := strings.Join(cols, ", ")
+	res, err := tx.Exec(`INSERT INTO `+table+` (`+colList+`)
+		SELECT `+colList+` FROM audit_logs.pwagis_track_log
+		WHERE created_at >= $1 AND created_at < $2 AND id <= $3`, start, end, maxID)
+	if err != nil {
+		return 0, "", fmt.Errorf("copy to %s failed: %v", table, err)
+	}
+	copied, _ := res.RowsAffected()
+	if err := recordArchivedChain(tx, runID, start, end, maxID); err != nil {
+		return 0, "", err
+	}
+	res, err = tx.Exec(`DELETE FROM audit_logs.pwagis_track_log
+		WHERE created_at >= $1 AND created_at < $2 AND id <= $3`, start, end, maxID)
+	if err != nil {
+		return 0, "", fmt.Errorf("delete failed: %v", err)
+	}
+	if deleted, _ := res.RowsAffected(); deleted != copied {
+		return 0, "", fmt.Errorf("copied %d rows but would delete %d, rolled back", copied, deleted)
+	}
+	if err := tx.Commit(); err != nil {
+		return 0, "", err
+	}
+	return copied, table, nil
+}
+
+// archiveTableColumns returns the columns of an archive table. Columns added
+// to the live table after the archive table was created are added to it.
+func archiveTableColumns(table string) ([]string, error) {
+	rows, err := config.PgDB.Query(`
+		SELECT l.column_name, l.data_type, a.column_name IS NOT NULL
+		FROM information_schema.columns l
+		LEFT JOIN information_schema.columns a
+			ON a.table_schema = l.table_schema AND a.table_name = $1 AND a.column_name = l.column_name
+		WHERE l.table_schema = 'audit_logs' AND l.table_name = 'pwagis_track_log'
+		ORDER BY l.ordinal_position
+	`, table)
+	if err != nil {
+		return nil, fmt.Errorf("read columns of %s failed: %v", table, err)
+	}
+	type col struct {
+		name, dataType string
+		present        bool
+	}
+	var all []col
+	for rows.Next() {
+		var c col
+		if err := rows.Scan(&c.name, &c.dataType, &c.present); err != nil {
+			rows.Close()
+			return nil, err
+		}
+		all = append(all, c)
+	}
+	rows.Close()
+
+	names := make([]string, 0, len(all))
+	for _, c := range all {
+		if !c.present {
+			if _, err := config.PgDB.Exec(fmt.Sprintf(`ALTER TABLE audit_logs.%s ADD COLUMN %s %s`,
+				table, pqIdent(c.name), c.dataType)); err != nil {
+				return nil, fmt.Errorf("add column %s to %s failed: %v", c.name, table, err)
+			}
+		}
+		names = append(names, pqIdent(c.name))
+	}
+	return names, nil
+}
+
+// pqIdent quotes a column name read from information_schema.
+func pqIdent(name string) string {
+	return `"` + strings.Replace(name, `"`, `""`, -1) + `"`
+}
+
+// archiveMonthToFile writes one month to a gzip CSV and deletes it from the
+// live table. The file is fsynced before the delete commits.
+func archiveMonthToFile(runID int, month string, start, end time.Time, maxID int64) (int64, string, error) {
+	dir := os.Getenv("AUDIT_ARCHIVE_DIR")
+	if dir == "" {
+		dir = "audit_archive"
+	}
+	if err := os.MkdirAll(dir, 0750); err != nil {
+		return 0, "", fmt.Errorf("archive dir: %v", err)
+	}
+	path := filepath.Join(dir, fmt.Sprintf("pwagis_track_log_%s_%d.csv.gz", month, time.Now().Unix()))
+
+	tx, err := config.PgDB.Begin()
+	if err != nil {
+		return 0, "", err
+	}
+	defer tx.Rollback()
+
+	// Lock the month's rows so the file and the delete see the same set
+	rows, err := tx.Query(`SELECT * FROM audit_logs.pwagis_track_log
+		WHERE created_at >= $1 AND created_at < $2 AND id <= $3
+		ORDER BY id FOR UPDATE`, start, end, maxID)
+	if err != nil {
+		return 0, "", fmt.Errorf("read month failed: %v", err)
+	}
+	n, err := writeArchiveFile(path, rows)
+	rows.Close()
+	if err != nil {
+		os.Remove(path)
+		return 0, "", err
+	}
+	if err := recordArchivedChain(tx, runID, start, end, maxID); err != nil {
+		os.Remove(path)
+		return 0, "", err
+	}
+
+	res, err := tx.Exec(`DELETE FROM audit_logs.pwagis_track_log
+		WHERE created_at >= $1 AND created_at < $2 AND id <= $3`, start, end, maxID)
+	if err != nil {
+		os.Remove(path)
+		return 0, "", fmt.Errorf("delete failed: %v", err)
+	}
+	if deleted, _ := res.RowsAffected(); deleted != n {
+		os.Remove(path)
+		return 0, "", fmt.Errorf("wrote %d rows but would delete %d, rolled back", n, deleted)
+	}
+	if err := tx.Commit(); err != nil {
+		os.Remove(path)
+		return 0, "", err
+	}
+	return n, path, nil
+}
+
+// recordArchivedChain stores the last chained export row of the month in the
+// run row, inside the transaction that deletes it from the live table.
+func recordArchivedChain(tx *sql.Tx, runID int, start, end time.Time, maxID int64) error {
+	var lastID int64
+	var lastHash string
+	err := tx.QueryRow(`SELECT id, chain_hash FROM audit_logs.pwagis_track_log
+		WHERE created_at >= $1 AND created_at < $2 AND id <= $3 AND chain_hash IS NOT NULL
+		ORDER BY id DESC LIMIT 1`, start, end, maxID).Scan(&lastID, &lastHash)
+	if err == sql.ErrNoRows {
+		return nil
+	}
+	if err != nil {
+		return fmt.Errorf("read last chained row failed: %v", err)
+	}
+	if _, err := tx.Exec(`UPDATE audit_logs.pwagis_archive_run
+		SET chain_last_id = $2, chain_last_hash = $3, chain_anchor_mac = $4
+		WHERE id = $1 AND (chain_last_id IS NULL OR chain_last_id < $2)`,
+		runID, lastID, lastHash, AuditChainAnchorMAC(runID, lastID, lastHash)); err != nil {
+		return fmt.Errorf("record archived chain failed: %v", err)
+	}
+	return nil
+}
+
+// writeArchiveFile streams rows into a gzip CSV with a header line.
+func writeArchiveFile(path string, rows *sql.Rows) (int64, error) {
+	cols, err := rows.Columns()
+	if err != nil {
+		return 0, err
+	}
+	f, err := os.OpenFile(path, os.O_CREATE|os.O_EXCL|os.O_WRONLY, 0640)
+	if err != nil {
+		return 0, fmt.Errorf("create %s: %v", path, err)
+	}
+	defer f.Close()
+
+	gz := gzip.NewWriter(f)
+	w := csv.NewWriter(gz)
+	w.Write(cols)
+
+	vals := make([]interface{}, len(cols))
+	ptrs := make([]interface{}, len(cols))
+	for i := range vals {
+		ptrs[i] = &vals[i]
+	}
+	record := make([]string, len(cols))
+	var n int64
+	for rows.Next() {
+		if err := rows.Scan(ptrs...); err != nil {
+			return n, err
+		}
+		for i, v := range vals {
+			switch val := v.(type) {
+			case nil:
+				record[i] = ""
+			case time.Time:
+				record[i] = val.Format(time.RFC3339Nano)
+			case []byte:
+				record[i] = string(val)
+			default:
+				record[i] = fmt.Sprintf("%v", val)
+			}
+		}
+		if err := w.Write(record); err != nil {
+			return n, err
+		}
+		n++
+	}
+	if err := rows.Err(); err != nil {
+		return n, err
+	}
+	w.Flush()
+	if err := w.Error(); err != nil {
+		return n, err
+	}
+	if err := gz.Close(); err != nil {
+		return n, err
+	}
+	return n, f.Sync()
+}
+
+// ListArchiveRuns returns the most recent runs.
+func ListArchiveRuns(limit int) ([]ArchiveRun, error) {
+	if config.PgDB == nil {
+		return nil, fmt.Errorf("postgres not connected")
+	}
+	rows, err := config.PgDB.Query(`
+		SELECT id, started_at, finished_at, run_trigger, mode, cutoff, moved_rows,
+			COALESCE(months, ''), COALESCE(error, ''), COALESCE(run_by, ''),
+			COALESCE(chain_last_id, 0), COALESCE(chain_last_hash, '')
+		FROM audit_logs.pwagis_archive_run
+		ORDER BY started_at DESC LIMIT $1
+	`, limit)
+	if err != nil {
+		return nil, fmt.Errorf("query archive runs failed: %v", err)
+	}
+	defer rows.Close()
+
+	list := []ArchiveRun{}
+	for rows.Next() {
+		var r ArchiveRun
+		var finished sql.NullTime
+		if err := rows.Scan(&r.ID, &r.StartedAt, &finished, &r.Trigger, &r.Mode, &r.Cutoff,
+			&r.MovedRows, &r.Months, &r.Error, &r.RunBy, &r.ChainLastID, &r.ChainLastHash); err != nil {
+			return nil, fmt.Errorf("scan archive run failed: %v", err)
+		}
+		if finished.Valid {
+			r.FinishedAt = &finished.Time
+		}
+		list = append(list, r)
+	}
+	return list, rows.Err()
+}
+
+// ─── Scheduler ───────────────────────────────────────────────────────────────
+
+// StartAuditArchiver checks the schedule every minute and runs the archival
+// once per day after run_at.
+func StartAuditArchiver(ctx context.Context) {
+	go func() {
+		ticker := time.NewTicker(time.Minute)
+		defer ticker.Stop()
+		for {
+			select {
+			case <-ticker.C:
+				runScheduledArchive()
+			case <-ctx.Done():
+				return
+			}
+		}
+	}()
+}
+
+func runScheduledArchive() {
+	if config.PgDB == nil {
+		return
+	}
+	s, err := GetArchiveSchedule()
+	if err != nil || !s.Enabled {
+		return
+	}
+	now := time.Now()
+	at, err := time.ParseInLocation("15:04", s.RunAt, time.Local)
+	if err != nil {
+		return
+	}
+	due := time.Date(now.Year(), now.Month(), now.Day(), at.Hour(), at.Minute(), 0, 0, time.Local)
+	if now.Before(due) {
+		return
+	}
+
+	// Already ran (or is running) today on some instance?
+	var ranToday bool
+	if err := config.PgDB.QueryRow(`
+		SELECT EXISTS (SELECT 1 FROM audit_logs.pwagis_archive_run
+			WHERE run_trigger = 'schedule' AND started_at >= $1)
+	`, due).Scan(&ranToday); err != nil || ranToday {
+		return
+	}
+
+	if _, err := RunAuditArchive(s.RetentionDays, s.Mode, "schedule", ""); err != nil && err != ErrArchiveRunning {
+		log.Printf("[AuditArchive] scheduled run failed: %v", err)
+	}
+}
@@ -0,0 +1,403 @@
+// Package services/audit_chain.go
+// Tamper-evident hash chain over export audit rows.
+//
+// Every export_* row of audit_logs.pwagis_track_log carries
+//
+//	prev_hash  = chain_hash of the previous export row ("" for the first)
+//	chain_hash = hex(HMAC-SHA256(AUDIT_CHAIN_KEY, JSON[prev_hash, fields...]))
+//
+// A plain hash could be recomputed by anyone who can edit the table, so the
+// chain requires AUDIT_CHAIN_KEY: without it export rows are written
+// unchained and VerifyAuditChain reports unkeyed. The writer appends rows
+// while holding the single row of audit_logs.pwagis_export_chain FOR UPDATE,
+// so the chain stays linear across instances, and the head row records the
+// last id/hash so deleting the newest rows is detected too.
+//
+// VerifyAuditChain walks the chain in id order and reports the first row
+// whose link or content does not match. Rows moved out by the archival job
+// leave the first live row pointing at an archived hash (the anchor). The
+// anchor must be the last chained row recorded by the archival run
+// (audit_archive.go), whose chain_anchor_mac authenticates the run id, row
+// id and hash so a forged run row cannot hide a deleted prefix; archives
+// from before runs recorded it are searched in the pwagis_track_log_YYYYMM
+// tables. Any other anchor is a break.
+package services
+
+import (
+	"context"
+	"crypto/hmac"
+	"crypto/sha256"
+	"database/sql"
+	"encoding/hex"
+	"encoding/json"
+	"fmt"
+	"os"
+	"strconv"
+	"strings"
+	"time"
+
+	"pwa_gis_tracking/config"
+)
+
+// auditChainTimeLayout is how created_at enters the hash. TIMESTAMP keeps
+// the wall clock at microsecond precision, so values are truncated first.
+const auditChainTimeLayout = "2006-01-02 15:04:05.000000"
+
+// IsChainedAuditAction reports whether rows of this action are chained.
+func IsChainedAuditAction(action string) bool {
+	return strings.HasPrefix(action, "export_")
+}
+
+// AuditChainTime truncates t to what Postgres stores.
+func AuditChainTime(t time.Time) time.Time {
+	return t.Truncate(time.Microsecond)
+}
+
+// AuditChainFields is the hashed content of one audit row.
+type AuditChainFields struct {
+	UserID         string
+	UserName       string
+	PwaCode        string
+	PermLevel      string
+	Action         string
+	TargetType     string
+	TargetValue    string
+	IP             string
+	UserAgent      string
+	RequestPath    string
+	RequestMethod  string
+	ResponseStatus int
+	DurationMs     int
+	AuthMethod     string
+	ImpersonatorID string
+	CreatedAt      time.Time
+}
+
+// AuditChainKeyed reports whether AUDIT_CHAIN_KEY is set. Without it export
+// rows are not chained and the chain cannot be verified.
+func AuditChainKeyed() bool {
+	return os.Getenv("AUDIT_CHAIN_KEY") != ""
+}
+
+// auditChainMAC returns hex(HMAC-SHA256(AUDIT_CHAIN_KEY, payload)).
+func auditChainMAC(payload []byte) string {
+	h := hmac.New(sha256.New, []byte(os.Getenv("AUDIT_CHAIN_KEY")))
+	h.Write(payload)
+	return hex.EncodeToString(h.Sum(nil))
+}
+
+// AuditChainHash returns the chain_hash of a row that follows prev.
+// Callers check AuditChainKeyed first.
+func AuditChainHash(prev string, f AuditChainFields) string {
+	payload, _ := json.Marshal([]string{
+		prev,
+		f.UserID, f.UserName, f.PwaCode, f.PermLevel,
+		f.Action, f.TargetType, f.TargetValue,
+		f.IP, f.UserAgent, f.RequestPath, f.RequestMethod,
+		strconv.Itoa(f.ResponseStatus), strconv.Itoa(f.DurationMs),
+		f.AuthMethod, f.ImpersonatorID,
+		f.CreatedAt.Format(auditChainTimeLayout),
+	})
+	return auditChainMAC(payload)
+}
+
+// AuditChainAnchorMAC authenticates the last chained row recorded by an
+// archival run (pwagis_archive_run.chain_anchor_mac).
+func AuditChainAnchorMAC(runID int, lastID int64, lastHash string) string {
+	payload, _ := json.Marshal([]string{
+		"archive_anchor", strconv.Itoa(runID), strconv.FormatInt(lastID, 10), lastHash,
+	})
+	return auditChainMAC(payload)
+}
+
+// LockAuditChainHead locks the chain head inside tx and returns the last
+// chained id and hash. Callers append rows and then UpdateAuditChainHead.
+func LockAuditChainHead(tx *sql.Tx) (int64, string, error) {
+	var lastID int64
+	var lastHash string
+	err := tx.QueryRow(`
+		SELECT last_id, last_hash FROM audit_logs.pwagis_export_chain WHERE id = 1 FOR UPDATE
+	`).Scan(&lastID, &lastHash)
+	if err == sql.ErrNoRows {
+		// Head row missing (migration seeds it); create it under the tx
+		if _, err := tx.Exec(`INSERT INTO audit_logs.pwagis_export_chain (id, last_id, last_hash) VALUES (1, 0, '')`); err != nil {
+			return 0, "", fmt.Errorf("create audit chain head failed: %v", err)
+		}
+		return 0, "", nil
+	}
+	if err != nil {
+		return 0, "", fmt.Errorf("lock audit chain head failed: %v", err)
+	}
+	return lastID, lastHash, nil
+}
+
+// UpdateAuditChainHead records the newest chained row.
+func UpdateAuditChainHead(tx *sql.Tx, lastID int64, lastHash string) error {
+	if _, err := tx.Exec(`
+		UPDATE audit_logs.pwagis_export_chain SET last_id = $1, last_hash = $2, updated_at = NOW() WHERE id = 1
+	`, lastID, lastHash); err != nil {
+		return fmt.Errorf("update audit chain head failed: %v", err)
+	}
+	return nil
+}
+
+// AuditChainBreak describes the first row that fails verification.
+type AuditChainBreak struct {
+	ID       int64  `json:"id"`
+	Reason   string `json:"reason"`
+	Expected string `json:"expected,omitempty"`
+	Stored   string `json:"stored,omitempty"`
+}
+
+// AuditChainReport is the result of VerifyAuditChain.
+type AuditChainReport struct {
+	OK         bool             `json:"ok"`
+	Checked    int64            `json:"checked"`
+	FirstID    int64            `json:"first_id,omitempty"`
+	LastID     int64            `json:"last_id,omitempty"`
+	Anchor     string           `json:"anchor,omitempty"` // prev_hash of the first live row (archived predecessor)
+	AnchorID   int64            `json:"anchor_id,omitempty"`
+	AnchorFrom string           `json:"anchor_from,omitempty"` // "archive_run" or the archive table
+	HeadID     int64            `json:"head_id"`
+	Unchained  int64            `json:"unchained_before_first"` // export rows older than the chain
+	Unkeyed    bool             `json:"unkeyed,omitempty"`      // AUDIT_CHAIN_KEY not set: nothing verified
+	Break      *AuditChainBreak `json:"break,omitempty"`
+	VerifiedAt time.Time        `json:"verified_at"`
+}
+
+// VerifyAuditChain walks every export row in id order and stops at the
+// first broken link.
+func VerifyAuditChain(ctx context.Context) (AuditChainReport, error) {
+	rep := AuditChainReport{VerifiedAt: time.Now()}
+	if !AuditChainKeyed() {
+		rep.Unkeyed = true
+		return rep, nil
+	}
+	if config.PgDB == nil {
+		return rep, fmt.Errorf("postgres not connected")
+	}
+
+	var headHash string
+	err := config.PgDB.QueryRowContext(ctx, `
+		SELECT last_id, last_hash FROM audit_logs.pwagis_export_chain WHERE id = 1
+	`).Scan(&rep.HeadID, &headHash)
+	if err != nil && err != sql.ErrNoRows {
+		return rep, fmt.Errorf("read audit chain head failed: %v", err)
+	}
+
+	rows, err := config.PgDB.QueryContext(ctx, `
+		SELECT id, COALESCE(user_id, ''), COALESCE(user_name, ''), COALESCE(pwa_code, ''),
+			COALESCE(permission_level, ''), action, COALESCE(target_type, ''), COALESCE(target_value, ''),
+			COALESCE(ip_address, ''), COALESCE(user_agent, ''), COALESCE(request_path, ''),
+			COALESCE(request_method, ''), COALESCE(response_status, 0), COALESCE(duration_ms, 0),
+			COALESCE(auth_method, ''), COALESCE(impersonator_id, ''), created_at,
+			prev_hash, chain_hash
+		FROM audit_logs.pwagis_track_log
+		WHERE action LIKE 'export\_%'
+		ORDER BY id
+	`)
+	if err != nil {
+		return rep, fmt.Errorf("read export audit rows failed: %v", err)
+	}
+	defer rows.Close()
+
+	w := auditChainWalker{rep: &rep}
+	for rows.Next() {
+		var id int64
+		var f AuditChainFields
+		var prevHash, chainHash sql.NullString
+		if err := rows.Scan(&id, &f.UserID, &f.UserName, &f.PwaCode, &f.PermLevel, &f.Action,
+			&f.TargetType, &f.TargetValue, &f.IP, &f.UserAgent, &f.RequestPath, &f.RequestMethod,
+			&f.ResponseStatus, &f.DurationMs, &f.AuthMethod, &f.ImpersonatorID, &f.CreatedAt,
+			&prevHash, &chainHash); err != nil {
+			return rep, fmt.Errorf("scan export audit row failed: %v", err)
+		}
+
+		if w.next(id, f, prevHash, chainHash) && rep.Break == nil {
+			if err := checkAuditChainAnchor(ctx, &rep); err != nil {
+				return rep, err
+			}
+		}
+		if rep.Break != nil {
+			return rep, nil
+		}
+	}
+	if err := rows.Err(); err != nil {
+		return rep, err
+	}
+
+	// The head must point at the last row we saw
+	if rep.Checked == 0 && rep.HeadID > 0 {
+		// Everything archived: the archive must end at the head
+		lastID, lastHash, authentic, err := lastArchivedChainRow(ctx, rep.HeadID+1)
+		if err != nil {
+			return rep, err
+		}
+		if lastID > 0 && !authentic {
+			rep.Break = &AuditChainBreak{ID: rep.HeadID, Reason: "archival run anchor fails chain_anchor_mac (forged archive run)"}
+			return rep, nil
+		}
+		if lastID != rep.HeadID || lastHash != headHash {
+			rep.Break = &AuditChainBreak{ID: rep.HeadID, Reason: "no chained export rows left in the live table and the archive does not end at the chain head (rows deleted)",
+				Expected: headHash, Stored: lastHash}
+			return rep, nil
+		}
+		rep.AnchorID, rep.Anchor, rep.AnchorFrom = lastID, lastHash, "archive_run"
+		rep.OK = true
+		return rep, nil
+	}
+	w.checkHead(headHash)
+	rep.OK = rep.Break == nil
+	return rep, nil
+}
+
+// auditChainWalker checks export rows in id order for VerifyAuditChain.
+type auditChainWalker struct {
+	rep     *AuditChainReport
+	prev    string // chain_hash of the previous row
+	started bool
+}
+
+// next checks one row and sets rep.Break at the first broken link. It
+// returns true for the first chained row, whose prev_hash (rep.Anchor)
+// points into the archive and is checked by the caller.
+func (w *auditChainWalker) next(id int64, f AuditChainFields, prevHash, chainHash sql.NullString) bool {
+	rep := w.rep
+	first := false
+	if !w.started {
+		if !chainHash.Valid {
+			// Written before the chain existed
+			rep.Unchained++
+			return false
+		}
+		w.started, first = true, true
+		rep.FirstID = id
+		w.prev = prevHash.String
+		rep.Anchor = w.prev
+	}
+	rep.Checked++
+	rep.LastID = id
+
+	switch {
+	case !chainHash.Valid:
+		rep.Break = &AuditChainBreak{ID: id, Reason: "missing chain_hash"}
+	case prevHash.String != w.prev:
+		rep.Break = &AuditChainBreak{ID: id, Reason: "prev_hash does not match previous row (row deleted or reordered)",
+			Expected: w.prev, Stored: prevHash.String}
+	default:
+		if want := AuditChainHash(w.prev, f); want != chainHash.String {
+			rep.Break = &AuditChainBreak{ID: id, Reason: "content does not match chain_hash (row modified)",
+				Expected: want, Stored: chainHash.String}
+		}
+	}
+	w.prev = chainHash.String
+	return first
+}
+
+// checkHead sets rep.Break unless the chain head points at the last row.
+func (w *auditChainWalker) checkHead(headHash string) {
+	if w.rep.HeadID != w.rep.LastID || headHash != w.prev {
+		w.rep.Break = &AuditChainBreak{ID: w.rep.HeadID, Reason: "chain head does not match the last export row (newest rows deleted)",
+			Expected: headHash, Stored: w.prev}
+	}
+}
+
+// checkAuditChainAnchor checks the prev_hash of the first live chained row
+// (rep.Anchor) against the archived rows and sets rep.Break on a mismatch.
+func checkAuditChainAnchor(ctx context.Context, rep *AuditChainReport) error {
+	lastID, lastHash, authentic, err := lastArchivedChainRow(ctx, rep.FirstID)
+	if err != nil {
+		return err
+	}
+	if lastID > 0 {
+		if !authentic {
+			rep.Break = &AuditChainBreak{ID: rep.FirstID, Reason: "archival run anchor fails chain_anchor_mac (forged archive run)",
+				Stored: lastHash}
+			return nil
+		}
+		if rep.Anchor != lastHash {
+			rep.Break = &AuditChainBreak{ID: rep.FirstID, Reason: "prev_hash does not match the last archived export row (rows deleted)",
+				Expected: lastHash, Stored: rep.Anchor}
+			return nil
+		}
+		rep.AnchorID, rep.AnchorFrom = lastID, "archive_run"
+		return nil
+	}
+	if rep.Anchor == "" {
+		return nil // the chain starts here
+	}
+
+	id, table, err := findArchivedChainHash(ctx, rep.Anchor)
+	if err != nil {
+		return err
+	}
+	if id == 0 {
+		rep.Break = &AuditChainBreak{ID: rep.FirstID, Reason: "prev_hash points at a row that is not live, not recorded by an archival run and not in an archive table (rows deleted)",
+			Stored: rep.Anchor}
+		return nil
+	}
+	rep.AnchorID, rep.AnchorFrom = id, table
+	return nil
+}
+
+// lastArchivedChainRow returns the newest chained row recorded by an
+// archival run below beforeID (0, "" when none) and whether the run's
+// chain_anchor_mac matches it.
+func lastArchivedChainRow(ctx context.Context, beforeID int64) (int64, string, bool, error) {
+	var runID int
+	var id int64
+	var h, mac string
+	err := config.PgDB.QueryRowContext(ctx, `
+		SELECT id, chain_last_id, chain_last_hash, COALESCE(chain_anchor_mac, '')
+		FROM audit_logs.pwagis_archive_run
+		WHERE chain_last_id IS NOT NULL AND chain_last_id < $1
+		ORDER BY chain_last_id DESC LIMIT 1
+	`, beforeID).Scan(&runID, &id, &h, &mac)
+	if err == sql.ErrNoRows {
+		return 0, "", false, nil
+	}
+	if err != nil {
+		return 0, "", false, fmt.Errorf("read archived chain anchor failed: %v", err)
+	}
+	authentic := hmac.Equal([]byte(mac), []byte(AuditChainAnchorMAC(runID, id, h)))
+	return id, h, authentic, nil
+}
+
+// findArchivedChainHash looks for a chain_hash in the monthly archive tables
+// ("table" mode). Returns the row id and table, or 0 when not found.
+func findArchivedChainHash(ctx context.Context, chainHash string) (int64, string, error) {
+	rows, err := config.PgDB.QueryContext(ctx, `
+		SELECT t.table_name FROM information_schema.tables t
+		JOIN information_schema.columns c
+			ON c.table_schema = t.table_schema AND c.table_name = t.table_name AND c.column_name = 'chain_hash'
+		WHERE t.table_schema = 'audit_logs' AND t.table_name ~ '^pwagis_track_log_[0-9]{6}$'
+		ORDER BY t.table_name DESC
+	`)
+	if err != nil {
+		return 0, "", fmt.Errorf("list archive tables failed: %v", err)
+	}
+	var tables []string
+	for rows.Next() {
+		var name string
+		if err := rows.Scan(&name); err != nil {
+			rows.Close()
+			return 0, "", err
+		}
+		tables = append(tables, name)
+	}
+	rows.Close()
+
+	for _, name := range tables {
+		var id int64
+		err := config.PgDB.QueryRowContext(ctx, `SELECT id FROM audit_logs.`+name+`
+			WHERE chain_hash = $1 ORDER BY id DESC LIMIT 1`, chainHash).Scan(&id)
+		if err == sql.ErrNoRows {
+			continue
+		}
+		if err != nil {
+			return 0, "", fmt.Errorf("search %s failed: %v", name, err)
+		}
+		return id, "audit_logs." + name, nil
+	}
+	return 0, "", nil
+}
@@ -0,0 +1,238 @@
+package services
+
+import (
+	"database/sql"
+	"strings"
+	"testing"
+	"time"
+)
+
+func TestAuditChainHash(t *testing.T) {
+	t.Setenv("AUDIT_CHAIN_KEY", "secret")
+	at := time.Date(2024, time.March, 1, 9, 30, 0, 123456789, time.UTC)
+	base := AuditChainFields{UserID: "1234567", Action: "export_geojson", TargetValue: "5531011:pipe", ResponseStatus: 200, CreatedAt: at}
+
+	h := AuditChainHash("", base)
+	if len(h) != 64 {
+		t.Fatalf("hash length = %d, want 64 hex chars", len(h))
+	}
+	if again := AuditChainHash("", base); again != h {
+		t.Errorf("hash is not deterministic: %s != %s", again, h)
+	}
+
+	// Postgres keeps microseconds: a reloaded row must hash the same
+	reloaded := base
+	reloaded.CreatedAt = AuditChainTime(at)
+	if got := AuditChainHash("", reloaded); got != h {
+		t.Errorf("hash changes after microsecond truncation: %s != %s", got, h)
+	}
+
+	tests := []struct {
+		name   string
+		prev   string
+		modify func(*AuditChainFields)
+	}{
+		{"prev", "abc", func(*AuditChainFields) {}},
+		{"target_value", "", func(f *AuditChainFields) { f.TargetValue = "5531011:meter" }},
+		{"status", "", func(f *AuditChainFields) { f.ResponseStatus = 500 }},
+		{"created_at", "", func(f *AuditChainFields) { f.CreatedAt = f.CreatedAt.Add(time.Microsecond) }},
+		// Field boundaries are part of the hash: moving text between
+		// fields must not collide
+		{"field shift", "", func(f *AuditChainFields) { f.UserID, f.UserName = "123456", "7" }},
+	}
+	for _, tt := range tests {
+		f := base
+		tt.modify(&f)
+		if got := AuditChainHash(tt.prev, f); got == h {
+			t.Errorf("%s: hash unchanged after modification", tt.name)
+		}
+	}
+
+	t.Setenv("AUDIT_CHAIN_KEY", "other")
+	if got := AuditChainHash("", base); got == h {
+		t.Error("AUDIT_CHAIN_KEY does not change the hash")
+	}
+}
+
+func TestAuditChainAnchorMAC(t *testing.T) {
+	t.Setenv("AUDIT_CHAIN_KEY", "secret")
+	mac := AuditChainAnchorMAC(7, 1200, "feedface")
+	if again := AuditChainAnchorMAC(7, 1200, "feedface"); again != mac {
+		t.Errorf("anchor MAC is not deterministic: %s != %s", again, mac)
+	}
+	// A run row copied or edited by hand must not keep a valid MAC
+	for _, other := range []string{
+		AuditChainAnchorMAC(8, 1200, "feedface"),
+		AuditChainAnchorMAC(7, 1201, "feedface"),
+		AuditChainAnchorMAC(7, 1200, "deadbeef"),
+	} {
+		if other == mac {
+			t.Error("anchor MAC unchanged after modifying the run row")
+		}
+	}
+}
+
+// chainRow is one row fed to auditChainWalker.
+type chainRow struct {
+	id        int64
+	f         AuditChainFields
+	prev      sql.NullString
+	chainHash sql.NullString
+}
+
+// buildChain returns n correctly linked rows with ids 1..n after anchor.
+func buildChain(n int, anchor string) []chainRow {
+	rows := make([]chainRow, n)
+	prev := anchor
+	for i := range rows {
+		f := AuditChainFields{
+			UserID: "1234567", Action: "export_csv_query", TargetValue: "row" + string(rune('a'+i)),
+			CreatedAt: time.Date(2024, time.March, 1, 9, i, 0, 0, time.UTC),
+		}
+		h := AuditChainHash(prev, f)
+		rows[i] = chainRow{id: int64(i + 1), f: f, prev: sql.NullString{String: prev, Valid: true}, chainHash: sql.NullString{String: h, Valid: true}}
+		prev = h
+	}
+	return rows
+}
+
+func TestAuditChainWalker(t *testing.T) {
+	t.Setenv("AUDIT_CHAIN_KEY", "secret")
+
+	tests := []struct {
+		name       string
+		rows       func() []chainRow
+		dropNewest bool   // delete the newest row but keep the head pointing at it
+		wantBreak  int64  // 0 = chain intact
+		wantReason string // substring of the break reason
+		wantAnchor string
+		wantFirst  int64
+		unchained  int64
+	}{
+		{
+			name: "intact",
+			rows: func() []chainRow { return buildChain(4, "") },
+		},
+		{
+			name:       "archived predecessor",
+			rows:       func() []chainRow { return buildChain(3, "feedface") },
+			wantAnchor: "feedface",
+		},
+		{
+			name: "unchained rows before the chain",
+			rows: func() []chainRow {
+				old := chainRow{id: 1, f: AuditChainFields{Action: "export_csv"}}
+				rows := buildChain(3, "")
+				for i := range rows {
+					rows[i].id += 1
+				}
+				return append([]chainRow{old}, rows...)
+			},
+			wantFirst: 2,
+			unchained: 1,
+		},
+		{
+			name: "row modified",
+			rows: func() []chainRow {
+				rows := buildChain(4, "")
+				rows[2].f.TargetValue = "tampered"
+				return rows
+			},
+			wantBreak:  3,
+			wantReason: "row modified",
+		},
+		{
+			name: "row deleted",
+			rows: func() []chainRow {
+				rows := buildChain(4, "")
+				return append(rows[:1], rows[2:]...)
+			},
+			wantBreak:  3,
+			wantReason: "row deleted or reordered",
+		},
+		{
+			name: "rows reordered",
+			rows: func() []chainRow {
+				rows := buildChain(4, "")
+				rows[1], rows[2] = rows[2], rows[1]
+				return rows
+			},
+			wantBreak:  3,
+			wantReason: "row deleted or reordered",
+		},
+		{
+			name: "chain_hash cleared",
+			rows: func() []chainRow {
+				rows := buildChain(4, "")
+				rows[1].chainHash = sql.NullString{}
+				return rows
+			},
+			wantBreak:  2,
+			wantReason: "missing chain_hash",
+		},
+		{
+			name:       "newest row deleted",
+			rows:       func() []chainRow { return buildChain(4, "") },
+			dropNewest: true,
+			wantBreak:  4,
+			wantReason: "newest rows deleted",
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			rows := tt.rows()
+			last := rows[len(rows)-1]
+			headID, headHash := last.id, last.chainHash.String
+			if tt.dropNewest {
+				rows = rows[:len(rows)-1]
+			}
+
+			rep := AuditChainReport{HeadID: headID}
+			w := auditChainWalker{rep: &rep}
+			firstSeen := 0
+			for _, r := range rows {
+				if w.next(r.id, r.f, r.prev, r.chainHash) {
+					firstSeen++
+				}
+				if rep.Break != nil {
+					break
+				}
+			}
+			if rep.Break == nil {
+				w.checkHead(headHash)
+			}
+
+			if tt.wantBreak == 0 {
+				if rep.Break != nil {
+					t.Fatalf("unexpected break at %d: %s", rep.Break.ID, rep.Break.Reason)
+				}
+			} else {
+				if rep.Break == nil {
+					t.Fatalf("no break, want one at id %d", tt.wantBreak)
+				}
+				if rep.Break.ID != tt.wantBreak || !strings.Contains(rep.Break.Reason, tt.wantReason) {
+					t.Errorf("break at %d (%s), want %d (%s)", rep.Break.ID, rep.Break.Reason, tt.wantBreak, tt.wantReason)
+				}
+				return
+			}
+
+			if firstSeen != 1 {
+				t.Errorf("first chained row reported %d times, want 1", firstSeen)
+			}
+			if rep.Anchor != tt.wantAnchor {
+				t.Errorf("anchor = %q, want %q", rep.Anchor, tt.wantAnchor)
+			}
+			wantFirst := tt.wantFirst
+			if wantFirst == 0 {
+				wantFirst = 1
+			}
+			if rep.FirstID != wantFirst || rep.Unchained != tt.unchained {
+				t.Errorf("first_id = %d, unchained = %d, want %d, %d", rep.FirstID, rep.Unchained, wantFirst, tt.unchained)
+			}
+			if rep.Checked != int64(len(rows))-tt.unchained {
+				t.Errorf("checked = %d, want %d", rep.Checked, int64(len(rows))-tt.unchained)
+			}
+		})
+	}
+}
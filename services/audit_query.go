@@ -0,0 +1,267 @@
+// Package services/audit_query.go
+// Read side of audit_logs.pwagis_track_log for the admin audit endpoints.
+//
+// AuditLogFilter is shared by the row search and the aggregate reports so
+// every report can be narrowed the same way (user, action, branch, target,
+// status, date range).
+package services
+
+import (
+	"fmt"
+	"strconv"
+	"strings"
+	"time"
+
+	"pwa_gis_tracking/config"
+)
+
+// MaxAuditExportRows caps CSV/Excel downloads of the audit log.
+const MaxAuditExportRows = 50000
+
+// AuditLogFilter narrows audit rows. Empty fields are ignored.
+type AuditLogFilter struct {
+	UserID     string    // exact employee ID (matches user_id or impersonator_id)
+	Actions    []string  // exact actions; a trailing "*" is a prefix ("export_*")
+	PwaCode    string    // user's branch, or the branch in target_value
+	TargetType string    // exact target_type
+	Target     string    // substring of target_value
+	Status     string    // "200", "4xx", "5xx" or "failed" (>= 400)
+	From       time.Time // created_at >= From
+	To         time.Time // created_at <  To
+}
+
+// AuditLogRow is one row of audit_logs.pwagis_track_log.
+type AuditLogRow struct {
+	ID              int       `json:"id"`
+	UserID          string    `json:"user_id"`
+	UserName        string    `json:"user_name"`
+	PwaCode         string    `json:"pwa_code"`
+	PermissionLevel string    `json:"permission_level"`
+	Action          string    `json:"action"`
+	TargetType      string    `json:"target_type"`
+	TargetValue     string    `json:"target_value"`
+	IP              string    `json:"ip_address"`
+	UserAgent       string    `json:"user_agent"`
+	RequestPath     string    `json:"request_path"`
+	RequestMethod   string    `json:"request_method"`
+	ResponseStatus  int       `json:"response_status"`
+	DurationMs      int       `json:"duration_ms"`
+	AuthMethod      string    `json:"auth_method"`
+	ImpersonatorID  string    `json:"impersonator_id"`
+	CreatedAt       time.Time `json:"created_at"`
+}
+
+// where builds the WHERE clause and its arguments.
+func (f AuditLogFilter) where() (string, []interface{}) {
+	var conds []string
+	var args []interface{}
+	arg := func(v interface{}) string {
+		args = append(args, v)
+		return "$" + strconv.Itoa(len(args))
+	}
+
+	if f.UserID != "" {
+		p := arg(f.UserID)
+		conds = append(conds, "(user_id = "+p+" OR impersonator_id = "+p+")")
+	}
+	if len(f.Actions) > 0 {
+		var or []string
+		for _, a := range f.Actions {
+			if strings.HasSuffix(a, "*") {
+				or = append(or, "action LIKE "+arg(strings.TrimSuffix(a, "*")+"%"))
+			} else {
+				or = append(or, "action = "+arg(a))
+			}
+		}
+		conds = append(conds, "("+strings.Join(or, " OR ")+")")
+	}
+	if f.PwaCode != "" {
+		conds = append(conds, "(pwa_code = "+arg(f.PwaCode)+
+			" OR (target_type IN ('branch','layer') AND split_part(target_value, ':', 1) = "+arg(f.PwaCode)+"))")
+	}
+	if f.TargetType != "" {
+		conds = append(conds, "target_type = "+arg(f.TargetType))
+	}
+	if f.Target != "" {
+		conds = append(conds, "target_value ILIKE "+arg("%"+escapeLike(f.Target)+"%"))
+	}
+	switch f.Status {
+	case "":
+	case "failed":
+		conds = append(conds, "response_status >= 400")
+	case "4xx":
+		conds = append(conds, "response_status BETWEEN 400 AND 499")
+	case "5xx":
+		conds = append(conds, "response_status >= 500")
+	default:
+		if n, err := strconv.Atoi(f.Status); err == nil {
+			conds = append(conds, "response_status = "+arg(n))
+		}
+	}
+	if !f.From.IsZero() {
+		conds = append(conds, "created_at >= "+arg(f.From))
+	}
+	if !f.To.IsZero() {
+		conds = append(conds, "created_at < "+arg(f.To))
+	}
+
+	if len(conds) == 0 {
+		return "", nil
+	}
+	return " WHERE " + strings.Join(conds, " AND "), args
+}
+
+// escapeLike escapes the LIKE wildcards in user input.
+func escapeLike(s string) string {
+	return strings.NewReplacer(`\`, `\\`, `%`, `\%`, `_`, `\_`).Replace(s)
+}
+
+// ValidateAuditStatus checks the Status filter value.
+func ValidateAuditStatus(s string) error {
+	switch s {
+	case "", "failed", "4xx", "5xx":
+		return nil
+	}
+	if n, err := strconv.Atoi(s); err != nil || n < 100 || n > 599 {
+		return fmt.Errorf("invalid status: %s (use a code, 4xx, 5xx or failed)", s)
+	}
+	return nil
+}
+
+const auditLogColumns = `
+	id, COALESCE(user_id, ''), COALESCE(user_name, ''), COALESCE(pwa_code, ''),
+	COALESCE(permission_level, ''), action, COALESCE(target_type, ''),
+	COALESCE(target_value, ''), COALESCE(ip_address, ''), COALESCE(user_agent, ''),
+	COALESCE(request_path, ''), COALESCE(request_method, ''),
+	COALESCE(response_status, 0), COALESCE(duration_ms, 0),
+	COALESCE(auth_method, ''), COALESCE(impersonator_id, ''), created_at`
+
+// SearchAuditLogs returns one page of rows (newest first) and the total
+// number of matching rows. pageSize 0 returns up to MaxAuditExportRows.
+func SearchAuditLogs(f AuditLogFilter, page, pageSize int) ([]AuditLogRow, int64, error) {
+	if config.PgDB == nil {
+		return nil, 0, fmt.Errorf("postgres not connected")
+	}
+	where, args := f.where()
+
+	var total int64
+	if err := config.PgDB.QueryRow(`SELECT COUNT(*) FROM audit_logs.pwagis_track_log`+where, args...).Scan(&total); err != nil {
+		return nil, 0, fmt.Errorf("count audit logs failed: %v", err)
+	}
+
+	limit, offset := MaxAuditExportRows, 0
+	if pageSize > 0 {
+		limit, offset = pageSize, (page-1)*pageSize
+	}
+	query := fmt.Sprintf(`SELECT %s FROM audit_logs.pwagis_track_log%s
+		ORDER BY created_at DESC, id DESC LIMIT %d OFFSET %d`, auditLogColumns, where, limit, offset)
+	rows, err := config.PgDB.Query(query, args...)
+	if err != nil {
+		return nil, 0, fmt.Errorf("query audit logs failed: %v", err)
+	}
+	defer rows.Close()
+
+	list := []AuditLogRow{}
+	for rows.Next() {
+		var r AuditLogRow
+		if err := rows.Scan(&r.ID, &r.UserID, &r.UserName, &r.PwaCode, &r.PermissionLevel,
+			&r.Action, &r.TargetType, &r.TargetValue, &r.IP, &r.UserAgent,
+			&r.RequestPath, &r.RequestMethod, &r.ResponseStatus, &r.DurationMs,
+			&r.AuthMethod, &r.ImpersonatorID, &r.CreatedAt); err != nil {
+			return nil, 0, fmt.Errorf("scan audit log failed: %v", err)
+		}
+		list = append(list, r)
+	}
+	return list, total, rows.Err()
+}
+
+// ─── Aggregate reports ───────────────────────────────────────────────────────
+
+// Audit report kinds.
+const (
+	AuditReportExportsPerUser = "exports_per_user"
+	AuditReportTopBranches    = "top_branches"
+	AuditReportFailedRequests = "failed_requests"
+)
+
+// AuditReportKinds lists the reports in display order.
+var AuditReportKinds = []string{AuditReportExportsPerUser, AuditReportTopBranches, AuditReportFailedRequests}
+
+// AuditReportRow is one group of an aggregate report.
+//
+//	                  key             label      detail         distinct
+//	exports_per_user  user_id         user_name  formats        targets exported
+//	top_branches      pwa_code        -          -              users
+//	failed_requests   method + path   action     status codes   users
+type AuditReportRow struct {
+	Key      string    `json:"key"`
+	Label    string    `json:"label"`
+	Detail   string    `json:"detail"`
+	Count    int64     `json:"count"`
+	Distinct int64     `json:"distinct"`
+	LastAt   time.Time `json:"last_at"`
+}
+
+// auditReportQueries hold the SELECT for each report. %s is the WHERE
+// clause of the filter; each report adds its own condition after it.
+var auditReportQueries = map[string]string{
+	AuditReportExportsPerUser: `
+		SELECT COALESCE(user_id, ''), COALESCE(MAX(user_name), ''),
+			string_agg(DISTINCT substring(action from 8), ','),
+			COUNT(*), COUNT(DISTINCT target_value), MAX(created_at)
+		FROM audit_logs.pwagis_track_log%s action LIKE 'export\_%%'
+		GROUP BY user_id
+		ORDER BY COUNT(*) DESC`,
+	AuditReportTopBranches: `
+		SELECT split_part(target_value, ':', 1), '', '',
+			COUNT(*), COUNT(DISTINCT user_id), MAX(created_at)
+		FROM audit_logs.pwagis_track_log%s target_type IN ('branch','layer')
+			AND action IN ('view_detail','view_map','view_feature_props','click_layer_modal')
+			AND COALESCE(target_value, '') <> ''
+		GROUP BY split_part(target_value, ':', 1)
+		ORDER BY COUNT(*) DESC`,
+	AuditReportFailedRequests: `
+		SELECT COALESCE(request_method, '') || ' ' || COALESCE(request_path, ''),
+			COALESCE(MAX(action), ''),
+			string_agg(DISTINCT response_status::text, ','),
+			COUNT(*), COUNT(DISTINCT user_id), MAX(created_at)
+		FROM audit_logs.pwagis_track_log%s response_status >= 400
+		GROUP BY request_method, request_path
+		ORDER BY COUNT(*) DESC`,
+}
+
+// AuditReport runs one aggregate report over the filtered rows.
+func AuditReport(kind string, f AuditLogFilter, limit int) ([]AuditReportRow, error) {
+	if config.PgDB == nil {
+		return nil, fmt.Errorf("postgres not connected")
+	}
+	tmpl, ok := auditReportQueries[kind]
+	if !ok {
+		return nil, fmt.Errorf("unknown report: %s (valid: %s)", kind, strings.Join(AuditReportKinds, ", "))
+	}
+	where, args := f.where()
+	if where == "" {
+		where = " WHERE"
+	} else {
+		where += " AND"
+	}
+	if limit <= 0 || limit > MaxAuditExportRows {
+		limit = MaxAuditExportRows
+	}
+
+	rows, err := config.PgDB.Query(fmt.Sprintf(tmpl, where)+fmt.Sprintf(" LIMIT %d", limit), args...)
+	if err != nil {
+		return nil, fmt.Errorf("audit report %s failed: %v", kind, err)
+	}
+	defer rows.Close()
+
+	list := []AuditReportRow{}
+	for rows.Next() {
+		var r AuditReportRow
+		if err := rows.Scan(&r.Key, &r.Label, &r.Detail, &r.Count, &r.Distinct, &r.LastAt); err != nil {
+			return nil, fmt.Errorf("scan audit report failed: %v", err)
+		}
+		list = append(list, r)
+	}
+	return list, rows.Err()
+}
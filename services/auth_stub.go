@@ -0,0 +1,73 @@
+// Package services/auth_stub.go
+// File- or config-backed Authenticator for local development and tests.
+// Never enable in production: passwords are compared in plain text.
+//
+// AUTH_STUB_FILE format:
+//
+//	{
+//	  "14180": {
+//	    "password": "secret",
+//	    "user": { "user": "14180", "Myname": "สมชาย", "MySurname": "ใจดี",
+//	              "dep_name": "สำนักควบคุมน้ำสูญเสีย", "area": "3", "ba": "1020" }
+//	  }
+//	}
+package services
+
+import (
+	"context"
+	"crypto/subtle"
+	"encoding/json"
+	"fmt"
+	"os"
+)
+
+// StubUser is one local account of the stub provider.
+type StubUser struct {
+	Password string       `json:"password"`
+	User     IntranetUser `json:"user"`
+}
+
+// StubAuthenticator answers logins from an in-memory user table.
+type StubAuthenticator struct {
+	users map[string]StubUser // key = username (employee number)
+}
+
+// NewStubAuthenticator builds a stub from the given username → account map.
+func NewStubAuthenticator(users map[string]StubUser) *StubAuthenticator {
+	return &StubAuthenticator{users: users}
+}
+
+// LoadStubAuthenticatorFile reads a stub user table from a JSON file.
+func LoadStubAuthenticatorFile(path string) (*StubAuthenticator, error) {
+	if path == "" {
+		return nil, fmt.Errorf("AUTH_PROVIDER=stub requires AUTH_STUB_FILE")
+	}
+	raw, err := os.ReadFile(path)
+	if err != nil {
+		return nil, fmt.Errorf("read stub users: %w", err)
+	}
+	users := map[string]StubUser{}
+	if err := json.Unmarshal(raw, &users); err != nil {
+		return nil, fmt.Errorf("parse stub users: %w", err)
+	}
+	return NewStubAuthenticator(users), nil
+}
+
+// Name implements Authenticator.
+func (s *StubAuthenticator) Name() string { return "stub" }
+
+// Authenticate implements Authenticator. Unknown users and wrong passwords
+// return Check="F", exactly like the intranet API.
+func (s *StubAuthenticator) Authenticate(_ context.Context, username, password string) (*IntranetUser, error) {
+	account, ok := s.users[username]
+	if !ok || subtle.ConstantTimeCompare([]byte(account.Password), []byte(password)) != 1 {
+		return &IntranetUser{Check: "F"}, nil
+	}
+
+	user := account.User
+	user.Check = "P"
+	if user.User == "" {
+		user.User = username
+	}
+	return &user, nil
+}
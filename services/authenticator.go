@@ -0,0 +1,212 @@
+// Package services/authenticator.go
+// Pluggable login back-ends for HandleLogin.
+//
+// AUTH_PROVIDER selects the implementation:
+//   "intranet" (default) – native net/http client, falls back to curl on
+//                          transport errors unless INTRANET_CURL_FALLBACK=false
+//                          (never on certificate verification errors)
+//   "curl"               – curl only
+//   "stub"               – local users from AUTH_STUB_FILE (dev / tests)
+//
+// Every provider returns the same IntranetUser mapping, so the RBAC and
+// session code in HandleLogin does not care which one is active.
+package services
+
+import (
+	"context"
+	"crypto/tls"
+	"crypto/x509"
+	"errors"
+	"fmt"
+	"io"
+	"log"
+	"net"
+	"os"
+	"strings"
+	"sync"
+	"syscall"
+	"time"
+)
+
+// Authenticator verifies a username/password pair.
+//
+// A nil error with user.Check != "P" means "wrong credentials";
+// a non-nil error means the back-end itself could not be reached.
+type Authenticator interface {
+	Authenticate(ctx context.Context, username, password string) (*IntranetUser, error)
+	Name() string
+}
+
+// fallbackAuthenticator tries each back-end in order until one answers
+// without a transport error. A certificate that fails verification is not
+// a transport problem: it ends the chain instead of being retried elsewhere.
+// Neither is an answer the intranet did give (non-2xx status, undecodable
+// body): another client would only get the same answer.
+type fallbackAuthenticator struct {
+	chain []Authenticator
+}
+
+// Name implements Authenticator.
+func (f *fallbackAuthenticator) Name() string {
+	names := make([]string, len(f.chain))
+	for i, a := range f.chain {
+		names[i] = a.Name()
+	}
+	return strings.Join(names, "+")
+}
+
+// Authenticate implements Authenticator.
+func (f *fallbackAuthenticator) Authenticate(ctx context.Context, username, password string) (*IntranetUser, error) {
+	var lastErr error
+	for _, a := range f.chain {
+		user, err := a.Authenticate(ctx, username, password)
+		if err == nil {
+			return user, nil
+		}
+		if isCertificateError(err) || !isTransportError(err) {
+			return nil, err
+		}
+		log.Printf("[Auth] %s failed, trying next provider: %v", a.Name(), err)
+		lastErr = err
+	}
+	return nil, lastErr
+}
+
+// isCertificateError reports whether err is a TLS certificate verification
+// failure (unknown CA, wrong host name, expired certificate).
+func isCertificateError(err error) bool {
+	var verifyErr *tls.CertificateVerificationError
+	var authorityErr x509.UnknownAuthorityError
+	var hostErr x509.HostnameError
+	var invalidErr x509.CertificateInvalidError
+	return errors.As(err, &verifyErr) || errors.As(err, &authorityErr) ||
+		errors.As(err, &hostErr) || errors.As(err, &invalidErr)
+}
+
+// isTransportError reports whether err means the back-end could not be
+// reached or the connection broke: dial and TLS handshake failures,
+// timeouts, resets and truncated responses.
+func isTransportError(err error) bool {
+	var netErr net.Error // includes *url.Error from http.Client.Do
+	return errors.As(err, &netErr) || errors.Is(err, io.EOF) ||
+		errors.Is(err, io.ErrUnexpectedEOF) || errors.Is(err, syscall.ECONNRESET)
+}
+
+var (
+	activeAuth   Authenticator
+	activeAuthMu sync.RWMutex
+)
+
+// GetAuthenticator returns the configured Authenticator.
+// Falls back to the default intranet client if InitAuthenticator was never called.
+func GetAuthenticator() Authenticator {
+	activeAuthMu.RLock()
+	a := activeAuth
+	activeAuthMu.RUnlock()
+	if a != nil {
+		return a
+	}
+	a, err := NewAuthenticatorFromEnv()
+	if err != nil {
+		log.Printf("[Auth] %v — using curl", err)
+		a = &CurlAuthenticator{BaseURL: defaultIntranetURL}
+	}
+	SetAuthenticator(a)
+	return a
+}
+
+// SetAuthenticator replaces the active Authenticator (used by tests and main.go).
+func SetAuthenticator(a Authenticator) {
+	activeAuthMu.Lock()
+	activeAuth = a
+	activeAuthMu.Unlock()
+}
+
+// InitAuthenticator configures the active Authenticator from env vars.
+// Call once from main.go; exits on invalid configuration.
+func InitAuthenticator() {
+	a, err := NewAuthenticatorFromEnv()
+	if err != nil {
+		log.Fatalf("authenticator config error: %v", err)
+	}
+	SetAuthenticator(a)
+	log.Printf("Authenticator: %s", a.Name())
+}
+
+// NewAuthenticatorFromEnv builds the Authenticator described by AUTH_PROVIDER
+// and the INTRANET_* / AUTH_STUB_* variables.
+func NewAuthenticatorFromEnv() (Authenticator, error) {
+	baseURL := envOr("INTRANET_URL", defaultIntranetURL)
+	curl := &CurlAuthenticator{
+		BaseURL:  baseURL,
+		CurlPath: os.Getenv("INTRANET_CURL_PATH"),
+		Insecure: os.Getenv("INTRANET_TLS_INSECURE") == "true",
+		CAFile:   os.Getenv("INTRANET_TLS_CA_FILE"),
+	}
+
+	switch provider := strings.ToLower(envOr("AUTH_PROVIDER", "intranet")); provider {
+	case "stub":
+		return LoadStubAuthenticatorFile(os.Getenv("AUTH_STUB_FILE"))
+
+	case "curl":
+		return curl, nil
+
+	case "intranet":
+		minV, err := parseTLSVersion(os.Getenv("INTRANET_TLS_MIN_VERSION"))
+		if err != nil {
+			return nil, err
+		}
+		maxV, err := parseTLSVersion(os.Getenv("INTRANET_TLS_MAX_VERSION"))
+		if err != nil {
+			return nil, err
+		}
+		timeout := 15 * time.Second
+		if s := os.Getenv("INTRANET_TIMEOUT"); s != "" {
+			if timeout, err = time.ParseDuration(s); err != nil {
+				return nil, fmt.Errorf("INTRANET_TIMEOUT: %w", err)
+			}
+		}
+
+		native, err := NewIntranetHTTPAuthenticator(baseURL, IntranetTLSConfig{
+			InsecureSkipVerify: os.Getenv("INTRANET_TLS_INSECURE") == "true",
+			CAFile:             os.Getenv("INTRANET_TLS_CA_FILE"),
+			MinVersion:         minV,
+			MaxVersion:         maxV,
+			Renegotiation:      os.Getenv("INTRANET_TLS_RENEGOTIATE") == "true",
+		}, timeout)
+		if err != nil {
+			return nil, err
+		}
+		if os.Getenv("INTRANET_CURL_FALLBACK") != "false" {
+			return &fallbackAuthenticator{chain: []Authenticator{native, curl}}, nil
+		}
+		return native, nil
+
+	default:
+		return nil, fmt.Errorf("unknown AUTH_PROVIDER: %s", provider)
+	}
+}
+
+// parseTLSVersion maps "1.0".."1.3" to the crypto/tls constants ("" = library default).
+func parseTLSVersion(s string) (uint16, error) {
+	switch strings.TrimSpace(s) {
+	case "":
+		return 0, nil
+	case "1.0":
+		return tls.VersionTLS10, nil
+	case "1.1":
+		return tls.VersionTLS11, nil
+	case "1.2":
+		return tls.VersionTLS12, nil
+	case "1.3":
+		return tls.VersionTLS13, nil
+	}
+	return 0, fmt.Errorf("invalid TLS version: %s", s)
+}
+
+func envOr(key, fallback string) string {
+	if v := os.Getenv(key); v != "" {
+		return v
+	}
+	return fallback
+}
@@ -0,0 +1,252 @@
+// Package services/capabilities.go
+// Per-layer, per-action capabilities on top of the permission level.
+//
+// The permission level (all/reg/branch) decides WHICH branches a user sees;
+// capabilities decide WHAT they may do with each layer there:
+//
+//	view_map         draw features on the map           (/features/map)
+//	view_attributes  read feature properties / queries  (/features/properties, list, suggest, facets, advanced-query)
+//	export_vector    download GIS files                 (/export/geodata, advanced-query export except csv)
+//	export_excel     download tabular files             (/export/excel, advanced-query export csv)
+//	use_chatbot      ask the chatbot                     (/chatbot/query)
+//
+// Grants live in rbac.pwagis_capability (see sql/create_capabilities.sql).
+// permission_level, layer and capability accept "*" as a wildcard. A
+// capability is allowed when at least one "allow" grant matches and no
+// "deny" grant matches. The seed row (*, *, *, allow) keeps today's
+// behaviour; restrictions are added as deny rows, e.g.
+// (branch, *, export_vector, deny).
+package services
+
+import (
+	"database/sql"
+	"fmt"
+	"log"
+	"strings"
+	"sync"
+	"time"
+
+	"pwa_gis_tracking/config"
+)
+
+// Capabilities.
+const (
+	CapViewMap        = "view_map"
+	CapViewAttributes = "view_attributes"
+	CapExportVector   = "export_vector"
+	CapExportExcel    = "export_excel"
+	CapUseChatbot     = "use_chatbot"
+)
+
+// AllCapabilities lists every capability in display order.
+var AllCapabilities = []string{CapViewMap, CapViewAttributes, CapExportVector, CapExportExcel, CapUseChatbot}
+
+// Grant effects.
+const (
+	EffectAllow = "allow"
+	EffectDeny  = "deny"
+)
+
+// capWildcard matches any level, layer or capability.
+const capWildcard = "*"
+
+// CapabilityGrant is one row of rbac.pwagis_capability.
+type CapabilityGrant struct {
+	ID              int       `json:"id"`
+	PermissionLevel string    `json:"permission_level"`
+	Layer           string    `json:"layer"`
+	Capability      string    `json:"capability"`
+	Effect          string    `json:"effect"`
+	Note            string    `json:"note"`
+	CreatedBy       string    `json:"created_by"`
+	CreatedAt       time.Time `json:"created_at"`
+}
+
+// matches reports whether the grant applies to (level, layer, capability).
+// An empty layer means "not layer-specific" and only matches layer "*".
+func (g CapabilityGrant) matches(level, layer, capability string) bool {
+	if g.PermissionLevel != capWildcard && g.PermissionLevel != level {
+		return false
+	}
+	if g.Layer != capWildcard && g.Layer != layer {
+		return false
+	}
+	return g.Capability == capWildcard || g.Capability == capability
+}
+
+// ─── In-memory grant set ─────────────────────────────────────────────────────
+
+// defaultCapabilityGrants is the seed of sql/create_capabilities.sql.
+var defaultCapabilityGrants = []CapabilityGrant{
+	{PermissionLevel: capWildcard, Layer: capWildcard, Capability: capWildcard, Effect: EffectAllow},
+}
+
+var (
+	capabilityGrants   = defaultCapabilityGrants
+	capabilityGrantsMu sync.RWMutex
+)
+
+// ReloadCapabilityGrants replaces the in-memory grants with the rows in
+// rbac.pwagis_capability. On error the previous grants are kept.
+func ReloadCapabilityGrants() error {
+	grants, err := ListCapabilityGrants()
+	if err != nil {
+		return err
+	}
+	capabilityGrantsMu.Lock()
+	capabilityGrants = grants
+	capabilityGrantsMu.Unlock()
+	return nil
+}
+
+// HasCapability reports whether a permission level may perform capability
+// on layer. Pass layer "" for actions that are not tied to one layer.
+func HasCapability(level, layer, capability string) bool {
+	capabilityGrantsMu.RLock()
+	grants := capabilityGrants
+	capabilityGrantsMu.RUnlock()
+
+	allowed := false
+	for _, g := range grants {
+		if !g.matches(level, layer, capability) {
+			continue
+		}
+		if g.Effect == EffectDeny {
+			return false
+		}
+		allowed = true
+	}
+	return allowed
+}
+
+// CapabilityMatrix returns the capabilities of a permission level, globally
+// (layer-independent) and for every known layer, for GET /api/session/info.
+func CapabilityMatrix(level string) (map[string]bool, map[string]map[string]bool) {
+	global := make(map[string]bool, len(AllCapabilities))
+	for _, capability := range AllCapabilities {
+		global[capability] = HasCapability(level, "", capability)
+	}
+	layers := make(map[string]map[string]bool)
+	for _, layer := range GetAllLayerNames() {
+		m := make(map[string]bool, len(AllCapabilities))
+		for _, capability := range AllCapabilities {
+			m[capability] = HasCapability(level, layer, capability)
+		}
+		layers[layer] = m
+	}
+	return global, layers
+}
+
+// ─── CRUD ────────────────────────────────────────────────────────────────────
+
+// ValidateCapabilityGrant normalises and checks a grant before it is written.
+func ValidateCapabilityGrant(g *CapabilityGrant) error {
+	g.PermissionLevel = strings.TrimSpace(g.PermissionLevel)
+	g.Layer = strings.TrimSpace(g.Layer)
+	g.Capability = strings.TrimSpace(g.Capability)
+	if g.Effect == "" {
+		g.Effect = EffectAllow
+	}
+
+	switch g.PermissionLevel {
+	case capWildcard, "all", "reg", "branch":
+	default:
+		return fmt.Errorf("invalid permission_level: %s", g.PermissionLevel)
+	}
+	if g.Layer == "" {
+		g.Layer = capWildcard
+	}
+	if g.Layer != capWildcard {
+		known := false
+		for _, l := range GetAllLayerNames() {
+			if l == g.Layer {
+				known = true
+				break
+			}
+		}
+		if !known {
+			return fmt.Errorf("unknown layer: %s", g.Layer)
+		}
+	}
+	valid := g.Capability == capWildcard
+	for _, capability := range AllCapabilities {
+		if g.Capability == capability {
+			valid = true
+		}
+	}
+	if !valid {
+		return fmt.Errorf("invalid capability: %s (valid: %s)", g.Capability, strings.Join(AllCapabilities, ", "))
+	}
+	if g.Effect != EffectAllow && g.Effect != EffectDeny {
+		return fmt.Errorf("invalid effect: %s", g.Effect)
+	}
+	return nil
+}
+
+const capabilityColumns = `
+	id, permission_level, layer, capability, effect,
+	COALESCE(note, ''), COALESCE(created_by, ''), created_at`
+
+// ListCapabilityGrants returns every grant.
+func ListCapabilityGrants() ([]CapabilityGrant, error) {
+	if config.PgDB == nil {
+		return nil, fmt.Errorf("postgres not connected")
+	}
+	rows, err := config.PgDB.Query(`SELECT ` + capabilityColumns + `
+		FROM rbac.pwagis_capability
+		ORDER BY permission_level, layer, capability, id`)
+	if err != nil {
+		return nil, fmt.Errorf("query capabilities failed: %v", err)
+	}
+	defer rows.Close()
+
+	grants := []CapabilityGrant{}
+	for rows.Next() {
+		var g CapabilityGrant
+		if err := rows.Scan(&g.ID, &g.PermissionLevel, &g.Layer, &g.Capability, &g.Effect,
+			&g.Note, &g.CreatedBy, &g.CreatedAt); err != nil {
+			log.Printf("scan capability row error: %v", err)
+			continue
+		}
+		grants = append(grants, g)
+	}
+	return grants, rows.Err()
+}
+
+// CreateCapabilityGrant inserts a grant and reloads the in-memory grants.
+func CreateCapabilityGrant(g CapabilityGrant, actor string) (CapabilityGrant, error) {
+	if err := ValidateCapabilityGrant(&g); err != nil {
+		return CapabilityGrant{}, err
+	}
+	err := config.PgDB.QueryRow(`
+		INSERT INTO rbac.pwagis_capability
+			(permission_level, layer, capability, effect, note, created_by)
+		VALUES ($1,$2,$3,$4,$5,$6)
+		RETURNING id, created_at
+	`, g.PermissionLevel, g.Layer, g.Capability, g.Effect, g.Note, actor).Scan(&g.ID, &g.CreatedAt)
+	if err != nil {
+		return CapabilityGrant{}, fmt.Errorf("insert capability failed: %v", err)
+	}
+	g.CreatedBy = actor
+	reloadCapabilitiesAfterChange()
+	return g, nil
+}
+
+// DeleteCapabilityGrant removes a grant and reloads the in-memory grants.
+func DeleteCapabilityGrant(id int) error {
+	res, err := config.PgDB.Exec(`DELETE FROM rbac.pwagis_capability WHERE id = $1`, id)
+	if err != nil {
+		return fmt.Errorf("delete capability failed: %v", err)
+	}
+	if n, _ := res.RowsAffected(); n == 0 {
+		return sql.ErrNoRows
+	}
+	reloadCapabilitiesAfterChange()
+	return nil
+}
+
+func reloadCapabilitiesAfterChange() {
+	if err := ReloadCapabilityGrants(); err != nil {
+		log.Printf("[RBAC] capability reload after change failed: %v", err)
+	}
+}
@@ -0,0 +1,162 @@
+// Package services/change_watch.go
+// Detects writes to feature data so cached dashboards can be refreshed.
+//
+// StartFeatureChangeWatcher opens a database-level MongoDB change stream
+// filtered to features_* collections and the "collections" metadata, and
+// reports the ObjectID of every changed collection. Change streams need a
+// replica set; on a standalone server the watcher falls back to polling
+// EstimatedDocumentCount of every indexed collection, which sees inserts
+// and deletes (not in-place updates) within one poll interval.
+package services
+
+import (
+	"context"
+	"errors"
+	"log"
+	"strings"
+	"time"
+
+	"pwa_gis_tracking/config"
+
+	"go.mongodb.org/mongo-driver/bson"
+	"go.mongodb.org/mongo-driver/bson/primitive"
+	"go.mongodb.org/mongo-driver/mongo"
+	"go.mongodb.org/mongo-driver/mongo/options"
+)
+
+// FeatureChange reports one changed collection.
+type FeatureChange struct {
+	CollectionID string // ObjectID hex of features_<id> (or of the metadata document)
+	Metadata     bool   // the "collections" metadata document changed
+}
+
+// changeStreamUnsupported is the server error for $changeStream on a
+// standalone mongod.
+const changeStreamUnsupported = 40573
+
+// StartFeatureChangeWatcher reports changes to notify until ctx is cancelled.
+// notify is called from the watcher goroutine and must not block for long.
+func StartFeatureChangeWatcher(ctx context.Context, pollInterval time.Duration, notify func(FeatureChange)) {
+	if config.MongoDB == nil {
+		return
+	}
+	go func() {
+		var resume bson.Raw
+		for ctx.Err() == nil {
+			err := watchFeatureChanges(ctx, &resume, notify)
+			if ctx.Err() != nil {
+				return
+			}
+			if isChangeStreamUnsupported(err) {
+				log.Printf("[ChangeWatch] change streams unavailable, polling every %v: %v", pollInterval, err)
+				pollFeatureChanges(ctx, pollInterval, notify)
+				return
+			}
+			log.Printf("[ChangeWatch] change stream closed, reopening in 10s: %v", err)
+			select {
+			case <-time.After(10 * time.Second):
+			case <-ctx.Done():
+				return
+			}
+		}
+	}()
+}
+
+func isChangeStreamUnsupported(err error) bool {
+	var se mongo.ServerError
+	if errors.As(err, &se) && se.HasErrorCode(changeStreamUnsupported) {
+		return true
+	}
+	return err != nil && strings.Contains(err.Error(), "only supported on replica sets")
+}
+
+// watchFeatureChanges runs one change stream until it fails. *resume is
+// updated after every event so a reopened stream continues where it stopped.
+func watchFeatureChanges(ctx context.Context, resume *bson.Raw, notify func(FeatureChange)) error {
+	pipeline := mongo.Pipeline{
+		{{Key: "$match", Value: bson.M{"$or": bson.A{
+			bson.M{"ns.coll": bson.M{"$regex": "^features_"}},
+			bson.M{"ns.coll": "collections"},
+		}}}},
+		{{Key: "$project", Value: bson.M{"ns": 1, "operationType": 1, "documentKey": 1}}},
+	}
+	opts := options.ChangeStream()
+	if *resume != nil {
+		opts.SetResumeAfter(*resume)
+	}
+
+	cs, err := config.MongoDB.Database(config.MongoDBName).Watch(ctx, pipeline, opts)
+	if err != nil {
+		return err
+	}
+	defer cs.Close(context.Background())
+	log.Println("[ChangeWatch] watching features_* and collections")
+
+	for cs.Next(ctx) {
+		var ev struct {
+			OperationType string `bson:"operationType"`
+			NS            struct {
+				Coll string `bson:"coll"`
+			} `bson:"ns"`
+			DocumentKey struct {
+				ID interface{} `bson:"_id"`
+			} `bson:"documentKey"`
+		}
+		if err := cs.Decode(&ev); err != nil {
+			continue
+		}
+		*resume = cs.ResumeToken()
+
+		switch {
+		case ev.OperationType == "invalidate":
+			// The stream cannot be resumed past an invalidate
+			*resume = nil
+			return errors.New("change stream invalidated")
+		case ev.NS.Coll == "collections":
+			id, _ := ev.DocumentKey.ID.(primitive.ObjectID)
+			notify(FeatureChange{CollectionID: id.Hex(), Metadata: true})
+		case strings.HasPrefix(ev.NS.Coll, "features_"):
+			notify(FeatureChange{CollectionID: strings.TrimPrefix(ev.NS.Coll, "features_")})
+		}
+	}
+	return cs.Err()
+}
+
+// pollFeatureChanges compares estimated document counts every interval.
+// The first pass only records the baseline.
+func pollFeatureChanges(ctx context.Context, interval time.Duration, notify func(FeatureChange)) {
+	counts := make(map[string]int64)
+	var metaCount int64 = -1
+
+	poll := func(baseline bool) {
+		db := config.MongoDB.Database(config.MongoDBName)
+		if n, err := db.Collection("collections").EstimatedDocumentCount(ctx); err == nil {
+			if !baseline && metaCount >= 0 && n != metaCount {
+				notify(FeatureChange{Metadata: true})
+			}
+			metaCount = n
+		}
+		for _, id := range CollectionIDs() {
+			n, err := db.Collection("features_" + id).EstimatedDocumentCount(ctx)
+			if err != nil {
+				continue
+			}
+			if prev, ok := counts[id]; ok && !baseline && prev != n {
+				notify(FeatureChange{CollectionID: id})
+			}
+			counts[id] = n
+		}
+	}
+
+	poll(true)
+	ticker := time.NewTicker(interval)
+	defer ticker.Stop()
+	for {
+		select {
+		case <-ticker.C:
+			poll(false)
+		case <-ctx.Done():
+			return
+		}
+	}
+}
@@ -0,0 +1,253 @@
+// Package services/collection_index.go
+// In-memory alias → collection ObjectID index for FindCollectionID.
+//
+// Every count, export, list and facet call resolves b{pwaCode}_{layer}
+// through the "collections" metadata collection; a dashboard alone needs
+// thousands of lookups. The index loads all aliases at startup and every
+// refresh interval. Aliases that are not in the index fall back to one
+// FindOne (collections created since the last refresh); aliases that are
+// not found there either are remembered for collectionMissTTL so repeated
+// requests for a missing layer do not hit Mongo again.
+package services
+
+import (
+	"context"
+	"fmt"
+	"log"
+	"sort"
+	"strings"
+	"sync"
+	"time"
+
+	"pwa_gis_tracking/config"
+
+	"go.mongodb.org/mongo-driver/bson"
+	"go.mongodb.org/mongo-driver/bson/primitive"
+	"go.mongodb.org/mongo-driver/mongo"
+	"go.mongodb.org/mongo-driver/mongo/options"
+)
+
+// collectionMissTTL is how long a missing alias is negatively cached.
+const collectionMissTTL = 5 * time.Minute
+
+type collectionIndex struct {
+	mu       sync.RWMutex
+	ids      map[string]string    // alias → ObjectID hex
+	aliases  map[string]string    // ObjectID hex → alias
+	missing  map[string]time.Time // alias → negative-cache expiry
+	loadedAt time.Time
+}
+
+var collIndex = &collectionIndex{
+	ids:     make(map[string]string),
+	aliases: make(map[string]string),
+	missing: make(map[string]time.Time),
+}
+
+// lookup returns the cached id, or found=false; missing=true means the
+// alias is negatively cached.
+func (x *collectionIndex) lookup(alias string) (id string, found, missing bool) {
+	x.mu.RLock()
+	defer x.mu.RUnlock()
+	if id, ok := x.ids[alias]; ok {
+		return id, true, false
+	}
+	if exp, ok := x.missing[alias]; ok && time.Now().Before(exp) {
+		return "", false, true
+	}
+	return "", false, false
+}
+
+func (x *collectionIndex) remember(alias, id string) {
+	x.mu.Lock()
+	defer x.mu.Unlock()
+	if id == "" {
+		x.missing[alias] = time.Now().Add(collectionMissTTL)
+		return
+	}
+	x.ids[alias] = id
+	x.aliases[id] = alias
+	delete(x.missing, alias)
+}
+
+// ReloadCollectionIndex replaces the index with every alias in the
+// "collections" metadata collection and clears the negative cache.
+func ReloadCollectionIndex() error {
+	if config.MongoDB == nil {
+		return fmt.Errorf("mongodb not connected")
+	}
+	ctx, cancel := context.WithTimeout(context.Background(), 60*time.Second)
+	defer cancel()
+
+	cur, err := config.GetMongoCollection("collections").Find(ctx,
+		bson.M{"alias": bson.M{"$exists": true}},
+		options.Find().SetProjection(bson.M{"_id": 1, "alias": 1}))
+	if err != nil {
+		return fmt.Errorf("load collection aliases failed: %v", err)
+	}
+	defer cur.Close(ctx)
+
+	ids := make(map[string]string)
+	aliases := make(map[string]string)
+	for cur.Next(ctx) {
+		var doc struct {
+			ID    primitive.ObjectID `bson:"_id"`
+			Alias string             `bson:"alias"`
+		}
+		if err := cur.Decode(&doc); err != nil || doc.Alias == "" {
+			continue
+		}
+		ids[doc.Alias] = doc.ID.Hex()
+		aliases[doc.ID.Hex()] = doc.Alias
+	}
+	if err := cur.Err(); err != nil {
+		return fmt.Errorf("load collection aliases failed: %v", err)
+	}
+
+	collIndex.mu.Lock()
+	collIndex.ids = ids
+	collIndex.aliases = aliases
+	collIndex.missing = make(map[string]time.Time)
+	collIndex.loadedAt = time.Now()
+	collIndex.mu.Unlock()
+	log.Printf("[CollectionIndex] loaded %d aliases", len(ids))
+	return nil
+}
+
+// StartCollectionIndexRefresher loads the index now and reloads it every
+// interval until ctx is cancelled. A failed reload keeps the previous index.
+func StartCollectionIndexRefresher(ctx context.Context, interval time.Duration) {
+	if err := ReloadCollectionIndex(); err != nil {
+		log.Printf("[CollectionIndex] initial load failed, falling back to per-call lookups: %v", err)
+	}
+
+	go func() {
+		ticker := time.NewTicker(interval)
+		defer ticker.Stop()
+		for {
+			select {
+			case <-ticker.C:
+				if err := ReloadCollectionIndex(); err != nil {
+					log.Printf("[CollectionIndex] reload failed (keeping previous index): %v", err)
+				}
+			case <-ctx.Done():
+				return
+			}
+		}
+	}()
+}
+
+// CollectionAlias returns the alias of a collection ObjectID (hex), as of
+// the last load. Used to map changed features_<id> collections to branches.
+func CollectionAlias(id string) (string, bool) {
+	collIndex.mu.RLock()
+	defer collIndex.mu.RUnlock()
+	alias, ok := collIndex.aliases[id]
+	return alias, ok
+}
+
+// CollectionIDs returns every indexed collection ObjectID (hex).
+func CollectionIDs() []string {
+	collIndex.mu.RLock()
+	defer collIndex.mu.RUnlock()
+	ids := make([]string, 0, len(collIndex.aliases))
+	for id := range collIndex.aliases {
+		ids = append(ids, id)
+	}
+	return ids
+}
+
+// ParseCollectionAlias splits b{pwaCode}_{layer}; the layer may itself
+// contain "_" (pwa_waterworks, pipe_serv).
+func ParseCollectionAlias(alias string) (pwaCode, layer string, ok bool) {
+	rest := strings.TrimPrefix(alias, "b")
+	i := strings.Index(rest, "_")
+	if rest == alias || i <= 0 {
+		return "", "", false
+	}
+	return rest[:i], rest[i+1:], true
+}
+
+// CollectionIndexStats describes the index for the admin endpoint.
+type CollectionIndexStats struct {
+	Aliases  int       `json:"aliases"`
+	Missing  int       `json:"negatively_cached"`
+	LoadedAt time.Time `json:"loaded_at"`
+}
+
+// GetCollectionIndexStats returns the size and age of the index.
+func GetCollectionIndexStats() CollectionIndexStats {
+	collIndex.mu.RLock()
+	defer collIndex.mu.RUnlock()
+	return CollectionIndexStats{Aliases: len(collIndex.ids), Missing: len(collIndex.missing), LoadedAt: collIndex.loadedAt}
+}
+
+// findCollectionIDUncached is the FindOne fallback for aliases not in the index.
+func findCollectionIDUncached(alias string) (string, error) {
+	ctx, cancel := context.WithTimeout(context.Background(), 10*time.Second)
+	defer cancel()
+
+	var result struct {
+		ID primitive.ObjectID `bson:"_id"`
+	}
+	err := config.GetMongoCollection("collections").FindOne(ctx, bson.M{"alias": alias}).Decode(&result)
+	if err == mongo.ErrNoDocuments {
+		collIndex.remember(alias, "")
+		return "", fmt.Errorf("collection not found: %s", alias)
+	}
+	if err != nil {
+		// Transport errors are not negatively cached
+		return "", fmt.Errorf("collection lookup failed: %s: %v", alias, err)
+	}
+	id := result.ID.Hex()
+	collIndex.remember(alias, id)
+	return id, nil
+}
+
+// OrphanCollection is an alias in Mongo whose branch is not in pwa_office234.
+type OrphanCollection struct {
+	Alias        string `json:"alias"`
+	CollectionID string `json:"collection_id"`
+	PwaCode      string `json:"pwa_code"`
+	Layer        string `json:"layer"`
+	Reason       string `json:"reason"`
+}
+
+// FindOrphanCollections compares the loaded index with pwa_office234.
+// Aliases that do not follow b{pwaCode}_{layer} are reported too.
+func FindOrphanCollections() ([]OrphanCollection, error) {
+	offices, err := GetAllOffices()
+	if err != nil {
+		return nil, err
+	}
+	known := make(map[string]bool, len(offices))
+	for _, o := range offices {
+		known[o.PwaCode] = true
+	}
+
+	collIndex.mu.RLock()
+	aliases := make([]string, 0, len(collIndex.ids))
+	ids := make(map[string]string, len(collIndex.ids))
+	for a, id := range collIndex.ids {
+		aliases = append(aliases, a)
+		ids[a] = id
+	}
+	collIndex.mu.RUnlock()
+	sort.Strings(aliases)
+
+	list := []OrphanCollection{}
+	for _, a := range aliases {
+		o := OrphanCollection{Alias: a, CollectionID: ids[a]}
+		var ok bool
+		if o.PwaCode, o.Layer, ok = ParseCollectionAlias(a); !ok {
+			o.Reason = "alias is not b{pwaCode}_{layer}"
+			list = append(list, o)
+			continue
+		}
+		if !known[o.PwaCode] {
+			o.Reason = "pwa_code not in pwa_office234"
+			list = append(list, o)
+		}
+	}
+	return list, nil
+}
@@ -0,0 +1,104 @@
+package services
+
+import (
+	"testing"
+	"time"
+)
+
+func TestParseCronField(t *testing.T) {
+	bits := func(vs ...int) uint64 {
+		var b uint64
+		for _, v := range vs {
+			b |= 1 << uint(v)
+		}
+		return b
+	}
+	tests := []struct {
+		field    string
+		min, max int
+		want     uint64
+		wantErr  bool
+	}{
+		{"*", 0, 6, bits(0, 1, 2, 3, 4, 5, 6), false},
+		{"5", 0, 59, bits(5), false},
+		{"1,3,5", 0, 6, bits(1, 3, 5), false},
+		{"1-3", 0, 6, bits(1, 2, 3), false},
+		{"*/15", 0, 59, bits(0, 15, 30, 45), false},
+		{"10-20/5", 0, 59, bits(10, 15, 20), false},
+		{"5/20", 0, 59, bits(5, 25, 45), false},
+		{"1-2,*/30", 0, 59, bits(0, 1, 2, 30), false},
+		{"7", 0, 7, bits(7), false},
+		{"60", 0, 59, 0, true},
+		{"0", 1, 31, 0, true},
+		{"5-3", 0, 59, 0, true},
+		{"*/0", 0, 59, 0, true},
+		{"*/x", 0, 59, 0, true},
+		{"a", 0, 59, 0, true},
+		{"", 0, 59, 0, true},
+	}
+	for _, tt := range tests {
+		got, err := parseCronField(tt.field, tt.min, tt.max)
+		if (err != nil) != tt.wantErr {
+			t.Errorf("parseCronField(%q) error = %v, wantErr %v", tt.field, err, tt.wantErr)
+			continue
+		}
+		if got != tt.want {
+			t.Errorf("parseCronField(%q) = %b, want %b", tt.field, got, tt.want)
+		}
+	}
+}
+
+func TestParseCronErrors(t *testing.T) {
+	for _, expr := range []string{"", "* * * *", "* * * * * *", "60 * * * *", "* 24 * * *", "* * 32 * *", "* * * 13 *", "* * * * 8"} {
+		if _, err := ParseCron(expr); err == nil {
+			t.Errorf("ParseCron(%q) succeeded, want error", expr)
+		}
+	}
+}
+
+func TestCronMatches(t *testing.T) {
+	// 2024-01-07 is a Sunday, 2024-01-08 a Monday, 2024-01-15 a Monday
+	at := func(day, hour, min int) time.Time {
+		return time.Date(2024, time.January, day, hour, min, 0, 0, time.Local)
+	}
+	tests := []struct {
+		expr string
+		t    time.Time
+		want bool
+	}{
+		{"*/15 * * * *", at(8, 10, 30), true},
+		{"*/15 * * * *", at(8, 10, 31), false},
+		{"5 6-18 * * 1-5", at(8, 6, 5), true},
+		{"5 6-18 * * 1-5", at(8, 19, 5), false},
+		{"5 6-18 * * 1-5", at(7, 6, 5), false}, // Sunday
+
+		// 0 and 7 are both Sunday
+		{"0 0 * * 0", at(7, 0, 0), true},
+		{"0 0 * * 7", at(7, 0, 0), true},
+		{"0 0 * * 7", at(8, 0, 0), false},
+		{"0 0 * * 5-7", at(7, 0, 0), true},
+
+		// Month restriction
+		{"0 2 1 * *", at(1, 2, 0), true},
+		{"0 2 1 2 *", at(1, 2, 0), false},
+
+		// Day-of-month and day-of-week both restricted: either matches
+		{"0 0 15 * 0", at(15, 0, 0), true}, // the 15th, a Monday
+		{"0 0 15 * 0", at(7, 0, 0), true},  // a Sunday, not the 15th
+		{"0 0 15 * 0", at(8, 0, 0), false}, // neither
+
+		// Only one restricted: it must match
+		{"0 0 15 * *", at(8, 0, 0), false},
+		{"0 0 * * 1", at(15, 0, 0), true},
+		{"0 0 * * 1", at(7, 0, 0), false},
+	}
+	for _, tt := range tests {
+		s, err := ParseCron(tt.expr)
+		if err != nil {
+			t.Fatalf("ParseCron(%q): %v", tt.expr, err)
+		}
+		if got := s.Matches(tt.t); got != tt.want {
+			t.Errorf("%q.Matches(%s) = %v, want %v", tt.expr, tt.t.Format("Mon 2006-01-02 15:04"), got, tt.want)
+		}
+	}
+}
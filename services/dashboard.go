@@ -0,0 +1,163 @@
+// Package services/dashboard.go
+// The dashboard summary, computed in one place.
+//
+// GetDashboardSummary (lazy cache), the background warmer and the Excel
+// report all call BuildDashboardSummary, so a cached response is always
+// the same shape as a freshly computed one.
+package services
+
+import (
+	"sort"
+	"strconv"
+
+	"pwa_gis_tracking/models"
+)
+
+// dashboardBranchConcurrency limits parallel per-branch counts of one build.
+const dashboardBranchConcurrency = 15
+
+// DashboardBranch is one branch row of the dashboard.
+type DashboardBranch struct {
+	PwaCode          string           `json:"pwa_code"`
+	BranchName       string           `json:"branch_name"`
+	Zone             string           `json:"zone"`
+	Layers           map[string]int64 `json:"layers"`
+	Total            int64            `json:"total"`
+	PipeLong         float64          `json:"pipe_long"`
+	PipeLongExSleeve float64          `json:"pipe_long_ex_sleeve"`
+	ActiveMeter      int64            `json:"active_meter"`
+}
+
+// DashboardSummary is the GET /api/dashboard response.
+type DashboardSummary struct {
+	Status        string                      `json:"status"`
+	Branches      []DashboardBranch           `json:"branches"`
+	ZoneTotals    map[string]map[string]int64 `json:"zone_totals"` // per zone: layer counts, "_total", "_branches"
+	GrandTotal    map[string]int64            `json:"grand_total"`
+	ZoneNames     []string                    `json:"zone_names"`
+	TotalBranches int                         `json:"total_branches"`
+}
+
+// BuildDashboardSummary counts every layer of every branch in zone ("" =
+// all zones) within the optional date range and aggregates the totals.
+// A branch whose counts fail is reported with empty layers.
+func BuildDashboardSummary(zone, startDate, endDate string) (*DashboardSummary, error) {
+	var offices []models.PwaOffice
+	var err error
+	if zone != "" {
+		offices, err = GetOfficesByZone(zone)
+	} else {
+		offices, err = GetAllOffices()
+	}
+	if err != nil {
+		return nil, err
+	}
+
+	// Count features for all branches concurrently
+	results := make(chan DashboardBranch, len(offices))
+	sem := make(chan struct{}, dashboardBranchConcurrency)
+	for _, o := range offices {
+		sem <- struct{}{}
+		go func(o models.PwaOffice) {
+			defer func() { <-sem }()
+			results <- buildDashboardBranch(o, startDate, endDate)
+		}(o)
+	}
+
+	branches := make([]DashboardBranch, 0, len(offices))
+	for i := 0; i < len(offices); i++ {
+		branches = append(branches, <-results)
+	}
+
+	return summarizeDashboard(branches), nil
+}
+
+// OnlyBranches returns a copy of the summary restricted to pwaCodes, with
+// the zone and grand totals recomputed from the remaining branches.
+func (s *DashboardSummary) OnlyBranches(pwaCodes []string) *DashboardSummary {
+	keep := make(map[string]bool, len(pwaCodes))
+	for _, code := range pwaCodes {
+		keep[code] = true
+	}
+	branches := []DashboardBranch{}
+	for _, b := range s.Branches {
+		if keep[b.PwaCode] {
+			branches = append(branches, b)
+		}
+	}
+	out := summarizeDashboard(branches)
+	out.Status = s.Status
+	return out
+}
+
+// summarizeDashboard sorts the branch rows and aggregates the totals.
+func summarizeDashboard(branches []DashboardBranch) *DashboardSummary {
+	// Sort by zone (numeric) then pwaCode
+	sort.Slice(branches, func(i, j int) bool {
+		zi, _ := strconv.Atoi(branches[i].Zone)
+		zj, _ := strconv.Atoi(branches[j].Zone)
+		if zi != zj {
+			return zi < zj
+		}
+		return branches[i].PwaCode < branches[j].PwaCode
+	})
+
+	// Aggregate totals per zone
+	zoneTotals := make(map[string]map[string]int64)
+	zoneNames := []string{}
+	for _, b := range branches {
+		zt, ok := zoneTotals[b.Zone]
+		if !ok {
+			zt = make(map[string]int64)
+			zoneTotals[b.Zone] = zt
+			zoneNames = append(zoneNames, b.Zone)
+		}
+		for layer, cnt := range b.Layers {
+			zt[layer] += cnt
+		}
+		zt["_total"] += b.Total
+		zt["_branches"]++
+	}
+
+	// Sort zone names numerically
+	sort.Slice(zoneNames, func(i, j int) bool {
+		a, _ := strconv.Atoi(zoneNames[i])
+		b, _ := strconv.Atoi(zoneNames[j])
+		return a < b
+	})
+
+	// Compute grand totals across all zones
+	grandTotal := make(map[string]int64)
+	for _, zt := range zoneTotals {
+		for k, v := range zt {
+			grandTotal[k] += v
+		}
+	}
+
+	return &DashboardSummary{
+		Status:        "success",
+		Branches:      branches,
+		ZoneTotals:    zoneTotals,
+		GrandTotal:    grandTotal,
+		ZoneNames:     zoneNames,
+		TotalBranches: len(branches),
+	}
+}
+
+// buildDashboardBranch counts one branch.
+func buildDashboardBranch(o models.PwaOffice, startDate, endDate string) DashboardBranch {
+	b := DashboardBranch{PwaCode: o.PwaCode, BranchName: o.Name, Zone: o.Zone, Layers: map[string]int64{}}
+
+	layers, err := CountAllLayersForBranch(o.PwaCode, startDate, endDate)
+	if err != nil {
+		return b
+	}
+	b.Layers = layers
+	for _, cnt := range layers {
+		b.Total += cnt
+	}
+	b.PipeLong, _ = SumPipeLength(o.PwaCode, startDate, endDate)
+	b.PipeLongExSleeve, _ = SumPipeLengthExcludingSleeve(o.PwaCode, startDate, endDate)
+	b.ActiveMeter, _ = CountActiveMeters(o.PwaCode, startDate, endDate)
+	return b
+}
@@ -0,0 +1,209 @@
+// Package services/dashboard_warm.go
+// What the dashboard cache warmer computes ahead of time.
+//
+// WarmConfig (WARM_CONFIG_FILE, JSON) lists schedules; each fires on a
+// cron expression and warms every zone ("" + each zone) for its date
+// presets, and/or the most requested zone/date combinations from the
+// audit log:
+//
+//	{"schedules": [
+//	  {"name": "base",   "cron": "*/15 * * * *", "presets": ["none"], "on_startup": true},
+//	  {"name": "ranges", "cron": "7 * * * *",    "presets": ["current_year", "fiscal_year", "last_month"]},
+//	  {"name": "usage",  "cron": "*/30 7-18 * * 1-5", "usage": {"days": 7, "top": 20}, "ttl": "45m"}
+//	]}
+//
+// Presets (dates as the dashboard sends them, YYYY-MM-DD, end inclusive):
+//
+//	none          no date filter
+//	current_year  1 Jan – 31 Dec of this year (the year picker)
+//	fiscal_year   1 Oct – 30 Sep of the current Thai fiscal year
+//	last_month    first – last day of the previous month
+//	years         every year of GetYearsFromRecordDate (heavy: years × zones)
+package services
+
+import (
+	"encoding/json"
+	"fmt"
+	"os"
+	"regexp"
+	"time"
+
+	"pwa_gis_tracking/config"
+)
+
+// DashboardQuery is one zone/date combination of the dashboard.
+type DashboardQuery struct {
+	Zone      string `json:"zone"`
+	StartDate string `json:"startDate"`
+	EndDate   string `json:"endDate"`
+	Hits      int    `json:"hits,omitempty"` // usage mode only
+}
+
+// WarmUsage selects the most requested dashboard queries.
+type WarmUsage struct {
+	Days int `json:"days"` // look-back window (default 7)
+	Top  int `json:"top"`  // number of combinations (default 20)
+}
+
+// WarmSchedule is one entry of WarmConfig.
+type WarmSchedule struct {
+	Name      string     `json:"name"`
+	Cron      string     `json:"cron"`
+	Presets   []string   `json:"presets,omitempty"`
+	Usage     *WarmUsage `json:"usage,omitempty"`
+	TTL       string     `json:"ttl,omitempty"` // Go duration; default WarmCacheTTL
+	OnStartup bool       `json:"on_startup,omitempty"`
+
+	Schedule *CronSchedule `json:"-"`
+	TTLDur   time.Duration `json:"-"` // 0 = caller's default
+}
+
+// WarmConfig is the warmer configuration.
+type WarmConfig struct {
+	Schedules []WarmSchedule `json:"schedules"`
+}
+
+// DefaultWarmConfig warms the no-date dashboards every 15 minutes and on
+// startup, as the warmer always did.
+func DefaultWarmConfig() *WarmConfig {
+	cfg := &WarmConfig{Schedules: []WarmSchedule{
+		{Name: "default", Cron: "*/15 * * * *", Presets: []string{"none"}, OnStartup: true},
+	}}
+	cfg.validate()
+	return cfg
+}
+
+// LoadWarmConfig reads WARM_CONFIG_FILE, or returns DefaultWarmConfig
+// when it is unset.
+func LoadWarmConfig() (*WarmConfig, error) {
+	path := os.Getenv("WARM_CONFIG_FILE")
+	if path == "" {
+		return DefaultWarmConfig(), nil
+	}
+	raw, err := os.ReadFile(path)
+	if err != nil {
+		return nil, fmt.Errorf("read %s: %v", path, err)
+	}
+	var cfg WarmConfig
+	if err := json.Unmarshal(raw, &cfg); err != nil {
+		return nil, fmt.Errorf("parse %s: %v", path, err)
+	}
+	if err := cfg.validate(); err != nil {
+		return nil, fmt.Errorf("%s: %v", path, err)
+	}
+	return &cfg, nil
+}
+
+var warmPresets = map[string]bool{"none": true, "current_year": true, "fiscal_year": true, "last_month": true, "years": true}
+
+func (cfg *WarmConfig) validate() error {
+	if len(cfg.Schedules) == 0 {
+		return fmt.Errorf("no schedules")
+	}
+	for i := range cfg.Schedules {
+		s := &cfg.Schedules[i]
+		if s.Name == "" {
+			s.Name = fmt.Sprintf("schedule%d", i+1)
+		}
+		sched, err := ParseCron(s.Cron)
+		if err != nil {
+			return fmt.Errorf("%s: %v", s.Name, err)
+		}
+		s.Schedule = sched
+		for _, p := range s.Presets {
+			if !warmPresets[p] {
+				return fmt.Errorf("%s: unknown preset %q", s.Name, p)
+			}
+		}
+		if len(s.Presets) == 0 && s.Usage == nil {
+			return fmt.Errorf("%s: needs presets or usage", s.Name)
+		}
+		if s.Usage != nil {
+			if s.Usage.Days <= 0 {
+				s.Usage.Days = 7
+			}
+			if s.Usage.Top <= 0 {
+				s.Usage.Top = 20
+			}
+		}
+		if s.TTL != "" {
+			if s.TTLDur, err = time.ParseDuration(s.TTL); err != nil || s.TTLDur <= 0 {
+				return fmt.Errorf("%s: invalid ttl %q", s.Name, s.TTL)
+			}
+		}
+	}
+	return nil
+}
+
+// WarmPresetRanges returns the date ranges of a preset as of now.
+func WarmPresetRanges(preset string, now time.Time) ([][2]string, error) {
+	const layout = "2006-01-02"
+	year := now.Year()
+	switch preset {
+	case "none":
+		return [][2]string{{"", ""}}, nil
+	case "current_year":
+		return [][2]string{{fmt.Sprintf("%d-01-01", year), fmt.Sprintf("%d-12-31", year)}}, nil
+	case "fiscal_year":
+		// Thai government fiscal year N runs 1 Oct N-1 – 30 Sep N
+		fy := year
+		if now.Month() >= time.October {
+			fy++
+		}
+		return [][2]string{{fmt.Sprintf("%d-10-01", fy-1), fmt.Sprintf("%d-09-30", fy)}}, nil
+	case "last_month":
+		first := time.Date(year, now.Month(), 1, 0, 0, 0, 0, now.Location()).AddDate(0, -1, 0)
+		last := first.AddDate(0, 1, -1)
+		return [][2]string{{first.Format(layout), last.Format(layout)}}, nil
+	case "years":
+		years, err := GetYearsFromRecordDate()
+		if err != nil {
+			return nil, err
+		}
+		ranges := make([][2]string, 0, len(years))
+		for _, y := range years {
+			ranges = append(ranges, [2]string{fmt.Sprintf("%d-01-01", y), fmt.Sprintf("%d-12-31", y)})
+		}
+		return ranges, nil
+	}
+	return nil, fmt.Errorf("unknown preset %q", preset)
+}
+
+var dashboardDateRe = regexp.MustCompile(`^(\d{4}-\d{2}-\d{2})?$`)
+
+// TopDashboardQueries returns the most requested dashboard zone/date
+// combinations of the last days, from view_dashboard audit rows.
+func TopDashboardQueries(days, limit int) ([]DashboardQuery, error) {
+	if config.PgDB == nil {
+		return nil, fmt.Errorf("postgres not connected")
+	}
+	rows, err := config.PgDB.Query(`
+		SELECT target_value, COUNT(*) AS hits
+		FROM audit_logs.pwagis_track_log
+		WHERE action = 'view_dashboard' AND target_type = 'dashboard' AND created_at >= $1
+		GROUP BY target_value
+		ORDER BY hits DESC
+		LIMIT $2
+	`, time.Now().AddDate(0, 0, -days), limit)
+	if err != nil {
+		return nil, fmt.Errorf("query dashboard usage failed: %v", err)
+	}
+	defer rows.Close()
+
+	list := []DashboardQuery{}
+	for rows.Next() {
+		var raw string
+		var hits int
+		if err := rows.Scan(&raw, &hits); err != nil {
+			return nil, fmt.Errorf("scan dashboard usage failed: %v", err)
+		}
+		var q DashboardQuery
+		if json.Unmarshal([]byte(raw), &q) != nil ||
+			!dashboardDateRe.MatchString(q.StartDate) || !dashboardDateRe.MatchString(q.EndDate) {
+			continue
+		}
+		q.Hits = hits
+		list = append(list, q)
+	}
+	return list, rows.Err()
+}
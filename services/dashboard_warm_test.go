@@ -0,0 +1,46 @@
+package services
+
+import (
+	"reflect"
+	"testing"
+	"time"
+)
+
+func TestWarmPresetRanges(t *testing.T) {
+	date := func(y int, m time.Month, d int) time.Time {
+		return time.Date(y, m, d, 12, 0, 0, 0, time.Local)
+	}
+	tests := []struct {
+		preset string
+		now    time.Time
+		want   [][2]string
+	}{
+		{"none", date(2024, time.May, 10), [][2]string{{"", ""}}},
+		{"current_year", date(2024, time.May, 10), [][2]string{{"2024-01-01", "2024-12-31"}}},
+
+		// Fiscal year N runs 1 Oct N-1 – 30 Sep N and rolls over in October
+		{"fiscal_year", date(2024, time.May, 10), [][2]string{{"2023-10-01", "2024-09-30"}}},
+		{"fiscal_year", date(2024, time.September, 30), [][2]string{{"2023-10-01", "2024-09-30"}}},
+		{"fiscal_year", date(2024, time.October, 1), [][2]string{{"2024-10-01", "2025-09-30"}}},
+		{"fiscal_year", date(2024, time.December, 31), [][2]string{{"2024-10-01", "2025-09-30"}}},
+		{"fiscal_year", date(2025, time.January, 1), [][2]string{{"2024-10-01", "2025-09-30"}}},
+
+		{"last_month", date(2024, time.May, 10), [][2]string{{"2024-04-01", "2024-04-30"}}},
+		{"last_month", date(2024, time.March, 31), [][2]string{{"2024-02-01", "2024-02-29"}}},
+		{"last_month", date(2024, time.January, 15), [][2]string{{"2023-12-01", "2023-12-31"}}},
+	}
+	for _, tt := range tests {
+		got, err := WarmPresetRanges(tt.preset, tt.now)
+		if err != nil {
+			t.Errorf("WarmPresetRanges(%q, %s): %v", tt.preset, tt.now.Format("2006-01-02"), err)
+			continue
+		}
+		if !reflect.DeepEqual(got, tt.want) {
+			t.Errorf("WarmPresetRanges(%q, %s) = %v, want %v", tt.preset, tt.now.Format("2006-01-02"), got, tt.want)
+		}
+	}
+
+	if _, err := WarmPresetRanges("next_year", time.Now()); err == nil {
+		t.Error("WarmPresetRanges(unknown) succeeded, want error")
+	}
+}
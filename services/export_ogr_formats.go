@@ -10,8 +10,8 @@ import (
 
 // ExportAsGeoPackage converts GeoJSON to GeoPackage (.gpkg) using ogr2ogr.
 // Returns the raw .gpkg file bytes.
-func ExportAsGeoPackage(pwaCode, collection, startDate, endDate string) ([]byte, error) {
-	geojsonData, err := ExportFeaturesAsGeoJSON(pwaCode, collection, startDate, endDate)
+func ExportAsGeoPackage(pwaCode, collection, startDate, endDate string, mask MaskMode) ([]byte, error) {
+	geojsonData, err := ExportFeaturesAsGeoJSON(pwaCode, collection, startDate, endDate, mask)
 	if err != nil {
 		return nil, fmt.Errorf("GeoJSON export failed: %w", err)
 	}
@@ -53,7 +53,7 @@ func convertGeoJSONToGPKG(geojsonData []byte, pwaCode, collection string) ([]byt
 		"-nln", collection,
 		"-overwrite",
 	)
-	output, err := cmd.CombinedOutput()
+	output, err := runExportTool(cmd, "GPKG")
 	if err != nil {
 		return nil, fmt.Errorf("ogr2ogr GPKG failed: %s — %w", string(output), err)
 	}
@@ -69,8 +69,8 @@ func convertGeoJSONToGPKG(geojsonData []byte, pwaCode, collection string) ([]byt
 
 // ExportAsShapefile converts GeoJSON to ESRI Shapefile using ogr2ogr.
 // Returns a zip containing .shp, .shx, .dbf, .prj files.
-func ExportAsShapefile(pwaCode, collection, startDate, endDate string) ([]byte, error) {
-	geojsonData, err := ExportFeaturesAsGeoJSON(pwaCode, collection, startDate, endDate)
+func ExportAsShapefile(pwaCode, collection, startDate, endDate string, mask MaskMode) ([]byte, error) {
+	geojsonData, err := ExportFeaturesAsGeoJSON(pwaCode, collection, startDate, endDate, mask)
 	if err != nil {
 		return nil, fmt.Errorf("GeoJSON export failed: %w", err)
 	}
@@ -115,7 +115,7 @@ func convertGeoJSONToShapefile(geojsonData []byte, pwaCode, collection string) (
 		"-lco", "ENCODING=UTF-8",
 		"-overwrite",
 	)
-	output, err := cmd.CombinedOutput()
+	output, err := runExportTool(cmd, "ESRI Shapefile")
 	if err != nil {
 		return nil, fmt.Errorf("ogr2ogr Shapefile failed: %s — %w", string(output), err)
 	}
@@ -28,12 +28,12 @@ import (
 // ExportAsMapInfoTAB converts GeoJSON to MapInfo TAB format using ogr2ogr.
 // Returns a zip file containing .tab, .dat, .map, .id files.
 // Uses Go's archive/zip package (cross-platform, no external zip needed).
-func ExportAsMapInfoTAB(pwaCode, collection, startDate, endDate string) ([]byte, error) {
+func ExportAsMapInfoTAB(pwaCode, collection, startDate, endDate string, mask MaskMode) ([]byte, error) {
 	ogr2ogrPath, err := findOgr2ogr()
 	if err != nil {
 		return nil, err
 	}
-	geojsonData, err := ExportFeaturesAsGeoJSON(pwaCode, collection, startDate, endDate)
+	geojsonData, err := ExportFeaturesAsGeoJSON(pwaCode, collection, startDate, endDate, mask)
 	if err != nil {
 		return nil, fmt.Errorf("GeoJSON export failed: %w", err)
 	}
@@ -78,7 +78,7 @@ func convertGeoJSONToTAB(ogr2ogrPath string, geojsonData []byte, pwaCode, collec
 		"-nln", collection,
 		"-overwrite",
 	)
-	output, err := cmd.CombinedOutput()
+	output, err := runExportTool(cmd, "MapInfo File")
 	if err != nil {
 		return nil, fmt.Errorf("ogr2ogr TAB failed: %s — %w", string(output), err)
 	}
@@ -94,8 +94,8 @@ func convertGeoJSONToTAB(ogr2ogrPath string, geojsonData []byte, pwaCode, collec
 
 // ExportAsPMTiles converts GeoJSON to PMTiles vector tiles.
 // Priority: tippecanoe → ogr2ogr+GPKG+pmtiles → error with install hint.
-func ExportAsPMTiles(pwaCode, collection, startDate, endDate string) ([]byte, error) {
-	geojsonData, err := ExportFeaturesAsGeoJSON(pwaCode, collection, startDate, endDate)
+func ExportAsPMTiles(pwaCode, collection, startDate, endDate string, mask MaskMode) ([]byte, error) {
+	geojsonData, err := ExportFeaturesAsGeoJSON(pwaCode, collection, startDate, endDate, mask)
 	if err != nil {
 		return nil, fmt.Errorf("GeoJSON export failed: %w", err)
 	}
@@ -140,7 +140,7 @@ func convertGeoJSONToPMTiles(geojsonData []byte, pwaCode, collection string) ([]
 			"--force",
 			inputPath,
 		)
-		out, err := cmd.CombinedOutput()
+		out, err := runExportTool(cmd, "PMTiles")
 		if err == nil {
 			data, readErr := os.ReadFile(outputPath)
 			if readErr == nil {
@@ -166,7 +166,7 @@ func convertGeoJSONToPMTiles(geojsonData []byte, pwaCode, collection string) ([]
 			"-dsco", "MAXZOOM=14",
 			"-dsco", "MINZOOM=4",
 		)
-		out, err := cmd.CombinedOutput()
+		out, err := runExportTool(cmd, "MBTiles")
 
 		if err != nil {
 			log.Printf("[Export] ogr2ogr MBTiles not available: %s", string(out))
@@ -183,7 +183,7 @@ func convertGeoJSONToPMTiles(geojsonData []byte, pwaCode, collection string) ([]
 				"-nln", collection,
 				"-overwrite",
 			)
-			gpkgOut, gpkgErr := gpkgCmd.CombinedOutput()
+			gpkgOut, gpkgErr := runExportTool(gpkgCmd, "GPKG")
 			if gpkgErr != nil {
 				log.Printf("[Export] ogr2ogr GPKG also failed: %s", string(gpkgOut))
 			} else {
@@ -206,7 +206,7 @@ func convertGeoJSONToPMTiles(geojsonData []byte, pwaCode, collection string) ([]
 		// MBTiles succeeded — try pmtiles convert
 		if _, pmErr := exec.LookPath("pmtiles"); pmErr == nil {
 			convertCmd := exec.Command("pmtiles", "convert", mbtilesPath, outputPath)
-			if convertOut, convertErr := convertCmd.CombinedOutput(); convertErr != nil {
+			if convertOut, convertErr := runExportTool(convertCmd, "PMTiles"); convertErr != nil {
 				log.Printf("[Export] pmtiles convert failed: %s", string(convertOut))
 			} else {
 				data, readErr := os.ReadFile(outputPath)
@@ -64,12 +64,15 @@ type ColumnInfo struct {
 
 // ListFeaturesPaginated fetches features from MongoDB with pagination,
 // maps properties through FieldMapping, and supports case-insensitive search.
+// Sensitive fields are masked with mask; unless mask is MaskFull they are
+// also excluded from search, column filters and sorting.
 func ListFeaturesPaginated(
 	pwaCode, collection, startDate, endDate, search string,
 	page, pageSize int,
 	raw bool,
 	filters map[string]string,
 	sortBy string, sortOrder int,
+	mask MaskMode,
 ) (*PaginatedResult, error) {
 
 	ctx, cancel := context.WithTimeout(context.Background(), 30*time.Second)
@@ -115,6 +118,9 @@ func ListFeaturesPaginated(
 					if pgKey == "password" {
 						continue
 					}
+					if mask != MaskFull && IsSensitiveField(collection, mongoKey) {
+						continue
+					}
 					orConds = append(orConds, bson.M{
 						"properties." + mongoKey: termRegex,
 					})
@@ -170,6 +176,9 @@ func ListFeaturesPaginated(
 		}
 
 		for field, value := range filters {
+			if mask != MaskFull && IsSensitiveField(collection, field) {
+				continue
+			}
 			propField := "properties." + field
 			// Date fields: use range matching (start of day to end of day)
 			if field == "recordDate" || field == "leakDatetime" || field == "beginCustDate" {
@@ -239,7 +248,9 @@ func ListFeaturesPaginated(
 	// ────────────────────────────────────────────
 	// Count total matching documents
 	// ────────────────────────────────────────────
+	countStart := time.Now()
 	total, err := coll.CountDocuments(ctx, filter)
+	MongoOpSeconds.ObserveSince(countStart, "count", collection)
 	if err != nil {
 		return nil, fmt.Errorf("count error: %w", err)
 	}
@@ -262,7 +273,7 @@ func ListFeaturesPaginated(
 	// Build sort — use sortBy if provided, default to recordDate desc
 	sortKey := "properties.recordDate"
 	sortDir := -1 // descending (newest first)
-	if sortBy != "" {
+	if sortBy != "" && (mask == MaskFull || !IsSensitiveField(collection, sortBy)) {
 		sortKey = "properties." + sortBy
 		sortDir = sortOrder
 	}
@@ -325,6 +336,7 @@ func ListFeaturesPaginated(
 		} else {
 			row = MapProperties(collection, rawProps)
 		}
+		row = MaskProperties(collection, row, mask)
 
 		// Add the MongoDB document _id as reference (hidden column)
 		if docID, ok := doc["_id"]; ok {
@@ -359,6 +371,15 @@ func ListFeaturesPaginated(
 	} else {
 		columns = buildColumnInfo(collection)
 	}
+	if mask == MaskRemoved {
+		kept := columns[:0]
+		for _, col := range columns {
+			if !IsSensitiveField(collection, col.MongoKey) {
+				kept = append(kept, col)
+			}
+		}
+		columns = kept
+	}
 
 	log.Printf("[FeaturesList] %s/%s page=%d total=%d rows=%d search=%q",
 		pwaCode, collection, page, total, len(data), search)
@@ -457,7 +478,8 @@ func buildColumnInfo(collection string) []ColumnInfo {
 
 // SuggestFeatureValues returns autocomplete suggestions for a search query.
 // It searches across all mapped fields and returns distinct matching values.
-func SuggestFeatureValues(pwaCode, collection, query string, limit int) ([]map[string]string, error) {
+// Sensitive fields are only searched when mask is MaskFull.
+func SuggestFeatureValues(pwaCode, collection, query string, limit int, mask MaskMode) ([]map[string]string, error) {
 	if query == "" || len(query) < 2 {
 		return nil, nil
 	}
@@ -486,6 +508,9 @@ func SuggestFeatureValues(pwaCode, collection, query string, limit int) ([]map[s
 		if pgKey == "password" {
 			continue
 		}
+		if mask != MaskFull && IsSensitiveField(collection, mongoKey) {
+			continue
+		}
 		orConds = append(orConds, bson.M{
 			"properties." + mongoKey: searchRegex,
 		})
@@ -624,7 +649,9 @@ func GetFacetValues(pwaCode, collection string) ([]FacetResult, error) {
 		bson.M{"$facet": facetStage},
 	}
 
+	start := time.Now()
 	cursor, err := coll.Aggregate(ctx, pipeline)
+	MongoOpSeconds.ObserveSince(start, "aggregate", collection)
 	if err != nil {
 		return nil, fmt.Errorf("facet aggregation error: %w", err)
 	}
@@ -159,6 +159,23 @@ var FieldMapping = map[string]map[string]string{
 	"pwa_waterworks": {},
 }
 
+// FieldSensitivity จัดประเภทข้อมูลส่วนบุคคล (PII) ของลูกค้า
+// key = MongoDB field name, value = ประเภทข้อมูล (Sensitivity*)
+// การปิดบังข้อมูลตามระดับสิทธิ์ดูที่ pii_masking.go
+var FieldSensitivity = map[string]map[string]string{
+	"meter": {
+		"custCode":     SensitivityCode,
+		"custFullName": SensitivityName,
+		"addressNo":    SensitivityAddress,
+	},
+	"bldg": {
+		"custCode":     SensitivityCode,
+		"custCodeOld":  SensitivityCode,
+		"custFullName": SensitivityName,
+		"addressNo":    SensitivityAddress,
+	},
+}
+
 // MapProperties - แปลง MongoDB properties ให้ใช้ชื่อ field แบบ Postgres
 // เฉพาะ field ที่อยู่ใน mapping เท่านั้นจะถูกส่งออก
 func MapProperties(collectionType string, mongoProps map[string]interface{}) map[string]interface{} {
@@ -72,7 +72,8 @@ type fgbFeature struct {
 }
 
 // ExportAsFlatGeobuf queries MongoDB and returns a FlatGeobuf binary file.
-func ExportAsFlatGeobuf(pwaCode, layerName, startDate, endDate string) ([]byte, error) {
+// Sensitive fields are masked with mask, as in ExportFeaturesAsGeoJSON.
+func ExportAsFlatGeobuf(pwaCode, layerName, startDate, endDate string, mask MaskMode) ([]byte, error) {
 	collectionID, err := FindCollectionID(pwaCode, layerName)
 	if err != nil {
 		return nil, fmt.Errorf("collection not found: %s_%s", pwaCode, layerName)
@@ -143,6 +144,13 @@ func ExportAsFlatGeobuf(pwaCode, layerName, startDate, endDate string) ([]byte,
 		// Parse properties
 		if props, ok := doc["properties"].(bson.M); ok {
 			for k, v := range props {
+				// Sensitive fields: drop, or mask to a string column
+				if cls := sensitivityClass(layerName, k); cls != "" && mask != MaskFull {
+					if mask == MaskRemoved {
+						continue
+					}
+					v = maskValue(cls, v)
+				}
 				switch val := v.(type) {
 				case string:
 					feat.Props[k] = val
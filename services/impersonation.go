@@ -0,0 +1,79 @@
+// Package services/impersonation.go
+// Looks up what another employee would see, for admin "view as user".
+//
+// We cannot log in as the user (no password), so the profile comes from
+// their most recent successful login event in audit_logs.pwagis_track_log
+// (written by handlers.logAuthEvent). When that event carries the intranet
+// department/division/job, the permission is re-resolved against the
+// current RBAC rules so the admin sees exactly what the next login would get.
+package services
+
+import (
+	"database/sql"
+	"encoding/json"
+	"fmt"
+	"time"
+
+	"pwa_gis_tracking/config"
+)
+
+// UserProfile is the resolved scope of one employee.
+type UserProfile struct {
+	UserID         string    `json:"user_id"`
+	UserName       string    `json:"user_name"`
+	PwaCode        string    `json:"pwa_code"`
+	Zone           string    `json:"area"`
+	Permission     string    `json:"permission"`
+	PermissionLeak string    `json:"permission_leak"`
+	DepName        string    `json:"dep_name,omitempty"`
+	DivName        string    `json:"div_name,omitempty"`
+	JobName        string    `json:"job_name,omitempty"`
+	LastLoginAt    time.Time `json:"last_login_at"`
+	Reresolved     bool      `json:"reresolved"` // permission recomputed from current rules
+}
+
+// ErrNoLoginHistory is returned when the employee has never logged in
+// (since login events were recorded).
+var ErrNoLoginHistory = fmt.Errorf("no login history for this employee")
+
+// LookupUserProfile returns the profile from the employee's latest login.
+func LookupUserProfile(userID string) (UserProfile, error) {
+	p := UserProfile{UserID: userID}
+	var payload string
+	err := config.PgDB.QueryRow(`
+		SELECT COALESCE(user_name, ''), COALESCE(target_value, ''), created_at
+		FROM audit_logs.pwagis_track_log
+		WHERE user_id = $1 AND action = 'login' AND target_type = 'auth'
+		ORDER BY created_at DESC
+		LIMIT 1
+	`, userID).Scan(&p.UserName, &payload, &p.LastLoginAt)
+	if err == sql.ErrNoRows {
+		return p, ErrNoLoginHistory
+	}
+	if err != nil {
+		return p, fmt.Errorf("lookup login history failed: %v", err)
+	}
+
+	var ev struct {
+		PwaCode        string `json:"pwa_code"`
+		Permission     string `json:"permission"`
+		PermissionLeak string `json:"permission_leak"`
+		Zone           string `json:"zone"`
+		DepName        string `json:"dep_name"`
+		DivName        string `json:"div_name"`
+		JobName        string `json:"job_name"`
+	}
+	if err := json.Unmarshal([]byte(payload), &ev); err != nil {
+		return p, fmt.Errorf("malformed login event: %v", err)
+	}
+	p.PwaCode, p.Zone = ev.PwaCode, ev.Zone
+	p.Permission, p.PermissionLeak = ev.Permission, ev.PermissionLeak
+	p.DepName, p.DivName, p.JobName = ev.DepName, ev.DivName, ev.JobName
+
+	if p.DepName != "" || p.DivName != "" || p.JobName != "" {
+		perm := ResolvePermission(p.DepName, p.DivName, p.JobName, userID)
+		p.Permission, p.PermissionLeak = perm.Permission, perm.PermissionLeak
+		p.Reresolved = true
+	}
+	return p, nil
+}
@@ -2,24 +2,31 @@
 // Wraps the HTTP call to the PWA intranet authentication endpoint,
 // replicating the PHP curl + JSON-cleanup pattern in check_user.php.
 //
-// NOTE: We shell out to curl.exe because the PWA intranet (IIS/8.5) forcibly
-// rejects Go's native TLS Client Hello. curl.exe uses Windows' schannel which
-// the intranet server accepts without issues.
+// Two transports are provided (see authenticator.go for selection):
+//   - IntranetHTTPAuthenticator — native net/http with configurable TLS
+//   - CurlAuthenticator         — shells out to curl; kept as a fallback because
+//     the PWA intranet (IIS/8.5) has rejected Go's TLS Client Hello in the past,
+//     while curl.exe with Windows schannel is accepted.
 package services
 
 import (
 	"context"
 	"crypto/md5" //nolint:gosec // Legacy API requires MD5 hashing
+	"crypto/tls"
+	"crypto/x509"
 	"encoding/json"
 	"fmt"
+	"io"
+	"net/http"
 	"net/url"
+	"os"
 	"os/exec"
 	"strings"
 	"time"
 )
 
-// intranetBaseURL is the upstream authentication endpoint.
-const intranetBaseURL = "https://intranet.pwa.co.th/login/app_gis.php"
+// defaultIntranetURL is the upstream authentication endpoint.
+const defaultIntranetURL = "https://intranet.pwa.co.th/login/app_gis.php"
 
 // IntranetUser holds the fields returned by the upstream API that we care about.
 // Field names match the JSON keys observed in the PHP code.
@@ -45,40 +52,142 @@ func hashPassword(plain string) string {
 	return fmt.Sprintf("%x", md5.Sum([]byte(plain)))
 }
 
-// AuthenticateIntranet calls the PWA intranet API via curl.exe and returns
-// the decoded user on success.
-//
-// We use curl.exe because Go's crypto/tls is incompatible with the legacy
-// IIS/8.5 TLS stack (connection forcibly closed during handshake).
-// curl.exe uses Windows schannel which handles the renegotiation correctly.
-func AuthenticateIntranet(username, password string) (*IntranetUser, error) {
-	// Build target URL
+// intranetRequestURL builds the GET URL with the username and MD5 password.
+func intranetRequestURL(baseURL, username, password string) string {
 	params := url.Values{}
 	params.Set("u", username)
 	params.Set("p", hashPassword(password))
-	target := intranetBaseURL + "?" + params.Encode()
+	return baseURL + "?" + params.Encode()
+}
 
-	// 15-second timeout
-	ctx, cancel := context.WithTimeout(context.Background(), 15*time.Second)
-	defer cancel()
+// decodeIntranetResponse replicates PHP's character-stripping and decodes
+// the JSONP-ish body into an IntranetUser:
+//
+//	$json = str_replace("(", "", $json);
+//	$json = str_replace(")", "", $json);
+//	$json = str_replace(";", "", $json);
+func decodeIntranetResponse(body []byte) (*IntranetUser, error) {
+	cleaned := strings.NewReplacer("(", "", ")", "", ";", "").Replace(string(body))
 
-	// Shell out to curl.exe (-k = skip TLS verify, -s = silent, -S = show errors)
-	cmd := exec.CommandContext(ctx, `C:\Windows\System32\curl.exe`, "-k", "-s", "-S", target)
-	output, err := cmd.Output()
+	var user IntranetUser
+	if err := json.Unmarshal([]byte(cleaned), &user); err != nil {
+		return nil, fmt.Errorf("intranet JSON decode failed: %w (body: %.200s)", err, cleaned)
+	}
+	return &user, nil
+}
+
+// ─── Native net/http ─────────────────────────────────────────────────────────
+
+// IntranetTLSConfig holds the TLS knobs for the native client.
+type IntranetTLSConfig struct {
+	InsecureSkipVerify bool   // INTRANET_TLS_INSECURE=true (mirrors curl -k)
+	CAFile             string // INTRANET_TLS_CA_FILE — extra PEM root(s)
+	MinVersion         uint16 // INTRANET_TLS_MIN_VERSION=1.0|1.1|1.2|1.3
+	MaxVersion         uint16 // INTRANET_TLS_MAX_VERSION=1.0|1.1|1.2|1.3
+	Renegotiation      bool   // INTRANET_TLS_RENEGOTIATE=true (legacy IIS)
+}
+
+// IntranetHTTPAuthenticator calls the intranet API with Go's net/http client.
+type IntranetHTTPAuthenticator struct {
+	BaseURL string
+	client  *http.Client
+}
+
+// NewIntranetHTTPAuthenticator builds the native client with the given TLS settings.
+func NewIntranetHTTPAuthenticator(baseURL string, tlsCfg IntranetTLSConfig, timeout time.Duration) (*IntranetHTTPAuthenticator, error) {
+	tc := &tls.Config{
+		InsecureSkipVerify: tlsCfg.InsecureSkipVerify, //nolint:gosec // opt-in via env, mirrors curl -k
+		MinVersion:         tlsCfg.MinVersion,
+		MaxVersion:         tlsCfg.MaxVersion,
+	}
+	if tlsCfg.Renegotiation {
+		tc.Renegotiation = tls.RenegotiateOnceAsClient
+	}
+	if tlsCfg.CAFile != "" {
+		pem, err := os.ReadFile(tlsCfg.CAFile)
+		if err != nil {
+			return nil, fmt.Errorf("read CA file: %w", err)
+		}
+		pool, err := x509.SystemCertPool()
+		if err != nil || pool == nil {
+			pool = x509.NewCertPool()
+		}
+		if !pool.AppendCertsFromPEM(pem) {
+			return nil, fmt.Errorf("no certificates found in %s", tlsCfg.CAFile)
+		}
+		tc.RootCAs = pool
+	}
+
+	transport := http.DefaultTransport.(*http.Transport).Clone()
+	transport.TLSClientConfig = tc
+
+	return &IntranetHTTPAuthenticator{
+		BaseURL: baseURL,
+		client:  &http.Client{Timeout: timeout, Transport: transport},
+	}, nil
+}
+
+// Name implements Authenticator.
+func (a *IntranetHTTPAuthenticator) Name() string { return "intranet" }
+
+// Authenticate implements Authenticator.
+func (a *IntranetHTTPAuthenticator) Authenticate(ctx context.Context, username, password string) (*IntranetUser, error) {
+	req, err := http.NewRequestWithContext(ctx, http.MethodGet, intranetRequestURL(a.BaseURL, username, password), nil)
 	if err != nil {
-		return nil, fmt.Errorf("intranet request failed (curl): %w", err)
+		return nil, fmt.Errorf("intranet request build failed: %w", err)
 	}
 
-	// Replicate PHP's character-stripping:
-	//   $json = str_replace("(", "", $json);
-	//   $json = str_replace(")", "", $json);
-	//   $json = str_replace(";", "", $json);
-	cleaned := strings.NewReplacer("(", "", ")", "", ";", "").Replace(string(output))
+	resp, err := a.client.Do(req)
+	if err != nil {
+		return nil, fmt.Errorf("intranet request failed (http): %w", err)
+	}
+	defer resp.Body.Close()
 
-	var user IntranetUser
-	if err := json.Unmarshal([]byte(cleaned), &user); err != nil {
-		return nil, fmt.Errorf("intranet JSON decode failed: %w (body: %.200s)", err, cleaned)
+	body, err := io.ReadAll(io.LimitReader(resp.Body, 1<<20))
+	if err != nil {
+		return nil, fmt.Errorf("intranet read failed: %w", err)
+	}
+	if resp.StatusCode != http.StatusOK {
+		return nil, fmt.Errorf("intranet returned HTTP %d (body: %.200s)", resp.StatusCode, body)
 	}
 
-	return &user, nil
-}
\ No newline at end of file
+	return decodeIntranetResponse(body)
+}
+
+// ─── curl fallback ───────────────────────────────────────────────────────────
+
+// CurlAuthenticator shells out to curl (-s -S), as the original
+// implementation did with C:\Windows\System32\curl.exe. The certificate is
+// verified unless Insecure is set (-k, INTRANET_TLS_INSECURE=true).
+type CurlAuthenticator struct {
+	BaseURL  string
+	CurlPath string // INTRANET_CURL_PATH; "curl" resolves via PATH on Linux and Windows
+	Insecure bool   // INTRANET_TLS_INSECURE=true → curl -k
+	CAFile   string // INTRANET_TLS_CA_FILE → curl --cacert
+}
+
+// Name implements Authenticator.
+func (a *CurlAuthenticator) Name() string { return "curl" }
+
+// Authenticate implements Authenticator.
+func (a *CurlAuthenticator) Authenticate(ctx context.Context, username, password string) (*IntranetUser, error) {
+	curlPath := a.CurlPath
+	if curlPath == "" {
+		curlPath = "curl"
+	}
+
+	args := []string{"-s", "-S"}
+	if a.Insecure {
+		args = append(args, "-k")
+	}
+	if a.CAFile != "" {
+		args = append(args, "--cacert", a.CAFile)
+	}
+	cmd := exec.CommandContext(ctx, curlPath, append(args, intranetRequestURL(a.BaseURL, username, password))...)
+	output, err := cmd.Output()
+	if err != nil {
+		return nil, fmt.Errorf("intranet request failed (curl): %w", err)
+	}
+
+	return decodeIntranetResponse(output)
+}
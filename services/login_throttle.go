@@ -0,0 +1,377 @@
+// Package services/login_throttle.go
+// Brute-force protection for HandleLogin.
+//
+// Failed logins are counted per employee ID and per client IP in
+// rbac.pwagis_login_attempt (see sql/create_login_throttle.sql), so every app
+// instance sees the same counters. For each key:
+//
+//	failures < BackoffAfter              → no delay
+//	BackoffAfter ≤ failures < LockAfter  → wait BackoffBase·2^(n-BackoffAfter) after the last failure
+//	failures ≥ LockAfter                 → locked for LockDuration·2^(n-LockAfter), capped at MaxLock
+//
+// Every attempt is counted before the intranet is contacted
+// (BeginLoginAttempt), so parallel requests cannot all pass the check; a
+// successful login clears the employee-ID counter, and attempts that were not
+// a wrong password are taken back. Counters restart when the last failure is
+// older than Window. All times are measured by the database clock. Admins can
+// unlock a key early via UnlockLogin.
+package services
+
+import (
+	"database/sql"
+	"fmt"
+	"log"
+	"strconv"
+	"time"
+
+	"pwa_gis_tracking/config"
+)
+
+// Throttle scopes.
+const (
+	LoginScopeUser = "user"
+	LoginScopeIP   = "ip"
+)
+
+// LoginThrottlePolicy holds the limits for one scope.
+type LoginThrottlePolicy struct {
+	BackoffAfter int           // failures before backoff starts
+	BackoffBase  time.Duration // first backoff delay
+	MaxBackoff   time.Duration
+	LockAfter    int           // failures before lockout
+	LockDuration time.Duration // first lockout length
+	MaxLock      time.Duration
+	Window       time.Duration // failures older than this are forgotten
+}
+
+// Default policies. IPs get a higher threshold because offices share NAT.
+var (
+	UserLoginPolicy = LoginThrottlePolicy{
+		BackoffAfter: 3, BackoffBase: 2 * time.Second, MaxBackoff: time.Minute,
+		LockAfter: 10, LockDuration: 15 * time.Minute, MaxLock: 24 * time.Hour,
+		Window: time.Hour,
+	}
+	IPLoginPolicy = LoginThrottlePolicy{
+		BackoffAfter: 20, BackoffBase: time.Second, MaxBackoff: 30 * time.Second,
+		LockAfter: 100, LockDuration: 15 * time.Minute, MaxLock: 6 * time.Hour,
+		Window: time.Hour,
+	}
+)
+
+// InitLoginThrottle overrides the lockout thresholds from env vars:
+// LOGIN_LOCK_AFTER_USER, LOGIN_LOCK_AFTER_IP, LOGIN_LOCK_DURATION.
+func InitLoginThrottle() {
+	if n, err := strconv.Atoi(envOr("LOGIN_LOCK_AFTER_USER", "")); err == nil && n > 0 {
+		UserLoginPolicy.LockAfter = n
+	}
+	if n, err := strconv.Atoi(envOr("LOGIN_LOCK_AFTER_IP", "")); err == nil && n > 0 {
+		IPLoginPolicy.LockAfter = n
+	}
+	if d, err := time.ParseDuration(envOr("LOGIN_LOCK_DURATION", "")); err == nil && d > 0 {
+		UserLoginPolicy.LockDuration = d
+		IPLoginPolicy.LockDuration = d
+	}
+	log.Printf("Login throttle: user lock after %d, ip lock after %d, lock %v",
+		UserLoginPolicy.LockAfter, IPLoginPolicy.LockAfter, UserLoginPolicy.LockDuration)
+}
+
+func loginPolicy(scope string) LoginThrottlePolicy {
+	if scope == LoginScopeIP {
+		return IPLoginPolicy
+	}
+	return UserLoginPolicy
+}
+
+// backoff returns the wait after the n-th consecutive failure.
+func (p LoginThrottlePolicy) backoff(n int) time.Duration {
+	if n < p.BackoffAfter {
+		return 0
+	}
+	return doubleCapped(p.BackoffBase, n-p.BackoffAfter, p.MaxBackoff)
+}
+
+// lockout returns the lock length after the n-th failure (0 = not locked).
+func (p LoginThrottlePolicy) lockout(n int) time.Duration {
+	if n < p.LockAfter {
+		return 0
+	}
+	return doubleCapped(p.LockDuration, n-p.LockAfter, p.MaxLock)
+}
+
+func doubleCapped(base time.Duration, exp int, max time.Duration) time.Duration {
+	d := base
+	for i := 0; i < exp && d < max; i++ {
+		d *= 2
+	}
+	if d > max {
+		d = max
+	}
+	return d
+}
+
+// LoginAttempt is one row of rbac.pwagis_login_attempt.
+type LoginAttempt struct {
+	Scope          string     `json:"scope"`
+	Key            string     `json:"key"`
+	FailCount      int        `json:"fail_count"`
+	FirstFailureAt time.Time  `json:"first_failure_at"`
+	LastFailureAt  time.Time  `json:"last_failure_at"`
+	LockedUntil    *time.Time `json:"locked_until,omitempty"`
+	RetryIn        int        `json:"retry_in"` // seconds until the next attempt is accepted
+
+	// Measured against the database clock (NOW()), not time.Now(): the
+	// columns are TIMESTAMP without time zone.
+	sinceLast time.Duration // NOW() - last_failure_at
+	lockLeft  time.Duration // locked_until - NOW(), <= 0 when not locked
+}
+
+// LoginBlock describes why a login attempt is refused.
+type LoginBlock struct {
+	Scope     string        // LoginScopeUser | LoginScopeIP
+	Locked    bool          // true = lockout, false = backoff
+	RetryIn   time.Duration // time until the next attempt is accepted
+	FailCount int
+}
+
+// retryAfter returns how long the row blocks new attempts (0 = allowed).
+func (a LoginAttempt) retryAfter() (time.Duration, bool) {
+	if a.lockLeft > 0 {
+		return a.lockLeft, true
+	}
+	p := loginPolicy(a.Scope)
+	if a.sinceLast > p.Window {
+		return 0, false
+	}
+	if wait := p.backoff(a.FailCount) - a.sinceLast; wait > 0 {
+		return wait, false
+	}
+	return 0, false
+}
+
+// BeginLoginAttempt counts a login for userID from ip against both counters
+// before the intranet is contacted, and returns a block instead when either
+// key is backing off or locked. Check and increment run in one transaction
+// holding the counter rows, so a parallel burst is serialised: each request
+// sees the attempts counted before it.
+//
+// The attempt stays counted as a failure unless the caller reports the
+// outcome with ResetLoginFailures (success) or CancelLoginAttempt (not the
+// user's fault). Fails open (logs and allows) when PostgreSQL is unavailable.
+func BeginLoginAttempt(userID, ip string) *LoginBlock {
+	if config.PgDB == nil {
+		return nil
+	}
+	block, err := beginLoginAttempt(loginKeys(userID, ip))
+	if err != nil {
+		log.Printf("[LoginThrottle] begin user=%s ip=%s failed: %v", userID, ip, err)
+		return nil
+	}
+	return block
+}
+
+func beginLoginAttempt(keys [][2]string) (*LoginBlock, error) {
+	tx, err := config.PgDB.Begin()
+	if err != nil {
+		return nil, err
+	}
+	defer tx.Rollback()
+
+	var block *LoginBlock
+	for _, k := range keys {
+		a, err := lockLoginAttempt(tx, k[0], k[1])
+		if err != nil {
+			return nil, fmt.Errorf("%s=%s: %v", k[0], k[1], err)
+		}
+		wait, locked := a.retryAfter()
+		if wait > 0 && (block == nil || wait > block.RetryIn) {
+			block = &LoginBlock{Scope: a.Scope, Locked: locked, RetryIn: wait, FailCount: a.FailCount}
+		}
+	}
+	if block != nil {
+		return block, nil // refused attempts are not counted
+	}
+
+	for _, k := range keys {
+		if err := incrementLoginFailure(tx, k[0], k[1]); err != nil {
+			return nil, fmt.Errorf("%s=%s: %v", k[0], k[1], err)
+		}
+	}
+	return nil, tx.Commit()
+}
+
+// CancelLoginAttempt takes back the attempt counted by BeginLoginAttempt,
+// for outcomes that are not a guessed password (intranet outage, valid
+// credentials without rights).
+func CancelLoginAttempt(userID, ip string) {
+	if config.PgDB == nil {
+		return
+	}
+	for _, k := range loginKeys(userID, ip) {
+		if err := releaseLoginAttempt(k[0], k[1]); err != nil {
+			log.Printf("[LoginThrottle] cancel %s=%s failed: %v", k[0], k[1], err)
+		}
+	}
+}
+
+// ResetLoginFailures clears the employee-ID counter after a successful login
+// and takes back the attempt counted against ip. The rest of the IP counter
+// is left to expire so one valid account cannot reset it.
+func ResetLoginFailures(userID, ip string) {
+	if config.PgDB == nil || userID == "" {
+		return
+	}
+	if _, err := config.PgDB.Exec(`
+		DELETE FROM rbac.pwagis_login_attempt WHERE scope = $1 AND key = $2
+	`, LoginScopeUser, userID); err != nil {
+		log.Printf("[LoginThrottle] reset user=%s failed: %v", userID, err)
+	}
+	if ip == "" {
+		return
+	}
+	if err := releaseLoginAttempt(LoginScopeIP, ip); err != nil {
+		log.Printf("[LoginThrottle] release ip=%s failed: %v", ip, err)
+	}
+}
+
+// ListLoginLockouts returns keys that are locked or currently backing off.
+func ListLoginLockouts() ([]LoginAttempt, error) {
+	rows, err := config.PgDB.Query(`SELECT ` + loginAttemptColumns + `
+		FROM rbac.pwagis_login_attempt
+		ORDER BY last_failure_at DESC`)
+	if err != nil {
+		return nil, fmt.Errorf("query login attempts failed: %v", err)
+	}
+	defer rows.Close()
+
+	result := []LoginAttempt{}
+	for rows.Next() {
+		a, err := scanLoginAttempt(rows)
+		if err != nil {
+			continue
+		}
+		if wait, _ := a.retryAfter(); wait > 0 {
+			a.RetryIn = int(wait.Seconds()) + 1
+			result = append(result, a)
+		}
+	}
+	return result, rows.Err()
+}
+
+// UnlockLogin removes the counter for one key. Returns sql.ErrNoRows when
+// the key has no recorded failures.
+func UnlockLogin(scope, key string) error {
+	if scope != LoginScopeUser && scope != LoginScopeIP {
+		return fmt.Errorf("invalid scope: %s", scope)
+	}
+	res, err := config.PgDB.Exec(`
+		DELETE FROM rbac.pwagis_login_attempt WHERE scope = $1 AND key = $2
+	`, scope, key)
+	if err != nil {
+		return fmt.Errorf("unlock failed: %v", err)
+	}
+	if n, _ := res.RowsAffected(); n == 0 {
+		return sql.ErrNoRows
+	}
+	return nil
+}
+
+// ─── Helpers ────────────────────────────────────────────────────────────────
+
+// loginAttemptColumns includes the row age and remaining lock measured by
+// the database clock (see LoginAttempt).
+const loginAttemptColumns = `scope, key, fail_count, first_failure_at, last_failure_at, locked_until,
+	EXTRACT(EPOCH FROM NOW() - last_failure_at),
+	COALESCE(EXTRACT(EPOCH FROM locked_until - NOW()), 0)`
+
+func scanLoginAttempt(s rowScanner) (LoginAttempt, error) {
+	var a LoginAttempt
+	var locked sql.NullTime
+	var sinceLast, lockLeft float64
+	if err := s.Scan(&a.Scope, &a.Key, &a.FailCount, &a.FirstFailureAt, &a.LastFailureAt, &locked,
+		&sinceLast, &lockLeft); err != nil {
+		return LoginAttempt{}, err
+	}
+	if locked.Valid {
+		a.LockedUntil = &locked.Time
+	}
+	a.sinceLast = time.Duration(sinceLast * float64(time.Second))
+	a.lockLeft = time.Duration(lockLeft * float64(time.Second))
+	return a, nil
+}
+
+// loginKeys returns the counters a login touches, user before IP so that
+// concurrent transactions lock rows in the same order.
+func loginKeys(userID, ip string) [][2]string {
+	var keys [][2]string
+	for _, k := range [][2]string{{LoginScopeUser, userID}, {LoginScopeIP, ip}} {
+		if k[1] != "" {
+			keys = append(keys, k)
+		}
+	}
+	return keys
+}
+
+// lockLoginAttempt reads one counter FOR UPDATE, creating an empty row
+// first so that there is something to lock. A concurrent insert of the same
+// key fails the transaction; the login then fails open like any other error.
+// INSERT ... WHERE NOT EXISTS instead of ON CONFLICT (PostgreSQL 9.4 compatible).
+func lockLoginAttempt(tx *sql.Tx, scope, key string) (LoginAttempt, error) {
+	if _, err := tx.Exec(`
+		INSERT INTO rbac.pwagis_login_attempt (scope, key, fail_count)
+		SELECT $1, $2, 0
+		WHERE NOT EXISTS (SELECT 1 FROM rbac.pwagis_login_attempt WHERE scope = $1 AND key = $2)
+	`, scope, key); err != nil {
+		return LoginAttempt{}, err
+	}
+	row := tx.QueryRow(`SELECT `+loginAttemptColumns+`
+		FROM rbac.pwagis_login_attempt WHERE scope = $1 AND key = $2
+		FOR UPDATE`, scope, key)
+	return scanLoginAttempt(row)
+}
+
+// incrementLoginFailure bumps one counter locked by lockLoginAttempt
+// (restarting it outside the window) and sets locked_until once the lockout
+// threshold is reached.
+func incrementLoginFailure(tx *sql.Tx, scope, key string) error {
+	p := loginPolicy(scope)
+	window := int(p.Window.Seconds())
+
+	var n int
+	err := tx.QueryRow(`
+		UPDATE rbac.pwagis_login_attempt SET
+			fail_count       = CASE WHEN last_failure_at < NOW() - ($3 * INTERVAL '1 second')
+			                        THEN 1 ELSE fail_count + 1 END,
+			first_failure_at = CASE WHEN last_failure_at < NOW() - ($3 * INTERVAL '1 second') OR fail_count = 0
+			                        THEN NOW() ELSE first_failure_at END,
+			last_failure_at  = NOW()
+		WHERE scope = $1 AND key = $2
+		RETURNING fail_count
+	`, scope, key, window).Scan(&n)
+	if err != nil {
+		return err
+	}
+
+	if lock := p.lockout(n); lock > 0 {
+		_, err = tx.Exec(`
+			UPDATE rbac.pwagis_login_attempt
+			SET locked_until = NOW() + ($3 * INTERVAL '1 second')
+			WHERE scope = $1 AND key = $2
+		`, scope, key, int(lock.Seconds()))
+		if err == nil {
+			log.Printf("[LoginThrottle] %s=%s locked for %v after %d failures", scope, key, lock, n)
+		}
+	}
+	return err
+}
+
+// releaseLoginAttempt takes one attempt off a counter, lifting a lockout that
+// this attempt triggered.
+func releaseLoginAttempt(scope, key string) error {
+	_, err := config.PgDB.Exec(`
+		UPDATE rbac.pwagis_login_attempt SET
+			fail_count   = GREATEST(fail_count - 1, 0),
+			locked_until = CASE WHEN fail_count - 1 < $3 THEN NULL ELSE locked_until END
+		WHERE scope = $1 AND key = $2
+	`, scope, key, loginPolicy(scope).LockAfter)
+	return err
+}
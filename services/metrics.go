@@ -0,0 +1,319 @@
+// Package services/metrics.go
+// Minimal Prometheus metrics (text exposition format 0.0.4).
+//
+// Counters, histograms and gauge callbacks register themselves on creation
+// and WriteMetrics renders all of them for GET /metrics. Label values must
+// come from a small fixed set (routes, layers, formats) — never from user
+// input that has not been validated.
+//
+// Metrics recorded in services:
+//
+//	pwagis_mongo_operation_duration_seconds{op,layer}      CountDocuments / aggregate
+//	pwagis_export_tool_duration_seconds{tool,driver,result} ogr2ogr, tippecanoe, pmtiles
+//	pwagis_postgres_connections{state}                      database/sql pool
+package services
+
+import (
+	"bufio"
+	"fmt"
+	"io"
+	"math"
+	"os/exec"
+	"path/filepath"
+	"runtime"
+	"sort"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+
+	"pwa_gis_tracking/config"
+)
+
+// DurationBuckets suit request and query latencies (seconds).
+var DurationBuckets = []float64{.005, .01, .025, .05, .1, .25, .5, 1, 2.5, 5, 10, 30}
+
+// LongDurationBuckets suit exports and warm cycles (seconds).
+var LongDurationBuckets = []float64{.1, .5, 1, 2.5, 5, 10, 30, 60, 120, 300, 600}
+
+// SizeBuckets suit export file sizes (bytes, 1 KB – 1 GB).
+var SizeBuckets = []float64{1 << 10, 16 << 10, 256 << 10, 1 << 20, 4 << 20, 16 << 20, 64 << 20, 256 << 20, 1 << 30}
+
+type metric interface {
+	write(w *bufio.Writer)
+}
+
+var (
+	metricsMu sync.Mutex
+	metrics   []metric
+)
+
+func registerMetric(m metric) {
+	metricsMu.Lock()
+	metrics = append(metrics, m)
+	metricsMu.Unlock()
+}
+
+// WriteMetrics renders every registered metric in registration order.
+func WriteMetrics(out io.Writer) error {
+	metricsMu.Lock()
+	list := append([]metric(nil), metrics...)
+	metricsMu.Unlock()
+
+	w := bufio.NewWriter(out)
+	for _, m := range list {
+		m.write(w)
+	}
+	return w.Flush()
+}
+
+// ─── Counter ─────────────────────────────────────────────────────────────────
+
+// CounterVec is a counter with labels.
+type CounterVec struct {
+	name, help string
+	labels     []string
+
+	mu     sync.Mutex
+	series map[string]*counterSeries
+}
+
+type counterSeries struct {
+	values []string
+	v      float64
+}
+
+// NewCounterVec creates and registers a counter.
+func NewCounterVec(name, help string, labels ...string) *CounterVec {
+	c := &CounterVec{name: name, help: help, labels: labels, series: make(map[string]*counterSeries)}
+	registerMetric(c)
+	return c
+}
+
+// Add adds v to the series of labelValues (in label order).
+func (c *CounterVec) Add(v float64, labelValues ...string) {
+	key := strings.Join(labelValues, "\xff")
+	c.mu.Lock()
+	s, ok := c.series[key]
+	if !ok {
+		s = &counterSeries{values: labelValues}
+		c.series[key] = s
+	}
+	s.v += v
+	c.mu.Unlock()
+}
+
+// Inc adds 1.
+func (c *CounterVec) Inc(labelValues ...string) { c.Add(1, labelValues...) }
+
+func (c *CounterVec) write(w *bufio.Writer) {
+	writeHeader(w, c.name, c.help, "counter")
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	for _, key := range sortedKeys(c.series) {
+		s := c.series[key]
+		fmt.Fprintf(w, "%s%s %s\n", c.name, formatLabels(c.labels, s.values, "", ""), formatValue(s.v))
+	}
+}
+
+// ─── Histogram ───────────────────────────────────────────────────────────────
+
+// HistogramVec is a histogram with labels.
+type HistogramVec struct {
+	name, help string
+	labels     []string
+	buckets    []float64
+
+	mu     sync.Mutex
+	series map[string]*histogramSeries
+}
+
+type histogramSeries struct {
+	values []string
+	counts []uint64 // per bucket, not cumulative
+	count  uint64
+	sum    float64
+}
+
+// NewHistogramVec creates and registers a histogram with upper bounds buckets.
+func NewHistogramVec(name, help string, buckets []float64, labels ...string) *HistogramVec {
+	h := &HistogramVec{name: name, help: help, labels: labels, buckets: buckets, series: make(map[string]*histogramSeries)}
+	registerMetric(h)
+	return h
+}
+
+// Observe records v in the series of labelValues (in label order).
+func (h *HistogramVec) Observe(v float64, labelValues ...string) {
+	key := strings.Join(labelValues, "\xff")
+	i := sort.SearchFloat64s(h.buckets, v) // first bucket with bound >= v
+	h.mu.Lock()
+	s, ok := h.series[key]
+	if !ok {
+		s = &histogramSeries{values: labelValues, counts: make([]uint64, len(h.buckets))}
+		h.series[key] = s
+	}
+	if i < len(h.buckets) {
+		s.counts[i]++
+	}
+	s.count++
+	s.sum += v
+	h.mu.Unlock()
+}
+
+// ObserveSince records the seconds elapsed since start.
+func (h *HistogramVec) ObserveSince(start time.Time, labelValues ...string) {
+	h.Observe(time.Since(start).Seconds(), labelValues...)
+}
+
+func (h *HistogramVec) write(w *bufio.Writer) {
+	writeHeader(w, h.name, h.help, "histogram")
+	h.mu.Lock()
+	defer h.mu.Unlock()
+	for _, key := range sortedKeys(h.series) {
+		s := h.series[key]
+		var cum uint64
+		for i, bound := range h.buckets {
+			cum += s.counts[i]
+			fmt.Fprintf(w, "%s_bucket%s %d\n", h.name, formatLabels(h.labels, s.values, "le", formatValue(bound)), cum)
+		}
+		fmt.Fprintf(w, "%s_bucket%s %d\n", h.name, formatLabels(h.labels, s.values, "le", "+Inf"), s.count)
+		fmt.Fprintf(w, "%s_sum%s %s\n", h.name, formatLabels(h.labels, s.values, "", ""), formatValue(s.sum))
+		fmt.Fprintf(w, "%s_count%s %d\n", h.name, formatLabels(h.labels, s.values, "", ""), s.count)
+	}
+}
+
+// ─── Gauge ───────────────────────────────────────────────────────────────────
+
+// gaugeFunc is a gauge whose series are read when metrics are scraped.
+type gaugeFunc struct {
+	name, help string
+	label      string // "" for a single unlabelled series
+	fn         func() map[string]float64
+}
+
+// NewGaugeFunc registers a gauge that reports fn() at scrape time.
+func NewGaugeFunc(name, help string, fn func() float64) {
+	registerMetric(&gaugeFunc{name: name, help: help, fn: func() map[string]float64 {
+		return map[string]float64{"": fn()}
+	}})
+}
+
+// NewGaugeVecFunc registers a gauge with one label; fn returns value per
+// label value.
+func NewGaugeVecFunc(name, help, label string, fn func() map[string]float64) {
+	registerMetric(&gaugeFunc{name: name, help: help, label: label, fn: fn})
+}
+
+func (g *gaugeFunc) write(w *bufio.Writer) {
+	writeHeader(w, g.name, g.help, "gauge")
+	values := g.fn()
+	for _, key := range sortedKeys(values) {
+		labels := ""
+		if g.label != "" {
+			labels = formatLabels([]string{g.label}, []string{key}, "", "")
+		}
+		fmt.Fprintf(w, "%s%s %s\n", g.name, labels, formatValue(values[key]))
+	}
+}
+
+// ─── Text format helpers ─────────────────────────────────────────────────────
+
+func writeHeader(w *bufio.Writer, name, help, typ string) {
+	fmt.Fprintf(w, "# HELP %s %s\n", name, strings.NewReplacer(`\`, `\\`, "\n", `\n`).Replace(help))
+	fmt.Fprintf(w, "# TYPE %s %s\n", name, typ)
+}
+
+var labelEscaper = strings.NewReplacer(`\`, `\\`, `"`, `\"`, "\n", `\n`)
+
+// formatLabels renders {a="x",b="y"}, with an extra label (le) if set.
+func formatLabels(names, values []string, extraName, extraValue string) string {
+	if len(names) == 0 && extraName == "" {
+		return ""
+	}
+	var b strings.Builder
+	b.WriteByte('{')
+	for i, n := range names {
+		if i > 0 {
+			b.WriteByte(',')
+		}
+		v := ""
+		if i < len(values) {
+			v = values[i]
+		}
+		b.WriteString(n + `="` + labelEscaper.Replace(v) + `"`)
+	}
+	if extraName != "" {
+		if len(names) > 0 {
+			b.WriteByte(',')
+		}
+		b.WriteString(extraName + `="` + extraValue + `"`)
+	}
+	b.WriteByte('}')
+	return b.String()
+}
+
+func formatValue(v float64) string {
+	switch {
+	case math.IsInf(v, 1):
+		return "+Inf"
+	case math.IsInf(v, -1):
+		return "-Inf"
+	}
+	return strconv.FormatFloat(v, 'g', -1, 64)
+}
+
+func sortedKeys[V any](m map[string]V) []string {
+	keys := make([]string, 0, len(m))
+	for k := range m {
+		keys = append(keys, k)
+	}
+	sort.Strings(keys)
+	return keys
+}
+
+// ─── Service metrics ─────────────────────────────────────────────────────────
+
+// MongoOpSeconds is the latency of feature CountDocuments / aggregate calls.
+var MongoOpSeconds = NewHistogramVec("pwagis_mongo_operation_duration_seconds",
+	"MongoDB feature collection operation latency by operation and layer.",
+	DurationBuckets, "op", "layer")
+
+// ExportToolSeconds is the run time of external export converters.
+var ExportToolSeconds = NewHistogramVec("pwagis_export_tool_duration_seconds",
+	"Run time of external export tools (ogr2ogr, tippecanoe, pmtiles) by output driver.",
+	LongDurationBuckets, "tool", "driver", "result")
+
+func init() {
+	NewGaugeVecFunc("pwagis_postgres_connections",
+		"PostgreSQL connection pool (database/sql) connections by state.", "state",
+		func() map[string]float64 {
+			if config.PgDB == nil {
+				return nil
+			}
+			st := config.PgDB.Stats()
+			return map[string]float64{"open": float64(st.OpenConnections), "in_use": float64(st.InUse), "idle": float64(st.Idle)}
+		})
+	NewGaugeFunc("pwagis_postgres_wait_duration_seconds",
+		"Total time blocked waiting for a PostgreSQL connection since startup.",
+		func() float64 {
+			if config.PgDB == nil {
+				return 0
+			}
+			return config.PgDB.Stats().WaitDuration.Seconds()
+		})
+	NewGaugeFunc("go_goroutines", "Number of goroutines that currently exist.",
+		func() float64 { return float64(runtime.NumGoroutine()) })
+}
+
+// runExportTool runs an export converter and records its run time.
+func runExportTool(cmd *exec.Cmd, driver string) ([]byte, error) {
+	start := time.Now()
+	out, err := cmd.CombinedOutput()
+	result := "ok"
+	if err != nil {
+		result = "error"
+	}
+	tool := strings.TrimSuffix(filepath.Base(cmd.Path), ".exe")
+	ExportToolSeconds.ObserveSince(start, tool, driver, result)
+	return out, err
+}
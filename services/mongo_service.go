@@ -45,10 +45,8 @@ func GetAllLayerNames() []string {
 
 // FindCollectionID looks up the MongoDB collection ObjectID from the "collections"
 // metadata collection using alias format: b{pwaCode}_{featureType}
+// Lookups are served from the in-memory index (collection_index.go).
 func FindCollectionID(pwaCode string, featureType string) (string, error) {
-	ctx, cancel := context.WithTimeout(context.Background(), 10*time.Second)
-	defer cancel()
-
 	// Prepend "b" prefix if not already present
 	code := pwaCode
 	if !strings.HasPrefix(code, "b") {
@@ -56,18 +54,14 @@ func FindCollectionID(pwaCode string, featureType string) (string, error) {
 	}
 
 	alias := fmt.Sprintf("%s_%s", code, featureType)
-	metaCollection := config.GetMongoCollection("collections")
-
-	log.Printf("FindCollectionID: alias=%s, db=%s", alias, config.MongoDBName)
-
-	var result struct {
-		ID primitive.ObjectID `bson:"_id"`
-	}
-	err := metaCollection.FindOne(ctx, bson.M{"alias": alias}).Decode(&result)
-	if err != nil {
+	if id, found, missing := collIndex.lookup(alias); found {
+		return id, nil
+	} else if missing {
 		return "", fmt.Errorf("collection not found: %s", alias)
 	}
-	return result.ID.Hex(), nil
+
+	log.Printf("FindCollectionID: alias=%s not indexed, db=%s", alias, config.MongoDBName)
+	return findCollectionIDUncached(alias)
 }
 
 // CountFeatures counts the number of documents in a feature collection.
@@ -96,7 +90,9 @@ func CountFeatures(pwaCode string, layerName string, startDate, endDate string)
 		}
 	}
 
+	start := time.Now()
 	count, err := featuresCol.CountDocuments(ctx, filter)
+	MongoOpSeconds.ObserveSince(start, "count", layerName)
 	if err != nil {
 		return 0, fmt.Errorf("count failed for %s_%s: %v", pwaCode, layerName, err)
 	}
@@ -247,7 +243,7 @@ func GetCollectionsForPwaCode(pwaCode string) ([]map[string]interface{}, error)
 
 // ExportFeaturesAsGeoJSON exports features as a GeoJSON FeatureCollection byte array.
 // Supports optional date range filtering. Returns all properties per feature.
-func ExportFeaturesAsGeoJSON(pwaCode, layerName, startDate, endDate string) ([]byte, error) {
+func ExportFeaturesAsGeoJSON(pwaCode, layerName, startDate, endDate string, mask MaskMode) ([]byte, error) {
 	collectionID, err := FindCollectionID(pwaCode, layerName)
 	if err != nil {
 		return nil, fmt.Errorf("collection not found: %s_%s", pwaCode, layerName)
@@ -316,7 +312,7 @@ func ExportFeaturesAsGeoJSON(pwaCode, layerName, startDate, endDate string) ([]b
 		features = append(features, Feature{
 			Type:       "Feature",
 			Geometry:   cleanBsonForJSON(geom),
-			Properties: props,
+			Properties: MaskProperties(layerName, props, mask),
 		})
 	}
 
@@ -382,7 +378,7 @@ func SumPipeLength(pwaCode string, startDate, endDate string) (float64, error) {
 	// Try each possible field name for pipe length
 	fieldNames := []string{"length", "PIPE_LONG", "pipe_long", "pipeLength", "PIPE_LEN", "pipe_len"}
 	for _, fieldName := range fieldNames {
-		total := sumFieldAsDouble(ctx, featuresCol, filter, "properties."+fieldName)
+		total := sumFieldAsDouble(ctx, featuresCol, filter, "properties."+fieldName, "pipe")
 		if total > 0 {
 			return total, nil
 		}
@@ -421,7 +417,7 @@ func SumPipeLengthExcludingSleeve(pwaCode string, startDate, endDate string) (fl
 
 	fieldNames := []string{"length", "PIPE_LONG", "pipe_long", "pipeLength", "PIPE_LEN", "pipe_len"}
 	for _, fieldName := range fieldNames {
-		total := sumFieldAsDouble(ctx, featuresCol, filter, "properties."+fieldName)
+		total := sumFieldAsDouble(ctx, featuresCol, filter, "properties."+fieldName, "pipe")
 		if total > 0 {
 			return total, nil
 		}
@@ -459,7 +455,9 @@ func CountActiveMeters(pwaCode string, startDate, endDate string) (int64, error)
 		}
 	}
 
+	start := time.Now()
 	count, err := featuresCol.CountDocuments(ctx, filter)
+	MongoOpSeconds.ObserveSince(start, "count", "meter")
 	if err != nil {
 		return 0, err
 	}
@@ -467,7 +465,8 @@ func CountActiveMeters(pwaCode string, startDate, endDate string) (int64, error)
 }
 
 // sumFieldAsDouble aggregates $sum on a field, converting string values to double.
-func sumFieldAsDouble(ctx context.Context, col *mongo.Collection, filter bson.M, field string) float64 {
+// layer labels the latency metric.
+func sumFieldAsDouble(ctx context.Context, col *mongo.Collection, filter bson.M, field, layer string) float64 {
 	pipeline := []bson.M{
 		{"$match": filter},
 		{"$group": bson.M{
@@ -485,7 +484,9 @@ func sumFieldAsDouble(ctx context.Context, col *mongo.Collection, filter bson.M,
 		}},
 	}
 
+	start := time.Now()
 	cursor, err := col.Aggregate(ctx, pipeline)
+	MongoOpSeconds.ObserveSince(start, "aggregate", layer)
 	if err != nil {
 		return 0
 	}
@@ -590,7 +591,7 @@ func ExportFeaturesForMap(pwaCode, layerName, startDate, endDate string) ([]byte
 
 // GetFeatureProperties returns full properties for a single feature by ObjectID.
 // Used for lazy-loading on map click.
-func GetFeatureProperties(pwaCode, layerName, featureID string) (map[string]interface{}, error) {
+func GetFeatureProperties(pwaCode, layerName, featureID string, mask MaskMode) (map[string]interface{}, error) {
 	collectionID, err := FindCollectionID(pwaCode, layerName)
 	if err != nil {
 		return nil, fmt.Errorf("collection not found: %s_%s", pwaCode, layerName)
@@ -629,7 +630,7 @@ func GetFeatureProperties(pwaCode, layerName, featureID string) (map[string]inte
 		}
 	}
 
-	return props, nil
+	return MaskProperties(layerName, props, mask), nil
 }
 
 // GetYearsFromRecordDate returns a list of years that have recorded data.
@@ -665,7 +666,7 @@ func GetLayerDisplayName(layer string) string {
 // ExportMergedFeaturesAsGeoJSON exports features from multiple pwaCode+layer combinations
 // into a single merged GeoJSON FeatureCollection. Each feature is tagged with
 // _pwaCode and _layerName in properties for identification.
-func ExportMergedFeaturesAsGeoJSON(pwaCodes []string, layerNames []string, startDate, endDate string) ([]byte, error) {
+func ExportMergedFeaturesAsGeoJSON(pwaCodes []string, layerNames []string, startDate, endDate string, mask MaskMode) ([]byte, error) {
 	type Feature struct {
 		Type       string                 `json:"type"`
 		Geometry   interface{}            `json:"geometry"`
@@ -742,7 +743,7 @@ func ExportMergedFeaturesAsGeoJSON(pwaCodes []string, layerNames []string, start
 				allFeatures = append(allFeatures, Feature{
 					Type:       "Feature",
 					Geometry:   cleanBsonForJSON(geom),
-					Properties: props,
+					Properties: MaskProperties(layerName, props, mask),
 				})
 			}
 			cursor.Close(ctx)
@@ -276,3 +276,22 @@ func sortOfficesNumeric(offices []models.PwaOffice) {
 		return offices[i].PwaCode < offices[j].PwaCode
 	})
 }
+
+// GetOfficeZone returns the zone code of a single branch office.
+// Returns an empty string (not an error) when the pwa_code is unknown.
+func GetOfficeZone(pwaCode string) (string, error) {
+	var zone string
+	err := config.PgDB.QueryRow(`
+		SELECT zone
+		FROM pwa_office.pwa_office234
+		WHERE pwa_code = $1
+		LIMIT 1
+	`, pwaCode).Scan(&zone)
+	if err == sql.ErrNoRows {
+		return "", nil
+	}
+	if err != nil {
+		return "", fmt.Errorf("query office zone failed: %v", err)
+	}
+	return zone, nil
+}
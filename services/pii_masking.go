@@ -0,0 +1,260 @@
+// Package services/pii_masking.go
+// Masks customer PII (see FieldSensitivity in field_mapping.go) before
+// feature attributes leave the server.
+//
+// Every path that emits properties takes a MaskMode: the list / advanced
+// query rows, GET /features/properties, all GeoJSON exports and therefore
+// CSV and the ogr2ogr / PMTiles conversions built from them, FlatGeobuf,
+// and the chatbot results (MaskAnyJSON).
+//
+//	full     values unchanged
+//	partial  "สมชาย ใจดี" → "สม*** ใจ***", "1234567890" → "***7890", "123/4" → "1***"
+//	removed  the field is dropped
+//
+// The mode per permission level comes from PII_MASK_POLICY, e.g.
+// "all=full,reg=partial,branch=removed". Levels not listed (and unknown
+// levels) get "removed".
+package services
+
+import (
+	"fmt"
+	"log"
+	"os"
+	"strings"
+	"sync"
+)
+
+// MaskMode is how sensitive fields are emitted.
+type MaskMode string
+
+// Mask modes.
+const (
+	MaskFull    MaskMode = "full"
+	MaskPartial MaskMode = "partial"
+	MaskRemoved MaskMode = "removed"
+)
+
+// Sensitivity classes used by FieldSensitivity.
+const (
+	SensitivityName    = "pii_name"
+	SensitivityCode    = "pii_code"
+	SensitivityAddress = "pii_address"
+)
+
+// defaultPIIMaskPolicy applies when PII_MASK_POLICY is not set.
+var defaultPIIMaskPolicy = map[string]MaskMode{
+	"all":    MaskFull,
+	"reg":    MaskPartial,
+	"branch": MaskPartial,
+}
+
+var (
+	piiMaskPolicy   = defaultPIIMaskPolicy
+	piiMaskPolicyMu sync.RWMutex
+)
+
+// InitPIIMasking reads PII_MASK_POLICY ("level=mode,...").
+func InitPIIMasking() {
+	raw := strings.TrimSpace(os.Getenv("PII_MASK_POLICY"))
+	if raw == "" {
+		log.Printf("[PII] mask policy: default %v", defaultPIIMaskPolicy)
+		return
+	}
+	policy, err := ParsePIIMaskPolicy(raw)
+	if err != nil {
+		log.Printf("[PII] invalid PII_MASK_POLICY (%v), using default %v", err, defaultPIIMaskPolicy)
+		return
+	}
+	piiMaskPolicyMu.Lock()
+	piiMaskPolicy = policy
+	piiMaskPolicyMu.Unlock()
+	log.Printf("[PII] mask policy: %v", policy)
+}
+
+// ParsePIIMaskPolicy parses "all=full,reg=partial,branch=removed".
+func ParsePIIMaskPolicy(raw string) (map[string]MaskMode, error) {
+	policy := map[string]MaskMode{}
+	for _, part := range strings.Split(raw, ",") {
+		part = strings.TrimSpace(part)
+		if part == "" {
+			continue
+		}
+		kv := strings.SplitN(part, "=", 2)
+		if len(kv) != 2 {
+			return nil, fmt.Errorf("expected level=mode, got %q", part)
+		}
+		level, mode := strings.TrimSpace(kv[0]), MaskMode(strings.TrimSpace(kv[1]))
+		switch level {
+		case "all", "reg", "branch":
+		default:
+			return nil, fmt.Errorf("unknown permission level %q", level)
+		}
+		switch mode {
+		case MaskFull, MaskPartial, MaskRemoved:
+		default:
+			return nil, fmt.Errorf("unknown mask mode %q", mode)
+		}
+		policy[level] = mode
+	}
+	return policy, nil
+}
+
+// PIIMaskModeFor returns the mask mode of a permission level.
+func PIIMaskModeFor(level string) MaskMode {
+	piiMaskPolicyMu.RLock()
+	defer piiMaskPolicyMu.RUnlock()
+	if mode, ok := piiMaskPolicy[level]; ok {
+		return mode
+	}
+	return MaskRemoved
+}
+
+// ─── Classification lookup ───────────────────────────────────────────────────
+
+// sensitivityClass returns the class of key in collection, or "" when the
+// field is not sensitive. key may be the MongoDB or the Postgres name.
+func sensitivityClass(collection, key string) string {
+	fields := FieldSensitivity[collection]
+	if len(fields) == 0 {
+		return ""
+	}
+	if cls, ok := fields[key]; ok {
+		return cls
+	}
+	for mongoKey, pgKey := range FieldMapping[collection] {
+		if pgKey == key {
+			if cls, ok := fields[mongoKey]; ok {
+				return cls
+			}
+		}
+	}
+	return ""
+}
+
+// IsSensitiveField reports whether key (MongoDB or Postgres name) holds PII.
+func IsSensitiveField(collection, key string) bool {
+	return sensitivityClass(collection, key) != ""
+}
+
+// SensitiveConditionField returns the first sensitive field referenced by an
+// advanced-query condition group (see TranslateConditions), or "".
+func SensitiveConditionField(collection string, group map[string]interface{}) string {
+	rules, _ := group["rules"].([]interface{})
+	for _, r := range rules {
+		rule, ok := r.(map[string]interface{})
+		if !ok {
+			continue
+		}
+		if _, nested := rule["logic"]; nested {
+			if f := SensitiveConditionField(collection, rule); f != "" {
+				return f
+			}
+			continue
+		}
+		if f, _ := rule["field"].(string); f != "" && IsSensitiveField(collection, f) {
+			return f
+		}
+	}
+	return ""
+}
+
+// ─── Masking ─────────────────────────────────────────────────────────────────
+
+// MaskProperties applies mode to the sensitive fields of props in place and
+// returns props.
+func MaskProperties(collection string, props map[string]interface{}, mode MaskMode) map[string]interface{} {
+	if mode == MaskFull || len(FieldSensitivity[collection]) == 0 {
+		return props
+	}
+	for k, v := range props {
+		cls := sensitivityClass(collection, k)
+		if cls == "" {
+			continue
+		}
+		if mode == MaskPartial {
+			props[k] = maskValue(cls, v)
+		} else {
+			delete(props, k)
+		}
+	}
+	return props
+}
+
+// MaskAnyJSON applies mode to a decoded JSON value whose collection is not
+// known (chatbot results: find rows, aggregation output, PostGIS rows). Every
+// object key that is sensitive in any collection — MongoDB or Postgres name,
+// with or without the "properties." prefix — is masked, at any depth.
+func MaskAnyJSON(v interface{}, mode MaskMode) interface{} {
+	if mode == MaskFull {
+		return v
+	}
+	switch t := v.(type) {
+	case map[string]interface{}:
+		for k, val := range t {
+			cls := anySensitivityClass(strings.TrimPrefix(k, "properties."))
+			switch {
+			case cls == "":
+				t[k] = MaskAnyJSON(val, mode)
+			case mode == MaskPartial:
+				t[k] = maskValue(cls, val)
+			default:
+				delete(t, k)
+			}
+		}
+	case []interface{}:
+		for i := range t {
+			t[i] = MaskAnyJSON(t[i], mode)
+		}
+	}
+	return v
+}
+
+// anySensitivityClass is sensitivityClass over every collection.
+func anySensitivityClass(key string) string {
+	for collection := range FieldSensitivity {
+		if cls := sensitivityClass(collection, key); cls != "" {
+			return cls
+		}
+	}
+	return ""
+}
+
+// maskValue returns the partial mask of one value. nil and "" pass through.
+func maskValue(class string, v interface{}) interface{} {
+	if v == nil {
+		return nil
+	}
+	s := strings.TrimSpace(fmt.Sprintf("%v", v))
+	if s == "" {
+		return s
+	}
+	switch class {
+	case SensitivityName:
+		words := strings.Fields(s)
+		for i, w := range words {
+			words[i] = keepPrefix(w, 2)
+		}
+		return strings.Join(words, " ")
+	case SensitivityCode:
+		r := []rune(s)
+		if len(r) <= 4 {
+			return "***"
+		}
+		return "***" + string(r[len(r)-4:])
+	default:
+		return keepPrefix(s, 1)
+	}
+}
+
+// keepPrefix keeps the first n runes of s (fewer for short words) and
+// appends "***".
+func keepPrefix(s string, n int) string {
+	r := []rune(s)
+	if len(r) <= n {
+		n = len(r) - 1
+	}
+	if n < 0 {
+		n = 0
+	}
+	return string(r[:n]) + "***"
+}
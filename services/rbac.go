@@ -6,10 +6,24 @@
 //   "reg"    – regional access (งานแผนที่แนวท่อ + zone 10 user IDs)
 //   "branch" – branch access  (งานบริการและควบคุมน้ำสูญเสีย + manager IDs)
 //   ""       – no access (matched user but wrong role — should be rejected upstream)
+//
+// Rules live in rbac.pwagis_access_rule (see sql/create_rbac_rules.sql) and
+// are hot-reloaded into memory, so granting access no longer needs a deploy.
+// The built-in defaults below are only used until the first successful load
+// (or when the table does not exist yet).
 package services
 
 import (
+	"context"
+	"database/sql"
+	"fmt"
+	"log"
+	"sort"
 	"strings"
+	"sync"
+	"time"
+
+	"pwa_gis_tracking/config"
 )
 
 // Permission bundles the two permission strings the PHP session stored.
@@ -18,42 +32,139 @@ type Permission struct {
 	PermissionLeak  string // "all" | "reg" | "branch" | ""
 }
 
-// ─── Allow-lists ─────────────────────────────────────────────────────────────
-
-// allowedDepartments grants full ("all") leak permission to entire departments.
-var allowedDepartments = map[string]string{
-	"สำนักควบคุมน้ำสูญเสีย":      "all",
-	"สำนักตรวจสอบกระบวนการหลัก": "all",
-}
+// Rule types — which IntranetUser field a rule matches against.
+const (
+	RuleTypeDepartment = "department"
+	RuleTypeDivision   = "division"
+	RuleTypeJobName    = "job_name"
+	RuleTypeUserID     = "user_id"
+)
 
-// allowedDivisions grants full ("all") leak permission to specific divisions.
-var allowedDivisions = map[string]string{
-	"กองเทคโนโลยีสารสนเทศระบบประปา": "all",
-	"กองบริหารความเสี่ยง":            "all",
+// AccessRule is one row of rbac.pwagis_access_rule.
+type AccessRule struct {
+	ID              int        `json:"id"`
+	RuleType        string     `json:"rule_type"`
+	MatchValue      string     `json:"match_value"`
+	PermissionLevel string     `json:"permission_level"`
+	Priority        int        `json:"priority"`
+	ValidFrom       *time.Time `json:"valid_from,omitempty"`
+	ValidUntil      *time.Time `json:"valid_until,omitempty"`
+	Enabled         bool       `json:"enabled"`
+	Note            string     `json:"note"`
+	CreatedBy       string     `json:"created_by"`
+	CreatedAt       time.Time  `json:"created_at"`
+	UpdatedBy       string     `json:"updated_by"`
+	UpdatedAt       time.Time  `json:"updated_at"`
 }
 
-// allowedJobNames grants permission based on the sanitised job_name.
-// Keys are the Thai-only lowercase strings after stripping non-Thai characters.
-var allowedJobNames = map[string]string{
-	"งานแผนที่แนวท่อ":               "reg",
-	"งานบริการและควบคุมน้ำสูญเสีย": "branch",
-}
+// ─── Built-in defaults ───────────────────────────────────────────────────────
 
-// explicitUserIDs grants permission to specific employee numbers.
-// These mirror the hard-coded lists in check_user.php.
-var explicitUserIDs = map[string]string{
+// defaultAccessRules mirror the hard-coded lists in check_user.php.
+// They are the seed data of sql/create_rbac_rules.sql.
+var defaultAccessRules = []AccessRule{
+	{RuleType: RuleTypeDepartment, MatchValue: "สำนักควบคุมน้ำสูญเสีย", PermissionLevel: "all", Priority: 100, Enabled: true},
+	{RuleType: RuleTypeDepartment, MatchValue: "สำนักตรวจสอบกระบวนการหลัก", PermissionLevel: "all", Priority: 100, Enabled: true},
+	{RuleType: RuleTypeDivision, MatchValue: "กองเทคโนโลยีสารสนเทศระบบประปา", PermissionLevel: "all", Priority: 200, Enabled: true},
+	{RuleType: RuleTypeDivision, MatchValue: "กองบริหารความเสี่ยง", PermissionLevel: "all", Priority: 200, Enabled: true},
+	{RuleType: RuleTypeJobName, MatchValue: "งานแผนที่แนวท่อ", PermissionLevel: "reg", Priority: 300, Enabled: true},
+	{RuleType: RuleTypeJobName, MatchValue: "งานบริการและควบคุมน้ำสูญเสีย", PermissionLevel: "branch", Priority: 300, Enabled: true},
 	// HR — full access
-	"14180": "all",
-	"16361": "all",
-	"15632": "all",
+	{RuleType: RuleTypeUserID, MatchValue: "14180", PermissionLevel: "all", Priority: 400, Enabled: true},
+	{RuleType: RuleTypeUserID, MatchValue: "16361", PermissionLevel: "all", Priority: 400, Enabled: true},
+	{RuleType: RuleTypeUserID, MatchValue: "15632", PermissionLevel: "all", Priority: 400, Enabled: true},
 	// Zone 10
-	"10928": "reg",
-	"15011": "reg",
-	"16212": "reg",
+	{RuleType: RuleTypeUserID, MatchValue: "10928", PermissionLevel: "reg", Priority: 400, Enabled: true},
+	{RuleType: RuleTypeUserID, MatchValue: "15011", PermissionLevel: "reg", Priority: 400, Enabled: true},
+	{RuleType: RuleTypeUserID, MatchValue: "16212", PermissionLevel: "reg", Priority: 400, Enabled: true},
 	// Managers (ผช. ผจก.)
-	"11424": "branch",
-	"9489":  "branch",
-	"6026":  "branch",
+	{RuleType: RuleTypeUserID, MatchValue: "11424", PermissionLevel: "branch", Priority: 400, Enabled: true},
+	{RuleType: RuleTypeUserID, MatchValue: "9489", PermissionLevel: "branch", Priority: 400, Enabled: true},
+	{RuleType: RuleTypeUserID, MatchValue: "6026", PermissionLevel: "branch", Priority: 400, Enabled: true},
+}
+
+// ─── In-memory rule set ──────────────────────────────────────────────────────
+
+// accessRules holds only the rules active at the last load: enabled and
+// inside their validity window, which the DB checks against NOW() because
+// valid_from / valid_until are naive TIMESTAMPs. A window opening or
+// closing therefore takes effect on the next periodic reload.
+var (
+	accessRules       = sortedRules(defaultAccessRules)
+	accessRulesMu     sync.RWMutex
+	accessRulesLoaded time.Time // zero until the first successful DB load
+)
+
+// sortedRules returns a copy ordered by priority, then id (stable).
+func sortedRules(rules []AccessRule) []AccessRule {
+	out := make([]AccessRule, len(rules))
+	copy(out, rules)
+	sort.SliceStable(out, func(i, j int) bool {
+		if out[i].Priority != out[j].Priority {
+			return out[i].Priority < out[j].Priority
+		}
+		return out[i].ID < out[j].ID
+	})
+	return out
+}
+
+// ReloadAccessRules replaces the in-memory rule set with the active rows in
+// rbac.pwagis_access_rule. On error, or when no rule is active (which would
+// lock everyone out), the previous rule set is kept.
+func ReloadAccessRules() error {
+	rules, err := listActiveAccessRules()
+	if err != nil {
+		return err
+	}
+	if len(rules) == 0 {
+		log.Printf("[RBAC] WARNING: rbac.pwagis_access_rule has no active rules — keeping the previous rule set")
+		return fmt.Errorf("no active access rules, keeping the previous rule set")
+	}
+
+	accessRulesMu.Lock()
+	accessRules = sortedRules(rules)
+	accessRulesLoaded = time.Now()
+	accessRulesMu.Unlock()
+	return nil
+}
+
+// AccessRulesLoadedAt returns when the rule set was last loaded from the DB
+// (zero time = still on built-in defaults).
+func AccessRulesLoadedAt() time.Time {
+	accessRulesMu.RLock()
+	defer accessRulesMu.RUnlock()
+	return accessRulesLoaded
+}
+
+// StartAccessRuleReloader loads the rules (and capability grants, see
+// capabilities.go) immediately and then every interval,
+// so edits made directly in the DB (or by another instance) are picked up.
+func StartAccessRuleReloader(ctx context.Context, interval time.Duration) {
+	if err := ReloadAccessRules(); err != nil {
+		log.Printf("[RBAC] initial rule load failed, using built-in defaults: %v", err)
+	} else {
+		log.Println("[RBAC] access rules loaded from database")
+	}
+	if err := ReloadCapabilityGrants(); err != nil {
+		log.Printf("[RBAC] initial capability load failed, allowing everything: %v", err)
+	}
+
+	go func() {
+		ticker := time.NewTicker(interval)
+		defer ticker.Stop()
+		for {
+			select {
+			case <-ticker.C:
+				if err := ReloadAccessRules(); err != nil {
+					log.Printf("[RBAC] rule reload failed (keeping previous rules): %v", err)
+				}
+				if err := ReloadCapabilityGrants(); err != nil {
+					log.Printf("[RBAC] capability reload failed (keeping previous grants): %v", err)
+				}
+			case <-ctx.Done():
+				return
+			}
+		}
+	}()
 }
 
 // ─── Public API ──────────────────────────────────────────────────────────────
@@ -62,25 +173,30 @@ var explicitUserIDs = map[string]string{
 // based on their department, division, job name and employee ID.
 // Returns a zero-value Permission (both fields "") if the user has no access.
 func ResolvePermission(depName, divName, jobName, userID string) Permission {
-	// 1. Department-level rules
-	if level, ok := allowedDepartments[depName]; ok {
-		return Permission{Permission: "leak", PermissionLeak: level}
-	}
-
-	// 2. Division-level rules
-	if level, ok := allowedDivisions[divName]; ok {
-		return Permission{Permission: "leak", PermissionLeak: level}
-	}
+	accessRulesMu.RLock()
+	rules := accessRules
+	accessRulesMu.RUnlock()
 
-	// 3. Job-name rules (strip all non-Thai characters then lowercase)
+	// Job-name rules compare against the Thai-only lowercase form
 	sanitised := sanitiseJobName(jobName)
-	if level, ok := allowedJobNames[sanitised]; ok {
-		return Permission{Permission: "leak", PermissionLeak: level}
-	}
 
-	// 4. Explicit user-ID overrides
-	if level, ok := explicitUserIDs[userID]; ok {
-		return Permission{Permission: "leak", PermissionLeak: level}
+	for _, r := range rules {
+		var candidate string
+		switch r.RuleType {
+		case RuleTypeDepartment:
+			candidate = depName
+		case RuleTypeDivision:
+			candidate = divName
+		case RuleTypeJobName:
+			candidate = sanitised
+		case RuleTypeUserID:
+			candidate = userID
+		default:
+			continue
+		}
+		if candidate != "" && candidate == r.MatchValue {
+			return Permission{Permission: "leak", PermissionLeak: r.PermissionLevel}
+		}
 	}
 
 	// No matching rule — user authenticated but not authorised.
@@ -93,8 +209,183 @@ func IsAuthorised(p Permission) bool {
 	return p.Permission != "" && p.PermissionLeak != ""
 }
 
+// ─── Rule CRUD ───────────────────────────────────────────────────────────────
+
+// ValidateAccessRule normalises and checks a rule before it is written.
+func ValidateAccessRule(r *AccessRule) error {
+	r.RuleType = strings.TrimSpace(r.RuleType)
+	r.MatchValue = strings.TrimSpace(r.MatchValue)
+	r.PermissionLevel = strings.TrimSpace(r.PermissionLevel)
+
+	switch r.RuleType {
+	case RuleTypeDepartment, RuleTypeDivision, RuleTypeUserID:
+	case RuleTypeJobName:
+		r.MatchValue = sanitiseJobName(r.MatchValue)
+	default:
+		return fmt.Errorf("invalid rule_type: %s", r.RuleType)
+	}
+	if r.MatchValue == "" {
+		return fmt.Errorf("match_value is required")
+	}
+	switch r.PermissionLevel {
+	case "all", "reg", "branch":
+	default:
+		return fmt.Errorf("invalid permission_level: %s", r.PermissionLevel)
+	}
+	if r.ValidFrom != nil && r.ValidUntil != nil && r.ValidUntil.Before(*r.ValidFrom) {
+		return fmt.Errorf("valid_until must be after valid_from")
+	}
+	return nil
+}
+
+const accessRuleColumns = `
+	id, rule_type, match_value, permission_level, priority,
+	valid_from, valid_until, enabled, COALESCE(note, ''),
+	COALESCE(created_by, ''), created_at, COALESCE(updated_by, ''), updated_at`
+
+// ListAccessRules returns every rule ordered by priority.
+func ListAccessRules() ([]AccessRule, error) {
+	return queryAccessRules(``)
+}
+
+// listActiveAccessRules returns the enabled rules whose validity window
+// contains NOW().
+func listActiveAccessRules() ([]AccessRule, error) {
+	return queryAccessRules(`WHERE enabled
+		  AND (valid_from IS NULL OR valid_from <= NOW())
+		  AND (valid_until IS NULL OR valid_until >= NOW())`)
+}
+
+func queryAccessRules(where string) ([]AccessRule, error) {
+	if config.PgDB == nil {
+		return nil, fmt.Errorf("postgres not connected")
+	}
+	rows, err := config.PgDB.Query(`SELECT ` + accessRuleColumns + `
+		FROM rbac.pwagis_access_rule ` + where + `
+		ORDER BY priority, id`)
+	if err != nil {
+		return nil, fmt.Errorf("query access rules failed: %v", err)
+	}
+	defer rows.Close()
+
+	rules := []AccessRule{}
+	for rows.Next() {
+		r, err := scanAccessRule(rows)
+		if err != nil {
+			log.Printf("scan access rule row error: %v", err)
+			continue
+		}
+		rules = append(rules, r)
+	}
+	return rules, rows.Err()
+}
+
+// GetAccessRule returns one rule by id (sql.ErrNoRows when missing).
+func GetAccessRule(id int) (AccessRule, error) {
+	row := config.PgDB.QueryRow(`SELECT `+accessRuleColumns+`
+		FROM rbac.pwagis_access_rule WHERE id = $1`, id)
+	return scanAccessRule(row)
+}
+
+// CreateAccessRule inserts a rule and reloads the in-memory rule set.
+func CreateAccessRule(r AccessRule, actor string) (AccessRule, error) {
+	if err := ValidateAccessRule(&r); err != nil {
+		return AccessRule{}, err
+	}
+	var id int
+	err := config.PgDB.QueryRow(`
+		INSERT INTO rbac.pwagis_access_rule
+			(rule_type, match_value, permission_level, priority,
+			 valid_from, valid_until, enabled, note, created_by, updated_by)
+		VALUES ($1,$2,$3,$4,$5,$6,$7,$8,$9,$9)
+		RETURNING id
+	`, r.RuleType, r.MatchValue, r.PermissionLevel, r.Priority,
+		r.ValidFrom, r.ValidUntil, r.Enabled, r.Note, actor).Scan(&id)
+	if err != nil {
+		return AccessRule{}, fmt.Errorf("insert access rule failed: %v", err)
+	}
+	reloadAfterChange(actor)
+	return GetAccessRule(id)
+}
+
+// UpdateAccessRule overwrites a rule and reloads the in-memory rule set.
+func UpdateAccessRule(id int, r AccessRule, actor string) (AccessRule, error) {
+	if err := ValidateAccessRule(&r); err != nil {
+		return AccessRule{}, err
+	}
+	res, err := config.PgDB.Exec(`
+		UPDATE rbac.pwagis_access_rule SET
+			rule_type = $2, match_value = $3, permission_level = $4, priority = $5,
+			valid_from = $6, valid_until = $7, enabled = $8, note = $9,
+			updated_by = $10, updated_at = NOW()
+		WHERE id = $1
+	`, id, r.RuleType, r.MatchValue, r.PermissionLevel, r.Priority,
+		r.ValidFrom, r.ValidUntil, r.Enabled, r.Note, actor)
+	if err != nil {
+		return AccessRule{}, fmt.Errorf("update access rule failed: %v", err)
+	}
+	if n, _ := res.RowsAffected(); n == 0 {
+		return AccessRule{}, sql.ErrNoRows
+	}
+	reloadAfterChange(actor)
+	return GetAccessRule(id)
+}
+
+// DeleteAccessRule removes a rule and reloads the in-memory rule set.
+func DeleteAccessRule(id int, actor string) error {
+	res, err := config.PgDB.Exec(`DELETE FROM rbac.pwagis_access_rule WHERE id = $1`, id)
+	if err != nil {
+		return fmt.Errorf("delete access rule failed: %v", err)
+	}
+	if n, _ := res.RowsAffected(); n == 0 {
+		return sql.ErrNoRows
+	}
+	reloadAfterChange(actor)
+	return nil
+}
+
 // ─── Helper ──────────────────────────────────────────────────────────────────
 
+// rowScanner is satisfied by both *sql.Row and *sql.Rows.
+type rowScanner interface {
+	Scan(dest ...interface{}) error
+}
+
+func scanAccessRule(s rowScanner) (AccessRule, error) {
+	var r AccessRule
+	var from, until sql.NullTime
+	err := s.Scan(&r.ID, &r.RuleType, &r.MatchValue, &r.PermissionLevel, &r.Priority,
+		&from, &until, &r.Enabled, &r.Note,
+		&r.CreatedBy, &r.CreatedAt, &r.UpdatedBy, &r.UpdatedAt)
+	if err != nil {
+		return AccessRule{}, err
+	}
+	if from.Valid {
+		r.ValidFrom = &from.Time
+	}
+	if until.Valid {
+		r.ValidUntil = &until.Time
+	}
+	return r, nil
+}
+
+// reloadAfterChange applies a CRUD change to this instance immediately;
+// other instances pick it up on their next periodic reload. API tokens whose
+// owner lost the token's permission level are revoked.
+func reloadAfterChange(actor string) {
+	if err := ReloadAccessRules(); err != nil {
+		log.Printf("[RBAC] reload after change failed: %v", err)
+		return
+	}
+	n, err := RevokeUncoveredAPITokens(actor)
+	if err != nil {
+		log.Printf("[RBAC] revoking api tokens after change failed: %v", err)
+	}
+	if n > 0 {
+		log.Printf("[RBAC] revoked %d api token(s) whose owner lost access", n)
+	}
+}
+
 // sanitiseJobName keeps only Thai Unicode characters (U+0E00–U+0E7F) and
 // lowercases the result, replicating PHP's preg_replace('~[^ก-๛]~iu','', ...).
 func sanitiseJobName(s string) string {
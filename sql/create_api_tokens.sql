@@ -0,0 +1,49 @@
+-- ================================================================
+-- PWA GIS Online Tracking — API Tokens (machine clients / ETL jobs)
+-- PostgreSQL 9.4 compatible
+--
+-- Only the SHA-256 of each token is stored; the plain token is shown
+-- once when it is issued.
+-- ================================================================
+
+-- 1. Create schema (shared with RBAC rules)
+CREATE SCHEMA IF NOT EXISTS rbac;
+
+-- 2. Create table
+CREATE TABLE IF NOT EXISTS rbac.pwagis_api_token (
+    id               SERIAL PRIMARY KEY,
+    token_prefix     VARCHAR(16)  NOT NULL,        -- first chars of the token, for display only
+    token_hash       CHAR(64)     NOT NULL UNIQUE, -- hex SHA-256 of the full token
+    name             VARCHAR(100) NOT NULL,        -- e.g. 'nightly-etl'
+    token_type       VARCHAR(20)  NOT NULL,        -- 'personal' | 'service'
+    owner_user_id    VARCHAR(20)  NOT NULL,        -- employee number the token acts as
+    permission_level VARCHAR(20)  NOT NULL,        -- 'all' | 'reg' | 'branch'
+    zone             VARCHAR(10),                  -- for 'reg'
+    pwa_codes        TEXT,                         -- comma-separated, for 'branch'
+    expires_at       TIMESTAMP    NOT NULL,
+    created_by       VARCHAR(20)  NOT NULL,
+    created_at       TIMESTAMP    NOT NULL DEFAULT NOW(),
+    revoked_at       TIMESTAMP,
+    revoked_by       VARCHAR(20),
+    last_used_at     TIMESTAMP,
+    last_used_ip     VARCHAR(50),
+    -- owner's intranet profile at issue time; the owner's permission is
+    -- re-resolved from it on every use
+    owner_dep_name   VARCHAR(200),
+    owner_div_name   VARCHAR(200),
+    owner_job_name   VARCHAR(200),
+    owner_area       VARCHAR(10),
+    owner_pwa_code   VARCHAR(20),
+    CONSTRAINT chk_token_type CHECK (token_type IN ('personal', 'service')),
+    CONSTRAINT chk_token_level CHECK (permission_level IN ('all', 'reg', 'branch'))
+);
+
+-- 3. Indexes
+CREATE INDEX idx_api_token_owner ON rbac.pwagis_api_token (owner_user_id);
+
+-- 4. Attribute audit rows to the credential used ('session' or 'token:<id>')
+ALTER TABLE audit_logs.pwagis_track_log ADD COLUMN auth_method VARCHAR(40);
+
+-- 5. Comment
+COMMENT ON TABLE rbac.pwagis_api_token IS 'API token สำหรับสคริปต์/งาน ETL — เก็บเฉพาะ hash';
+COMMENT ON COLUMN audit_logs.pwagis_track_log.auth_method IS 'session | token:<id>';
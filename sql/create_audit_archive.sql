@@ -0,0 +1,49 @@
+-- ================================================================
+-- PWA GIS Online Tracking — Audit log retention & archival
+-- PostgreSQL 9.4 compatible
+--
+-- services/audit_archive.go moves rows older than retention_days out of
+-- audit_logs.pwagis_track_log, either into monthly tables
+-- audit_logs.pwagis_track_log_YYYYMM (created on demand) or into gzip CSV
+-- files. audit_logs.daily_usage keeps reading the live table only.
+-- ================================================================
+
+-- 1. Schedule (single row, id = 1)
+CREATE TABLE IF NOT EXISTS audit_logs.pwagis_archive_schedule (
+    id              INTEGER PRIMARY KEY DEFAULT 1 CHECK (id = 1),
+    enabled         BOOLEAN NOT NULL DEFAULT FALSE,
+    run_at          VARCHAR(5) NOT NULL DEFAULT '02:30',   -- HH:MM local time, daily
+    retention_days  INTEGER NOT NULL DEFAULT 180 CHECK (retention_days >= 7),
+    mode            VARCHAR(10) NOT NULL DEFAULT 'table' CHECK (mode IN ('table', 'file')),
+    updated_by      VARCHAR(20),
+    updated_at      TIMESTAMP NOT NULL DEFAULT NOW()
+);
+
+INSERT INTO audit_logs.pwagis_archive_schedule (id)
+SELECT 1 WHERE NOT EXISTS (SELECT 1 FROM audit_logs.pwagis_archive_schedule WHERE id = 1);
+
+-- 2. Run history
+CREATE TABLE IF NOT EXISTS audit_logs.pwagis_archive_run (
+    id              SERIAL PRIMARY KEY,
+    started_at      TIMESTAMP NOT NULL DEFAULT NOW(),
+    finished_at     TIMESTAMP,
+    run_trigger     VARCHAR(10) NOT NULL,                  -- 'schedule' | 'manual'
+    mode            VARCHAR(10) NOT NULL,
+    cutoff          TIMESTAMP NOT NULL,
+    moved_rows      BIGINT NOT NULL DEFAULT 0,
+    months          TEXT,                                  -- "202401:1234,202402:987"
+    error           TEXT,
+    run_by          VARCHAR(20),
+    chain_last_id   BIGINT,                                -- last export hash-chain row moved
+    chain_last_hash VARCHAR(64),
+    chain_anchor_mac VARCHAR(64)                           -- HMAC(AUDIT_CHAIN_KEY) of id + chain_last_id + chain_last_hash
+);
+
+CREATE INDEX idx_archive_run_started ON audit_logs.pwagis_archive_run (started_at);
+
+-- 3. Comments
+COMMENT ON TABLE audit_logs.pwagis_archive_schedule IS 'ตั้งเวลาย้ายบันทึกการใช้งานเก่าออกจากตารางหลัก (แถวเดียว)';
+COMMENT ON TABLE audit_logs.pwagis_archive_run IS 'ประวัติการย้ายบันทึกการใช้งานไปยังตารางรายเดือนหรือไฟล์';
+COMMENT ON COLUMN audit_logs.pwagis_archive_run.chain_last_hash IS 'chain_hash ของแถวส่งออกข้อมูลล่าสุดที่ถูกย้าย ใช้ตรวจ prev_hash ของแถวแรกในตารางหลัก';
+COMMENT ON COLUMN audit_logs.pwagis_archive_run.chain_anchor_mac IS 'HMAC ด้วย AUDIT_CHAIN_KEY ของ id + chain_last_id + chain_last_hash ป้องกันการปลอมแถวประวัติ';
+COMMENT ON COLUMN audit_logs.pwagis_archive_schedule.mode IS 'table=ตาราง pwagis_track_log_YYYYMM | file=ไฟล์ CSV.gz ใน AUDIT_ARCHIVE_DIR';
@@ -0,0 +1,32 @@
+-- ================================================================
+-- PWA GIS Online Tracking — Export audit hash chain
+-- PostgreSQL 9.4 compatible
+--
+-- Every export_* row of audit_logs.pwagis_track_log stores the chain_hash
+-- of the previous export row (prev_hash) and its own chain_hash computed
+-- over prev_hash + the row content (services/audit_chain.go). Editing or
+-- deleting a row breaks the chain; verify with
+--   GET /api/admin/audit/chain/verify   or   go run . verify-audit-chain
+-- Rows written before this migration have no hash and are not checked.
+-- AUDIT_CHAIN_KEY must be set: without it export rows are not chained.
+-- ================================================================
+
+-- 1. Hash columns
+ALTER TABLE audit_logs.pwagis_track_log ADD COLUMN prev_hash  VARCHAR(64);
+ALTER TABLE audit_logs.pwagis_track_log ADD COLUMN chain_hash VARCHAR(64);
+
+-- 2. Chain head (single row, id = 1) — serialises writers and detects
+--    deletion of the newest rows
+CREATE TABLE IF NOT EXISTS audit_logs.pwagis_export_chain (
+    id          INTEGER PRIMARY KEY DEFAULT 1 CHECK (id = 1),
+    last_id     BIGINT NOT NULL DEFAULT 0,
+    last_hash   VARCHAR(64) NOT NULL DEFAULT '',
+    updated_at  TIMESTAMP NOT NULL DEFAULT NOW()
+);
+
+INSERT INTO audit_logs.pwagis_export_chain (id)
+SELECT 1 WHERE NOT EXISTS (SELECT 1 FROM audit_logs.pwagis_export_chain WHERE id = 1);
+
+-- 3. Comments
+COMMENT ON COLUMN audit_logs.pwagis_track_log.chain_hash IS 'HMAC-SHA256 ด้วย AUDIT_CHAIN_KEY ของ prev_hash + ข้อมูลแถว เฉพาะ action export_*';
+COMMENT ON TABLE audit_logs.pwagis_export_chain IS 'แถวล่าสุดของสายแฮชบันทึกการส่งออกข้อมูล (แถวเดียว)';
@@ -34,7 +34,7 @@ CREATE INDEX idx_audit_target     ON audit_logs.pwagis_track_log (target_type, t
 
 -- 4. Comment
 COMMENT ON TABLE audit_logs.pwagis_track_log IS 'ตารางบันทึกการใช้งานระบบ PWA GIS Online Tracking — อ้างอิงจาก API Intranet PWA';
-COMMENT ON COLUMN audit_logs.pwagis_track_log.action IS 'login | logout | view_detail | view_map | export_geojson | export_gpkg | export_shp | export_fgb | export_tab | export_pmtiles | export_excel | click_layer_modal';
+COMMENT ON COLUMN audit_logs.pwagis_track_log.action IS 'login | logout | login_failed | permission_denied | view_detail | view_map | export_geojson | export_gpkg | export_shp | export_fgb | export_tab | export_pmtiles | export_excel | click_layer_modal | advanced_query | advanced_query_export | chatbot_query';
 COMMENT ON COLUMN audit_logs.pwagis_track_log.permission_level IS 'all=สำนักงานใหญ่ | reg=เขต | branch=สาขา';
 
 -- 5. Utility view: daily usage summary (เปลี่ยน FILTER เป็น SUM CASE WHEN)
@@ -0,0 +1,13 @@
+-- ================================================================
+-- PWA GIS Online Tracking — Audit log search & report indexes
+-- PostgreSQL 9.4 compatible
+--
+-- Supports GET /api/admin/audit/logs and /api/admin/audit/reports/:kind,
+-- which filter by status and sort newest first within a user.
+-- ================================================================
+
+-- 1. Failed-request report (response_status >= 400)
+CREATE INDEX idx_audit_status ON audit_logs.pwagis_track_log (response_status);
+
+-- 2. Per-user history, newest first
+CREATE INDEX idx_audit_user_created ON audit_logs.pwagis_track_log (user_id, created_at DESC);
@@ -0,0 +1,43 @@
+-- ================================================================
+-- PWA GIS Online Tracking — Per-layer / Per-action Capabilities
+-- PostgreSQL 9.4 compatible
+--
+-- Grants on top of the permission level (see services/capabilities.go).
+-- permission_level, layer and capability accept '*' as a wildcard.
+-- A capability is allowed when an 'allow' row matches and no 'deny'
+-- row matches (deny wins).
+-- ================================================================
+
+-- 1. Create schema (shared with RBAC rules)
+CREATE SCHEMA IF NOT EXISTS rbac;
+
+-- 2. Create table
+CREATE TABLE IF NOT EXISTS rbac.pwagis_capability (
+    id               SERIAL PRIMARY KEY,
+    permission_level VARCHAR(20)  NOT NULL, -- 'all' | 'reg' | 'branch' | '*'
+    layer            VARCHAR(100) NOT NULL, -- collection name | '*'
+    capability       VARCHAR(40)  NOT NULL, -- view_map | view_attributes | export_vector | export_excel | use_chatbot | '*'
+    effect           VARCHAR(10)  NOT NULL DEFAULT 'allow',
+    note             TEXT,
+    created_by       VARCHAR(20),
+    created_at       TIMESTAMP    NOT NULL DEFAULT NOW(),
+    CONSTRAINT chk_cap_level CHECK (permission_level IN ('all', 'reg', 'branch', '*')),
+    CONSTRAINT chk_cap_capability CHECK (capability IN
+        ('view_map', 'view_attributes', 'export_vector', 'export_excel', 'use_chatbot', '*')),
+    CONSTRAINT chk_cap_effect CHECK (effect IN ('allow', 'deny'))
+);
+
+-- 3. Indexes
+CREATE INDEX idx_capability_level ON rbac.pwagis_capability (permission_level);
+
+-- 4. Seed: everyone keeps every capability (behaviour before this table)
+INSERT INTO rbac.pwagis_capability (permission_level, layer, capability, effect, note, created_by) VALUES
+    ('*', '*', '*', 'allow', 'default — restrict with deny rows', 'system');
+
+-- Example restrictions:
+--   INSERT INTO rbac.pwagis_capability (permission_level, layer, capability, effect, created_by)
+--   VALUES ('branch', '*', 'export_vector', 'deny', 'admin'),
+--          ('reg', 'meter', 'view_attributes', 'deny', 'admin');
+
+-- 5. Comment
+COMMENT ON TABLE rbac.pwagis_capability IS 'สิทธิ์รายการกระทำต่อชั้นข้อมูล (ดูแผนที่/ดูข้อมูล/ส่งออก/แชทบอท) ตามระดับสิทธิ์';
@@ -0,0 +1,25 @@
+-- ================================================================
+-- PWA GIS Online Tracking — Shared dashboard cache
+-- PostgreSQL 9.4 compatible
+--
+-- Used when DASHBOARD_CACHE=postgres (handlers/cache_pg.go) so that every
+-- instance behind the load balancer shares warm entries and invalidation.
+-- UNLOGGED: contents are disposable and are lost on a database crash.
+-- ================================================================
+
+-- 1. Create schema
+CREATE SCHEMA IF NOT EXISTS cache;
+
+-- 2. Entries (data = serialised dashboard JSON)
+CREATE UNLOGGED TABLE IF NOT EXISTS cache.pwagis_dashboard_cache (
+    cache_key   VARCHAR(64) PRIMARY KEY,
+    data        BYTEA NOT NULL,
+    source      VARCHAR(10) NOT NULL,                 -- 'lazy' | 'warm'
+    expires_at  TIMESTAMP WITH TIME ZONE NOT NULL,
+    updated_at  TIMESTAMP WITH TIME ZONE NOT NULL DEFAULT NOW()
+);
+
+CREATE INDEX idx_dashboard_cache_expires ON cache.pwagis_dashboard_cache (expires_at);
+
+-- 3. Comment
+COMMENT ON TABLE cache.pwagis_dashboard_cache IS 'แคชข้อมูล dashboard ที่ใช้ร่วมกันทุก instance (DASHBOARD_CACHE=postgres)';
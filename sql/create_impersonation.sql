@@ -0,0 +1,16 @@
+-- ================================================================
+-- PWA GIS Online Tracking — Admin "View as User" audit attribution
+-- PostgreSQL 9.4 compatible
+--
+-- While an admin impersonates an employee, user_id holds the employee
+-- and impersonator_id holds the admin, so every row carries both.
+-- ================================================================
+
+-- 1. Add column
+ALTER TABLE audit_logs.pwagis_track_log ADD COLUMN impersonator_id VARCHAR(20);
+
+-- 2. Index (sparse in practice — only impersonated rows are non-NULL)
+CREATE INDEX idx_audit_impersonator ON audit_logs.pwagis_track_log (impersonator_id);
+
+-- 3. Comment
+COMMENT ON COLUMN audit_logs.pwagis_track_log.impersonator_id IS 'รหัสพนักงานผู้ดูแลระบบที่ใช้โหมดดูในฐานะผู้ใช้อื่น (NULL = ใช้งานปกติ)';
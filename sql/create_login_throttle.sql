@@ -0,0 +1,29 @@
+-- ================================================================
+-- PWA GIS Online Tracking — Login Throttling / Lockout
+-- PostgreSQL 9.4 compatible
+--
+-- One row per throttled key: scope 'user' (employee ID) or 'ip'.
+-- Used by services.BeginLoginAttempt / ResetLoginFailures so that the
+-- counters are shared by every app instance.
+-- ================================================================
+
+-- 1. Create schema (shared with RBAC rules)
+CREATE SCHEMA IF NOT EXISTS rbac;
+
+-- 2. Create table
+CREATE TABLE IF NOT EXISTS rbac.pwagis_login_attempt (
+    scope            VARCHAR(10)  NOT NULL,            -- 'user' | 'ip'
+    key              VARCHAR(64)  NOT NULL,            -- employee ID or client IP
+    fail_count       INTEGER      NOT NULL DEFAULT 0,  -- consecutive failures inside the window
+    first_failure_at TIMESTAMP    NOT NULL DEFAULT NOW(),
+    last_failure_at  TIMESTAMP    NOT NULL DEFAULT NOW(),
+    locked_until     TIMESTAMP,                        -- NULL = not locked
+    PRIMARY KEY (scope, key),
+    CONSTRAINT chk_login_attempt_scope CHECK (scope IN ('user', 'ip'))
+);
+
+-- 3. Indexes
+CREATE INDEX idx_login_attempt_locked ON rbac.pwagis_login_attempt (locked_until);
+
+-- 4. Comment
+COMMENT ON TABLE rbac.pwagis_login_attempt IS 'ตัวนับการเข้าสู่ระบบผิดพลาด ต่อรหัสพนักงาน/IP — ใช้ระงับการเข้าสู่ระบบชั่วคราว';
@@ -0,0 +1,56 @@
+-- ================================================================
+-- PWA GIS Online Tracking — RBAC Access Rules
+-- PostgreSQL 9.4 compatible
+--
+-- Replaces the hard-coded allow-lists in services/rbac.go.
+-- Rules are evaluated in ascending priority order; the first enabled rule
+-- that matches and is inside its validity window decides the permission.
+-- ================================================================
+
+-- 1. Create schema
+CREATE SCHEMA IF NOT EXISTS rbac;
+
+-- 2. Create table
+CREATE TABLE IF NOT EXISTS rbac.pwagis_access_rule (
+    id               SERIAL PRIMARY KEY,
+    rule_type        VARCHAR(20)  NOT NULL, -- 'department' | 'division' | 'job_name' | 'user_id'
+    match_value      VARCHAR(200) NOT NULL, -- dep_name / div_name / sanitised job_name / employee number
+    permission_level VARCHAR(20)  NOT NULL, -- 'all' | 'reg' | 'branch'
+    priority         INTEGER      NOT NULL DEFAULT 100, -- lower = evaluated first
+    valid_from       TIMESTAMP,             -- NULL = no lower bound
+    valid_until      TIMESTAMP,             -- NULL = no upper bound
+    enabled          BOOLEAN      NOT NULL DEFAULT TRUE,
+    note             TEXT,
+    created_by       VARCHAR(20),
+    created_at       TIMESTAMP    NOT NULL DEFAULT NOW(),
+    updated_by       VARCHAR(20),
+    updated_at       TIMESTAMP    NOT NULL DEFAULT NOW(),
+    CONSTRAINT chk_rule_type CHECK (rule_type IN ('department', 'division', 'job_name', 'user_id')),
+    CONSTRAINT chk_permission_level CHECK (permission_level IN ('all', 'reg', 'branch'))
+);
+
+-- 3. Indexes
+CREATE INDEX idx_rbac_rule_match ON rbac.pwagis_access_rule (rule_type, match_value);
+
+-- 4. Seed with the rules previously hard-coded in services/rbac.go
+--    (priorities keep the original evaluation order: department → division → job → user)
+INSERT INTO rbac.pwagis_access_rule (rule_type, match_value, permission_level, priority, note, created_by) VALUES
+    ('department', 'สำนักควบคุมน้ำสูญเสีย',         'all',    100, 'migrated from rbac.go', 'system'),
+    ('department', 'สำนักตรวจสอบกระบวนการหลัก',    'all',    100, 'migrated from rbac.go', 'system'),
+    ('division',   'กองเทคโนโลยีสารสนเทศระบบประปา', 'all',    200, 'migrated from rbac.go', 'system'),
+    ('division',   'กองบริหารความเสี่ยง',            'all',    200, 'migrated from rbac.go', 'system'),
+    ('job_name',   'งานแผนที่แนวท่อ',               'reg',    300, 'migrated from rbac.go', 'system'),
+    ('job_name',   'งานบริการและควบคุมน้ำสูญเสีย',  'branch', 300, 'migrated from rbac.go', 'system'),
+    ('user_id',    '14180', 'all',    400, 'HR',                   'system'),
+    ('user_id',    '16361', 'all',    400, 'HR',                   'system'),
+    ('user_id',    '15632', 'all',    400, 'HR',                   'system'),
+    ('user_id',    '10928', 'reg',    400, 'Zone 10',              'system'),
+    ('user_id',    '15011', 'reg',    400, 'Zone 10',              'system'),
+    ('user_id',    '16212', 'reg',    400, 'Zone 10',              'system'),
+    ('user_id',    '11424', 'branch', 400, 'Managers (ผช. ผจก.)', 'system'),
+    ('user_id',    '9489',  'branch', 400, 'Managers (ผช. ผจก.)', 'system'),
+    ('user_id',    '6026',  'branch', 400, 'Managers (ผช. ผจก.)', 'system');
+
+-- 5. Comment
+COMMENT ON TABLE rbac.pwagis_access_rule IS 'กฎสิทธิ์การเข้าใช้งานระบบ PWA GIS Online Tracking — โหลดเข้า ResolvePermission อัตโนมัติ';
+COMMENT ON COLUMN rbac.pwagis_access_rule.permission_level IS 'all=สำนักงานใหญ่ | reg=เขต | branch=สาขา';
@@ -0,0 +1,36 @@
+-- ================================================================
+-- PWA GIS Online Tracking — Server-side Sessions
+-- PostgreSQL 9.4 compatible
+--
+-- Used by config.PGStore (SESSION_STORE=postgres). The browser cookie only
+-- carries the signed session id; values live in `data` (gob-encoded).
+-- ================================================================
+
+-- 1. Create schema (shared with RBAC rules)
+CREATE SCHEMA IF NOT EXISTS rbac;
+
+-- 2. Create table
+CREATE TABLE IF NOT EXISTS rbac.pwagis_session (
+    id               VARCHAR(64) PRIMARY KEY,
+    user_id          VARCHAR(20),
+    user_name        VARCHAR(200),
+    pwa_code         VARCHAR(7),
+    zone             VARCHAR(10),
+    permission_level VARCHAR(20),
+    data             BYTEA       NOT NULL,
+    ip_address       VARCHAR(50),
+    user_agent       TEXT,
+    created_at       TIMESTAMP   NOT NULL DEFAULT NOW(),
+    last_seen_at     TIMESTAMP   NOT NULL DEFAULT NOW(), -- sliding idle timeout
+    expires_at       TIMESTAMP   NOT NULL,               -- absolute lifetime (2 hours)
+    revoked_at       TIMESTAMP,                          -- forced logout
+    revoked_by       VARCHAR(20)
+);
+
+-- 3. Indexes
+CREATE INDEX idx_session_user_id    ON rbac.pwagis_session (user_id);
+CREATE INDEX idx_session_pwa_code   ON rbac.pwagis_session (pwa_code);
+CREATE INDEX idx_session_expires_at ON rbac.pwagis_session (expires_at);
+
+-- 4. Comment
+COMMENT ON TABLE rbac.pwagis_session IS 'session ฝั่ง server — ยกเลิก (force logout) ได้ก่อนหมดอายุ';
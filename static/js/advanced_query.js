@@ -50,6 +50,11 @@ var AdvancedQuery = (function () {
   var nextId = 1;
   var BASE = "/pwa_gis_tracking";
 
+  // CSRF token from /api/session/info (loaded by detail.js into userSession)
+  function csrfToken() {
+    return (typeof userSession !== "undefined" && userSession.csrf_token) || "";
+  }
+
   // ═══════════════════════════════════════════════
   // Condition Model
   // ═══════════════════════════════════════════════
@@ -828,7 +833,7 @@ var AdvancedQuery = (function () {
 
     fetch(BASE + "/api/features/advanced-query", {
       method: "POST",
-      headers: { "Content-Type": "application/json" },
+      headers: { "Content-Type": "application/json", "X-CSRF-Token": csrfToken() },
       credentials: "same-origin",
       body: JSON.stringify(body),
     })
@@ -1060,7 +1065,7 @@ var AdvancedQuery = (function () {
 
     fetch(BASE + "/api/features/advanced-query/export", {
       method: "POST",
-      headers: { "Content-Type": "application/json" },
+      headers: { "Content-Type": "application/json", "X-CSRF-Token": csrfToken() },
       credentials: "same-origin",
       body: JSON.stringify(body),
     })
@@ -1298,7 +1303,7 @@ var AdvancedQuery = (function () {
 
     fetch(BASE + "/api/features/advanced-query/export", {
       method: "POST",
-      headers: { "Content-Type": "application/json" },
+      headers: { "Content-Type": "application/json", "X-CSRF-Token": csrfToken() },
       credentials: "same-origin",
       body: JSON.stringify(body),
     })
@@ -54,7 +54,7 @@ async function sendChatMessage() {
     try {
         var res = await fetch('/pwa_gis_tracking/api/chatbot/query', {
             method: 'POST',
-            headers: { 'Content-Type': 'application/json' },
+            headers: { 'Content-Type': 'application/json', 'X-CSRF-Token': (typeof userSession !== 'undefined' && userSession.csrf_token) || '' },
             body: JSON.stringify({ prompt: prompt, pwa_code: pwaCode })
         });
 
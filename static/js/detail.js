@@ -86,9 +86,27 @@ async function loadSessionInfo() {
             '<span>สิทธิ์การใช้งาน: <strong>' + (permText[permLevel] || permLevel) + '</strong></span>' +
             (permLevel === 'reg' ? ' — เขต ' + (userSession.area || '') : '') +
             (permLevel === 'branch' ? ' — สาขา ' + (userSession.pwa_code || '') : '');
+        applyCapabilities();
     } catch (e) { console.error('Session load error:', e); }
 }
 
+/**
+ * Capability check against /api/session/info → capabilities.
+ * Missing data means "allowed" — the server enforces the real check.
+ */
+function hasCapability(cap, layer) {
+    var caps = userSession.capabilities;
+    if (!caps) return true;
+    if (layer && caps.layers && caps.layers[layer]) return caps.layers[layer][cap] !== false;
+    return !caps.global || caps.global[cap] !== false;
+}
+
+/** Hide UI actions the user's permission level cannot perform. */
+function applyCapabilities() {
+    var fab = document.getElementById('chatbotFab');
+    if (fab && !hasCapability('use_chatbot')) fab.style.display = 'none';
+}
+
 /* ─── Load Data ──────────────────────────────── */
 async function loadAllZonesAndOffices() {
     try {
@@ -1084,6 +1102,7 @@ function populateExportLayers() {
     var list = document.getElementById('exportLayerList');
     list.innerHTML = '';
     selectedLayers.forEach(function (name) {
+        if (!hasCapability('export_vector', name)) return;
         var cfg = LAYER_MAP_CONFIG[name] || { color: '#888' };
         var div = document.createElement('div');
         div.className = 'export-layer-item';
@@ -0,0 +1,53 @@
+/**
+ * PWA GIS Online Tracking - "View as User" banner
+ * - Shows a fixed red bar while an admin is impersonating another employee
+ * - Exit button calls POST /api/impersonate/stop and reloads the page
+ */
+(function () {
+    'use strict';
+
+    var BASE = '/pwa_gis_tracking';
+
+    function escapeHtml(s) {
+        return String(s == null ? '' : s).replace(/[&<>"']/g, function (ch) {
+            return { '&': '&amp;', '<': '&lt;', '>': '&gt;', '"': '&quot;', "'": '&#39;' }[ch];
+        });
+    }
+
+    function showBanner(info) {
+        var imp = info.impersonation;
+        var bar = document.createElement('div');
+        bar.id = 'impersonationBanner';
+        bar.style.cssText = 'position:sticky;top:0;z-index:9999;background:#C0392B;color:#fff;' +
+            'padding:8px 16px;font-size:14px;display:flex;align-items:center;gap:12px;flex-wrap:wrap;';
+        bar.innerHTML =
+            '<i class="fas fa-user-secret"></i>' +
+            '<span>กำลังดูในฐานะ <b>' + escapeHtml(info.uname || info.uid) + '</b> (' + escapeHtml(info.uid) + ')' +
+            ' — สิทธิ์ ' + escapeHtml(info.permission_leak) +
+            (info.area ? ' เขต ' + escapeHtml(info.area) : '') +
+            (info.pwa_code ? ' สาขา ' + escapeHtml(info.pwa_code) : '') +
+            ' · ผู้ดูแล ' + escapeHtml(imp.admin_name || imp.admin_uid) + '</span>' +
+            '<button type="button" id="impersonationExit" style="margin-left:auto;background:#fff;color:#C0392B;' +
+            'border:none;border-radius:4px;padding:4px 12px;font-weight:600;cursor:pointer;">ออกจากโหมดนี้</button>';
+        document.body.insertBefore(bar, document.body.firstChild);
+
+        document.getElementById('impersonationExit').addEventListener('click', function () {
+            fetch(BASE + '/api/impersonate/stop', {
+                method: 'POST',
+                headers: { 'X-CSRF-Token': info.csrf_token || '' },
+                credentials: 'same-origin'
+            }).then(function () {
+                window.location.reload();
+            });
+        });
+    }
+
+    document.addEventListener('DOMContentLoaded', function () {
+        fetch(BASE + '/api/session/info', { credentials: 'same-origin' })
+            .then(function (r) { return r.ok ? r.json() : null; })
+            .then(function (info) {
+                if (info && info.impersonation) showBanner(info);
+            })
+            .catch(function () { /* banner is best-effort */ });
+    });
+})();